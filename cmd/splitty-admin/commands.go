@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/almaznur91/splitty/internal/api"
+	"github.com/almaznur91/splitty/internal/repository"
+	"github.com/almaznur91/splitty/internal/service"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const dateFormat = "2006-01-02"
+
+// admin runs commands against the database, results are written to out
+type admin struct {
+	rs  *service.RoomService
+	rss *service.RoomStateService
+	br  repository.ButtonRepository
+	csr repository.ChatStateRepository
+	out io.Writer
+}
+
+func (a *admin) listRooms(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rooms", flag.ContinueOnError)
+	name := fs.String("name", "", "part of the room name, case insensitive")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rooms, err := a.rs.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tCREATED\tMEMBERS\tOPERATIONS\tCHAT\tNAME")
+	for _, r := range *rooms {
+		if !strings.Contains(strings.ToLower(r.Name), strings.ToLower(*name)) {
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n", r.ID.Hex(), r.CreateAt.Format(dateFormat),
+			len(*r.Members), countOperations(r), r.Chat.ID, r.Name)
+	}
+	return w.Flush()
+}
+
+func (a *admin) showRoom(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("room id is expected")
+	}
+	room, err := a.rs.FindById(ctx, args[0])
+	if err != nil {
+		return errors.Wrapf(err, "cannot find room %s", args[0])
+	}
+
+	_, _ = fmt.Fprintf(a.out, "%s %q, created %s, chat %d\n", room.ID.Hex(), room.Name, room.CreateAt.Format(dateFormat), room.Chat.ID)
+	_, _ = fmt.Fprintf(a.out, "finished: %v, paid off: %v, archived: %v\n\nmembers:\n", room.RoomStates.FinishedAddOperation,
+		room.RoomStates.PaidOffDebt, room.RoomStates.Archived)
+	for _, m := range *room.Members {
+		_, _ = fmt.Fprintf(a.out, "  %s\n", userName(&m))
+	}
+
+	_, _ = fmt.Fprintln(a.out, "\noperations:")
+	w := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	if room.Operations != nil {
+		for _, o := range *room.Operations {
+			_, _ = fmt.Fprintf(w, "  %s\t%s\t%d\t%s\t%s\t%s\n", o.ID.Hex(), o.CreateAt.Format(dateFormat), o.Sum,
+				userName(o.Donor), recipientNames(&o), o.Description)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintln(a.out, "\ndebts:")
+	debts, err := service.GetRoomDebts(*room)
+	if err != nil {
+		return errors.Wrap(err, "debts are not calculated")
+	}
+	for _, d := range debts {
+		_, _ = fmt.Fprintf(a.out, "  %s -> %s: %d\n", userName(d.Debtor), userName(d.Lender), d.Sum)
+	}
+
+	if problems := service.VerifyRoom(*room); len(problems) > 0 {
+		_, _ = fmt.Fprintln(a.out, "\nproblems:")
+		for _, p := range problems {
+			_, _ = fmt.Fprintf(a.out, "  %s\n", p)
+		}
+	}
+	return nil
+}
+
+// verifyDebts checks debts of every room, the error is returned if any room has problems
+func (a *admin) verifyDebts(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("debts", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "recompute paid off debts of every room before the check")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rooms, err := a.rs.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	var broken int
+	for i := range *rooms {
+		room := &(*rooms)[i]
+		if *fix {
+			if err := a.rss.DefinePaidOfDebtsUserIdsAndSave(ctx, room); err != nil {
+				log.Ctx(ctx).Error().Err(err).Msgf("cannot recompute debts of room %s", room.ID.Hex())
+				broken++
+				continue
+			}
+			if room, err = a.rs.FindById(ctx, room.ID.Hex()); err != nil {
+				return err
+			}
+		}
+		problems := service.VerifyRoom(*room)
+		if len(problems) > 0 {
+			broken++
+		}
+		for _, p := range problems {
+			_, _ = fmt.Fprintf(a.out, "%s %q: %s\n", room.ID.Hex(), room.Name, p)
+		}
+	}
+	_, _ = fmt.Fprintf(a.out, "%d rooms are verified, %d have problems\n", len(*rooms), broken)
+	if broken > 0 {
+		return errors.Errorf("%d rooms have problems", broken)
+	}
+	return nil
+}
+
+func (a *admin) listOrphans(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("no arguments are expected")
+	}
+	rooms, err := a.rs.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ROOM\tOPERATION\tCREATED\tSUM\tNOT MEMBERS\tDESCRIPTION")
+	for _, r := range *rooms {
+		for _, o := range service.OrphanOperations(r) {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", r.ID.Hex(), o.ID.Hex(), o.CreateAt.Format(dateFormat), o.Sum,
+				strings.Join(notMembers(r, o), ", "), o.Description)
+		}
+	}
+	return w.Flush()
+}
+
+// prune deletes buttons and chat states, whose rooms do not exist
+func (a *admin) prune(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "count orphans without deleting")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rooms, err := a.rs.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+	roomIds := make([]string, 0, len(*rooms))
+	for _, r := range *rooms {
+		roomIds = append(roomIds, r.ID.Hex())
+	}
+
+	var buttons, states int64
+	if *dryRun {
+		if buttons, err = a.br.CountNotInRooms(ctx, roomIds); err != nil {
+			return err
+		}
+		if states, err = a.csr.CountNotInRooms(ctx, roomIds); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(a.out, "%d buttons and %d chat states would be deleted\n", buttons, states)
+		return nil
+	}
+	if buttons, err = a.br.DeleteNotInRooms(ctx, roomIds); err != nil {
+		return err
+	}
+	if states, err = a.csr.DeleteNotInRooms(ctx, roomIds); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(a.out, "%d buttons and %d chat states are deleted\n", buttons, states)
+	return nil
+}
+
+func (a *admin) dump(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	out := fs.String("out", "", "file for the room, stdout by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("room id is expected")
+	}
+	room, err := a.rs.FindById(ctx, fs.Arg(0))
+	if err != nil {
+		return errors.Wrapf(err, "cannot find room %s", fs.Arg(0))
+	}
+	// extended json keeps types of bson and the fields, which are hidden from json, like sealed bank details
+	ext, err := bson.MarshalExtJSON(room, true, false)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err := json.Indent(&b, ext, "", "  "); err != nil {
+		return err
+	}
+	b.WriteByte('\n')
+	if *out == "" {
+		_, err = b.WriteTo(a.out)
+		return err
+	}
+	return ioutil.WriteFile(*out, b.Bytes(), 0600)
+}
+
+func (a *admin) restore(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	replace := fs.Bool("replace", false, "replace the existing room with the same id")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("file of the room is expected")
+	}
+	b, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	room := &api.Room{}
+	if err := bson.UnmarshalExtJSON(b, true, room); err != nil {
+		return errors.Wrapf(err, "cannot read room from %s", fs.Arg(0))
+	}
+	if room.ID.IsZero() || room.Members == nil || room.Operations == nil {
+		return errors.Errorf("%s is not a dump of the room", fs.Arg(0))
+	}
+
+	_, err = a.rs.FindById(ctx, room.ID.Hex())
+	switch {
+	case err == nil && !*replace:
+		return errors.Errorf("room %s exists, use -replace to overwrite it", room.ID.Hex())
+	case err != nil && err != mongo.ErrNoDocuments:
+		return err
+	}
+	if err := a.rs.RestoreRoom(ctx, room); err != nil {
+		return err
+	}
+	// room changes are published inside the bot process, summaries can't be refreshed from here
+	_, _ = fmt.Fprintf(a.out, "room %s %q is restored, version %d\n", room.ID.Hex(), room.Name, room.Version)
+	_, _ = fmt.Fprintln(a.out, "pinned summaries of the room are not refreshed until the next change of the room")
+	return nil
+}
+
+func countOperations(r api.Room) int {
+	if r.Operations == nil {
+		return 0
+	}
+	return len(*r.Operations)
+}
+
+func userName(u *api.User) string {
+	if u == nil {
+		return "-"
+	}
+	name := strconv.Itoa(u.ID) + " " + u.DisplayName
+	if u.IsVirtual {
+		name += " (virtual)"
+	}
+	return name
+}
+
+func recipientNames(o *api.Operation) string {
+	if o.Recipients == nil {
+		return ""
+	}
+	var names []string
+	for _, r := range *o.Recipients {
+		names = append(names, r.DisplayName)
+	}
+	return strings.Join(names, ", ")
+}
+
+// notMembers returns users of the operation who are not members of the room
+func notMembers(r api.Room, o api.Operation) []string {
+	var users []*api.User
+	for _, p := range o.Payers() {
+		users = append(users, p.User)
+	}
+	if o.Recipients != nil {
+		for i := range *o.Recipients {
+			users = append(users, &(*o.Recipients)[i])
+		}
+	}
+	for _, item := range o.Items {
+		for i := range item.Recipients {
+			users = append(users, &item.Recipients[i])
+		}
+	}
+
+	seen := map[int]bool{}
+	var names []string
+	for _, u := range users {
+		if u == nil || seen[u.ID] || r.FindMember(u.ID) != nil {
+			continue
+		}
+		seen[u.ID] = true
+		names = append(names, userName(u))
+	}
+	return names
+}
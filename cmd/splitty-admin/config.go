@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/caarlos0/env/v6"
+)
+
+type config struct {
+	LogLevel string `env:"LOG_LEVEL" envDefault:"info"`
+	DbAddr   string `env:"DB_HOST" envDefault:"mongodb://localhost:27017/"`
+	DbName   string `env:"DB_NAME" envDefault:"splitty"`
+}
+
+func initConfig() (*config, error) {
+	cfg := &config{}
+
+	if err := env.Parse(cfg); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
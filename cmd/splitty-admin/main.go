@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/almaznur91/splitty/internal/repository"
+	"github.com/almaznur91/splitty/internal/service"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command of the admin tool, args are arguments after the name of the command
+type command struct {
+	usage string
+	run   func(a *admin, ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"rooms":   {"rooms [-name part]: list rooms", (*admin).listRooms},
+	"room":    {"room <id>: show the room with operations, debts and problems", (*admin).showRoom},
+	"debts":   {"debts [-fix]: verify debts of every room, -fix recomputes paid off debts before", (*admin).verifyDebts},
+	"orphans": {"orphans: list operations whose donor or recipients are not members of the room", (*admin).listOrphans},
+	"prune":   {"prune [-dry-run]: delete buttons and chat states of deleted rooms", (*admin).prune},
+	"dump":    {"dump [-out file] <id>: write the room as json", (*admin).dump},
+	"restore": {"restore [-replace] <file>: save the room from json written by dump", (*admin).restore},
+}
+
+func main() {
+	cfg, err := initConfig()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Can not init config")
+	}
+	if err := initLogger(cfg); err != nil {
+		log.Fatal().Err(err).Msg("Can not init logger")
+	}
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	db, cleanup, err := initMongoConnection(ctx, cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Can not connect to mongo")
+	}
+	err = cmd.run(newAdmin(db, os.Stdout), ctx, os.Args[2:])
+	cleanup()
+	if err != nil {
+		log.Error().Err(err).Msgf("%s failed", os.Args[1])
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: splitty-admin <command> [flags] [args]")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+	}
+}
+
+func initLogger(c *config) error {
+	logLvl, err := zerolog.ParseLevel(strings.ToLower(c.LogLevel))
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(logLvl)
+	// stdout is for the output of commands
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	return nil
+}
+
+func initMongoConnection(ctx context.Context, cfg *config) (*mongo.Database, func(), error) {
+	client, err := mongo.NewClient(options.Client().ApplyURI(cfg.DbAddr))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = client.Connect(ctx); err != nil {
+		return nil, nil, err
+	}
+	if err = client.Ping(ctx, nil); err != nil {
+		return nil, nil, err
+	}
+	return client.Database(cfg.DbName), func() {
+		if err := client.Disconnect(ctx); err != nil {
+			log.Error().Err(err).Msg("error while disconnect from mongo")
+		}
+	}, nil
+}
+
+func newAdmin(db *mongo.Database, out io.Writer) *admin {
+	rr := repository.NewRoomRepository(db)
+	// rooms are changed offline, summaries of them are not updated
+	ops := service.NewOperationService(rr, nil)
+	return &admin{
+		rs:  service.NewRoomService(rr, nil),
+		rss: service.NewRoomStateService(ops, rr),
+		br:  repository.NewButtonRepository(db),
+		csr: repository.NewChatStateRepository(db),
+		out: out,
+	}
+}
@@ -1,11 +1,21 @@
 package main
 
-import "github.com/caarlos0/env/v6"
+import (
+	"github.com/caarlos0/env/v6"
+	"time"
+	// quiet hours are in the configured time zone, containers often have no zone database
+	_ "time/tzdata"
+)
 
 type config struct {
-	Listen   string `env:"LISTEN" envDefault:"localhost:7171"`
-	LogLevel string `env:"LOG_LEVEL" envDefault:"debug"`
-	LogFmt   string `env:"LOG_FMT" envDefault:"console"`
+	Listen    string `env:"LISTEN" envDefault:"localhost:7171"`
+	LogLevel  string `env:"LOG_LEVEL" envDefault:"debug"`
+	LogFmt    string `env:"LOG_FMT" envDefault:"console"`
+	TraceFile string `env:"TRACE_FILE"`
+
+	JournalFile     string `env:"JOURNAL_FILE"`
+	JournalMaxSize  int64  `env:"JOURNAL_MAX_SIZE_MB" envDefault:"100"`
+	JournalMaxFiles int    `env:"JOURNAL_MAX_FILES" envDefault:"5"`
 
 	DbAddr          string   `env:"DB_HOST" envDefault:"mongodb://localhost:27017/"`
 	DbName          string   `env:"DB_NAME" envDefault:"splitty"`
@@ -13,6 +23,23 @@ type config struct {
 	SuperUsers      []string `env:"SUPER_USER" envSeparator:":" envDefault:"mazanur:zagirnur"`
 	TgDebug         bool     `env:"TG_DEBUG" envDefault:"false"`
 	DefaultLanguage string   `env:"DEFAULT_LANGUAGE" envDefault:"en"`
+
+	MasterKeys      string `env:"MASTER_KEYS,required"`
+	MaskBankDetails bool   `env:"MASK_BANK_DETAILS" envDefault:"true"`
+
+	ReminderIntervalDays  int           `env:"REMINDER_INTERVAL_DAYS" envDefault:"3"`
+	ReminderCheckInterval time.Duration `env:"REMINDER_CHECK_INTERVAL" envDefault:"1h"`
+	QuietHoursFrom        int           `env:"QUIET_HOURS_FROM" envDefault:"22"`
+	QuietHoursTo          int           `env:"QUIET_HOURS_TO" envDefault:"9"`
+	QuietHoursTimeZone    string        `env:"QUIET_HOURS_TIME_ZONE" envDefault:"Europe/Moscow"`
+	DigestCheckInterval   time.Duration `env:"DIGEST_CHECK_INTERVAL" envDefault:"1h"`
+	ConsistencyInterval   time.Duration `env:"CONSISTENCY_CHECK_INTERVAL" envDefault:"6h"`
+
+	SendGlobalRate    float64       `env:"SEND_GLOBAL_RATE" envDefault:"30"`
+	SendChatRate      float64       `env:"SEND_CHAT_RATE" envDefault:"1"`
+	SendGroupRate     float64       `env:"SEND_GROUP_RATE" envDefault:"20"`
+	OutboxInterval    time.Duration `env:"OUTBOX_INTERVAL" envDefault:"500ms"`
+	OutboxMaxAttempts int           `env:"OUTBOX_MAX_ATTEMPTS" envDefault:"8"`
 }
 
 func initConfig() (*config, error) {
@@ -21,6 +48,9 @@ func initConfig() (*config, error) {
 	if err := env.Parse(cfg); err != nil {
 		return cfg, err
 	}
+	if _, err := time.LoadLocation(cfg.QuietHoursTimeZone); err != nil {
+		return cfg, err
+	}
 
 	return cfg, nil
 }
@@ -10,12 +10,17 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/text/language"
 	"math/rand"
+	"net/http"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/almaznur91/splitty/internal/bot"
 	"github.com/almaznur91/splitty/internal/events"
+	"github.com/almaznur91/splitty/internal/metrics"
+	"github.com/almaznur91/splitty/internal/secret"
+	"github.com/almaznur91/splitty/internal/service"
+	"github.com/almaznur91/splitty/internal/tracing"
 	tbapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/xlab/closer"
 )
@@ -24,7 +29,6 @@ var revision = "local"
 
 func main() {
 	defer closer.Close()
-	ctx := context.Background()
 
 	cfg, err := initConfig()
 	if err != nil {
@@ -36,9 +40,25 @@ func main() {
 	if err := initLogger(cfg); err != nil {
 		log.Fatal().Err(err).Msg("Can not init logger")
 	}
+	// handlers and repositories log by the logger of the context, updates add their ids to it
+	ctx := log.Logger.WithContext(context.Background())
+
+	flush, err := tracing.Init(cfg.TraceFile, revision)
+	if err != nil {
+		log.Error().Err(err).Msg("Can not init tracing")
+		return
+	}
+	closer.Bind(flush)
 
 	rand.Seed(int64(time.Now().Nanosecond()))
 
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := replay(ctx, cfg, os.Args[2:]); err != nil {
+			log.Error().Err(err).Msg("replay failed")
+		}
+		return
+	}
+
 	app, cl, err := initApp(ctx, cfg)
 	if err != nil {
 		log.Error().Err(err).Msg("Can not init application")
@@ -52,6 +72,69 @@ func main() {
 	}
 }
 
+// application runs telegram listener together with background jobs
+type application struct {
+	listener *events.TelegramListener
+	summary  *events.SummaryUpdater
+	reminder *events.ReminderScheduler
+	digest   *events.DigestScheduler
+	checker  *events.ConsistencyScheduler
+	outbox   *events.Outbox
+	server   *metrics.Server
+	us       *service.UserService
+}
+
+func newApplication(l *events.TelegramListener, su *events.SummaryUpdater, rs *events.ReminderScheduler, ds *events.DigestScheduler,
+	cs *events.ConsistencyScheduler, o *events.Outbox, srv *metrics.Server, us *service.UserService) *application {
+	return &application{listener: l, summary: su, reminder: rs, digest: ds, checker: cs, outbox: o, server: srv, us: us}
+}
+
+// Do starts background jobs and blocks on telegram listener
+func (a *application) Do(ctx context.Context) error {
+	go func() {
+		// bank details are secured before the sync, both of them update rooms
+		if err := a.us.SecureBankDetails(ctx); err != nil {
+			log.Error().Err(err).Msg("encryption of bank details failed")
+		}
+		if err := a.us.SyncUsersInRooms(ctx); err != nil {
+			log.Error().Err(err).Msg("backfill users in rooms failed")
+			return
+		}
+		log.Info().Msg("users in rooms are synced")
+	}()
+	go func() {
+		if err := a.server.Do(ctx); err != nil {
+			log.Error().Err(err).Msg("http server stopped")
+		}
+	}()
+	go func() {
+		if err := a.outbox.Do(ctx); err != nil {
+			log.Error().Err(err).Msg("outbox stopped")
+		}
+	}()
+	go func() {
+		if err := a.summary.Do(ctx); err != nil {
+			log.Error().Err(err).Msg("summary updater stopped")
+		}
+	}()
+	go func() {
+		if err := a.reminder.Do(ctx); err != nil {
+			log.Error().Err(err).Msg("reminder scheduler stopped")
+		}
+	}()
+	go func() {
+		if err := a.digest.Do(ctx); err != nil {
+			log.Error().Err(err).Msg("digest scheduler stopped")
+		}
+	}()
+	go func() {
+		if err := a.checker.Do(ctx); err != nil {
+			log.Error().Err(err).Msg("consistency scheduler stopped")
+		}
+	}()
+	return a.listener.Do(ctx)
+}
+
 type tgLogger struct {
 	zerolog.Logger
 }
@@ -60,7 +143,8 @@ func (i *tgLogger) Println(v ...interface{}) { i.Print(v...) }
 
 func initTelegramApi(cfg *config, bcfg *bot.Config) (*tbapi.BotAPI, error) {
 	_ = tbapi.SetLogger(&tgLogger{log.Output(zerolog.ConsoleWriter{Out: os.Stdout})})
-	tbAPI, err := tbapi.NewBotAPI(cfg.TgToken)
+	client := &http.Client{Transport: metrics.Transport(http.DefaultTransport)}
+	tbAPI, err := tbapi.NewBotAPIWithClient(cfg.TgToken, client)
 	if err != nil {
 		log.Error().Err(err).Msg("can't make telegram bot")
 		return nil, err
@@ -73,7 +157,8 @@ func initTelegramApi(cfg *config, bcfg *bot.Config) (*tbapi.BotAPI, error) {
 	return tbAPI, nil
 }
 
-func initTelegramConfig(tbAPI *tbapi.BotAPI, bots []bot.Interface, bs events.ButtonService, us events.UserService, cs events.ChatStateService) (*events.TelegramListener, error) {
+func initTelegramConfig(tbAPI *tbapi.BotAPI, bots []bot.Interface, bs events.ButtonService, us events.UserService, cs events.ChatStateService,
+	ss events.SummaryService, o *events.Outbox, j *events.Journal) (*events.TelegramListener, error) {
 	multiBot := bot.MultiBot(bots)
 
 	tgListener := &events.TelegramListener{
@@ -82,11 +167,85 @@ func initTelegramConfig(tbAPI *tbapi.BotAPI, bots []bot.Interface, bs events.But
 		ChatStateService: cs,
 		ButtonService:    bs,
 		UserService:      us,
+		SummaryService:   ss,
+		Outbox:           o,
+		Journal:          j,
 	}
 
 	return tgListener, nil
 }
 
+func initSummaryUpdater(o *events.Outbox, ss events.SummaryService, changes service.RoomChanges) *events.SummaryUpdater {
+	return &events.SummaryUpdater{
+		Outbox:         o,
+		SummaryService: ss,
+		Changes:        changes,
+	}
+}
+
+func initReminderScheduler(o *events.Outbox, rs events.ReminderService, c *config) *events.ReminderScheduler {
+	return &events.ReminderScheduler{
+		Outbox:          o,
+		ReminderService: rs,
+		Interval:        c.ReminderCheckInterval,
+	}
+}
+
+func initDigestScheduler(o *events.Outbox, ds events.DigestService, c *config) *events.DigestScheduler {
+	return &events.DigestScheduler{
+		Outbox:        o,
+		DigestService: ds,
+		Interval:      c.DigestCheckInterval,
+	}
+}
+
+func initConsistencyScheduler(o *events.Outbox, cs events.ConsistencyService, c *config) *events.ConsistencyScheduler {
+	return &events.ConsistencyScheduler{
+		Outbox:             o,
+		ConsistencyService: cs,
+		Interval:           c.ConsistencyInterval,
+	}
+}
+
+func initOutbox(tbAPI *tbapi.BotAPI, os events.OutboxService, c *config) *events.Outbox {
+	return &events.Outbox{
+		TbAPI:         tbAPI,
+		OutboxService: os,
+		Limiter:       events.NewLimiter(c.SendGlobalRate, c.SendChatRate, c.SendGroupRate/60),
+		Interval:      c.OutboxInterval,
+		MaxAttempts:   c.OutboxMaxAttempts,
+	}
+}
+
+// initJournal returns the journal of incoming updates, it is nil if the file is not set
+func initJournal(c *config) (*events.Journal, func()) {
+	if c.JournalFile == "" {
+		return nil, func() {}
+	}
+	j := &events.Journal{Path: c.JournalFile, MaxSize: c.JournalMaxSize << 20, MaxFiles: c.JournalMaxFiles}
+	return j, func() {
+		if err := j.Close(); err != nil {
+			log.Error().Err(err).Msg("can't close journal")
+		}
+	}
+}
+
+// initMetricsServer serves metrics and health checks of mongo and telegram
+func initMetricsServer(cfg *config, db *mongo.Database, tbAPI *tbapi.BotAPI) *metrics.Server {
+	return &metrics.Server{
+		Listen: cfg.Listen,
+		Checks: map[string]metrics.Check{
+			"mongo": func(ctx context.Context) error {
+				return db.Client().Ping(ctx, nil)
+			},
+			"telegram": func(ctx context.Context) error {
+				_, err := tbAPI.GetMe()
+				return err
+			},
+		},
+	}
+}
+
 func initLogger(c *config) error {
 	log.Debug().Msg("initialize logger")
 	logLvl, err := zerolog.ParseLevel(strings.ToLower(c.LogLevel))
@@ -105,7 +264,7 @@ func initLogger(c *config) error {
 }
 
 func initMongoConnection(ctx context.Context, cfg *config) (*mongo.Database, func(), error) {
-	client, err := mongo.NewClient(options.Client().ApplyURI(cfg.DbAddr))
+	client, err := mongo.NewClient(options.Client().ApplyURI(cfg.DbAddr).SetMonitor(metrics.MongoMonitor()))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -128,13 +287,32 @@ func initMongoConnection(ctx context.Context, cfg *config) (*mongo.Database, fun
 	}, nil
 }
 
+// quietHoursLocation returns the time zone of quiet hours, it is UTC if the zone is unknown
+func quietHoursLocation(c *config) *time.Location {
+	loc, err := time.LoadLocation(c.QuietHoursTimeZone)
+	if err != nil {
+		log.Error().Err(err).Msgf("unknown time zone of quiet hours %q, UTC is used", c.QuietHoursTimeZone)
+		return time.UTC
+	}
+	return loc
+}
+
 func initBotConfig(c *config) *bot.Config {
 	cfg := &bot.Config{
-		SuperUsers: c.SuperUsers,
+		SuperUsers:           c.SuperUsers,
+		ReminderIntervalDays: c.ReminderIntervalDays,
+		QuietHoursFrom:       c.QuietHoursFrom,
+		QuietHoursTo:         c.QuietHoursTo,
+		QuietHoursLocation:   quietHoursLocation(c),
+		MaskBankDetails:      c.MaskBankDetails,
 	}
 	return cfg
 }
 
+func initKeyring(c *config) (*secret.Keyring, error) {
+	return secret.NewKeyring(c.MasterKeys)
+}
+
 func initI18n(c *config) {
 	languages := map[string]string{
 		language.English.String(): "English",
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/almaznur91/splitty/internal/events"
+	"github.com/rs/zerolog/log"
+)
+
+// replay feeds the journal of updates through bots against the scratch database and writes responses of the bot.
+// Responses are compared with the previous replay, e.g. made by another version of the bot
+func replay(ctx context.Context, cfg *config, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	journal := fs.String("journal", cfg.JournalFile, "journal of updates to replay")
+	db := fs.String("db", "splitty_replay", "scratch database, it is dropped before the replay")
+	keep := fs.Bool("keep", false, "keep the scratch database, e.g. restored from the dump")
+	out := fs.String("out", "responses.jsonl", "file for responses of the bot")
+	diff := fs.String("diff", "", "responses of the previous replay to compare with")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *journal == "" {
+		return fmt.Errorf("journal is not set")
+	}
+	if *db == cfg.DbName {
+		return fmt.Errorf("scratch database %s is the database of the bot", *db)
+	}
+
+	entries, err := events.ReadJournal(*journal)
+	if err != nil {
+		return err
+	}
+	cfg.DbName = *db
+	if !*keep {
+		if err := dropDatabase(ctx, cfg); err != nil {
+			return err
+		}
+	}
+
+	r, cleanup, err := initReplay(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	responses := r.Replay(ctx, entries)
+	if err := events.WriteResponses(*out, responses); err != nil {
+		return err
+	}
+	log.Info().Msgf("%d updates are replayed, %d responses are written to %s", len(entries), len(responses), *out)
+
+	if *diff == "" {
+		return nil
+	}
+	before, err := events.ReadResponses(*diff)
+	if err != nil {
+		return err
+	}
+	if d := events.DiffResponses(before, responses); d != "" {
+		fmt.Print(d)
+		return fmt.Errorf("responses differ from %s", *diff)
+	}
+	log.Info().Msgf("responses are the same as in %s", *diff)
+	return nil
+}
+
+func dropDatabase(ctx context.Context, cfg *config) error {
+	db, cleanup, err := initMongoConnection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return db.Drop(ctx)
+}
@@ -7,31 +7,55 @@ import (
 	"github.com/almaznur91/splitty/internal/bot"
 	"github.com/almaznur91/splitty/internal/events"
 	"github.com/almaznur91/splitty/internal/repository"
+	"github.com/almaznur91/splitty/internal/secret"
 	"github.com/almaznur91/splitty/internal/service"
 	"github.com/google/wire"
 )
 
-func initApp(ctx context.Context, cfg *config) (tg *events.TelegramListener, closer func(), err error) {
-	wire.Build(initMongoConnection, initTelegramApi, initTelegramConfig, initBotConfig,
-		service.NewUserService, wire.Bind(new(bot.UserService), new(*service.UserService)),
-		wire.Bind(new(events.UserService), new(*service.UserService)),
-		service.NewRoomService, wire.Bind(new(bot.RoomService), new(*service.RoomService)),
-		service.NewChatStateService, wire.Bind(new(bot.ChatStateService), new(*service.ChatStateService)),
-		service.NewButtonService, wire.Bind(new(bot.ButtonService), new(*service.ButtonService)),
-		service.NewOperationService, wire.Bind(new(bot.OperationService), new(*service.OperationService)),
-		service.NewStatisticService, wire.Bind(new(bot.StatisticService), new(*service.StatisticService)),
-		service.NewRoomStateService, wire.Bind(new(bot.RoomStateService), new(*service.RoomStateService)),
-		wire.Bind(new(events.ChatStateService), new(*service.ChatStateService)),
-		wire.Bind(new(events.ButtonService), new(*service.ButtonService)),
-		ProvideBotList, bots,
-		repository.NewUserRepository, wire.Bind(new(repository.UserRepository), new(*repository.MongoUserRepository)),
-		repository.NewRoomRepository, wire.Bind(new(repository.RoomRepository), new(*repository.MongoRoomRepository)),
-		repository.NewChatStateRepository, wire.Bind(new(repository.ChatStateRepository), new(*repository.MongoChatStateRepository)),
-		repository.NewButtonRepository, wire.Bind(new(repository.ButtonRepository), new(*repository.MongoButtonRepository)),
+func initApp(ctx context.Context, cfg *config) (app *application, closer func(), err error) {
+	wire.Build(newApplication, initMongoConnection, initKeyring, initTelegramApi, initTelegramConfig, initBotConfig, initSummaryUpdater,
+		initReminderScheduler, initDigestScheduler, initConsistencyScheduler, initOutbox, initMetricsServer, initJournal,
+		bot.NewDebtReminder, wire.Bind(new(events.ReminderService), new(*bot.DebtReminder)),
+		bot.NewSpendingDigest, wire.Bind(new(events.DigestService), new(*bot.SpendingDigest)),
+		bot.NewConsistencyReport, wire.Bind(new(events.ConsistencyService), new(*bot.ConsistencyReport)),
+		services, ProvideBotList, bots,
 	)
 	return nil, nil, nil
 }
 
+func initReplay(ctx context.Context, cfg *config) (r *events.Replayer, closer func(), err error) {
+	wire.Build(events.NewReplayer, initMongoConnection, initKeyring, initBotConfig,
+		services, ProvideBotList, bots,
+	)
+	return nil, nil, nil
+}
+
+// services are shared by the bot and the replay of updates
+var services = wire.NewSet(
+	service.NewUserService, wire.Bind(new(bot.UserService), new(*service.UserService)),
+	wire.Bind(new(events.UserService), new(*service.UserService)),
+	service.NewRoomService, wire.Bind(new(bot.RoomService), new(*service.RoomService)),
+	wire.Bind(new(bot.ValidationService), new(*service.RoomService)),
+	service.NewChatStateService, wire.Bind(new(bot.ChatStateService), new(*service.ChatStateService)),
+	service.NewButtonService, wire.Bind(new(bot.ButtonService), new(*service.ButtonService)),
+	service.NewOperationService, wire.Bind(new(bot.OperationService), new(*service.OperationService)),
+	service.NewStatisticService, wire.Bind(new(bot.StatisticService), new(*service.StatisticService)),
+	service.NewRoomStateService, wire.Bind(new(bot.RoomStateService), new(*service.RoomStateService)),
+	wire.Bind(new(events.ChatStateService), new(*service.ChatStateService)),
+	wire.Bind(new(events.ButtonService), new(*service.ButtonService)),
+	service.NewOutboxService, wire.Bind(new(events.OutboxService), new(*service.OutboxService)),
+	service.NewAdminService, wire.Bind(new(bot.AdminService), new(*service.AdminService)),
+	service.NewRoomChanges,
+	bot.NewRoomSummary, wire.Bind(new(events.SummaryService), new(*bot.RoomSummary)),
+	repository.NewUserRepository, wire.Bind(new(repository.UserRepository), new(*repository.MongoUserRepository)),
+	wire.Bind(new(repository.Cipher), new(*secret.Keyring)),
+	repository.NewRoomRepository, wire.Bind(new(repository.RoomRepository), new(*repository.MongoRoomRepository)),
+	repository.NewChatStateRepository, wire.Bind(new(repository.ChatStateRepository), new(*repository.MongoChatStateRepository)),
+	repository.NewButtonRepository, wire.Bind(new(repository.ButtonRepository), new(*repository.MongoButtonRepository)),
+	repository.NewOutboxRepository, wire.Bind(new(repository.OutboxRepository), new(*repository.MongoOutboxRepository)),
+	repository.NewAuditRepository, wire.Bind(new(repository.AuditRepository), new(*repository.MongoAuditRepository)),
+)
+
 var bots = wire.NewSet(
 	bot.NewStartScreen,
 	bot.NewRoomCreating,
@@ -75,6 +99,60 @@ var bots = wire.NewSet(
 	bot.NewWantSetBankDetails,
 	bot.NewSetBankDetails,
 	bot.NewViewBankDetails,
+	bot.NewWantAddVirtualMember,
+	bot.NewAddVirtualMember,
+	bot.NewMergeVirtualMember,
+	bot.NewWantAddCoPayer,
+	bot.NewChooseCoPayer,
+	bot.NewAddCoPayer,
+	bot.NewEditOperationItem,
+	bot.NewWantEditOperationField,
+	bot.NewEditOperationField,
+	bot.NewLinkGroupChat,
+	bot.NewGroupAddOperation,
+	bot.NewGroupDebts,
+	bot.NewGroupSettle,
+	bot.NewGroupEditOperation,
+	bot.NewGroupSummary,
+	bot.NewPostRoomSummary,
+	bot.NewReviewRepayment,
+	bot.NewNudgeDebtor,
+	bot.NewReminderSetting,
+	bot.NewViewBalances,
+	bot.NewViewBalance,
+	bot.NewSettleBalance,
+	bot.NewChooseOperationCategory,
+	bot.NewSetOperationCategory,
+	bot.NewRoomCategories,
+	bot.NewRemoveRoomCategory,
+	bot.NewWantAddRoomCategory,
+	bot.NewAddRoomCategory,
+	bot.NewStatisticChart,
+	bot.NewBudgetSetting,
+	bot.NewChooseBudgetTarget,
+	bot.NewWantSetBudget,
+	bot.NewSetBudget,
+	bot.NewChooseDigest,
+	bot.NewAdminHelp,
+	bot.NewAdminStats,
+	bot.NewAdminRoom,
+	bot.NewAdminUser,
+	bot.NewAdminBroadcast,
+	bot.NewAdminBan,
+	bot.NewAdminCheck,
+	bot.NewPersonalData,
+	bot.NewExportPersonalData,
+	bot.NewWantDeleteAccount,
+	bot.NewDeleteAccount,
+	bot.NewPaymentMethods,
+	bot.NewWantAddPaymentMethod,
+	bot.NewAddPaymentMethod,
+	bot.NewDeletePaymentMethod,
+	bot.NewConfirmExpense,
+	bot.NewCancelExpense,
+	bot.NewRoomCurrency,
+	bot.NewReviewSettlement,
+	bot.NewGroupDeleteOperation,
 )
 
 func ProvideBotList(
@@ -120,7 +198,61 @@ func ProvideBotList(
 	b41 *bot.ViewBankDetails,
 	b42 *bot.SetBankDetails,
 	b43 *bot.WantSetBankDetails,
+	b44 *bot.WantAddVirtualMember,
+	b45 *bot.AddVirtualMember,
+	b46 *bot.MergeVirtualMember,
+	b47 *bot.WantAddCoPayer,
+	b48 *bot.ChooseCoPayer,
+	b49 *bot.AddCoPayer,
+	b50 *bot.EditOperationItem,
+	b51 *bot.WantEditOperationField,
+	b52 *bot.EditOperationField,
+	b53 *bot.LinkGroupChat,
+	b54 *bot.GroupAddOperation,
+	b55 *bot.GroupDebts,
+	b56 *bot.GroupSettle,
+	b57 *bot.GroupEditOperation,
+	b58 *bot.GroupSummary,
+	b59 *bot.PostRoomSummary,
+	b60 *bot.ReviewRepayment,
+	b61 *bot.NudgeDebtor,
+	b62 *bot.ReminderSetting,
+	b63 *bot.ViewBalances,
+	b64 *bot.ViewBalance,
+	b65 *bot.SettleBalance,
+	b66 *bot.ChooseOperationCategory,
+	b67 *bot.SetOperationCategory,
+	b68 *bot.RoomCategories,
+	b69 *bot.RemoveRoomCategory,
+	b70 *bot.WantAddRoomCategory,
+	b71 *bot.AddRoomCategory,
+	b72 *bot.StatisticChart,
+	b73 *bot.BudgetSetting,
+	b74 *bot.ChooseBudgetTarget,
+	b75 *bot.WantSetBudget,
+	b76 *bot.SetBudget,
+	b77 *bot.ChooseDigest,
+	b78 *bot.AdminHelp,
+	b79 *bot.AdminStats,
+	b80 *bot.AdminRoom,
+	b81 *bot.AdminUser,
+	b82 *bot.AdminBroadcast,
+	b83 *bot.AdminBan,
+	b84 *bot.AdminCheck,
+	b85 *bot.PersonalData,
+	b86 *bot.ExportPersonalData,
+	b87 *bot.WantDeleteAccount,
+	b88 *bot.DeleteAccount,
+	b89 *bot.PaymentMethods,
+	b90 *bot.WantAddPaymentMethod,
+	b91 *bot.AddPaymentMethod,
+	b92 *bot.DeletePaymentMethod,
+	b93 *bot.ConfirmExpense,
+	b94 *bot.CancelExpense,
+	b95 *bot.RoomCurrency,
+	b96 *bot.ReviewSettlement,
+	b97 *bot.GroupDeleteOperation,
 ) []bot.Interface {
 	return []bot.Interface{b1, b2, b3, b4, b5, b6, b8, b9, b10, b11, b12, b13, b14, b15, b16, b17, b18, b19, b20,
-		b21, b22, b23, b24, b25, b26, b27, b28, b29, b30, b31, b32, b33, b34, b35, b36, b37, b38, b39, b40, b41, b42, b43}
+		b21, b22, b23, b24, b25, b26, b27, b28, b29, b30, b31, b32, b33, b34, b35, b36, b37, b38, b39, b40, b41, b42, b43, b44, b45, b46, b47, b48, b49, b50, b51, b52, b53, b54, b55, b56, b57, b58, b59, b60, b61, b62, b63, b64, b65, b66, b67, b68, b69, b70, b71, b72, b73, b74, b75, b76, b77, b78, b79, b80, b81, b82, b83, b84, b85, b86, b87, b88, b89, b90, b91, b92, b93, b94, b95, b96, b97}
 }
@@ -11,13 +11,14 @@ import (
 	"github.com/almaznur91/splitty/internal/bot"
 	"github.com/almaznur91/splitty/internal/events"
 	"github.com/almaznur91/splitty/internal/repository"
+	"github.com/almaznur91/splitty/internal/secret"
 	"github.com/almaznur91/splitty/internal/service"
 	"github.com/google/wire"
 )
 
 // Injectors from wire.go:
 
-func initApp(ctx context.Context, cfg *config) (*events.TelegramListener, func(), error) {
+func initApp(ctx context.Context, cfg *config) (*application, func(), error) {
 	botConfig := initBotConfig(cfg)
 	botAPI, err := initTelegramApi(cfg, botConfig)
 	if err != nil {
@@ -32,25 +33,31 @@ func initApp(ctx context.Context, cfg *config) (*events.TelegramListener, func()
 	mongoButtonRepository := repository.NewButtonRepository(database)
 	buttonService := service.NewButtonService(mongoButtonRepository)
 	mongoRoomRepository := repository.NewRoomRepository(database)
-	roomService := service.NewRoomService(mongoRoomRepository)
+	roomChanges := service.NewRoomChanges()
+	roomService := service.NewRoomService(mongoRoomRepository, roomChanges)
 	operation := bot.NewOperation(chatStateService, buttonService, roomService, botConfig)
 	startScreen := bot.NewStartScreen(chatStateService, buttonService, botConfig)
 	roomCreating := bot.NewRoomCreating(chatStateService, buttonService, botConfig)
 	roomSetName := bot.NewRoomSetName(chatStateService, buttonService, roomService, botConfig)
 	joinRoom := bot.NewJoinRoom(chatStateService, buttonService, roomService, botConfig)
-	operationService := service.NewOperationService(mongoRoomRepository)
+	operationService := service.NewOperationService(mongoRoomRepository, roomChanges)
 	statisticService := service.NewStatisticService(roomService, operationService)
 	allRoomInline := bot.NewAllRoomInline(chatStateService, buttonService, roomService, statisticService, botConfig)
 	wantDonorOperation := bot.NewWantDonorOperation(chatStateService, buttonService, operationService, roomService, botConfig)
 	roomStateService := service.NewRoomStateService(operationService, mongoRoomRepository)
 	addDonorOperation := bot.NewAddDonorOperation(chatStateService, buttonService, operationService, roomService, roomStateService, botConfig)
 	editDonorOperation := bot.NewEditDonorOperation(buttonService, operationService, roomService, botConfig)
-	deleteDonorOperation := bot.NewDeleteDonorOperation(chatStateService, buttonService, operationService, roomService, botConfig)
 	viewRoom := bot.NewViewRoom(buttonService, roomService, chatStateService, botConfig)
 	viewAllOperations := bot.NewViewAllOperations(chatStateService, buttonService, operationService, botConfig)
 	allRoom := bot.NewAllRoom(chatStateService, buttonService, roomService, botConfig)
-	mongoUserRepository := repository.NewUserRepository(database)
-	userService := service.NewUserService(mongoUserRepository)
+	keyring, err := initKeyring(cfg)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	mongoUserRepository := repository.NewUserRepository(database, keyring)
+	userService := service.NewUserService(mongoUserRepository, mongoRoomRepository)
+	deleteDonorOperation := bot.NewDeleteDonorOperation(chatStateService, buttonService, operationService, roomService, userService, botConfig)
 	chooseRecepientOperation := bot.NewChooseRecepientOperation(chatStateService, buttonService, userService, operationService, roomService, botConfig)
 	wantReturnDebt := bot.NewWantReturnDebt(chatStateService, userService, buttonService, operationService, roomService, botConfig)
 	addRecepientOperation := bot.NewAddRecepientOperation(chatStateService, buttonService, operationService, userService, roomService, roomStateService, botConfig)
@@ -80,20 +87,249 @@ func initApp(ctx context.Context, cfg *config) (*events.TelegramListener, func()
 	viewBankDetails := bot.NewViewBankDetails(buttonService, chatStateService, botConfig)
 	setBankDetails := bot.NewSetBankDetails(buttonService, userService, chatStateService, botConfig)
 	wantSetBankDetails := bot.NewWantSetBankDetails(buttonService, chatStateService, botConfig)
-	v := ProvideBotList(operation, startScreen, roomCreating, roomSetName, joinRoom, allRoomInline, wantDonorOperation, addDonorOperation, editDonorOperation, deleteDonorOperation, viewRoom, viewAllOperations, allRoom, chooseRecepientOperation, wantReturnDebt, addRecepientOperation, viewUserDebts, viewAllDebts, roomSetting, archiveRoom, archivedRooms, statistic, viewAllDebtOperations, viewMyOperations, debt, userSetting, chooseLanguage, operationAdded, chooseNotification, selectedNotification, debtReturned, wantAddFileToOperation, addFileToOperation, viewFileOperation, viewDonorOperation, selectedLeaveRoom, viewOperationsWithMe, chooseCountInPage, finishedAddOperation, viewBankDetails, setBankDetails, wantSetBankDetails)
-	telegramListener, err := initTelegramConfig(botAPI, v, buttonService, userService, chatStateService)
+	wantAddVirtualMember := bot.NewWantAddVirtualMember(buttonService, chatStateService, botConfig)
+	addVirtualMember := bot.NewAddVirtualMember(buttonService, roomService, chatStateService, botConfig)
+	mergeVirtualMember := bot.NewMergeVirtualMember(roomService, botConfig)
+	wantAddCoPayer := bot.NewWantAddCoPayer(buttonService, roomService, botConfig)
+	chooseCoPayer := bot.NewChooseCoPayer(buttonService, chatStateService, roomService, botConfig)
+	addCoPayer := bot.NewAddCoPayer(buttonService, chatStateService, operationService, roomService, botConfig)
+	editOperationItem := bot.NewEditOperationItem(buttonService, operationService, roomService, botConfig)
+	wantEditOperationField := bot.NewWantEditOperationField(buttonService, chatStateService, roomService, botConfig)
+	editOperationField := bot.NewEditOperationField(buttonService, chatStateService, operationService, roomService, userService, botConfig)
+	linkGroupChat := bot.NewLinkGroupChat(roomService, botConfig)
+	groupAddOperation := bot.NewGroupAddOperation(roomService, buttonService, botConfig)
+	groupDebts := bot.NewGroupDebts(roomService, operationService, botConfig)
+	groupSettle := bot.NewGroupSettle(buttonService, roomService, operationService, roomStateService, botConfig)
+	groupEditOperation := bot.NewGroupEditOperation(buttonService, roomService, operationService, userService, botConfig)
+	groupSummary := bot.NewGroupSummary(roomService, operationService, statisticService, botConfig)
+	postRoomSummary := bot.NewPostRoomSummary(roomService, operationService, statisticService, botConfig)
+	reviewRepayment := bot.NewReviewRepayment(buttonService, operationService, roomService, roomStateService, userService, botConfig)
+	nudgeDebtor := bot.NewNudgeDebtor(buttonService, roomService, operationService, userService, botConfig)
+	reminderSetting := bot.NewReminderSetting(buttonService, roomService, botConfig)
+	viewBalances := bot.NewViewBalances(buttonService, operationService, botConfig)
+	viewBalance := bot.NewViewBalance(buttonService, operationService, botConfig)
+	settleBalance := bot.NewSettleBalance(buttonService, operationService, roomService, roomStateService, userService, botConfig)
+	chooseOperationCategory := bot.NewChooseOperationCategory(buttonService, roomService, botConfig)
+	setOperationCategory := bot.NewSetOperationCategory(operationService, roomService, botConfig)
+	roomCategories := bot.NewRoomCategories(buttonService, roomService, botConfig)
+	removeRoomCategory := bot.NewRemoveRoomCategory(roomService, botConfig)
+	wantAddRoomCategory := bot.NewWantAddRoomCategory(buttonService, chatStateService, botConfig)
+	addRoomCategory := bot.NewAddRoomCategory(buttonService, roomService, chatStateService, botConfig)
+	statisticChart := bot.NewStatisticChart(roomService, operationService, statisticService, botConfig)
+	budgetSetting := bot.NewBudgetSetting(buttonService, roomService, operationService, botConfig)
+	chooseBudgetTarget := bot.NewChooseBudgetTarget(buttonService, roomService, botConfig)
+	wantSetBudget := bot.NewWantSetBudget(buttonService, chatStateService, roomService, botConfig)
+	setBudget := bot.NewSetBudget(buttonService, chatStateService, roomService, botConfig)
+	chooseDigest := bot.NewChooseDigest(buttonService, userService, botConfig)
+	mongoAuditRepository := repository.NewAuditRepository(database)
+	adminService := service.NewAdminService(mongoAuditRepository, mongoUserRepository, mongoRoomRepository)
+	adminHelp := bot.NewAdminHelp(botConfig)
+	adminStats := bot.NewAdminStats(adminService, botConfig)
+	adminRoom := bot.NewAdminRoom(adminService, roomService, operationService, roomStateService, botConfig)
+	adminUser := bot.NewAdminUser(adminService, userService, roomService, botConfig)
+	adminBroadcast := bot.NewAdminBroadcast(adminService, userService, botConfig)
+	adminBan := bot.NewAdminBan(adminService, userService, roomService, botConfig)
+	adminCheck := bot.NewAdminCheck(adminService, roomService, botConfig)
+	personalData := bot.NewPersonalData(buttonService, botConfig)
+	exportPersonalData := bot.NewExportPersonalData(userService, botConfig)
+	wantDeleteAccount := bot.NewWantDeleteAccount(buttonService, userService, botConfig)
+	deleteAccount := bot.NewDeleteAccount(userService, chatStateService, botConfig)
+	paymentMethods := bot.NewPaymentMethods(buttonService, userService, botConfig)
+	wantAddPaymentMethod := bot.NewWantAddPaymentMethod(buttonService, chatStateService, botConfig)
+	addPaymentMethod := bot.NewAddPaymentMethod(buttonService, userService, chatStateService, botConfig)
+	deletePaymentMethod := bot.NewDeletePaymentMethod(userService, botConfig)
+	confirmExpense := bot.NewConfirmExpense(buttonService, operationService, roomService, roomStateService, botConfig)
+	cancelExpense := bot.NewCancelExpense(botConfig)
+	roomCurrency := bot.NewRoomCurrency(buttonService, roomService, botConfig)
+	reviewSettlement := bot.NewReviewSettlement(operationService, roomService, roomStateService, userService, botConfig)
+	groupDeleteOperation := bot.NewGroupDeleteOperation(roomService, operationService, userService, botConfig)
+	v := ProvideBotList(operation, startScreen, roomCreating, roomSetName, joinRoom, allRoomInline, wantDonorOperation, addDonorOperation, editDonorOperation, deleteDonorOperation, viewRoom, viewAllOperations, allRoom, chooseRecepientOperation, wantReturnDebt, addRecepientOperation, viewUserDebts, viewAllDebts, roomSetting, archiveRoom, archivedRooms, statistic, viewAllDebtOperations, viewMyOperations, debt, userSetting, chooseLanguage, operationAdded, chooseNotification, selectedNotification, debtReturned, wantAddFileToOperation, addFileToOperation, viewFileOperation, viewDonorOperation, selectedLeaveRoom, viewOperationsWithMe, chooseCountInPage, finishedAddOperation, viewBankDetails, setBankDetails, wantSetBankDetails, wantAddVirtualMember, addVirtualMember, mergeVirtualMember, wantAddCoPayer, chooseCoPayer, addCoPayer, editOperationItem, wantEditOperationField, editOperationField, linkGroupChat, groupAddOperation, groupDebts, groupSettle, groupEditOperation, groupSummary, postRoomSummary, reviewRepayment, nudgeDebtor, reminderSetting, viewBalances, viewBalance, settleBalance, chooseOperationCategory, setOperationCategory, roomCategories, removeRoomCategory, wantAddRoomCategory, addRoomCategory, statisticChart, budgetSetting, chooseBudgetTarget, wantSetBudget, setBudget, chooseDigest, adminHelp, adminStats, adminRoom, adminUser, adminBroadcast, adminBan, adminCheck, personalData, exportPersonalData, wantDeleteAccount, deleteAccount, paymentMethods, wantAddPaymentMethod, addPaymentMethod, deletePaymentMethod, confirmExpense, cancelExpense, roomCurrency, reviewSettlement, groupDeleteOperation)
+	roomSummary := bot.NewRoomSummary(buttonService, roomService, operationService, statisticService, botConfig)
+	mongoOutboxRepository := repository.NewOutboxRepository(database, keyring)
+	outboxService := service.NewOutboxService(mongoOutboxRepository)
+	outbox := initOutbox(botAPI, outboxService, cfg)
+	journal, cleanup2 := initJournal(cfg)
+	telegramListener, err := initTelegramConfig(botAPI, v, buttonService, userService, chatStateService, roomSummary, outbox, journal)
 	if err != nil {
+		cleanup2()
 		cleanup()
 		return nil, nil, err
 	}
-	return telegramListener, func() {
+	summaryUpdater := initSummaryUpdater(outbox, roomSummary, roomChanges)
+	debtReminder := bot.NewDebtReminder(buttonService, roomService, operationService, userService, botConfig)
+	reminderScheduler := initReminderScheduler(outbox, debtReminder, cfg)
+	spendingDigest := bot.NewSpendingDigest(buttonService, userService, statisticService, botConfig)
+	digestScheduler := initDigestScheduler(outbox, spendingDigest, cfg)
+	consistencyReport := bot.NewConsistencyReport(adminService, roomService, userService, botConfig)
+	consistencyScheduler := initConsistencyScheduler(outbox, consistencyReport, cfg)
+	server := initMetricsServer(cfg, database, botAPI)
+	mainApplication := newApplication(telegramListener, summaryUpdater, reminderScheduler, digestScheduler, consistencyScheduler, outbox, server, userService)
+	return mainApplication, func() {
+		cleanup2()
+		cleanup()
+	}, nil
+}
+
+func initReplay(ctx context.Context, cfg *config) (*events.Replayer, func(), error) {
+	botConfig := initBotConfig(cfg)
+	database, cleanup, err := initMongoConnection(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	mongoChatStateRepository := repository.NewChatStateRepository(database)
+	chatStateService := service.NewChatStateService(mongoChatStateRepository)
+	mongoButtonRepository := repository.NewButtonRepository(database)
+	buttonService := service.NewButtonService(mongoButtonRepository)
+	mongoRoomRepository := repository.NewRoomRepository(database)
+	roomChanges := service.NewRoomChanges()
+	roomService := service.NewRoomService(mongoRoomRepository, roomChanges)
+	operation := bot.NewOperation(chatStateService, buttonService, roomService, botConfig)
+	startScreen := bot.NewStartScreen(chatStateService, buttonService, botConfig)
+	roomCreating := bot.NewRoomCreating(chatStateService, buttonService, botConfig)
+	roomSetName := bot.NewRoomSetName(chatStateService, buttonService, roomService, botConfig)
+	joinRoom := bot.NewJoinRoom(chatStateService, buttonService, roomService, botConfig)
+	operationService := service.NewOperationService(mongoRoomRepository, roomChanges)
+	statisticService := service.NewStatisticService(roomService, operationService)
+	allRoomInline := bot.NewAllRoomInline(chatStateService, buttonService, roomService, statisticService, botConfig)
+	wantDonorOperation := bot.NewWantDonorOperation(chatStateService, buttonService, operationService, roomService, botConfig)
+	roomStateService := service.NewRoomStateService(operationService, mongoRoomRepository)
+	addDonorOperation := bot.NewAddDonorOperation(chatStateService, buttonService, operationService, roomService, roomStateService, botConfig)
+	editDonorOperation := bot.NewEditDonorOperation(buttonService, operationService, roomService, botConfig)
+	viewRoom := bot.NewViewRoom(buttonService, roomService, chatStateService, botConfig)
+	viewAllOperations := bot.NewViewAllOperations(chatStateService, buttonService, operationService, botConfig)
+	allRoom := bot.NewAllRoom(chatStateService, buttonService, roomService, botConfig)
+	keyring, err := initKeyring(cfg)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	mongoUserRepository := repository.NewUserRepository(database, keyring)
+	userService := service.NewUserService(mongoUserRepository, mongoRoomRepository)
+	deleteDonorOperation := bot.NewDeleteDonorOperation(chatStateService, buttonService, operationService, roomService, userService, botConfig)
+	chooseRecepientOperation := bot.NewChooseRecepientOperation(chatStateService, buttonService, userService, operationService, roomService, botConfig)
+	wantReturnDebt := bot.NewWantReturnDebt(chatStateService, userService, buttonService, operationService, roomService, botConfig)
+	addRecepientOperation := bot.NewAddRecepientOperation(chatStateService, buttonService, operationService, userService, roomService, roomStateService, botConfig)
+	viewUserDebts := bot.NewViewUserDebts(chatStateService, buttonService, operationService, botConfig)
+	viewAllDebts := bot.NewViewAllDebts(chatStateService, buttonService, operationService, botConfig)
+	roomSetting := bot.NewRoomSetting(buttonService, roomService, chatStateService, botConfig)
+	archiveRoom := bot.NewArchiveRoom(buttonService, roomStateService, roomService, chatStateService, botConfig, roomSetting)
+	archivedRooms := bot.NewArchivedRooms(chatStateService, buttonService, roomService, botConfig)
+	statistic := bot.NewStatistic(buttonService, roomService, chatStateService, statisticService, botConfig)
+	viewAllDebtOperations := bot.NewViewAllDebtOperations(chatStateService, buttonService, operationService, botConfig)
+	viewMyOperations := bot.NewViewMyOperations(chatStateService, buttonService, operationService, botConfig)
+	debt := bot.NewDebt(chatStateService, buttonService, operationService, botConfig)
+	userSetting := bot.NewUserSetting(buttonService, userService, chatStateService, botConfig)
+	chooseLanguage := bot.NewChooseLanguage(buttonService, roomService, chatStateService, botConfig)
+	operationAdded := bot.NewOperationAdded(chatStateService, buttonService, roomService, operationService, userService, botConfig)
+	chooseNotification := bot.NewChooseNotification(buttonService, chatStateService, botConfig)
+	selectedNotification := bot.NewSelectedNotification(buttonService, userService, chatStateService, botConfig)
+	debtReturned := bot.NewDebtReturned()
+	wantAddFileToOperation := bot.NewWantAddFileToOperation(chatStateService, buttonService, roomService, operationService, botConfig)
+	addFileToOperation := bot.NewAddFileToOperation(chatStateService, buttonService, roomService, operationService, botConfig)
+	viewFileOperation := bot.NewViewFileOperation(chatStateService, buttonService, roomService, operationService, botConfig)
+	viewDonorOperation := bot.NewViewDonorOperation(buttonService, operationService, roomService, botConfig)
+	selectedLeaveRoom := bot.NewSelectedLeaveRoom(buttonService, userService, roomService, chatStateService, botConfig)
+	viewOperationsWithMe := bot.NewViewOperationsWithMe(chatStateService, buttonService, operationService, botConfig)
+	chooseCountInPage := bot.NewChooseCountInPage(buttonService, chatStateService, userService, botConfig)
+	finishedAddOperation := bot.NewFinishedAddOperation(buttonService, chatStateService, roomService, roomStateService, userService, botConfig)
+	viewBankDetails := bot.NewViewBankDetails(buttonService, chatStateService, botConfig)
+	setBankDetails := bot.NewSetBankDetails(buttonService, userService, chatStateService, botConfig)
+	wantSetBankDetails := bot.NewWantSetBankDetails(buttonService, chatStateService, botConfig)
+	wantAddVirtualMember := bot.NewWantAddVirtualMember(buttonService, chatStateService, botConfig)
+	addVirtualMember := bot.NewAddVirtualMember(buttonService, roomService, chatStateService, botConfig)
+	mergeVirtualMember := bot.NewMergeVirtualMember(roomService, botConfig)
+	wantAddCoPayer := bot.NewWantAddCoPayer(buttonService, roomService, botConfig)
+	chooseCoPayer := bot.NewChooseCoPayer(buttonService, chatStateService, roomService, botConfig)
+	addCoPayer := bot.NewAddCoPayer(buttonService, chatStateService, operationService, roomService, botConfig)
+	editOperationItem := bot.NewEditOperationItem(buttonService, operationService, roomService, botConfig)
+	wantEditOperationField := bot.NewWantEditOperationField(buttonService, chatStateService, roomService, botConfig)
+	editOperationField := bot.NewEditOperationField(buttonService, chatStateService, operationService, roomService, userService, botConfig)
+	linkGroupChat := bot.NewLinkGroupChat(roomService, botConfig)
+	groupAddOperation := bot.NewGroupAddOperation(roomService, buttonService, botConfig)
+	groupDebts := bot.NewGroupDebts(roomService, operationService, botConfig)
+	groupSettle := bot.NewGroupSettle(buttonService, roomService, operationService, roomStateService, botConfig)
+	groupEditOperation := bot.NewGroupEditOperation(buttonService, roomService, operationService, userService, botConfig)
+	groupSummary := bot.NewGroupSummary(roomService, operationService, statisticService, botConfig)
+	postRoomSummary := bot.NewPostRoomSummary(roomService, operationService, statisticService, botConfig)
+	reviewRepayment := bot.NewReviewRepayment(buttonService, operationService, roomService, roomStateService, userService, botConfig)
+	nudgeDebtor := bot.NewNudgeDebtor(buttonService, roomService, operationService, userService, botConfig)
+	reminderSetting := bot.NewReminderSetting(buttonService, roomService, botConfig)
+	viewBalances := bot.NewViewBalances(buttonService, operationService, botConfig)
+	viewBalance := bot.NewViewBalance(buttonService, operationService, botConfig)
+	settleBalance := bot.NewSettleBalance(buttonService, operationService, roomService, roomStateService, userService, botConfig)
+	chooseOperationCategory := bot.NewChooseOperationCategory(buttonService, roomService, botConfig)
+	setOperationCategory := bot.NewSetOperationCategory(operationService, roomService, botConfig)
+	roomCategories := bot.NewRoomCategories(buttonService, roomService, botConfig)
+	removeRoomCategory := bot.NewRemoveRoomCategory(roomService, botConfig)
+	wantAddRoomCategory := bot.NewWantAddRoomCategory(buttonService, chatStateService, botConfig)
+	addRoomCategory := bot.NewAddRoomCategory(buttonService, roomService, chatStateService, botConfig)
+	statisticChart := bot.NewStatisticChart(roomService, operationService, statisticService, botConfig)
+	budgetSetting := bot.NewBudgetSetting(buttonService, roomService, operationService, botConfig)
+	chooseBudgetTarget := bot.NewChooseBudgetTarget(buttonService, roomService, botConfig)
+	wantSetBudget := bot.NewWantSetBudget(buttonService, chatStateService, roomService, botConfig)
+	setBudget := bot.NewSetBudget(buttonService, chatStateService, roomService, botConfig)
+	chooseDigest := bot.NewChooseDigest(buttonService, userService, botConfig)
+	mongoAuditRepository := repository.NewAuditRepository(database)
+	adminService := service.NewAdminService(mongoAuditRepository, mongoUserRepository, mongoRoomRepository)
+	adminHelp := bot.NewAdminHelp(botConfig)
+	adminStats := bot.NewAdminStats(adminService, botConfig)
+	adminRoom := bot.NewAdminRoom(adminService, roomService, operationService, roomStateService, botConfig)
+	adminUser := bot.NewAdminUser(adminService, userService, roomService, botConfig)
+	adminBroadcast := bot.NewAdminBroadcast(adminService, userService, botConfig)
+	adminBan := bot.NewAdminBan(adminService, userService, roomService, botConfig)
+	adminCheck := bot.NewAdminCheck(adminService, roomService, botConfig)
+	personalData := bot.NewPersonalData(buttonService, botConfig)
+	exportPersonalData := bot.NewExportPersonalData(userService, botConfig)
+	wantDeleteAccount := bot.NewWantDeleteAccount(buttonService, userService, botConfig)
+	deleteAccount := bot.NewDeleteAccount(userService, chatStateService, botConfig)
+	paymentMethods := bot.NewPaymentMethods(buttonService, userService, botConfig)
+	wantAddPaymentMethod := bot.NewWantAddPaymentMethod(buttonService, chatStateService, botConfig)
+	addPaymentMethod := bot.NewAddPaymentMethod(buttonService, userService, chatStateService, botConfig)
+	deletePaymentMethod := bot.NewDeletePaymentMethod(userService, botConfig)
+	confirmExpense := bot.NewConfirmExpense(buttonService, operationService, roomService, roomStateService, botConfig)
+	cancelExpense := bot.NewCancelExpense(botConfig)
+	roomCurrency := bot.NewRoomCurrency(buttonService, roomService, botConfig)
+	reviewSettlement := bot.NewReviewSettlement(operationService, roomService, roomStateService, userService, botConfig)
+	groupDeleteOperation := bot.NewGroupDeleteOperation(roomService, operationService, userService, botConfig)
+	v := ProvideBotList(operation, startScreen, roomCreating, roomSetName, joinRoom, allRoomInline, wantDonorOperation, addDonorOperation, editDonorOperation, deleteDonorOperation, viewRoom, viewAllOperations, allRoom, chooseRecepientOperation, wantReturnDebt, addRecepientOperation, viewUserDebts, viewAllDebts, roomSetting, archiveRoom, archivedRooms, statistic, viewAllDebtOperations, viewMyOperations, debt, userSetting, chooseLanguage, operationAdded, chooseNotification, selectedNotification, debtReturned, wantAddFileToOperation, addFileToOperation, viewFileOperation, viewDonorOperation, selectedLeaveRoom, viewOperationsWithMe, chooseCountInPage, finishedAddOperation, viewBankDetails, setBankDetails, wantSetBankDetails, wantAddVirtualMember, addVirtualMember, mergeVirtualMember, wantAddCoPayer, chooseCoPayer, addCoPayer, editOperationItem, wantEditOperationField, editOperationField, linkGroupChat, groupAddOperation, groupDebts, groupSettle, groupEditOperation, groupSummary, postRoomSummary, reviewRepayment, nudgeDebtor, reminderSetting, viewBalances, viewBalance, settleBalance, chooseOperationCategory, setOperationCategory, roomCategories, removeRoomCategory, wantAddRoomCategory, addRoomCategory, statisticChart, budgetSetting, chooseBudgetTarget, wantSetBudget, setBudget, chooseDigest, adminHelp, adminStats, adminRoom, adminUser, adminBroadcast, adminBan, adminCheck, personalData, exportPersonalData, wantDeleteAccount, deleteAccount, paymentMethods, wantAddPaymentMethod, addPaymentMethod, deletePaymentMethod, confirmExpense, cancelExpense, roomCurrency, reviewSettlement, groupDeleteOperation)
+	roomSummary := bot.NewRoomSummary(buttonService, roomService, operationService, statisticService, botConfig)
+	mongoOutboxRepository := repository.NewOutboxRepository(database, keyring)
+	outboxService := service.NewOutboxService(mongoOutboxRepository)
+	replayer := events.NewReplayer(v, buttonService, userService, chatStateService, roomSummary, outboxService)
+	return replayer, func() {
 		cleanup()
 	}, nil
 }
 
 // wire.go:
 
-var bots = wire.NewSet(bot.NewStartScreen, bot.NewRoomCreating, bot.NewRoomSetName, bot.NewJoinRoom, bot.NewAllRoomInline, bot.NewWantDonorOperation, bot.NewAddDonorOperation, bot.NewEditDonorOperation, bot.NewDeleteDonorOperation, bot.NewViewRoom, bot.NewViewAllOperations, bot.NewAllRoom, bot.NewChooseRecepientOperation, bot.NewWantReturnDebt, bot.NewAddRecepientOperation, bot.NewViewUserDebts, bot.NewViewAllDebts, bot.NewRoomSetting, bot.NewArchiveRoom, bot.NewArchivedRooms, bot.NewStatistic, bot.NewViewAllDebtOperations, bot.NewOperation, bot.NewViewMyOperations, bot.NewDebt, bot.NewUserSetting, bot.NewChooseLanguage, bot.NewOperationAdded, bot.NewChooseNotification, bot.NewSelectedNotification, bot.NewDebtReturned, bot.NewWantAddFileToOperation, bot.NewAddFileToOperation, bot.NewViewFileOperation, bot.NewViewDonorOperation, bot.NewSelectedLeaveRoom, bot.NewViewOperationsWithMe, bot.NewChooseCountInPage, bot.NewFinishedAddOperation, bot.NewWantSetBankDetails, bot.NewSetBankDetails, bot.NewViewBankDetails)
+// services are shared by the bot and the replay of updates
+var services = wire.NewSet(
+	service.NewUserService, wire.Bind(new(bot.UserService), new(*service.UserService)),
+	wire.Bind(new(events.UserService), new(*service.UserService)),
+	service.NewRoomService, wire.Bind(new(bot.RoomService), new(*service.RoomService)),
+	wire.Bind(new(bot.ValidationService), new(*service.RoomService)),
+	service.NewChatStateService, wire.Bind(new(bot.ChatStateService), new(*service.ChatStateService)),
+	service.NewButtonService, wire.Bind(new(bot.ButtonService), new(*service.ButtonService)),
+	service.NewOperationService, wire.Bind(new(bot.OperationService), new(*service.OperationService)),
+	service.NewStatisticService, wire.Bind(new(bot.StatisticService), new(*service.StatisticService)),
+	service.NewRoomStateService, wire.Bind(new(bot.RoomStateService), new(*service.RoomStateService)),
+	wire.Bind(new(events.ChatStateService), new(*service.ChatStateService)),
+	wire.Bind(new(events.ButtonService), new(*service.ButtonService)),
+	service.NewOutboxService, wire.Bind(new(events.OutboxService), new(*service.OutboxService)),
+	service.NewAdminService, wire.Bind(new(bot.AdminService), new(*service.AdminService)),
+	service.NewRoomChanges,
+	bot.NewRoomSummary, wire.Bind(new(events.SummaryService), new(*bot.RoomSummary)),
+	repository.NewUserRepository, wire.Bind(new(repository.UserRepository), new(*repository.MongoUserRepository)),
+	wire.Bind(new(repository.Cipher), new(*secret.Keyring)),
+	repository.NewRoomRepository, wire.Bind(new(repository.RoomRepository), new(*repository.MongoRoomRepository)),
+	repository.NewChatStateRepository, wire.Bind(new(repository.ChatStateRepository), new(*repository.MongoChatStateRepository)),
+	repository.NewButtonRepository, wire.Bind(new(repository.ButtonRepository), new(*repository.MongoButtonRepository)),
+	repository.NewOutboxRepository, wire.Bind(new(repository.OutboxRepository), new(*repository.MongoOutboxRepository)),
+	repository.NewAuditRepository, wire.Bind(new(repository.AuditRepository), new(*repository.MongoAuditRepository)),
+)
+
+var bots = wire.NewSet(bot.NewStartScreen, bot.NewRoomCreating, bot.NewRoomSetName, bot.NewJoinRoom, bot.NewAllRoomInline, bot.NewWantDonorOperation, bot.NewAddDonorOperation, bot.NewEditDonorOperation, bot.NewDeleteDonorOperation, bot.NewViewRoom, bot.NewViewAllOperations, bot.NewAllRoom, bot.NewChooseRecepientOperation, bot.NewWantReturnDebt, bot.NewAddRecepientOperation, bot.NewViewUserDebts, bot.NewViewAllDebts, bot.NewRoomSetting, bot.NewArchiveRoom, bot.NewArchivedRooms, bot.NewStatistic, bot.NewViewAllDebtOperations, bot.NewOperation, bot.NewViewMyOperations, bot.NewDebt, bot.NewUserSetting, bot.NewChooseLanguage, bot.NewOperationAdded, bot.NewChooseNotification, bot.NewSelectedNotification, bot.NewDebtReturned, bot.NewWantAddFileToOperation, bot.NewAddFileToOperation, bot.NewViewFileOperation, bot.NewViewDonorOperation, bot.NewSelectedLeaveRoom, bot.NewViewOperationsWithMe, bot.NewChooseCountInPage, bot.NewFinishedAddOperation, bot.NewWantSetBankDetails, bot.NewSetBankDetails, bot.NewViewBankDetails, bot.NewWantAddVirtualMember, bot.NewAddVirtualMember, bot.NewMergeVirtualMember, bot.NewWantAddCoPayer, bot.NewChooseCoPayer, bot.NewAddCoPayer, bot.NewEditOperationItem, bot.NewWantEditOperationField, bot.NewEditOperationField, bot.NewLinkGroupChat, bot.NewGroupAddOperation, bot.NewGroupDebts, bot.NewGroupSettle, bot.NewGroupEditOperation, bot.NewGroupSummary, bot.NewPostRoomSummary, bot.NewReviewRepayment, bot.NewNudgeDebtor, bot.NewReminderSetting, bot.NewViewBalances, bot.NewViewBalance, bot.NewSettleBalance, bot.NewChooseOperationCategory, bot.NewSetOperationCategory, bot.NewRoomCategories, bot.NewRemoveRoomCategory, bot.NewWantAddRoomCategory, bot.NewAddRoomCategory, bot.NewStatisticChart, bot.NewBudgetSetting, bot.NewChooseBudgetTarget, bot.NewWantSetBudget, bot.NewSetBudget, bot.NewChooseDigest, bot.NewAdminHelp, bot.NewAdminStats, bot.NewAdminRoom, bot.NewAdminUser, bot.NewAdminBroadcast, bot.NewAdminBan, bot.NewAdminCheck, bot.NewPersonalData, bot.NewExportPersonalData, bot.NewWantDeleteAccount, bot.NewDeleteAccount, bot.NewPaymentMethods, bot.NewWantAddPaymentMethod, bot.NewAddPaymentMethod, bot.NewDeletePaymentMethod, bot.NewConfirmExpense, bot.NewCancelExpense, bot.NewRoomCurrency, bot.NewReviewSettlement, bot.NewGroupDeleteOperation)
 
 func ProvideBotList(
 	b1 *bot.Operation,
@@ -138,7 +374,61 @@ func ProvideBotList(
 	b41 *bot.ViewBankDetails,
 	b42 *bot.SetBankDetails,
 	b43 *bot.WantSetBankDetails,
+	b44 *bot.WantAddVirtualMember,
+	b45 *bot.AddVirtualMember,
+	b46 *bot.MergeVirtualMember,
+	b47 *bot.WantAddCoPayer,
+	b48 *bot.ChooseCoPayer,
+	b49 *bot.AddCoPayer,
+	b50 *bot.EditOperationItem,
+	b51 *bot.WantEditOperationField,
+	b52 *bot.EditOperationField,
+	b53 *bot.LinkGroupChat,
+	b54 *bot.GroupAddOperation,
+	b55 *bot.GroupDebts,
+	b56 *bot.GroupSettle,
+	b57 *bot.GroupEditOperation,
+	b58 *bot.GroupSummary,
+	b59 *bot.PostRoomSummary,
+	b60 *bot.ReviewRepayment,
+	b61 *bot.NudgeDebtor,
+	b62 *bot.ReminderSetting,
+	b63 *bot.ViewBalances,
+	b64 *bot.ViewBalance,
+	b65 *bot.SettleBalance,
+	b66 *bot.ChooseOperationCategory,
+	b67 *bot.SetOperationCategory,
+	b68 *bot.RoomCategories,
+	b69 *bot.RemoveRoomCategory,
+	b70 *bot.WantAddRoomCategory,
+	b71 *bot.AddRoomCategory,
+	b72 *bot.StatisticChart,
+	b73 *bot.BudgetSetting,
+	b74 *bot.ChooseBudgetTarget,
+	b75 *bot.WantSetBudget,
+	b76 *bot.SetBudget,
+	b77 *bot.ChooseDigest,
+	b78 *bot.AdminHelp,
+	b79 *bot.AdminStats,
+	b80 *bot.AdminRoom,
+	b81 *bot.AdminUser,
+	b82 *bot.AdminBroadcast,
+	b83 *bot.AdminBan,
+	b84 *bot.AdminCheck,
+	b85 *bot.PersonalData,
+	b86 *bot.ExportPersonalData,
+	b87 *bot.WantDeleteAccount,
+	b88 *bot.DeleteAccount,
+	b89 *bot.PaymentMethods,
+	b90 *bot.WantAddPaymentMethod,
+	b91 *bot.AddPaymentMethod,
+	b92 *bot.DeletePaymentMethod,
+	b93 *bot.ConfirmExpense,
+	b94 *bot.CancelExpense,
+	b95 *bot.RoomCurrency,
+	b96 *bot.ReviewSettlement,
+	b97 *bot.GroupDeleteOperation,
 ) []bot.Interface {
 	return []bot.Interface{b1, b2, b3, b4, b5, b6, b8, b9, b10, b11, b12, b13, b14, b15, b16, b17, b18, b19, b20,
-		b21, b22, b23, b24, b25, b26, b27, b28, b29, b30, b31, b32, b33, b34, b35, b36, b37, b38, b39, b40, b41, b42, b43}
+		b21, b22, b23, b24, b25, b26, b27, b28, b29, b30, b31, b32, b33, b34, b35, b36, b37, b38, b39, b40, b41, b42, b43, b44, b45, b46, b47, b48, b49, b50, b51, b52, b53, b54, b55, b56, b57, b58, b59, b60, b61, b62, b63, b64, b65, b66, b67, b68, b69, b70, b71, b72, b73, b74, b75, b76, b77, b78, b79, b80, b81, b82, b83, b84, b85, b86, b87, b88, b89, b90, b91, b92, b93, b94, b95, b96, b97}
 }
@@ -1,7 +1,10 @@
 package api
 
 import (
+	"errors"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,37 +15,494 @@ type Room struct {
 	Members    *[]User            `json:"users" bson:"users"`
 	Operations *[]Operation       `json:"operations" bson:"operations"`
 	RoomStates RoomStatesUsers    `json:"roomStates" bson:"room_states"`
+	Summary    Summary            `json:"summary" bson:"summary,omitempty"`
+	Reminder   Reminder           `json:"reminder" bson:"reminder,omitempty"`
+	Categories []string           `json:"categories" bson:"categories,omitempty"`
+	Budget     Budget             `json:"budget" bson:"budget,omitempty"`
+	Currency   string             `json:"currency" bson:"currency,omitempty"` // ISO 4217 code of sums, empty if unknown
+	Version    int                `json:"version" bson:"version,omitempty"`   // incremented by every change of the room
 	CreateAt   time.Time          `json:"createAt" bson:"create_at"`
 }
 
+// CountRealMembers returns count of members with telegram account, virtual members are excluded
+func (r *Room) CountRealMembers() int {
+	var count int
+	for _, m := range *r.Members {
+		if !m.IsVirtual {
+			count++
+		}
+	}
+	return count
+}
+
+// FindMember returns room member by id or nil
+func (r *Room) FindMember(id int) *User {
+	for i := range *r.Members {
+		if (*r.Members)[i].ID == id {
+			return &(*r.Members)[i]
+		}
+	}
+	return nil
+}
+
+// DefaultCategories are categories of operations in rooms, where members have not configured them
+var DefaultCategories = []string{"food", "transport", "lodging", "entertainment", "shopping", "other"}
+
+// ExpenseCategories returns categories of operations configured in the room or default ones
+func (r *Room) ExpenseCategories() []string {
+	if len(r.Categories) == 0 {
+		return DefaultCategories
+	}
+	return r.Categories
+}
+
 type RoomStatesUsers struct {
 	Archived             []int `json:"archived" bson:"archived,omitempty"`
 	PaidOffDebt          []int `json:"paidOffDebts" bson:"paid_off_debts,omitempty"`
 	FinishedAddOperation []int `json:"finishedAddOperation" bson:"finished_add_operation,omitempty"`
 }
 
+// Summary is the posted messages with live room summary, they are edited on every change of the room
+type Summary struct {
+	ChatId           int64    `json:"chatId" bson:"chat_id,omitempty"`
+	MessageId        int      `json:"messageId" bson:"message_id,omitempty"`
+	InlineMessageIds []string `json:"inlineMessageIds" bson:"inline_message_ids,omitempty"`
+	JoinButtonId     string   `json:"joinButtonId" bson:"join_button_id,omitempty"` // join button of inline messages, it is saved once
+	Lang             string   `json:"lang" bson:"lang,omitempty"`
+}
+
+// ReminderOff is the reminder interval turning reminders off
+const ReminderOff = -1
+
+// Reminder is the schedule of reminders sent to debtors of the room
+type Reminder struct {
+	IntervalDays int            `json:"intervalDays" bson:"interval_days,omitempty"` // zero means the default interval
+	Sent         []ReminderSent `json:"sent" bson:"sent,omitempty"`
+}
+
+// ReminderSent is the last reminder sent to the user, count grows with every scheduled reminder
+type ReminderSent struct {
+	UserId int       `json:"userId" bson:"user_id"`
+	Count  int       `json:"count" bson:"count"`
+	SentAt time.Time `json:"sentAt" bson:"sent_at"`
+}
+
+// FindReminderSent returns the last reminder sent to the user, nil if nothing was sent
+func (r *Reminder) FindReminderSent(userId int) *ReminderSent {
+	for i := range r.Sent {
+		if r.Sent[i].UserId == userId {
+			return &r.Sent[i]
+		}
+	}
+	return nil
+}
+
+// BudgetTotalKey is the key of the total budget of the room
+const BudgetTotalKey = "total"
+
+const (
+	categoryBudgetPrefix = "category:"
+	memberBudgetPrefix   = "member:"
+)
+
+// Budget is the planned spending of the room, budgets with zero sum are not set
+type Budget struct {
+	Total      int              `json:"total" bson:"total,omitempty"`
+	Days       int              `json:"days" bson:"days,omitempty"` // planned length of the trip from the room creation, used for the forecast
+	Categories []CategoryBudget `json:"categories" bson:"categories,omitempty"`
+	Members    []MemberBudget   `json:"members" bson:"members,omitempty"`
+	Alerts     []BudgetAlert    `json:"alerts" bson:"alerts,omitempty"`
+}
+
+type CategoryBudget struct {
+	Category string `json:"category" bson:"category"`
+	Sum      int    `json:"sum" bson:"sum"`
+}
+
+// MemberBudget limits the share of spending of the member
+type MemberBudget struct {
+	UserId int `json:"userId" bson:"user_id"`
+	Sum    int `json:"sum" bson:"sum"`
+}
+
+// BudgetAlert is the highest threshold in percents, about which members have been alerted
+type BudgetAlert struct {
+	Key     string `json:"key" bson:"key"`
+	Percent int    `json:"percent" bson:"percent"`
+}
+
+// BudgetStatus is the spending of the room against one of its budgets
+type BudgetStatus struct {
+	Key      string
+	Category string
+	Member   *User
+	Limit    int
+	Spent    int
+}
+
+// BudgetForecast is the spending expected at the current rate
+type BudgetForecast struct {
+	Projected int // total spending by the end of the trip, zero if the trip length is not set
+	DaysLeft  int // days until the total budget is over, -1 if it can not be estimated
+}
+
+func CategoryBudgetKey(category string) string {
+	return categoryBudgetPrefix + category
+}
+
+func MemberBudgetKey(userId int) string {
+	return memberBudgetPrefix + strconv.Itoa(userId)
+}
+
+// Percent returns the spent part of the budget in percents
+func (s *BudgetStatus) Percent() int {
+	if s.Limit == 0 {
+		return 0
+	}
+	return s.Spent * 100 / s.Limit
+}
+
+// Left returns the rest of the budget, it is negative when the budget is exceeded
+func (s *BudgetStatus) Left() int {
+	return s.Limit - s.Spent
+}
+
+// Limit returns the sum of the budget by its key, zero if it is not set
+func (b *Budget) Limit(key string) int {
+	if key == BudgetTotalKey {
+		return b.Total
+	}
+	for _, c := range b.Categories {
+		if CategoryBudgetKey(c.Category) == key {
+			return c.Sum
+		}
+	}
+	for _, m := range b.Members {
+		if MemberBudgetKey(m.UserId) == key {
+			return m.Sum
+		}
+	}
+	return 0
+}
+
+// SetLimit sets the sum of the budget by its key, zero sum removes the budget.
+// Alerts of the budget are reset, so members are alerted again about the new sum
+func (b *Budget) SetLimit(key string, sum int) bool {
+	switch {
+	case key == BudgetTotalKey:
+		b.Total = sum
+	case strings.HasPrefix(key, categoryBudgetPrefix):
+		category := strings.TrimPrefix(key, categoryBudgetPrefix)
+		var categories []CategoryBudget
+		for _, c := range b.Categories {
+			if c.Category != category {
+				categories = append(categories, c)
+			}
+		}
+		if sum > 0 {
+			categories = append(categories, CategoryBudget{Category: category, Sum: sum})
+		}
+		b.Categories = categories
+	case strings.HasPrefix(key, memberBudgetPrefix):
+		userId, err := strconv.Atoi(strings.TrimPrefix(key, memberBudgetPrefix))
+		if err != nil {
+			return false
+		}
+		var members []MemberBudget
+		for _, m := range b.Members {
+			if m.UserId != userId {
+				members = append(members, m)
+			}
+		}
+		if sum > 0 {
+			members = append(members, MemberBudget{UserId: userId, Sum: sum})
+		}
+		b.Members = members
+	default:
+		return false
+	}
+	b.SetAlert(key, 0)
+	return true
+}
+
+// FindAlert returns the last alert about the budget, nil if members have not been alerted
+func (b *Budget) FindAlert(key string) *BudgetAlert {
+	for i := range b.Alerts {
+		if b.Alerts[i].Key == key {
+			return &b.Alerts[i]
+		}
+	}
+	return nil
+}
+
+// SetAlert saves the threshold about which members have been alerted, zero percent removes the alert
+func (b *Budget) SetAlert(key string, percent int) {
+	var alerts []BudgetAlert
+	for _, a := range b.Alerts {
+		if a.Key != key {
+			alerts = append(alerts, a)
+		}
+	}
+	if percent > 0 {
+		alerts = append(alerts, BudgetAlert{Key: key, Percent: percent})
+	}
+	b.Alerts = alerts
+}
+
+// periods of the spending digest, users without period do not receive it
+const (
+	DigestWeekly  = "weekly"
+	DigestMonthly = "monthly"
+)
+
+// NextDigestAt returns the moment when the next digest is due after the last one
+func NextDigestAt(period string, sentAt time.Time) time.Time {
+	if period == DigestMonthly {
+		return sentAt.AddDate(0, 1, 0)
+	}
+	return sentAt.AddDate(0, 0, 7)
+}
+
+// RoomDigest is the activity of the user in the room for the digest period and his outstanding debts
+type RoomDigest struct {
+	RoomId     string
+	RoomName   string
+	Spent      int         // share of the user in operations of the period
+	Paid       int         // sum paid by the user in operations of the period
+	Operations []Operation // operations of other members the user has been added to
+	Debts      []Debt      // unpaid debts of the user and to the user
+}
+
+// IsEmpty checks that nothing happened in the room and the user has no debts there
+func (d *RoomDigest) IsEmpty() bool {
+	return d.Spent == 0 && d.Paid == 0 && len(d.Operations) == 0 && len(d.Debts) == 0
+}
+
 type Operation struct {
 	ID               primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	Description      string             `json:"description" bson:"description"`
+	Category         string             `json:"category" bson:"category,omitempty"`
 	Donor            *User              `json:"donor" bson:"donor"`
+	CoPayers         []Payer            `json:"coPayers" bson:"co_payers,omitempty"`
 	Recipients       *[]User            `json:"recipients" bson:"recipients"`
+	Items            []Item             `json:"items" bson:"items,omitempty"`
 	IsDebtRepayment  bool               `json:"IsDebtRepayment" bson:"is_debt_repayment"`
+	RepaymentStatus  RepaymentStatus    `json:"repaymentStatus" bson:"repayment_status,omitempty"`
+	SettlementId     primitive.ObjectID `json:"settlementId" bson:"settlement_id,omitempty"` // repayments settling a balance in several rooms are confirmed together
 	Sum              int                `json:"sum" bson:"sum"`
 	NotificationSent []int              `json:"notificationSent" bson:"notification_sent"`
 	CreateAt         time.Time          `json:"createAt" bson:"create_at"`
 	Files            []File             `json:"files" bson:"files,omitempty"`
 }
 
+// Payers returns everybody who paid for the operation, donor pays the rest after co-payers
+func (o *Operation) Payers() []Payer {
+	payers := []Payer{{User: o.Donor, Sum: o.Sum - o.CoPayersSum()}}
+	return append(payers, o.CoPayers...)
+}
+
+// IsConfirmedRepayment checks that the lender confirmed the debt repayment.
+// Repayments recorded before the confirmation was introduced have no status and are confirmed
+func (o *Operation) IsConfirmedRepayment() bool {
+	return o.IsDebtRepayment && (o.RepaymentStatus == "" || o.RepaymentStatus == RepaymentConfirmed)
+}
+
+// IsPendingRepayment checks that the debt repayment waits for confirmation of the lender
+func (o *Operation) IsPendingRepayment() bool {
+	return o.IsDebtRepayment && o.RepaymentStatus == RepaymentPending
+}
+
+// CoPayersSum returns the part of the operation sum paid by co-payers
+func (o *Operation) CoPayersSum() int {
+	var sum int
+	for _, p := range o.CoPayers {
+		sum += p.Sum
+	}
+	return sum
+}
+
+// IsPayer checks if user paid for the operation as donor or co-payer
+func (o *Operation) IsPayer(userId int) bool {
+	for _, p := range o.Payers() {
+		if p.User.ID == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// ItemsSum returns sum of item prices, the rest of the operation sum is shared tax, service charge or tip
+func (o *Operation) ItemsSum() int {
+	var sum int
+	for _, item := range o.Items {
+		sum += item.Price
+	}
+	return sum
+}
+
+// Shares returns part of the operation sum for every recipient.
+// Operation without items is split evenly, otherwise every item is split between its recipients
+// and the rest of the sum is spread proportionally to the items cost of each recipient
+func (o *Operation) Shares() map[int]float64 {
+	shares := map[int]float64{}
+	if len(o.Items) == 0 {
+		for _, u := range *o.Recipients {
+			shares[u.ID] += float64(o.Sum) / float64(len(*o.Recipients))
+		}
+		return shares
+	}
+
+	itemsSum := o.ItemsSum()
+	for _, item := range o.Items {
+		for _, u := range item.Recipients {
+			shares[u.ID] += float64(item.Price) / float64(len(item.Recipients))
+		}
+	}
+	if itemsSum == 0 {
+		return shares
+	}
+	for id, share := range shares {
+		shares[id] = share + share/float64(itemsSum)*float64(o.Sum-itemsSum)
+	}
+	return shares
+}
+
+// Item is a receipt line of the operation with its own recipients
+type Item struct {
+	Name       string `json:"name" bson:"name"`
+	Price      int    `json:"price" bson:"price"`
+	Recipients []User `json:"recipients" bson:"recipients"`
+}
+
+// Payer is a user who paid a part of the operation sum
+type Payer struct {
+	User *User `json:"user" bson:"user"`
+	Sum  int   `json:"sum" bson:"sum"`
+}
+
 type File struct {
 	Type   FileType `json:"type" bson:"type"`
 	FileId string   `json:"fileId" bson:"file_id"`
 }
 type FileType string
 
+type RepaymentStatus string
+
+const (
+	RepaymentPending   RepaymentStatus = "pending"
+	RepaymentConfirmed RepaymentStatus = "confirmed"
+	RepaymentDisputed  RepaymentStatus = "disputed"
+)
+
 type Debt struct {
-	Lender *User `json:"lender" bson:"lender"`
-	Debtor *User `json:"debtor" bson:"debtor"`
-	Sum    int   `json:"sum" bson:"sum"`
+	Lender  *User `json:"lender" bson:"lender"`
+	Debtor  *User `json:"debtor" bson:"debtor"`
+	Sum     int   `json:"sum" bson:"sum"`
+	Pending int   `json:"pending" bson:"pending,omitempty"` // repaid part of the sum waiting for confirmation of the lender
+}
+
+// Unpaid returns the part of the debt which is not repaid even without confirmation
+func (d *Debt) Unpaid() int {
+	return d.Sum - d.Pending
+}
+
+// kinds of payment methods
+const (
+	PaymentIban   = "iban"   // IBAN with optional BIC, EUR accounts get EPC QR codes
+	PaymentCard   = "card"   // number of the bank card
+	PaymentPhone  = "phone"  // transfer by phone number, Bank is the bank of the receiver
+	PaymentPaypal = "paypal" // paypal.me handle
+)
+
+// PaymentMethods are kinds of payment methods in order of showing
+var PaymentMethods = []string{PaymentIban, PaymentCard, PaymentPhone, PaymentPaypal}
+
+// PaymentMethod is the structured requisite of the user for repayment of debts in the currency
+type PaymentMethod struct {
+	ID       primitive.ObjectID `json:"id" bson:"_id"`
+	Kind     string             `json:"kind" bson:"kind"`
+	Currency string             `json:"currency" bson:"currency"`
+	Account  string             `json:"account" bson:"account"` // IBAN, card number, phone number or paypal.me handle
+	Bic      string             `json:"bic,omitempty" bson:"bic,omitempty"`
+	Bank     string             `json:"bank,omitempty" bson:"bank,omitempty"`
+}
+
+// ErrInvalidPaymentMethod is returned, when the payment method entered by the user can't be parsed
+var ErrInvalidPaymentMethod = errors.New("payment method is invalid")
+
+// QRPayload returns the content of the payment QR code with the sum and the reference. EUR accounts get
+// EPC QR codes (SEPA credit transfer), paypal.me gets the link with the sum, other methods have no standard codes.
+// The sum is in the currency of the room, codes are made only for methods in the same currency
+func (m *PaymentMethod) QRPayload(holder string, sum int, currency string, reference string) (string, bool) {
+	if currency == "" || m.Currency != currency {
+		return "", false
+	}
+	switch {
+	case m.Kind == PaymentIban && m.Currency == "EUR":
+		if sum <= 0 || sum > 999999999 {
+			return "", false
+		}
+		return strings.Join([]string{"BCD", "002", "1", "SCT", m.Bic, truncate(holder, 70), m.Account,
+			"EUR" + strconv.Itoa(sum) + ".00", "", "", truncate(reference, 140)}, "\n"), true
+	case m.Kind == PaymentPaypal:
+		return "https://paypal.me/" + m.Account + "/" + strconv.Itoa(sum) + m.Currency, true
+	}
+	return "", false
+}
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+// RoomDebt is the debt in the room, it is a part of the balance between two users
+type RoomDebt struct {
+	RoomId   string `json:"roomId"`
+	RoomName string `json:"roomName"`
+	Debt     Debt   `json:"debt"`
+}
+
+// DeletedUserName is the name of the anonymous member, which replaces the deleted user in rooms
+const DeletedUserName = "Deleted user"
+
+// UserExport is the personal data of the user, it is sent to the user by request
+type UserExport struct {
+	ExportedAt  time.Time    `json:"exportedAt"`
+	Profile     User         `json:"profile"`
+	BankDetails string       `json:"bankDetails"`
+	Rooms       []RoomExport `json:"rooms"`
+}
+
+// RoomExport is the room of the user with operations and debts, in which he takes part
+type RoomExport struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	CreateAt   time.Time   `json:"createAt"`
+	Members    []string    `json:"members"`
+	Operations []Operation `json:"operations"`
+	Debts      []Debt      `json:"debts"`
+}
+
+// Balance is the netted result of all debts between the user and the counterparty in all active rooms.
+// Positive sum means the counterparty owes the user, negative sum means the user owes the counterparty
+type Balance struct {
+	Counterparty *User      `json:"counterparty"`
+	Sum          int        `json:"sum"`
+	Debts        []RoomDebt `json:"debts"`
+}
+
+// CategoryCosts is the sum spent in the category, empty category means operations without category
+type CategoryCosts struct {
+	Category string `json:"category"`
+	Sum      int    `json:"sum"`
+}
+
+// MemberCosts is the sum spent on the member, it is the member share of all operations
+type MemberCosts struct {
+	Member *User `json:"member"`
+	Sum    int   `json:"sum"`
 }
 
 // ChatState stores user state
@@ -53,6 +513,88 @@ type ChatState struct {
 	CallbackData *CallbackData      `json:"callbackData" bson:"callback_data"`
 }
 
+// ErrRoomChanged is returned, when the room is saved as a whole, but it has been changed since it was read
+var ErrRoomChanged = errors.New("room has been changed concurrently")
+
+// ErrMergeConflict is returned, when the virtual member and the joined user share an operation,
+// so the merge would change the split of the operation
+var ErrMergeConflict = errors.New("virtual member and user take part in the same operation")
+
+// ErrInconsistentRoom is returned, when debts of the room can't be calculated because its data breaks invariants
+var ErrInconsistentRoom = errors.New("room data is inconsistent")
+
+// kinds of violations of room invariants
+const (
+	ViolationUnbalanced   = "unbalanced"    // payments of the operation differ from shares of its recipients
+	ViolationOverpaid     = "overpaid"      // confirmed repayments of the user exceed his debts
+	ViolationUnknownUser  = "unknown_user"  // the user of the operation is not a member of the room
+	ViolationNoRecipients = "no_recipients" // the operation or its item has no recipients
+)
+
+// Violation is the broken invariant of the room, OperationId is empty for violations of the whole room
+type Violation struct {
+	RoomId      string
+	RoomName    string
+	OperationId string
+	Kind        string
+	Detail      string
+}
+
+func (v Violation) String() string {
+	s := v.RoomId + " " + strconv.Quote(v.RoomName)
+	if v.OperationId != "" {
+		s += ", operation " + v.OperationId
+	}
+	return s + ": " + v.Kind + ", " + v.Detail
+}
+
+// AuditRecord is the action of the super user, it is saved for every admin command
+type AuditRecord struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	AdminId   int                `json:"adminId" bson:"admin_id"`
+	AdminName string             `json:"adminName" bson:"admin_name"`
+	Action    string             `json:"action" bson:"action"`
+	Args      string             `json:"args" bson:"args,omitempty"`
+	Result    string             `json:"result" bson:"result,omitempty"`
+	CreateAt  time.Time          `json:"createAt" bson:"create_at"`
+}
+
+// AdminStats counts users, rooms and operations in total and created within recent periods
+type AdminStats struct {
+	Users      int64
+	Rooms      int64
+	Operations int64
+	Periods    []AdminStatsPeriod
+}
+
+type AdminStatsPeriod struct {
+	Days       int
+	Users      int64
+	Rooms      int64
+	Operations int64
+}
+
+// OutgoingMessage is the message waiting for sending to telegram, Payload is JSON of the telegram config of the Kind.
+// Messages may contain bank details, so the payload and the file are stored only encrypted in Sealed
+type OutgoingMessage struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ChatId        int64              `json:"chatId" bson:"chat_id"`
+	Kind          string             `json:"kind" bson:"kind"`
+	Payload       []byte             `json:"payload" bson:"payload,omitempty"` // plaintext of old versions only
+	File          *OutgoingFile      `json:"file" bson:"file,omitempty"`
+	Sealed        *Sealed            `json:"-" bson:"sealed,omitempty"`
+	Attempts      int                `json:"attempts" bson:"attempts"`
+	NextAttemptAt time.Time          `json:"nextAttemptAt" bson:"next_attempt_at"`
+	LastError     string             `json:"lastError" bson:"last_error,omitempty"`
+	CreateAt      time.Time          `json:"createAt" bson:"create_at"`
+}
+
+// OutgoingFile is the file uploaded with the outgoing message, example = rendered chart
+type OutgoingFile struct {
+	Name  string `json:"name" bson:"name"`
+	Bytes []byte `json:"bytes" bson:"bytes"`
+}
+
 // Button which is sent to the user as ReplyMarkup
 type Button struct {
 	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
@@ -71,6 +613,12 @@ type CallbackData struct {
 	ExternalData string             `json:"externalData" bson:"external_data,omitempty"`
 	OperationId  primitive.ObjectID `json:"operationId" bson:"operation_id,omitempty"`
 	Page         int                `json:"page" bson:"page,omitempty"`
+	DebtorId     int                `json:"debtorId" bson:"debtor_id,omitempty"`
+	Item         int                `json:"item" bson:"item,omitempty"`
+	Sum          int                `json:"sum" bson:"sum,omitempty"`
+	UserIds      []int              `json:"userIds" bson:"user_ids,omitempty"`
+	Items        []Item             `json:"items" bson:"items,omitempty"` // receipt items waiting for confirmation
+	Selected     bool               `json:"selected" bson:"selected,omitempty"` // the button chooses the value, which may be empty
 }
 
 func NewButton(action Action, data *CallbackData) *Button {
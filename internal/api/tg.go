@@ -43,6 +43,7 @@ type Message struct {
 	Image    *Image    `json:",omitempty"`
 	Document *Document `json:",omitempty"`
 	Video    *Video    `json:",omitempty"`
+	ReplyTo  *Message  `json:",omitempty"`
 }
 
 // Entity represents one special entity in a text message.
@@ -80,14 +81,44 @@ type Video struct {
 
 // User defines user info of the Message
 type User struct {
-	ID             int    `json:"id" bson:"_id"`
-	Username       string `json:"userName" bson:"user_name"`
-	DisplayName    string `json:"displayName" bson:"display_name"`
-	UserLang       string `json:"userLang" bson:"user_lang"`
-	SelectedLang   string `json:"selectedLang" bson:"selected_lang"`
-	NotificationOn *bool  `json:"notificationOn" bson:"notification_on,omitempty"`
-	CountInPage    int    `json:"countInPage" bson:"count_in_page,omitempty"`
-	BankDetails    string `json:"bankDetails" bson:"bank_details,omitempty"`
+	ID             int             `json:"id" bson:"_id"`
+	Username       string          `json:"userName" bson:"user_name"`
+	DisplayName    string          `json:"displayName" bson:"display_name"`
+	UserLang       string          `json:"userLang" bson:"user_lang"`
+	SelectedLang   string          `json:"selectedLang" bson:"selected_lang"`
+	NotificationOn *bool           `json:"notificationOn" bson:"notification_on,omitempty"`
+	CountInPage    int             `json:"countInPage" bson:"count_in_page,omitempty"`
+	BankDetails    string          `json:"bankDetails" bson:"-"` // decrypted by the repository, it is never stored as is
+	SealedBank     *Sealed         `json:"-" bson:"sealed_bank_details,omitempty"`
+	LegacyBank     string          `json:"-" bson:"bank_details,omitempty"` // plaintext of old versions, it is encrypted on start
+	Payments       []PaymentMethod `json:"paymentMethods" bson:"-"`         // decrypted by the repository like bank details
+	SealedPayments *Sealed         `json:"-" bson:"sealed_payment_methods,omitempty"`
+	IsVirtual      bool            `json:"isVirtual" bson:"is_virtual,omitempty"`
+	ManagerId      int             `json:"managerId" bson:"manager_id,omitempty"`
+	Deleted        bool            `json:"deleted" bson:"deleted,omitempty"` // anonymous member, which replaces the deleted user
+	DigestPeriod   string          `json:"digestPeriod" bson:"digest_period,omitempty"`
+	DigestSentAt   time.Time       `json:"digestSentAt" bson:"digest_sent_at,omitempty"`
+	Banned         bool            `json:"banned" bson:"banned,omitempty"`
+	CreateAt       time.Time       `json:"createAt" bson:"create_at,omitempty"`
+}
+
+// Sealed is the value encrypted by the data key, the data key is encrypted by the master key with KeyId.
+// Rotation of master keys re-encrypts data keys only
+type Sealed struct {
+	KeyId   string `json:"keyId" bson:"key_id"`
+	DataKey []byte `json:"dataKey" bson:"data_key"`
+	Data    []byte `json:"data" bson:"data"`
+}
+
+// IsManagedBy checks that user is virtual member and his balance managed by user with managerId
+func (u *User) IsManagedBy(managerId int) bool {
+	return u.IsVirtual && !u.Deleted && u.ManagerId == managerId
+}
+
+// IsMergeable checks that a joined user can take over operations of the member,
+// anonymous members of deleted users are never merged, otherwise anybody could take over their debts
+func (u *User) IsMergeable() bool {
+	return u.IsVirtual && !u.Deleted
 }
 
 func DefineLang(u *User) string {
@@ -123,8 +154,8 @@ type CallbackQuery struct {
 
 // Chat contains information about the place a message was sent.
 type Chat struct {
-	ID   int64  `json:"id"`
-	Type string `json:"type"`
+	ID   int64  `json:"id" bson:"id"`
+	Type string `json:"type" bson:"type"`
 }
 
 type TelegramMessage struct {
@@ -132,5 +163,8 @@ type TelegramMessage struct {
 	InlineConfig   *tgbotapi.InlineConfig
 	CallbackConfig *tgbotapi.CallbackConfig
 	Redirect       *Update
-	Send           bool // status
+	Pin            bool                                // pin the first sent message
+	SummaryOf      string                              // room id, the first sent message becomes summary of the room
+	Restrict       []tgbotapi.RestrictChatMemberConfig // members restricted in group chats
+	Send           bool                                // status
 }
@@ -0,0 +1,432 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminCommand is the command of super users in the private chat, example = /admin room 600e68d102ddac9888d0193e
+const adminCommand = "/admin"
+
+// admin subcommands
+const (
+	adminStats     = "stats"
+	adminRoom      = "room"
+	adminUser      = "user"
+	adminBroadcast = "broadcast"
+	adminBan       = "ban"
+	adminUnban     = "unban"
+	adminCheck     = "check"
+)
+
+var adminSubcommands = []string{adminStats, adminRoom, adminUser, adminBroadcast, adminBan, adminUnban, adminCheck}
+
+// broadcastLangs are languages of broadcast texts, the first one is used for users whose language has no text
+var broadcastLangs = []string{"en", "ru"}
+
+type AdminService interface {
+	Stats(ctx context.Context, now time.Time) (*api.AdminStats, error)
+	Audit(ctx context.Context, r *api.AuditRecord)
+	FindReportedViolations(ctx context.Context) ([]string, error)
+	SetReportedViolations(ctx context.Context, keys []string) error
+}
+
+// AdminHelp lists admin commands, it reacts on /admin without known subcommand
+type AdminHelp struct {
+	cfg *Config
+}
+
+func NewAdminHelp(cfg *Config) *AdminHelp {
+	return &AdminHelp{
+		cfg: cfg,
+	}
+}
+
+func (bot AdminHelp) HasReact(u *api.Update) bool {
+	sub, _, ok := parseAdminCommand(u, bot.cfg)
+	return ok && !contains(adminSubcommands, sub)
+}
+
+func (bot *AdminHelp) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	return adminReply(u, I18n(u.User, "scrn_admin_help"))
+}
+
+// AdminStats shows count of users, rooms and operations, example = /admin stats
+type AdminStats struct {
+	as  AdminService
+	cfg *Config
+}
+
+func NewAdminStats(as AdminService, cfg *Config) *AdminStats {
+	return &AdminStats{
+		as:  as,
+		cfg: cfg,
+	}
+}
+
+func (bot AdminStats) HasReact(u *api.Update) bool {
+	sub, _, ok := parseAdminCommand(u, bot.cfg)
+	return ok && sub == adminStats
+}
+
+func (bot *AdminStats) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	stats, err := bot.as.Stats(ctx, time.Now())
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get admin stats failed")
+		return
+	}
+	bot.as.Audit(ctx, newAuditRecord(u, adminStats, "", ""))
+
+	text := I18n(u.User, "scrn_admin_stats", stats.Users, stats.Rooms, stats.Operations)
+	for _, p := range stats.Periods {
+		text += I18n(u.User, "scrn_admin_stats_period", p.Days, p.Users, p.Rooms, p.Operations)
+	}
+	return adminReply(u, text)
+}
+
+// AdminRoom shows any room with its debts, debts are recomputed before, example = /admin room 600e68d102ddac9888d0193e
+type AdminRoom struct {
+	as  AdminService
+	rs  RoomService
+	os  OperationService
+	rss RoomStateService
+	cfg *Config
+}
+
+func NewAdminRoom(as AdminService, rs RoomService, os OperationService, rss RoomStateService, cfg *Config) *AdminRoom {
+	return &AdminRoom{
+		as:  as,
+		rs:  rs,
+		os:  os,
+		rss: rss,
+		cfg: cfg,
+	}
+}
+
+func (bot AdminRoom) HasReact(u *api.Update) bool {
+	sub, _, ok := parseAdminCommand(u, bot.cfg)
+	return ok && sub == adminRoom
+}
+
+func (bot *AdminRoom) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	_, roomId, _ := parseAdminCommand(u, bot.cfg)
+	var result string
+	defer func() { bot.as.Audit(ctx, newAuditRecord(u, adminRoom, roomId, result)) }()
+	room, err := bot.rs.FindById(ctx, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find room, id:%s", roomId)
+		result = auditError("room not found", err)
+		return adminReply(u, I18n(u.User, "msg_admin_not_found", roomId))
+	}
+	if err := bot.rss.DefinePaidOfDebtsUserIdsAndSave(ctx, room); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("recompute debts failed, room:%s", roomId)
+		result = auditError("recompute debts failed", err)
+		return failedResponse(u, err)
+	}
+	debts, err := bot.os.GetAllDebts(ctx, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get debts failed")
+		result = auditError("get debts failed", err)
+		return failedResponse(u, err)
+	}
+	result = fmt.Sprintf("debts recomputed: %d", len(debts))
+
+	var operations int
+	if room.Operations != nil {
+		operations = len(*room.Operations)
+	}
+	chat := "—"
+	if room.Chat.ID != 0 {
+		chat = strconv.FormatInt(room.Chat.ID, 10)
+	}
+	text := I18n(u.User, "scrn_admin_room", room.Name, roomId, room.CreateAt.Format("02 January 2006"), chat, operations)
+	for _, m := range *room.Members {
+		text += "- " + userLink(&m) + "\n"
+	}
+	text += "\n" + I18n(u.User, "scrn_all_debts") + "\n"
+	for _, d := range debts {
+		text += debtLine(&d)
+	}
+	text += I18n(u.User, "scrn_admin_room_recomputed")
+	return adminReply(u, text)
+}
+
+// AdminUser shows the profile of the user found by id or user name, example = /admin user @anna
+type AdminUser struct {
+	as  AdminService
+	us  UserService
+	rs  RoomService
+	cfg *Config
+}
+
+func NewAdminUser(as AdminService, us UserService, rs RoomService, cfg *Config) *AdminUser {
+	return &AdminUser{
+		as:  as,
+		us:  us,
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot AdminUser) HasReact(u *api.Update) bool {
+	sub, _, ok := parseAdminCommand(u, bot.cfg)
+	return ok && sub == adminUser
+}
+
+func (bot *AdminUser) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	_, arg, _ := parseAdminCommand(u, bot.cfg)
+	var result string
+	defer func() { bot.as.Audit(ctx, newAuditRecord(u, adminUser, arg, result)) }()
+	user, err := findAdminTarget(ctx, bot.us, arg)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find user %s", arg)
+		result = auditError("user not found", err)
+		return adminReply(u, I18n(u.User, "msg_admin_not_found", arg))
+	}
+	rooms, err := bot.rs.FindRoomsByUserId(ctx, user.ID)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find rooms of user %d", user.ID)
+		result = auditError("find rooms failed", err)
+		return
+	}
+	result = strconv.Itoa(user.ID)
+
+	created := "—"
+	if !user.CreateAt.IsZero() {
+		created = user.CreateAt.Format("02 January 2006")
+	}
+	banned := "—"
+	if user.Banned {
+		banned = "⛔️"
+	}
+	text := I18n(u.User, "scrn_admin_user", userLink(user), user.ID, user.Username, api.DefineLang(user), created, banned)
+	for _, r := range *rooms {
+		text += fmt.Sprintf("- %s `%s`\n", r.Name, r.ID.Hex())
+	}
+	return adminReply(u, text)
+}
+
+// AdminBroadcast sends the message to all users in their languages, messages wait in the outbox and are sent
+// within rate limits. Example = /admin broadcast
+// en: New version is released
+// ru: Вышла новая версия
+type AdminBroadcast struct {
+	as  AdminService
+	us  UserService
+	cfg *Config
+}
+
+func NewAdminBroadcast(as AdminService, us UserService, cfg *Config) *AdminBroadcast {
+	return &AdminBroadcast{
+		as:  as,
+		us:  us,
+		cfg: cfg,
+	}
+}
+
+func (bot AdminBroadcast) HasReact(u *api.Update) bool {
+	sub, _, ok := parseAdminCommand(u, bot.cfg)
+	return ok && sub == adminBroadcast
+}
+
+func (bot *AdminBroadcast) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	_, args, _ := parseAdminCommand(u, bot.cfg)
+	var result string
+	defer func() { bot.as.Audit(ctx, newAuditRecord(u, adminBroadcast, args, result)) }()
+	texts := parseBroadcast(args)
+	if len(texts) == 0 {
+		result = "empty"
+		return adminReply(u, I18n(u.User, "msg_admin_broadcast_empty"))
+	}
+	users, err := bot.us.FindAll(ctx)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("find users failed")
+		result = auditError("find users failed", err)
+		return
+	}
+
+	var messages []tgbotapi.Chattable
+	for i := range *users {
+		user := &(*users)[i]
+		if user.IsVirtual || user.Banned {
+			continue
+		}
+		text, ok := texts[api.DefineLang(user)]
+		if !ok {
+			text = texts[defaultBroadcastLang(texts)]
+		}
+		// the text of the admin is sent as is, it may break markdown
+		messages = append(messages, tgbotapi.NewMessage(int64(user.ID), text))
+	}
+	result = fmt.Sprintf("queued: %d", len(messages))
+
+	// the reply to the admin goes first, broadcast messages wait in the outbox
+	response = adminReply(u, I18n(u.User, "msg_admin_broadcast_queued", len(messages)))
+	response.Chattable = append(response.Chattable, messages...)
+	return response
+}
+
+// AdminBan bans or unbans the user in the bot and in group chats of his rooms, example = /admin ban @anna
+type AdminBan struct {
+	as  AdminService
+	us  UserService
+	rs  RoomService
+	cfg *Config
+}
+
+func NewAdminBan(as AdminService, us UserService, rs RoomService, cfg *Config) *AdminBan {
+	return &AdminBan{
+		as:  as,
+		us:  us,
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot AdminBan) HasReact(u *api.Update) bool {
+	sub, _, ok := parseAdminCommand(u, bot.cfg)
+	return ok && (sub == adminBan || sub == adminUnban)
+}
+
+func (bot *AdminBan) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	sub, arg, _ := parseAdminCommand(u, bot.cfg)
+	var result string
+	defer func() { bot.as.Audit(ctx, newAuditRecord(u, sub, arg, result)) }()
+	banned := sub == adminBan
+	user, err := findAdminTarget(ctx, bot.us, arg)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find user %s", arg)
+		result = auditError("user not found", err)
+		return adminReply(u, I18n(u.User, "msg_admin_not_found", arg))
+	}
+	if banned && bot.cfg.IsSuper(user.Username) {
+		result = fmt.Sprintf("user: %d, super user is not banned", user.ID)
+		return adminReply(u, I18n(u.User, "msg_admin_super_ban"))
+	}
+	rooms, err := bot.rs.FindRoomsByUserId(ctx, user.ID)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find rooms of user %d", user.ID)
+		result = auditError(fmt.Sprintf("user: %d, find rooms failed", user.ID), err)
+		return
+	}
+	if err := bot.us.SetBanned(ctx, user.ID, banned); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("set banned failed, user:%d", user.ID)
+		result = auditError(fmt.Sprintf("user: %d, set banned failed", user.ID), err)
+		return
+	}
+
+	var restrict []tgbotapi.RestrictChatMemberConfig
+	for _, r := range *rooms {
+		if r.Chat.ID == 0 {
+			continue
+		}
+		restrict = append(restrict, newRestrictConfig(r.Chat.ID, user.ID, !banned))
+	}
+	result = fmt.Sprintf("user: %d, group chats: %d", user.ID, len(restrict))
+
+	text := I18n(u.User, "msg_admin_unbanned", userLink(user))
+	if banned {
+		text = I18n(u.User, "msg_admin_banned", userLink(user), len(restrict))
+	}
+	response = adminReply(u, text)
+	response.Restrict = restrict
+	return response
+}
+
+// parseAdminCommand returns the subcommand and its arguments, the command is accepted from super users in the private chat only
+func parseAdminCommand(u *api.Update, cfg *Config) (string, string, bool) {
+	if !isPrivate(u) || !hasMessage(u) || u.User == nil || !cfg.IsSuper(u.User.Username) {
+		return "", "", false
+	}
+	text := u.Message.Text
+	if text != adminCommand && !strings.HasPrefix(text, adminCommand+" ") && !strings.HasPrefix(text, adminCommand+"\n") {
+		return "", "", false
+	}
+	args := strings.TrimSpace(strings.TrimPrefix(text, adminCommand))
+	i := strings.IndexAny(args, " \n")
+	if i < 0 {
+		return args, "", true
+	}
+	return args[:i], strings.TrimSpace(args[i:]), true
+}
+
+// findAdminTarget finds the user by id or by user name with or without @
+func findAdminTarget(ctx context.Context, us UserService, arg string) (*api.User, error) {
+	if id, err := strconv.Atoi(arg); err == nil {
+		return us.FindById(ctx, id)
+	}
+	return us.FindByUsername(ctx, strings.TrimPrefix(arg, "@"))
+}
+
+// parseBroadcast returns texts by languages, every text starts with the line "lang: ", next lines continue it
+func parseBroadcast(args string) map[string]string {
+	texts := map[string]string{}
+	var lang string
+	for _, line := range strings.Split(args, "\n") {
+		if i := strings.Index(line, ":"); i > 0 && contains(broadcastLangs, line[:i]) {
+			lang = strings.ToLower(line[:i])
+			texts[lang] = strings.TrimSpace(line[i+1:])
+			continue
+		}
+		if lang != "" {
+			texts[lang] += "\n" + line
+		}
+	}
+	for l, t := range texts {
+		if t = strings.TrimSpace(t); t == "" {
+			delete(texts, l)
+		} else {
+			texts[l] = t
+		}
+	}
+	return texts
+}
+
+// defaultBroadcastLang is the language of the text for users, whose language has no text
+func defaultBroadcastLang(texts map[string]string) string {
+	if _, ok := texts[broadcastLangs[0]]; ok {
+		return broadcastLangs[0]
+	}
+	for l := range texts {
+		return l
+	}
+	return ""
+}
+
+func newRestrictConfig(chatId int64, userId int, allowed bool) tgbotapi.RestrictChatMemberConfig {
+	return tgbotapi.RestrictChatMemberConfig{
+		ChatMemberConfig:      tgbotapi.ChatMemberConfig{ChatID: chatId, UserID: userId},
+		CanSendMessages:       &allowed,
+		CanSendMediaMessages:  &allowed,
+		CanSendOtherMessages:  &allowed,
+		CanAddWebPagePreviews: &allowed,
+	}
+}
+
+// auditError is the result of the failed admin action
+func auditError(reason string, err error) string {
+	return fmt.Sprintf("%s: %v", reason, err)
+}
+
+func newAuditRecord(u *api.Update, action string, args string, result string) *api.AuditRecord {
+	return &api.AuditRecord{
+		AdminId:   u.User.ID,
+		AdminName: u.User.Username,
+		Action:    action,
+		Args:      args,
+		Result:    result,
+		CreateAt:  time.Now(),
+	}
+}
+
+func adminReply(u *api.Update, text string) api.TelegramMessage {
+	msg := tgbotapi.NewMessage(getChatID(u), text)
+	msg.ParseMode = tgbotapi.ModeMarkdown
+	return api.TelegramMessage{Chattable: []tgbotapi.Chattable{msg}, Send: true}
+}
@@ -0,0 +1,152 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/almaznur91/splitty/internal/api"
+	"github.com/gookit/i18n"
+	"github.com/stretchr/testify/assert"
+)
+
+func adminUpdate(text string, chatType string, username string) *api.Update {
+	return &api.Update{
+		Message: &api.Message{ID: 1, Text: text, Chat: &api.Chat{ID: 10, Type: chatType}},
+		User:    &api.User{ID: 10, Username: username},
+	}
+}
+
+func TestParseAdminCommand(t *testing.T) {
+	cfg := &Config{SuperUsers: []string{"root"}}
+	tests := []struct {
+		name     string
+		update   *api.Update
+		sub, arg string
+		ok       bool
+	}{
+		{name: "help", update: adminUpdate("/admin", "private", "root"), ok: true},
+		{name: "subcommand", update: adminUpdate("/admin stats", "private", "root"), sub: "stats", ok: true},
+		{name: "argument", update: adminUpdate("/admin user  @anna ", "private", "root"), sub: "user", arg: "@anna", ok: true},
+		{name: "lines", update: adminUpdate("/admin broadcast\nen: hi\nru: привет", "private", "root"),
+			sub: "broadcast", arg: "en: hi\nru: привет", ok: true},
+		{name: "subcommand on the next line", update: adminUpdate("/admin\nstats", "private", "root"), sub: "stats", ok: true},
+		{name: "other command", update: adminUpdate("/administrator", "private", "root"), ok: false},
+		{name: "not super user", update: adminUpdate("/admin stats", "private", "anna"), ok: false},
+		{name: "no user name", update: adminUpdate("/admin stats", "private", ""), ok: false},
+		{name: "group chat", update: adminUpdate("/admin stats", "group", "root"), ok: false},
+		{name: "no message", update: &api.Update{User: &api.User{Username: "root"}}, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, arg, ok := parseAdminCommand(tt.update, cfg)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.sub, sub)
+			assert.Equal(t, tt.arg, arg)
+		})
+	}
+}
+
+func TestParseBroadcast(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  string
+		texts map[string]string
+	}{
+		{name: "languages", args: "en: New version\nru: Новая версия",
+			texts: map[string]string{"en": "New version", "ru": "Новая версия"}},
+		{name: "multiline text", args: "en: New version\n\nwith charts\nru: Новая версия",
+			texts: map[string]string{"en": "New version\n\nwith charts", "ru": "Новая версия"}},
+		{name: "text on the next line", args: "en:\nNew version", texts: map[string]string{"en": "New version"}},
+		{name: "colon in text", args: "en: Note: new version", texts: map[string]string{"en": "Note: new version"}},
+		{name: "unknown language is text", args: "en: New version\nde: Neue Version",
+			texts: map[string]string{"en": "New version\nde: Neue Version"}},
+		{name: "text before language", args: "New version\nen: hi", texts: map[string]string{"en": "hi"}},
+		{name: "empty text", args: "en:\nru: Новая версия", texts: map[string]string{"ru": "Новая версия"}},
+		{name: "no language", args: "New version", texts: map[string]string{}},
+		{name: "empty", args: "", texts: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.texts, parseBroadcast(tt.args))
+		})
+	}
+}
+
+// auditAdminService records audited actions
+type auditAdminService struct {
+	AdminService
+	records []api.AuditRecord
+}
+
+func (s *auditAdminService) Audit(_ context.Context, r *api.AuditRecord) {
+	s.records = append(s.records, *r)
+}
+
+// adminUserService finds users by name and fails to ban them
+type adminUserService struct {
+	UserService
+	users map[string]*api.User
+}
+
+func (s *adminUserService) FindByUsername(_ context.Context, userName string) (*api.User, error) {
+	if u, ok := s.users[userName]; ok {
+		return u, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (s *adminUserService) SetBanned(_ context.Context, _ int, _ bool) error {
+	return errors.New("write failed")
+}
+
+// adminRoomService has no rooms
+type adminRoomService struct {
+	RoomService
+}
+
+func (s *adminRoomService) FindById(_ context.Context, _ string) (*api.Room, error) {
+	return nil, errors.New("not found")
+}
+
+func (s *adminRoomService) FindRoomsByUserId(_ context.Context, _ int) (*[]api.Room, error) {
+	return &[]api.Room{}, nil
+}
+
+func TestAdminAuditsFailures(t *testing.T) {
+	i18n.Init("../../conf/lang", "en", map[string]string{"en": "English", "ru": "Русский"})
+	cfg := &Config{SuperUsers: []string{"root"}}
+	us := &adminUserService{users: map[string]*api.User{"anna": {ID: 2, Username: "anna"}, "root": {ID: 3, Username: "root"}}}
+	rs := &adminRoomService{}
+
+	tests := []struct {
+		text   string
+		bot    func(as AdminService) Interface
+		action string
+		result string
+	}{
+		{text: "/admin user @carl", bot: func(as AdminService) Interface { return NewAdminUser(as, us, rs, cfg) },
+			action: adminUser, result: "user not found: not found"},
+		{text: "/admin room 600e68d102ddac9888d0193e", bot: func(as AdminService) Interface { return NewAdminRoom(as, rs, nil, nil, cfg) },
+			action: adminRoom, result: "room not found: not found"},
+		{text: "/admin ban @carl", bot: func(as AdminService) Interface { return NewAdminBan(as, us, rs, cfg) },
+			action: adminBan, result: "user not found: not found"},
+		{text: "/admin ban @root", bot: func(as AdminService) Interface { return NewAdminBan(as, us, rs, cfg) },
+			action: adminBan, result: "user: 3, super user is not banned"},
+		{text: "/admin ban @anna", bot: func(as AdminService) Interface { return NewAdminBan(as, us, rs, cfg) },
+			action: adminBan, result: "user: 2, set banned failed: write failed"},
+		{text: "/admin broadcast", bot: func(as AdminService) Interface { return NewAdminBroadcast(as, us, cfg) },
+			action: adminBroadcast, result: "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			as := &auditAdminService{}
+			tt.bot(as).OnMessage(context.Background(), adminUpdate(tt.text, "private", "root"))
+			if assert.Len(t, as.records, 1) {
+				assert.Equal(t, tt.action, as.records[0].Action)
+				assert.Equal(t, tt.result, as.records[0].Result)
+				assert.Equal(t, "root", as.records[0].AdminName)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"github.com/almaznur91/splitty/internal/api"
 	"github.com/enescakir/emoji"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
@@ -42,11 +43,15 @@ func (bot *AllRoomInline) OnMessage(ctx context.Context, u *api.Update) (respons
 	var results []interface{}
 	for _, room := range *rooms {
 		debtorSum, lenderSum, err := bot.ss.GetUserDebtAndLendSum(ctx, userId, room.ID.Hex())
-		if err != nil {
+		if err != nil && !errors.Is(err, api.ErrInconsistentRoom) {
+			log.Ctx(ctx).Error().Err(err).Msg("get user debts sum failed")
 			return
 		}
 		var debtText string
-		if debtorSum != 0 {
+		// the broken room is shown with the error, other rooms are not hidden by it
+		if err != nil {
+			debtText = I18n(u.User, "msg_room_inconsistent")
+		} else if debtorSum != 0 {
 			debtText = I18n(u.User, "msg_you_debt", moneySpace(debtorSum))
 		} else if lenderSum != 0 {
 			debtText = I18n(u.User, "msg_lend_you", moneySpace(lenderSum))
@@ -61,7 +66,7 @@ func (bot *AllRoomInline) OnMessage(ctx context.Context, u *api.Update) (respons
 		startB := api.NewButton(viewStart, data)
 
 		if _, err := bot.bs.SaveAll(ctx, joinB, viewOpsB, viewDbtB, startB); err != nil {
-			log.Error().Err(err).Msg("create btn failed")
+			log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 			continue
 		}
 
@@ -110,7 +115,7 @@ func (bot *AllRoom) OnMessage(ctx context.Context, u *api.Update) (response api.
 
 	rooms, err := bot.rs.FindRoomsByUserId(ctx, getFrom(u).ID)
 	if err != nil {
-		log.Error().Err(err).Msgf("cannot find rooms")
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find rooms")
 		return
 	}
 	if len(*rooms) < 1 {
@@ -151,7 +156,7 @@ func (bot *AllRoom) OnMessage(ctx context.Context, u *api.Update) (response api.
 	})
 
 	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 
@@ -197,7 +202,7 @@ func (bot *ArchivedRooms) OnMessage(ctx context.Context, u *api.Update) (respons
 
 	rooms, err := bot.rs.FindArchivedRoomsByUserId(ctx, getFrom(u).ID)
 	if err != nil {
-		log.Error().Err(err).Msgf("cannot find rooms")
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find rooms")
 		return
 	}
 	if len(*rooms) < 1 {
@@ -238,7 +243,7 @@ func (bot *ArchivedRooms) OnMessage(ctx context.Context, u *api.Update) (respons
 	})
 
 	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 
@@ -260,7 +265,7 @@ const (
 func createRoomInfoText(r *api.Room, u *api.Update) string {
 	finishedAddOperationCount := len(r.RoomStates.FinishedAddOperation)
 	paidOffDebtCunt := len(r.RoomStates.PaidOffDebt)
-	memberCount := len(*r.Members)
+	memberCount := r.CountRealMembers()
 
 	partyType := definePartyType(finishedAddOperationCount, memberCount, paidOffDebtCunt)
 
@@ -307,7 +312,7 @@ func definePartyType(finishedAddOperationCount int, memberCount int, paidOffDebt
 func (bot AllRoomInline) findRoomsByUpdate(ctx context.Context, u *api.Update) *[]api.Room {
 	rooms, err := bot.rs.FindRoomsByLikeName(ctx, u.InlineQuery.From.ID, u.InlineQuery.Query)
 	if err != nil {
-		log.Error().Err(err).Msgf("can't send query to telegram %v", u.InlineQuery.From.ID)
+		log.Ctx(ctx).Error().Err(err).Msgf("can't send query to telegram %v", u.InlineQuery.From.ID)
 	}
 	return rooms
 }
@@ -0,0 +1,240 @@
+package bot
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+)
+
+// ViewBalances screen with balances of the user with other people over all active rooms
+type ViewBalances struct {
+	bs  ButtonService
+	os  OperationService
+	cfg *Config
+}
+
+func NewViewBalances(bs ButtonService, os OperationService, cfg *Config) *ViewBalances {
+	return &ViewBalances{
+		bs:  bs,
+		os:  os,
+		cfg: cfg,
+	}
+}
+
+func (bot ViewBalances) HasReact(u *api.Update) bool {
+	return hasAction(u, viewBalances)
+}
+
+func (bot *ViewBalances) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	balances, err := bot.os.GetUserBalances(ctx, u.User.ID)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get balances failed")
+		return failedResponse(u, err)
+	}
+	if len(balances) < 1 {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_have_not_balances"), true),
+			Send:           true,
+		}
+	}
+
+	var toSave []*api.Button
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for _, b := range balances {
+		btn := api.NewButton(viewBalance, &api.CallbackData{UserId: b.Counterparty.ID})
+		toSave = append(toSave, btn)
+		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(balanceButtonText(&b), btn.ID.Hex())})
+	}
+	backB := api.NewButton(viewStart, new(api.CallbackData))
+	toSave = append(toSave, backB)
+	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backB.ID.Hex())})
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, I18n(u.User, "scrn_balances"), &keyboard)},
+		Send:      true,
+	}
+}
+
+// ViewBalance screen with debts between the user and the counterparty in all rooms and their netted settlement
+type ViewBalance struct {
+	bs  ButtonService
+	os  OperationService
+	cfg *Config
+}
+
+func NewViewBalance(bs ButtonService, os OperationService, cfg *Config) *ViewBalance {
+	return &ViewBalance{
+		bs:  bs,
+		os:  os,
+		cfg: cfg,
+	}
+}
+
+func (bot ViewBalance) HasReact(u *api.Update) bool {
+	return hasAction(u, viewBalance)
+}
+
+func (bot *ViewBalance) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	counterpartyId := u.Button.CallbackData.UserId
+	balance, err := bot.os.GetUserBalance(ctx, u.User.ID, counterpartyId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get balance failed")
+		return failedResponse(u, err)
+	}
+	if balance == nil {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_have_not_balances"), true),
+			Send:           true,
+		}
+	}
+
+	text := I18n(u.User, "scrn_balance", userLink(balance.Counterparty))
+	for _, d := range balance.Debts {
+		text += "*" + d.RoomName + "*: " + debtLine(&d.Debt)
+	}
+	text += "\n" + balanceResultText(u.User, balance) + "\n\n" + I18n(u.User, "scrn_balance_settle_hint")
+
+	settleB := api.NewButton(settleBalance, &api.CallbackData{UserId: counterpartyId})
+	backB := api.NewButton(viewBalances, new(api.CallbackData))
+	if _, err := bot.bs.SaveAll(ctx, settleB, backB); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, text, &[][]tgbotapi.InlineKeyboardButton{
+			{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_settle_balance"), settleB.ID.Hex())},
+			{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backB.ID.Hex())},
+		})},
+		Send: true,
+	}
+}
+
+// SettleBalance records repayments of all debts between the user and the counterparty in every room as one settlement,
+// the settlement id is the id of the pressed button. The settlement is confirmed at once when the user receives the net
+// sum, otherwise the counterparty confirms or disputes the whole settlement
+type SettleBalance struct {
+	bs  ButtonService
+	os  OperationService
+	rs  RoomService
+	rss RoomStateService
+	us  UserService
+	cfg *Config
+}
+
+func NewSettleBalance(bs ButtonService, os OperationService, rs RoomService, rss RoomStateService, us UserService, cfg *Config) *SettleBalance {
+	return &SettleBalance{
+		bs:  bs,
+		os:  os,
+		rs:  rs,
+		rss: rss,
+		us:  us,
+		cfg: cfg,
+	}
+}
+
+func (bot SettleBalance) HasReact(u *api.Update) bool {
+	return hasAction(u, settleBalance)
+}
+
+func (bot *SettleBalance) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	counterpartyId := u.Button.CallbackData.UserId
+	counterparty, err := bot.us.FindById(ctx, counterpartyId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("find user failed %v", counterpartyId)
+		return
+	}
+	balance, repayments, err := bot.os.SettleBalance(ctx, u.User.ID, counterpartyId, u.Button.ID)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("settle balance failed")
+		return failedResponse(u, err)
+	}
+	if balance == nil {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_have_not_balances"), true),
+			Send:           true,
+		}
+	}
+	pending := len(repayments) > 0 && repayments[0].IsPendingRepayment()
+
+	if !pending {
+		//async calculate paidOfDebtsUserIds for rooms, after debt operations
+		go func() {
+			for _, d := range balance.Debts {
+				room, err := bot.rs.FindById(ctx, d.RoomId)
+				if err != nil {
+					log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+					continue
+				}
+				if err := bot.rss.DefinePaidOfDebtsUserIdsAndSave(ctx, room); err != nil {
+					log.Ctx(ctx).Error().Err(err).Msg("")
+				}
+			}
+		}()
+	}
+
+	backB := api.NewButton(viewBalances, new(api.CallbackData))
+	toSave := []*api.Button{backB}
+	var reviewKeyboard [][]tgbotapi.InlineKeyboardButton
+	if pending {
+		data := &api.CallbackData{OperationId: u.Button.ID, UserId: counterparty.ID, DebtorId: u.User.ID, Sum: -balance.Sum}
+		confirmB := api.NewButton(confirmSettlement, data)
+		disputeB := api.NewButton(disputeSettlement, data)
+		toSave = append(toSave, confirmB, disputeB)
+		reviewKeyboard = [][]tgbotapi.InlineKeyboardButton{{
+			tgbotapi.NewInlineKeyboardButtonData(I18n(counterparty, "btn_confirm_repayment"), confirmB.ID.Hex()),
+			tgbotapi.NewInlineKeyboardButtonData(I18n(counterparty, "btn_dispute_repayment"), disputeB.ID.Hex()),
+		}}
+	}
+	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	userText := I18n(u.User, "scrn_balance_settled", userLink(counterparty)) + balanceResultText(u.User, balance)
+	if pending {
+		userText += "\n\n" + I18n(u.User, "scrn_balance_settled_pending", userLink(counterparty))
+	}
+
+	counterpartyBalance := &api.Balance{Counterparty: u.User, Sum: -balance.Sum}
+	counterpartyText := I18n(counterparty, "scrn_balance_settled_counterparty", userLink(u.User))
+	for _, d := range balance.Debts {
+		counterpartyText += "*" + d.RoomName + "*: " + debtLine(&d.Debt)
+	}
+	counterpartyText += "\n" + balanceResultText(counterparty, counterpartyBalance)
+	if pending {
+		counterpartyText += I18n(counterparty, "scrn_confirm_settlement")
+	}
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{
+			createScreen(u, userText, &[][]tgbotapi.InlineKeyboardButton{{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_done"), backB.ID.Hex())}}),
+			NewMessage(int64(counterparty.ID), counterpartyText, reviewKeyboard),
+		},
+		Send: true,
+	}
+}
+
+// balanceResultText returns who has to transfer money to settle the balance
+func balanceResultText(user *api.User, b *api.Balance) string {
+	switch {
+	case b.Sum > 0:
+		return I18n(user, "scrn_balance_lend", userLink(b.Counterparty), moneySpace(b.Sum))
+	case b.Sum < 0:
+		return I18n(user, "scrn_balance_owe", userLink(b.Counterparty), moneySpace(-b.Sum))
+	default:
+		return I18n(user, "scrn_balance_even")
+	}
+}
+
+func balanceButtonText(b *api.Balance) string {
+	switch {
+	case b.Sum > 0:
+		return "🟢 " + shortName(b.Counterparty) + ": +" + moneySpace(b.Sum) + " $"
+	case b.Sum < 0:
+		return "🔴 " + shortName(b.Counterparty) + ": -" + moneySpace(-b.Sum) + " $"
+	default:
+		return "⚪️ " + shortName(b.Counterparty) + ": 0 $"
+	}
+}
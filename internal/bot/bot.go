@@ -2,7 +2,9 @@ package bot
 
 import (
 	"context"
+	"fmt"
 	"github.com/almaznur91/splitty/internal/api"
+	"github.com/almaznur91/splitty/internal/tracing"
 	"github.com/go-pkgz/syncs"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/rs/zerolog/log"
@@ -56,6 +58,50 @@ const (
 	chooseNotification     api.Action = "choose_notification"
 	selectedLanguage       api.Action = "selected_language"
 	selectedNotification   api.Action = "selected_notification"
+	wantAddVirtualMember   api.Action = "want_add_virtual_member"
+	addVirtualMember       api.Action = "add_virtual_member"
+	mergeVirtualMember     api.Action = "merge_virtual_member"
+	wantAddCoPayer         api.Action = "want_add_co_payer"
+	chooseCoPayer          api.Action = "choose_co_payer"
+	addCoPayer             api.Action = "add_co_payer"
+	editOperationItem      api.Action = "edit_operation_item"
+	wantEditOperationField api.Action = "want_edit_operation_field"
+	editOperationField     api.Action = "edit_operation_field"
+	postRoomSummary        api.Action = "post_room_summary"
+	confirmRepayment       api.Action = "confirm_repayment"
+	disputeRepayment       api.Action = "dispute_repayment"
+	confirmSettlement      api.Action = "confirm_settlement"
+	disputeSettlement      api.Action = "dispute_settlement"
+	reminderSetting        api.Action = "reminder_setting"
+	nudgeDebtor            api.Action = "nudge_debtor"
+	viewBalances           api.Action = "balances"
+	viewBalance            api.Action = "balance"
+	settleBalance          api.Action = "settle_balance"
+	chooseCategory         api.Action = "choose_category"
+	setCategory            api.Action = "set_category"
+	roomCategories         api.Action = "room_categories"
+	removeRoomCategory     api.Action = "remove_room_category"
+	wantAddRoomCategory    api.Action = "want_add_room_category"
+	addRoomCategory        api.Action = "add_room_category"
+	statisticChart         api.Action = "statistic_chart"
+	budgetSetting          api.Action = "budget_setting"
+	chooseBudgetTarget     api.Action = "choose_budget_target"
+	wantSetBudget          api.Action = "want_set_budget"
+	setBudget              api.Action = "set_budget"
+	chooseDigest           api.Action = "choose_digest"
+	personalData           api.Action = "personal_data"
+	exportPersonalData     api.Action = "export_personal_data"
+	wantDeleteAccount      api.Action = "want_delete_account"
+	deleteAccount          api.Action = "delete_account"
+	paymentMethods         api.Action = "payment_methods"
+	wantAddPaymentMethod   api.Action = "want_add_payment_method"
+	addPaymentMethod       api.Action = "add_payment_method"
+	deletePaymentMethod    api.Action = "delete_payment_method"
+	confirmExpense         api.Action = "confirm_expense"
+	cancelExpense          api.Action = "cancel_expense"
+	groupDeleteOperation   api.Action = "group_delete_operation"
+	roomCurrency           api.Action = "room_currency"
+	setRoomCurrency        api.Action = "set_room_currency"
 )
 
 const (
@@ -88,9 +134,12 @@ func (b MultiBot) OnMessage(ctx context.Context, update *api.Update) (response a
 	wg := syncs.NewSizedGroup(4)
 	for _, bot := range b {
 		bot := bot
-		wg.Go(func(ctx context.Context) {
-			defer handlePanic(bot)
+		// bots get the context of the update, not the one of the group
+		wg.Go(func(context.Context) {
+			defer handlePanic(ctx, bot)
 			if bot.HasReact(update) {
+				ctx, span := tracing.Start(ctx, fmt.Sprintf("%T", bot))
+				defer span.End()
 				if resp := bot.OnMessage(ctx, update); resp.Send {
 					resps <- resp
 				}
@@ -106,23 +155,28 @@ func (b MultiBot) OnMessage(ctx context.Context, update *api.Update) (response a
 
 	message := &api.TelegramMessage{Chattable: []tgbotapi.Chattable{}}
 	for r := range resps {
-		log.Debug().Msgf("collect %v", r)
+		log.Ctx(ctx).Debug().Msgf("collect %v", r)
 		message.Chattable = append(message.Chattable, r.Chattable...)
 		message.InlineConfig = r.InlineConfig
 		message.CallbackConfig = r.CallbackConfig
 		message.Redirect = r.Redirect
+		message.Pin = message.Pin || r.Pin
+		if r.SummaryOf != "" {
+			message.SummaryOf = r.SummaryOf
+		}
+		message.Restrict = append(message.Restrict, r.Restrict...)
 		message.Send = true
 	}
 
 	return *message
 }
-func handlePanic(bot Interface) {
+func handlePanic(ctx context.Context, bot Interface) {
 	if err := recover(); err != nil {
 		switch e := err.(type) {
 		case error:
-			log.Error().Err(e).Stack().Msgf("panic! bot: %T, stack: %s", bot, string(debug.Stack()))
+			log.Ctx(ctx).Error().Err(e).Stack().Msgf("panic! bot: %T, stack: %s", bot, string(debug.Stack()))
 		default:
-			log.Error().Stack().Msgf("panic! bot: %t, err: %v, stack: %s", bot, err, string(debug.Stack()))
+			log.Ctx(ctx).Error().Stack().Msgf("panic! bot: %t, err: %v, stack: %s", bot, err, string(debug.Stack()))
 		}
 	}
 }
@@ -0,0 +1,359 @@
+package bot
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// budgetDaysKey is the key of the planned trip length, it is set on the budget screen like budgets
+const budgetDaysKey = "days"
+
+// budgetThresholds are percents of the budget, about which members are alerted
+var budgetThresholds = []int{50, 80, 100}
+
+// BudgetSetting screen with budgets of the room and their spending
+type BudgetSetting struct {
+	bs  ButtonService
+	rs  RoomService
+	os  OperationService
+	cfg *Config
+}
+
+func NewBudgetSetting(bs ButtonService, rs RoomService, os OperationService, cfg *Config) *BudgetSetting {
+	return &BudgetSetting{
+		bs:  bs,
+		rs:  rs,
+		os:  os,
+		cfg: cfg,
+	}
+}
+
+func (bot BudgetSetting) HasReact(u *api.Update) bool {
+	return hasAction(u, budgetSetting)
+}
+
+func (bot *BudgetSetting) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	roomId := u.Button.CallbackData.RoomId
+	room, err := bot.rs.FindById(ctx, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find room, id:%s", roomId)
+		return
+	}
+
+	text := I18n(u.User, "scrn_budget_setting", room.Name) + "\n\n"
+	text += budgetText(u.User, room, bot.os.BudgetStatuses(room), bot.os.BudgetForecast(room, time.Now()))
+
+	totalB := api.NewButton(wantSetBudget, &api.CallbackData{RoomId: roomId, ExternalData: api.BudgetTotalKey})
+	daysB := api.NewButton(wantSetBudget, &api.CallbackData{RoomId: roomId, ExternalData: budgetDaysKey})
+	categoryB := api.NewButton(chooseBudgetTarget, &api.CallbackData{RoomId: roomId, ExternalData: "category"})
+	memberB := api.NewButton(chooseBudgetTarget, &api.CallbackData{RoomId: roomId, ExternalData: "member"})
+	backB := api.NewButton(roomSetting, &api.CallbackData{RoomId: roomId})
+	if _, err := bot.bs.SaveAll(ctx, totalB, daysB, categoryB, memberB, backB); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, text, &[][]tgbotapi.InlineKeyboardButton{
+			{
+				tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_budget_total"), totalB.ID.Hex()),
+				tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_budget_days"), daysB.ID.Hex()),
+			},
+			{
+				tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_budget_category"), categoryB.ID.Hex()),
+				tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_budget_member"), memberB.ID.Hex()),
+			},
+			{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backB.ID.Hex())},
+		})},
+		Send: true,
+	}
+}
+
+// ChooseBudgetTarget screen with categories or members of the room to set their budget, CallbackData.ExternalData is category or member
+type ChooseBudgetTarget struct {
+	bs  ButtonService
+	rs  RoomService
+	cfg *Config
+}
+
+func NewChooseBudgetTarget(bs ButtonService, rs RoomService, cfg *Config) *ChooseBudgetTarget {
+	return &ChooseBudgetTarget{
+		bs:  bs,
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot ChooseBudgetTarget) HasReact(u *api.Update) bool {
+	return hasAction(u, chooseBudgetTarget)
+}
+
+func (bot *ChooseBudgetTarget) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find room, id:%s", data.RoomId)
+		return
+	}
+
+	var toSave []*api.Button
+	var buttons []tgbotapi.InlineKeyboardButton
+	addButton := func(key string, name string) {
+		b := api.NewButton(wantSetBudget, &api.CallbackData{RoomId: data.RoomId, ExternalData: key})
+		toSave = append(toSave, b)
+		if limit := room.Budget.Limit(key); limit > 0 {
+			name += ": " + moneySpace(limit) + " $"
+		}
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(name, b.ID.Hex()))
+	}
+	text := I18n(u.User, "scrn_budget_choose_category")
+	if data.ExternalData == "member" {
+		text = I18n(u.User, "scrn_budget_choose_member")
+		for _, m := range *room.Members {
+			addButton(api.MemberBudgetKey(m.ID), shortName(&m))
+		}
+	} else {
+		for _, c := range room.ExpenseCategories() {
+			addButton(api.CategoryBudgetKey(c), categoryName(u.User, c))
+		}
+	}
+	backB := api.NewButton(budgetSetting, &api.CallbackData{RoomId: data.RoomId})
+	toSave = append(toSave, backB)
+	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+
+	keyboard := splitKeyboardButtons(buttons, 2)
+	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backB.ID.Hex())})
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, text, &keyboard)},
+		Send:      true,
+	}
+}
+
+// WantSetBudget screen asks the sum of the budget, the key of the budget is in CallbackData.ExternalData
+type WantSetBudget struct {
+	bs  ButtonService
+	css ChatStateService
+	rs  RoomService
+	cfg *Config
+}
+
+func NewWantSetBudget(bs ButtonService, css ChatStateService, rs RoomService, cfg *Config) *WantSetBudget {
+	return &WantSetBudget{
+		bs:  bs,
+		css: css,
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot WantSetBudget) HasReact(u *api.Update) bool {
+	return hasAction(u, wantSetBudget)
+}
+
+func (bot *WantSetBudget) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find room, id:%s", data.RoomId)
+		return
+	}
+	cs := &api.ChatState{UserId: int(getChatID(u)), Action: setBudget, CallbackData: &api.CallbackData{RoomId: data.RoomId, ExternalData: data.ExternalData}}
+	if err := bot.css.Save(ctx, cs); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create chat state failed")
+		return
+	}
+
+	cancelBtn := api.NewButton(budgetSetting, &api.CallbackData{RoomId: data.RoomId})
+	if _, err := bot.bs.SaveAll(ctx, cancelBtn); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, budgetPrompt(u.User, room, data.ExternalData), &[][]tgbotapi.InlineKeyboardButton{
+			{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), cancelBtn.ID.Hex())},
+		})},
+		Send: true,
+	}
+}
+
+// SetBudget saves the sum of the budget written by the user, zero removes the budget
+type SetBudget struct {
+	bs  ButtonService
+	css ChatStateService
+	rs  RoomService
+	cfg *Config
+}
+
+func NewSetBudget(bs ButtonService, css ChatStateService, rs RoomService, cfg *Config) *SetBudget {
+	return &SetBudget{
+		bs:  bs,
+		css: css,
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot SetBudget) HasReact(u *api.Update) bool {
+	return hasAction(u, setBudget) && hasMessage(u)
+}
+
+func (bot *SetBudget) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.ChatState.CallbackData
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find room, id:%s", data.RoomId)
+		return
+	}
+
+	sum, err := strconv.Atoi(strings.TrimSpace(u.Message.Text))
+	if err != nil || sum < 0 {
+		cancelBtn := api.NewButton(budgetSetting, &api.CallbackData{RoomId: data.RoomId})
+		if _, err := bot.bs.SaveAll(ctx, cancelBtn); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+			return
+		}
+		return api.TelegramMessage{
+			Chattable: []tgbotapi.Chattable{NewMessage(getChatID(u), I18n(u.User, "msg_wrong_format")+budgetPrompt(u.User, room, data.ExternalData),
+				[][]tgbotapi.InlineKeyboardButton{{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), cancelBtn.ID.Hex())}})},
+			Send: true,
+		}
+	}
+	defer bot.css.CleanChatState(ctx, u.ChatState)
+
+	budget := room.Budget
+	if data.ExternalData == budgetDaysKey {
+		budget.Days = sum
+	} else if !budget.SetLimit(data.ExternalData, sum) {
+		log.Ctx(ctx).Error().Msgf("wrong budget key %v", data.ExternalData)
+		return
+	}
+	if err := bot.rs.SetBudget(ctx, data.RoomId, budget); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("set budget failed")
+		return
+	}
+
+	u.ChatState = nil
+	u.Button = api.NewButton(budgetSetting, &api.CallbackData{RoomId: data.RoomId})
+	return api.TelegramMessage{
+		Redirect: u,
+		Send:     true,
+	}
+}
+
+// createBudgetAlerts returns alerts about budgets, whose spending has reached the next threshold, and saves them.
+// Alerts about the total and category budgets are sent to all members, about the member budget to the member only
+func createBudgetAlerts(ctx context.Context, rs RoomService, os OperationService, us UserService, room *api.Room) []tgbotapi.Chattable {
+	var messages []tgbotapi.Chattable
+	var changed bool
+	budget := room.Budget
+	for _, s := range os.BudgetStatuses(room) {
+		var threshold int
+		for _, t := range budgetThresholds {
+			if s.Percent() >= t {
+				threshold = t
+			}
+		}
+		alert := budget.FindAlert(s.Key)
+		if threshold == 0 || alert != nil && alert.Percent >= threshold {
+			continue
+		}
+		budget.SetAlert(s.Key, threshold)
+		changed = true
+
+		userIds := []int{}
+		if s.Member != nil {
+			userIds = append(userIds, notifiedUserId(s.Member))
+		} else {
+			for _, m := range *room.Members {
+				if !m.IsVirtual {
+					userIds = append(userIds, m.ID)
+				}
+			}
+		}
+		for _, id := range userIds {
+			user, err := us.FindById(ctx, id)
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Msgf("find user failed %v", id)
+				continue
+			}
+			if !*user.NotificationOn {
+				continue
+			}
+			text := I18n(user, "scrn_budget_alert", room.Name, s.Percent(), budgetName(user, &s), moneySpace(s.Spent), moneySpace(s.Limit))
+			if threshold >= 100 {
+				text = I18n(user, "scrn_budget_exceeded", room.Name, budgetName(user, &s), moneySpace(s.Spent), moneySpace(s.Limit))
+			}
+			messages = append(messages, NewMessage(int64(user.ID), text, nil))
+		}
+	}
+	if changed {
+		if err := rs.SetBudget(ctx, room.ID.Hex(), budget); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("save budget alerts failed")
+		}
+	}
+	return messages
+}
+
+// budgetText returns spending of every budget and the forecast of the total spending
+func budgetText(user *api.User, room *api.Room, statuses []api.BudgetStatus, forecast api.BudgetForecast) string {
+	if len(statuses) == 0 && room.Budget.Days == 0 {
+		return I18n(user, "scrn_budget_not_set")
+	}
+	var text string
+	for _, s := range statuses {
+		if s.Left() < 0 {
+			text += I18n(user, "scrn_budget_line_exceeded", budgetName(user, &s), moneySpace(s.Spent), moneySpace(s.Limit), s.Percent(), moneySpace(-s.Left()))
+		} else {
+			text += I18n(user, "scrn_budget_line", budgetName(user, &s), moneySpace(s.Spent), moneySpace(s.Limit), s.Percent(), moneySpace(s.Left()))
+		}
+	}
+	if room.Budget.Days > 0 {
+		text += "\n" + I18n(user, "scrn_budget_days", room.Budget.Days)
+		if room.Budget.Total > 0 && forecast.Projected > room.Budget.Total {
+			text += I18n(user, "scrn_budget_forecast_overspend", moneySpace(forecast.Projected), moneySpace(forecast.Projected-room.Budget.Total))
+		} else {
+			text += I18n(user, "scrn_budget_forecast", moneySpace(forecast.Projected))
+		}
+	} else if forecast.DaysLeft > 0 {
+		text += "\n" + I18n(user, "scrn_budget_days_left", forecast.DaysLeft)
+	}
+	return text
+}
+
+// budgetName returns the name of the budget for texts, example = Food
+func budgetName(user *api.User, s *api.BudgetStatus) string {
+	switch {
+	case s.Member != nil:
+		return userLink(s.Member)
+	case s.Key == api.BudgetTotalKey:
+		return I18n(user, "scrn_budget_total")
+	default:
+		return categoryName(user, s.Category)
+	}
+}
+
+// budgetPrompt asks the sum of the budget or the trip length by the key
+func budgetPrompt(user *api.User, room *api.Room, key string) string {
+	if key == budgetDaysKey {
+		return I18n(user, "scrn_budget_days_prompt")
+	}
+	name := I18n(user, "scrn_budget_total")
+	for _, c := range room.ExpenseCategories() {
+		if api.CategoryBudgetKey(c) == key {
+			name = categoryName(user, c)
+		}
+	}
+	for i := range *room.Members {
+		if api.MemberBudgetKey((*room.Members)[i].ID) == key {
+			name = userLink(&(*room.Members)[i])
+		}
+	}
+	return I18n(user, "scrn_budget_sum_prompt", name)
+}
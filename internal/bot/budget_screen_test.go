@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/gookit/i18n"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// budgetRoomService saves the budget back to the room, as if the room is read again before the next alert
+type budgetRoomService struct {
+	RoomService
+	room  *api.Room
+	saved int
+}
+
+func (s *budgetRoomService) SetBudget(_ context.Context, _ string, budget api.Budget) error {
+	s.saved++
+	s.room.Budget = budget
+	return nil
+}
+
+// budgetOperationService returns statuses set by the test
+type budgetOperationService struct {
+	OperationService
+	statuses []api.BudgetStatus
+}
+
+func (s *budgetOperationService) BudgetStatuses(_ *api.Room) []api.BudgetStatus {
+	return s.statuses
+}
+
+// budgetUserService returns users with notifications on
+type budgetUserService struct {
+	UserService
+}
+
+func (s *budgetUserService) FindById(_ context.Context, id int) (*api.User, error) {
+	on := true
+	return &api.User{ID: id, NotificationOn: &on}, nil
+}
+
+func alertChatIds(messages []tgbotapi.Chattable) []int64 {
+	var ids []int64
+	for _, m := range messages {
+		ids = append(ids, m.(tgbotapi.MessageConfig).ChatID)
+	}
+	return ids
+}
+
+func TestCreateBudgetAlerts(t *testing.T) {
+	i18n.Init("../../conf/lang", "en", map[string]string{"en": "English", "ru": "Русский"})
+	ctx := context.Background()
+	virtual := api.User{ID: -1, DisplayName: "Kid", IsVirtual: true, ManagerId: 2}
+	members := []api.User{{ID: 1, DisplayName: "A"}, {ID: 2, DisplayName: "B"}, virtual}
+	room := &api.Room{ID: primitive.NewObjectID(), Name: "Trip", Members: &members}
+	rs := &budgetRoomService{room: room}
+	os := &budgetOperationService{}
+	us := &budgetUserService{}
+
+	// spending jumps from nothing past 80%, the alert is sent once about the highest threshold
+	os.statuses = []api.BudgetStatus{{Key: api.BudgetTotalKey, Limit: 1000, Spent: 850}}
+	messages := createBudgetAlerts(ctx, rs, os, us, room)
+	assert.Equal(t, []int64{1, 2}, alertChatIds(messages), "real members are alerted once each")
+	assert.Equal(t, []api.BudgetAlert{{Key: api.BudgetTotalKey, Percent: 80}}, room.Budget.Alerts)
+	assert.Equal(t, 1, rs.saved)
+
+	// the same threshold is not alerted again
+	os.statuses[0].Spent = 900
+	assert.Empty(t, createBudgetAlerts(ctx, rs, os, us, room))
+	assert.Equal(t, 1, rs.saved, "the budget is not saved without new alerts")
+
+	// the next threshold is alerted
+	os.statuses[0].Spent = 1000
+	assert.Equal(t, []int64{1, 2}, alertChatIds(createBudgetAlerts(ctx, rs, os, us, room)))
+	assert.Equal(t, 100, room.Budget.FindAlert(api.BudgetTotalKey).Percent)
+
+	// the alert about the budget of the virtual member goes to his manager only
+	key := api.MemberBudgetKey(virtual.ID)
+	os.statuses = append(os.statuses, api.BudgetStatus{Key: key, Member: &virtual, Limit: 100, Spent: 60})
+	assert.Equal(t, []int64{2}, alertChatIds(createBudgetAlerts(ctx, rs, os, us, room)))
+	assert.Equal(t, 50, room.Budget.FindAlert(key).Percent)
+	assert.Empty(t, createBudgetAlerts(ctx, rs, os, us, room))
+}
@@ -0,0 +1,335 @@
+package bot
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+	"strings"
+)
+
+// ChooseOperationCategory screen with categories of the room for the operation
+type ChooseOperationCategory struct {
+	bs  ButtonService
+	rs  RoomService
+	cfg *Config
+}
+
+func NewChooseOperationCategory(bs ButtonService, rs RoomService, cfg *Config) *ChooseOperationCategory {
+	return &ChooseOperationCategory{
+		bs:  bs,
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot ChooseOperationCategory) HasReact(u *api.Update) bool {
+	return hasAction(u, chooseCategory)
+}
+
+func (bot *ChooseOperationCategory) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
+	operation := findOperation(room, data.OperationId)
+	if operation == nil {
+		log.Ctx(ctx).Error().Msgf("operation not found, id:%s", data.OperationId.Hex())
+		return
+	}
+
+	var toSave []*api.Button
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, c := range room.ExpenseCategories() {
+		b := api.NewButton(setCategory, &api.CallbackData{RoomId: data.RoomId, OperationId: data.OperationId, ExternalData: c})
+		toSave = append(toSave, b)
+		text := categoryName(u.User, c)
+		if c == operation.Category {
+			text = "✅ " + text
+		}
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(text, b.ID.Hex()))
+	}
+	backB := api.NewButton(editDonorOperation, &api.CallbackData{RoomId: data.RoomId, OperationId: data.OperationId})
+	toSave = append(toSave, backB)
+	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+
+	keyboard := splitKeyboardButtons(buttons, 2)
+	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backB.ID.Hex())})
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, I18n(u.User, "scrn_choose_category", operation.Description), &keyboard)},
+		Send:      true,
+	}
+}
+
+// SetOperationCategory saves the chosen category of the operation, the category is in CallbackData.ExternalData
+type SetOperationCategory struct {
+	os  OperationService
+	rs  RoomService
+	cfg *Config
+}
+
+func NewSetOperationCategory(os OperationService, rs RoomService, cfg *Config) *SetOperationCategory {
+	return &SetOperationCategory{
+		os:  os,
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot SetOperationCategory) HasReact(u *api.Update) bool {
+	return hasAction(u, setCategory)
+}
+
+func (bot *SetOperationCategory) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
+	operation := findOperation(room, data.OperationId)
+	if operation == nil {
+		log.Ctx(ctx).Error().Msgf("operation not found, id:%s", data.OperationId.Hex())
+		return
+	}
+	operation.Category = data.ExternalData
+	if err := bot.os.UpsertOperation(ctx, operation, data.RoomId); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("upsert operation failed")
+		return
+	}
+
+	u.Button = api.NewButton(editDonorOperation, &api.CallbackData{RoomId: data.RoomId, OperationId: data.OperationId})
+	return api.TelegramMessage{
+		Redirect: u,
+		Send:     true,
+	}
+}
+
+// RoomCategories screen with categories of operations in the room, pressed category is removed
+type RoomCategories struct {
+	bs  ButtonService
+	rs  RoomService
+	cfg *Config
+}
+
+func NewRoomCategories(bs ButtonService, rs RoomService, cfg *Config) *RoomCategories {
+	return &RoomCategories{
+		bs:  bs,
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot RoomCategories) HasReact(u *api.Update) bool {
+	return hasAction(u, roomCategories)
+}
+
+func (bot *RoomCategories) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	roomId := u.Button.CallbackData.RoomId
+	room, err := bot.rs.FindById(ctx, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find room, id:%s", roomId)
+		return
+	}
+
+	var toSave []*api.Button
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, c := range room.ExpenseCategories() {
+		b := api.NewButton(removeRoomCategory, &api.CallbackData{RoomId: roomId, ExternalData: c})
+		toSave = append(toSave, b)
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("❌ "+categoryName(u.User, c), b.ID.Hex()))
+	}
+	addB := api.NewButton(wantAddRoomCategory, &api.CallbackData{RoomId: roomId})
+	backB := api.NewButton(roomSetting, &api.CallbackData{RoomId: roomId})
+	toSave = append(toSave, addB, backB)
+	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+
+	keyboard := splitKeyboardButtons(buttons, 2)
+	keyboard = append(keyboard,
+		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_add_category"), addB.ID.Hex())},
+		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backB.ID.Hex())})
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, I18n(u.User, "scrn_room_categories", room.Name), &keyboard)},
+		Send:      true,
+	}
+}
+
+// RemoveRoomCategory removes the category from the room, operations keep it until edited
+type RemoveRoomCategory struct {
+	rs  RoomService
+	cfg *Config
+}
+
+func NewRemoveRoomCategory(rs RoomService, cfg *Config) *RemoveRoomCategory {
+	return &RemoveRoomCategory{
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot RemoveRoomCategory) HasReact(u *api.Update) bool {
+	return hasAction(u, removeRoomCategory)
+}
+
+func (bot *RemoveRoomCategory) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find room, id:%s", data.RoomId)
+		return
+	}
+	categories := room.ExpenseCategories()
+	if len(categories) < 2 {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_last_category"), true),
+			Send:           true,
+		}
+	}
+
+	var left []string
+	for _, c := range categories {
+		if c != data.ExternalData {
+			left = append(left, c)
+		}
+	}
+	if err := bot.rs.SetCategories(ctx, data.RoomId, left); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("set categories failed")
+		return
+	}
+
+	u.Button = api.NewButton(roomCategories, &api.CallbackData{RoomId: data.RoomId})
+	return api.TelegramMessage{
+		Redirect: u,
+		Send:     true,
+	}
+}
+
+// WantAddRoomCategory screen asks the name of the new category
+type WantAddRoomCategory struct {
+	bs  ButtonService
+	css ChatStateService
+	cfg *Config
+}
+
+func NewWantAddRoomCategory(bs ButtonService, css ChatStateService, cfg *Config) *WantAddRoomCategory {
+	return &WantAddRoomCategory{
+		bs:  bs,
+		css: css,
+		cfg: cfg,
+	}
+}
+
+func (bot WantAddRoomCategory) HasReact(u *api.Update) bool {
+	return hasAction(u, wantAddRoomCategory)
+}
+
+func (bot *WantAddRoomCategory) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	roomId := u.Button.CallbackData.RoomId
+	cs := &api.ChatState{UserId: int(getChatID(u)), Action: addRoomCategory, CallbackData: &api.CallbackData{RoomId: roomId}}
+	if err := bot.css.Save(ctx, cs); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create chat state failed")
+		return
+	}
+
+	cancelBtn := api.NewButton(roomCategories, &api.CallbackData{RoomId: roomId})
+	if _, err := bot.bs.SaveAll(ctx, cancelBtn); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, I18n(u.User, "scrn_category_name"), &[][]tgbotapi.InlineKeyboardButton{
+			{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), cancelBtn.ID.Hex())},
+		})},
+		Send: true,
+	}
+}
+
+// AddRoomCategory adds the category written by the user to the room
+type AddRoomCategory struct {
+	bs  ButtonService
+	rs  RoomService
+	css ChatStateService
+	cfg *Config
+}
+
+func NewAddRoomCategory(bs ButtonService, rs RoomService, css ChatStateService, cfg *Config) *AddRoomCategory {
+	return &AddRoomCategory{
+		bs:  bs,
+		rs:  rs,
+		css: css,
+		cfg: cfg,
+	}
+}
+
+func (bot AddRoomCategory) HasReact(u *api.Update) bool {
+	return hasAction(u, addRoomCategory) && hasMessage(u)
+}
+
+func (bot *AddRoomCategory) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	roomId := u.ChatState.CallbackData.RoomId
+	category := strings.TrimSpace(u.Message.Text)
+	if category == "" {
+		// the chat state is kept, so the user can send the name again
+		cancelBtn := api.NewButton(roomCategories, &api.CallbackData{RoomId: roomId})
+		if _, err := bot.bs.SaveAll(ctx, cancelBtn); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+			return
+		}
+		return api.TelegramMessage{
+			Chattable: []tgbotapi.Chattable{NewMessage(getChatID(u), I18n(u.User, "msg_wrong_format")+I18n(u.User, "scrn_category_name"),
+				[][]tgbotapi.InlineKeyboardButton{{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), cancelBtn.ID.Hex())}})},
+			Send: true,
+		}
+	}
+	defer bot.css.CleanChatState(ctx, u.ChatState)
+
+	room, err := bot.rs.FindById(ctx, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find room, id:%s", roomId)
+		return
+	}
+	categories := room.ExpenseCategories()
+	if !contains(categories, category) {
+		categories = append(append([]string{}, categories...), category)
+		if err := bot.rs.SetCategories(ctx, roomId, categories); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("set categories failed")
+			return
+		}
+	}
+
+	u.ChatState = nil
+	u.Button = api.NewButton(roomCategories, &api.CallbackData{RoomId: roomId})
+	return api.TelegramMessage{
+		Redirect: u,
+		Send:     true,
+	}
+}
+
+// categoryLine returns the category of the operation for operation screens, it is empty for operations without category
+func categoryLine(user *api.User, o *api.Operation) string {
+	if o.Category == "" {
+		return ""
+	}
+	return "🏷 " + categoryName(user, o.Category) + "\n"
+}
+
+// categoryName returns translated name of the default category, categories added by members are shown as is
+func categoryName(user *api.User, category string) string {
+	if category == "" {
+		return I18n(user, "btn_category_none")
+	}
+	for _, c := range api.DefaultCategories {
+		if c == category {
+			return I18n(user, "btn_category_"+category)
+		}
+	}
+	return category
+}
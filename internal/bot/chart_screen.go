@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/api"
+	"github.com/almaznur91/splitty/internal/chart"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+	"sort"
+)
+
+// kinds of statistic charts, the kind is in CallbackData.ExternalData
+const (
+	chartCategories = "categories"
+	chartMembers    = "members"
+	chartTimeline   = "timeline"
+	chartDebts      = "debts"
+)
+
+var chartKinds = []string{chartCategories, chartMembers, chartTimeline, chartDebts}
+
+// StatisticChart sends the chart of the room statistics as the image
+type StatisticChart struct {
+	rs  RoomService
+	os  OperationService
+	ss  StatisticService
+	cfg *Config
+}
+
+func NewStatisticChart(rs RoomService, os OperationService, ss StatisticService, cfg *Config) *StatisticChart {
+	return &StatisticChart{
+		rs:  rs,
+		os:  os,
+		ss:  ss,
+		cfg: cfg,
+	}
+}
+
+func (bot StatisticChart) HasReact(u *api.Update) bool {
+	return isPrivate(u) && hasAction(u, statisticChart)
+}
+
+func (bot *StatisticChart) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find room, id:%s", data.RoomId)
+		return
+	}
+
+	title := I18n(u.User, "scrn_chart_"+data.ExternalData, room.Name)
+	var image []byte
+	switch data.ExternalData {
+	case chartCategories:
+		image, err = bot.categoriesChart(ctx, u.User, title, data.RoomId)
+	case chartMembers:
+		image, err = bot.membersChart(ctx, title, data.RoomId)
+	case chartTimeline:
+		image, err = bot.timelineChart(ctx, title, data.RoomId)
+	case chartDebts:
+		image, err = bot.debtsChart(ctx, title, data.RoomId)
+	default:
+		log.Ctx(ctx).Error().Msgf("unknown chart %v", data.ExternalData)
+		return
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("render chart %v failed", data.ExternalData)
+		return failedResponse(u, err)
+	}
+	if image == nil {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_chart_empty"), true),
+			Send:           true,
+		}
+	}
+	return api.TelegramMessage{
+		Chattable:      []tgbotapi.Chattable{NewPhotoUpload(getChatID(u), title, data.ExternalData+".png", image)},
+		CallbackConfig: createCallback(u, "", false),
+		Send:           true,
+	}
+}
+
+func (bot *StatisticChart) categoriesChart(ctx context.Context, user *api.User, title string, roomId string) ([]byte, error) {
+	costs, err := bot.ss.GetCategoryCostsSums(ctx, roomId)
+	if err != nil || len(costs) == 0 {
+		return nil, err
+	}
+	var values []chart.Value
+	for _, c := range costs {
+		values = append(values, chart.Value{Label: categoryName(user, c.Category), Value: float64(c.Sum)})
+	}
+	return chart.Pie(title, values)
+}
+
+func (bot *StatisticChart) membersChart(ctx context.Context, title string, roomId string) ([]byte, error) {
+	costs, err := bot.ss.GetMembersCostsSums(ctx, roomId)
+	if err != nil || len(costs) == 0 {
+		return nil, err
+	}
+	var values []chart.Value
+	for _, m := range costs {
+		values = append(values, chart.Value{Label: shortName(m.Member), Value: float64(m.Sum)})
+	}
+	return chart.Bar(title, values)
+}
+
+// timelineChart shows cumulative spending of the room by operations in order of creation
+func (bot *StatisticChart) timelineChart(ctx context.Context, title string, roomId string) ([]byte, error) {
+	ops, err := bot.os.GetAllSpendOperations(ctx, roomId)
+	if err != nil || len(*ops) == 0 {
+		return nil, err
+	}
+	sorted := append([]api.Operation{}, *ops...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreateAt.Before(sorted[j].CreateAt)
+	})
+	var sum int
+	var points []chart.Point
+	for _, o := range sorted {
+		sum += o.Sum
+		points = append(points, chart.Point{Time: o.CreateAt, Value: float64(sum)})
+	}
+	return chart.Line(title, points)
+}
+
+// debtsChart shows unpaid debts as arrows from debtors to lenders
+func (bot *StatisticChart) debtsChart(ctx context.Context, title string, roomId string) ([]byte, error) {
+	debts, err := bot.os.GetAllDebts(ctx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	var edges []chart.Edge
+	for _, d := range debts {
+		if d.Unpaid() < 1 {
+			continue
+		}
+		edges = append(edges, chart.Edge{From: shortName(d.Debtor), To: shortName(d.Lender), Label: moneySpace(d.Unpaid()) + " $"})
+	}
+	if len(edges) == 0 {
+		return nil, nil
+	}
+	return chart.Graph(title, edges)
+}
+
+// createChartKeyboard returns buttons sending charts of the room statistics
+func createChartKeyboard(ctx context.Context, bs ButtonService, user *api.User, roomId string) ([][]tgbotapi.InlineKeyboardButton, error) {
+	var toSave []*api.Button
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, kind := range chartKinds {
+		b := api.NewButton(statisticChart, &api.CallbackData{RoomId: roomId, ExternalData: kind})
+		toSave = append(toSave, b)
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(I18n(user, "btn_chart_"+kind), b.ID.Hex()))
+	}
+	if _, err := bs.SaveAll(ctx, toSave...); err != nil {
+		return nil, err
+	}
+	return splitKeyboardButtons(buttons, 2), nil
+}
@@ -0,0 +1,250 @@
+package bot
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strconv"
+	"strings"
+)
+
+// WantAddCoPayer screen with members who could pay a part of the operation
+type WantAddCoPayer struct {
+	bs  ButtonService
+	rs  RoomService
+	cfg *Config
+}
+
+func NewWantAddCoPayer(bs ButtonService, rs RoomService, cfg *Config) *WantAddCoPayer {
+	return &WantAddCoPayer{
+		bs:  bs,
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot WantAddCoPayer) HasReact(u *api.Update) bool {
+	return hasAction(u, wantAddCoPayer)
+}
+
+func (bot *WantAddCoPayer) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
+	if room.CountRealMembers() == len(room.RoomStates.FinishedAddOperation) {
+		callback := createCallback(u, I18n(u.User, "msg_not_editable_all_operations_added"), true)
+		return api.TelegramMessage{
+			CallbackConfig: callback,
+			Send:           true,
+		}
+	}
+	operation := findOperation(room, data.OperationId)
+	if operation == nil {
+		log.Ctx(ctx).Error().Msgf("operation not found, id:%s", data.OperationId.Hex())
+		return
+	}
+
+	var toSave []*api.Button
+	var tgButtons []tgbotapi.InlineKeyboardButton
+	for _, m := range *room.Members {
+		if m.ID == operation.Donor.ID {
+			continue
+		}
+		b := api.NewButton(chooseCoPayer, &api.CallbackData{RoomId: data.RoomId, OperationId: data.OperationId, UserId: m.ID})
+		toSave = append(toSave, b)
+		tgButtons = append(tgButtons, tgbotapi.NewInlineKeyboardButtonData(coPayerSmile(operation, m.ID)+m.DisplayName, b.ID.Hex()))
+	}
+	backBtn := api.NewButton(donorOperation, &api.CallbackData{RoomId: data.RoomId, OperationId: data.OperationId})
+	toSave = append(toSave, backBtn)
+	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+
+	keyboard := optimizeKeyboardButtons(tgButtons)
+	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backBtn.ID.Hex())})
+	text := I18n(u.User, "scrn_choose_co_payer", operation.Description, moneySpace(operation.Sum), paidByText(operation))
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, text, &keyboard)},
+		Send:      true,
+	}
+}
+
+// ChooseCoPayer asks the sum paid by the chosen member
+type ChooseCoPayer struct {
+	bs  ButtonService
+	css ChatStateService
+	rs  RoomService
+	cfg *Config
+}
+
+func NewChooseCoPayer(bs ButtonService, css ChatStateService, rs RoomService, cfg *Config) *ChooseCoPayer {
+	return &ChooseCoPayer{
+		bs:  bs,
+		css: css,
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot ChooseCoPayer) HasReact(u *api.Update) bool {
+	return hasAction(u, chooseCoPayer)
+}
+
+func (bot *ChooseCoPayer) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
+	member := room.FindMember(data.UserId)
+	if member == nil {
+		log.Ctx(ctx).Error().Msgf("member not found, id:%d", data.UserId)
+		return
+	}
+
+	cs := &api.ChatState{UserId: int(getChatID(u)), Action: addCoPayer, CallbackData: data}
+	if err := bot.css.Save(ctx, cs); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create chat state failed")
+		return
+	}
+	cancelBtn := api.NewButton(donorOperation, &api.CallbackData{RoomId: data.RoomId, OperationId: data.OperationId})
+	if _, err := bot.bs.SaveAll(ctx, cancelBtn); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, I18n(u.User, "scrn_co_payer_sum", userLink(member)), &[][]tgbotapi.InlineKeyboardButton{
+			{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), cancelBtn.ID.Hex())},
+		})},
+		Send: true,
+	}
+}
+
+// AddCoPayer saves the sum paid by co-payer, zero sum removes co-payer from the operation
+type AddCoPayer struct {
+	bs  ButtonService
+	css ChatStateService
+	os  OperationService
+	rs  RoomService
+	cfg *Config
+}
+
+func NewAddCoPayer(bs ButtonService, css ChatStateService, os OperationService, rs RoomService, cfg *Config) *AddCoPayer {
+	return &AddCoPayer{
+		bs:  bs,
+		css: css,
+		os:  os,
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot AddCoPayer) HasReact(u *api.Update) bool {
+	return hasAction(u, addCoPayer) && hasMessage(u)
+}
+
+func (bot *AddCoPayer) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.ChatState.CallbackData
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
+	operation := findOperation(room, data.OperationId)
+	member := room.FindMember(data.UserId)
+	if operation == nil || member == nil {
+		log.Ctx(ctx).Error().Msgf("operation or member not found, operation:%s, member:%d", data.OperationId.Hex(), data.UserId)
+		return
+	}
+
+	sum, err := strconv.Atoi(strings.TrimSpace(u.Message.Text))
+	if err != nil || sum < 0 || !setCoPayer(operation, *member, sum) {
+		cancelBtn := api.NewButton(donorOperation, &api.CallbackData{RoomId: data.RoomId, OperationId: data.OperationId})
+		if _, err := bot.bs.SaveAll(ctx, cancelBtn); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+			return
+		}
+		return api.TelegramMessage{
+			Chattable: []tgbotapi.Chattable{NewMessage(getChatID(u), I18n(u.User, "msg_wrong_format")+I18n(u.User, "scrn_co_payer_sum", userLink(member)),
+				[][]tgbotapi.InlineKeyboardButton{{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), cancelBtn.ID.Hex())}})},
+			Send: true,
+		}
+	}
+	defer bot.css.CleanChatState(ctx, u.ChatState)
+
+	if err := bot.os.UpsertOperation(ctx, operation, data.RoomId); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("upsert operation failed")
+		return
+	}
+
+	u.ChatState = nil
+	u.Button = api.NewButton(donorOperation, &api.CallbackData{RoomId: data.RoomId, OperationId: data.OperationId})
+	return api.TelegramMessage{
+		Redirect: u,
+		Send:     true,
+	}
+}
+
+// setCoPayer sets the sum paid by member, the donor has to pay at least something himself
+func setCoPayer(o *api.Operation, member api.User, sum int) bool {
+	var coPayers []api.Payer
+	for _, p := range o.CoPayers {
+		if p.User.ID != member.ID {
+			coPayers = append(coPayers, p)
+		}
+	}
+	if sum > 0 {
+		coPayers = append(coPayers, api.Payer{User: &member, Sum: sum})
+	}
+
+	var coPayersSum int
+	for _, p := range coPayers {
+		coPayersSum += p.Sum
+	}
+	if coPayersSum >= o.Sum {
+		return false
+	}
+	o.CoPayers = coPayers
+	return true
+}
+
+func findOperation(room *api.Room, id primitive.ObjectID) *api.Operation {
+	if room.Operations == nil {
+		return nil
+	}
+	for i := range *room.Operations {
+		if (*room.Operations)[i].ID == id {
+			return &(*room.Operations)[i]
+		}
+	}
+	return nil
+}
+
+func coPayerSmile(o *api.Operation, userId int) string {
+	for _, p := range o.CoPayers {
+		if p.User.ID == userId {
+			return "💰 "
+		}
+	}
+	return ""
+}
+
+// paidByText returns donor link or list of payers with paid sums if operation has co-payers
+func paidByText(o *api.Operation) string {
+	if len(o.CoPayers) == 0 {
+		return userLink(o.Donor)
+	}
+	var payers []string
+	for _, p := range o.Payers() {
+		payers = append(payers, userLink(p.User)+" *"+moneySpace(p.Sum)+" $*")
+	}
+	return strings.Join(payers, ", ")
+}
@@ -0,0 +1,138 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// violationsLimit is the max count of violations listed in one message
+const violationsLimit = 20
+
+type ValidationService interface {
+	ValidateRooms(ctx context.Context) ([]api.Violation, error)
+}
+
+// Report is the report of new violations to super users. Keys of all current violations are saved as reported
+// by ConsistencyReport.Sent only after messages are enqueued, so the report, which could not be sent, is due again
+type Report struct {
+	Keys     []string
+	Messages []tgbotapi.Chattable
+}
+
+// ConsistencyReport periodically reports new violations of room invariants to super users, it is not a bot.
+// The violation is reported once, it is reported again if it has been fixed and broken since
+type ConsistencyReport struct {
+	as  AdminService
+	vs  ValidationService
+	us  UserService
+	cfg *Config
+}
+
+func NewConsistencyReport(as AdminService, vs ValidationService, us UserService, cfg *Config) *ConsistencyReport {
+	return &ConsistencyReport{
+		as:  as,
+		vs:  vs,
+		us:  us,
+		cfg: cfg,
+	}
+}
+
+// Due validates all rooms and returns the report to super users about violations, which have not been reported yet
+func (r *ConsistencyReport) Due(ctx context.Context, now time.Time) (*Report, error) {
+	violations, err := r.vs.ValidateRooms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := r.as.FindReportedViolations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reported := map[string]bool{}
+	for _, k := range keys {
+		reported[k] = true
+	}
+	report := &Report{}
+	var fresh []api.Violation
+	for _, v := range violations {
+		key := v.String()
+		if !reported[key] {
+			fresh = append(fresh, v)
+		}
+		report.Keys = append(report.Keys, key)
+	}
+	if len(fresh) == 0 {
+		return report, nil
+	}
+	log.Ctx(ctx).Warn().Msgf("%d new violations of room invariants are found", len(fresh))
+
+	for _, name := range r.cfg.SuperUsers {
+		admin, err := r.us.FindByUsername(ctx, name)
+		if err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msgf("super user %s is not found, he has not started the bot", name)
+			continue
+		}
+		report.Messages = append(report.Messages, violationsMessage(int64(admin.ID), admin, fresh))
+	}
+	return report, nil
+}
+
+// Sent saves violations of the report as reported, it is called after messages of the report are enqueued
+func (r *ConsistencyReport) Sent(ctx context.Context, report *Report) error {
+	return r.as.SetReportedViolations(ctx, report.Keys)
+}
+
+// AdminCheck validates all rooms at once and shows all violations, example = /admin check
+type AdminCheck struct {
+	as  AdminService
+	vs  ValidationService
+	cfg *Config
+}
+
+func NewAdminCheck(as AdminService, vs ValidationService, cfg *Config) *AdminCheck {
+	return &AdminCheck{
+		as:  as,
+		vs:  vs,
+		cfg: cfg,
+	}
+}
+
+func (bot AdminCheck) HasReact(u *api.Update) bool {
+	sub, _, ok := parseAdminCommand(u, bot.cfg)
+	return ok && sub == adminCheck
+}
+
+func (bot *AdminCheck) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	violations, err := bot.vs.ValidateRooms(ctx)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("validate rooms failed")
+		return
+	}
+	bot.as.Audit(ctx, newAuditRecord(u, adminCheck, "", fmt.Sprintf("violations: %d", len(violations))))
+
+	if len(violations) == 0 {
+		return adminReply(u, I18n(u.User, "msg_admin_check_ok"))
+	}
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{violationsMessage(getChatID(u), u.User, violations)},
+		Send:      true,
+	}
+}
+
+func violationsMessage(chatId int64, user *api.User, violations []api.Violation) tgbotapi.MessageConfig {
+	text := I18n(user, "scrn_consistency_report", len(violations))
+	for i, v := range violations {
+		if i == violationsLimit {
+			text += I18n(user, "scrn_consistency_more", len(violations)-violationsLimit)
+			break
+		}
+		// names of rooms and kinds of violations have underscores, they break markdown out of code
+		text += "`" + v.String() + "`\n"
+	}
+	msg := tgbotapi.NewMessage(chatId, text)
+	msg.ParseMode = tgbotapi.ModeMarkdown
+	return msg
+}
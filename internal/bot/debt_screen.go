@@ -39,8 +39,8 @@ func (bot Debt) OnMessage(ctx context.Context, u *api.Update) (response api.Tele
 	roomId := u.Button.CallbackData.RoomId
 	debts, err := bot.os.GetAllDebts(ctx, roomId)
 	if err != nil {
-		log.Error().Err(err).Msg("")
-		return
+		log.Ctx(ctx).Error().Err(err).Msg("get debts failed")
+		return failedResponse(u, err)
 	}
 	if len(debts) < 1 {
 		callback := createCallback(u, I18n(u.User, "msg_have_not_debts"), true)
@@ -56,7 +56,7 @@ func (bot Debt) OnMessage(ctx context.Context, u *api.Update) (response api.Tele
 	backB := api.NewButton(viewRoom, data)
 
 	if _, err := bot.bs.SaveAll(ctx, viewUserOpsB, viewAllOpsB, backB); err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 
@@ -100,7 +100,8 @@ func (bot ViewUserDebts) OnMessage(ctx context.Context, u *api.Update) (response
 
 	debts, err := bot.os.GetUserDebts(ctx, userId, roomId)
 	if err != nil {
-		return
+		log.Ctx(ctx).Error().Err(err).Msg("get user debts failed")
+		return failedResponse(u, err)
 	}
 	if len(*debts) < 1 {
 		callback := createCallback(u, I18n(u.User, "msg_have_not_user_debts"), true)
@@ -115,13 +116,16 @@ func (bot ViewUserDebts) OnMessage(ctx context.Context, u *api.Update) (response
 	for i := skip; i < skip+size && i < len(*debts); i++ {
 		debt := (*debts)[i]
 		var dbtB *api.Button
-		if debt.Debtor.ID == userId {
-			dbtB = api.NewButton(wantReturnDebt, &api.CallbackData{RoomId: roomId, UserId: debt.Lender.ID})
+		if debt.Debtor.ID == userId || debt.Debtor.IsManagedBy(userId) {
+			dbtB = api.NewButton(wantReturnDebt, &api.CallbackData{RoomId: roomId, UserId: debt.Lender.ID, DebtorId: debt.Debtor.ID})
 		} else {
 			dbtB = api.NewButton(viewUserDebts, &api.CallbackData{RoomId: roomId, Page: page})
 		}
 		toSave = append(toSave, dbtB)
 		text := fmt.Sprintf("%s➡️%s ₽➡️%s", shortName(debt.Debtor), moneySpace(debt.Sum), shortName(debt.Lender))
+		if debt.Pending > 0 {
+			text += " ⏳"
+		}
 		debtBtns = append(debtBtns, tgbotapi.NewInlineKeyboardButtonData(text, dbtB.ID.Hex()))
 	}
 
@@ -144,11 +148,11 @@ func (bot ViewUserDebts) OnMessage(ctx context.Context, u *api.Update) (response
 	keyboard = append(keyboard, navRow)
 
 	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
-		log.Error().Err(err).Msg("save buttons failed")
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
 		return
 	}
 
-	screen := createScreen(u, I18n(u.User, "scrn_my_debts"), &keyboard)
+	screen := createScreen(u, I18n(u.User, "scrn_my_debts")+pendingRepaymentsText(u.User, *debts), &keyboard)
 	return api.TelegramMessage{
 		Chattable: []tgbotapi.Chattable{screen},
 		Send:      true,
@@ -184,7 +188,8 @@ func (bot ViewAllDebts) OnMessage(ctx context.Context, u *api.Update) (response
 
 	debts, err := bot.os.GetAllDebts(ctx, roomId)
 	if err != nil {
-		return
+		log.Ctx(ctx).Error().Err(err).Msg("get debts failed")
+		return failedResponse(u, err)
 	}
 
 	var toSave []*api.Button
@@ -192,13 +197,18 @@ func (bot ViewAllDebts) OnMessage(ctx context.Context, u *api.Update) (response
 	for i := skip; i < skip+size && i < len(debts); i++ {
 		debt := (debts)[i]
 		var dbtB *api.Button
-		if debt.Debtor.ID == userId {
-			dbtB = api.NewButton(wantReturnDebt, &api.CallbackData{RoomId: roomId, UserId: debt.Lender.ID})
+		if debt.Debtor.ID == userId || debt.Debtor.IsManagedBy(userId) {
+			dbtB = api.NewButton(wantReturnDebt, &api.CallbackData{RoomId: roomId, UserId: debt.Lender.ID, DebtorId: debt.Debtor.ID})
+		} else if notifiedUserId(debt.Lender) == userId {
+			dbtB = api.NewButton(nudgeDebtor, &api.CallbackData{RoomId: roomId, UserId: debt.Lender.ID, DebtorId: debt.Debtor.ID})
 		} else {
 			dbtB = api.NewButton(viewAllDebts, &api.CallbackData{RoomId: roomId, Page: page})
 		}
 		toSave = append(toSave, dbtB)
 		text := fmt.Sprintf("%s➡️%s ₽➡️%s", shortName(debt.Debtor), moneySpace(debt.Sum), shortName(debt.Lender))
+		if dbtB.Action == nudgeDebtor {
+			text = "🔔 " + text
+		}
 		debtBtns = append(debtBtns, tgbotapi.NewInlineKeyboardButtonData(text, dbtB.ID.Hex()))
 	}
 
@@ -218,7 +228,7 @@ func (bot ViewAllDebts) OnMessage(ctx context.Context, u *api.Update) (response
 	}
 
 	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
-		log.Error().Err(err).Msg("save buttons failed")
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
 		return
 	}
 
@@ -230,3 +240,17 @@ func (bot ViewAllDebts) OnMessage(ctx context.Context, u *api.Update) (response
 		Send:      true,
 	}
 }
+
+// pendingRepaymentsText returns repayments of the debts waiting for confirmation of lenders
+func pendingRepaymentsText(user *api.User, debts []api.Debt) string {
+	var text string
+	for _, d := range debts {
+		if d.Pending > 0 {
+			text += userLink(d.Debtor) + " ➡️ " + userLink(d.Lender) + ": *" + moneySpace(d.Pending) + " $*\n"
+		}
+	}
+	if text == "" {
+		return ""
+	}
+	return "\n\n" + I18n(user, "scrn_pending_repayments") + text
+}
@@ -0,0 +1,174 @@
+package bot
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// digestOperationsLimit is the max count of new operations listed for every room in the digest
+const digestOperationsLimit = 5
+
+var digestPeriods = []string{api.DigestWeekly, api.DigestMonthly, ""}
+
+// Digest is the digest due to the user. It is saved as sent by SpendingDigest.Sent only after its messages are
+// enqueued, so the digest, which could not be sent, is due again at the next check
+type Digest struct {
+	UserId   int
+	Period   string
+	SentAt   time.Time
+	Messages []tgbotapi.Chattable
+}
+
+// SpendingDigest sends periodic digests of spending over all rooms to subscribed users, it is not a bot
+type SpendingDigest struct {
+	bs  ButtonService
+	us  UserService
+	ss  StatisticService
+	cfg *Config
+}
+
+func NewSpendingDigest(bs ButtonService, us UserService, ss StatisticService, cfg *Config) *SpendingDigest {
+	return &SpendingDigest{
+		bs:  bs,
+		us:  us,
+		ss:  ss,
+		cfg: cfg,
+	}
+}
+
+// Due returns digests which should be sent at the moment, digests without activity have no messages
+func (d *SpendingDigest) Due(ctx context.Context, now time.Time) ([]Digest, error) {
+	if d.cfg.IsQuietTime(now) {
+		return nil, nil
+	}
+	users, err := d.us.FindWithDigest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Digest
+	for i := range *users {
+		user := &(*users)[i]
+		if now.Before(api.NextDigestAt(user.DigestPeriod, user.DigestSentAt)) {
+			continue
+		}
+		digests, err := d.ss.GetUserDigest(ctx, user.ID, user.DigestSentAt)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("get digest failed, user:%d", user.ID)
+			continue
+		}
+		digest := Digest{UserId: user.ID, Period: user.DigestPeriod, SentAt: now}
+		if len(digests) == 0 {
+			result = append(result, digest)
+			continue
+		}
+
+		startB := api.NewButton(viewStart, new(api.CallbackData))
+		if _, err := d.bs.SaveAll(ctx, startB); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+			continue
+		}
+		digest.Messages = []tgbotapi.Chattable{NewMessage(int64(user.ID), digestText(user, digests),
+			[][]tgbotapi.InlineKeyboardButton{{tgbotapi.NewInlineKeyboardButtonData(I18n(user, "btn_to_start"), startB.ID.Hex())}})}
+		result = append(result, digest)
+	}
+	return result, nil
+}
+
+// Sent saves the digest as sent, it is called after messages of the digest are enqueued
+func (d *SpendingDigest) Sent(ctx context.Context, digest Digest) error {
+	return d.us.SetDigest(ctx, digest.UserId, digest.Period, digest.SentAt)
+}
+
+// ChooseDigest screen with periods of the digest, the chosen period is in CallbackData.ExternalData
+type ChooseDigest struct {
+	bs  ButtonService
+	us  UserService
+	cfg *Config
+}
+
+func NewChooseDigest(bs ButtonService, us UserService, cfg *Config) *ChooseDigest {
+	return &ChooseDigest{
+		bs:  bs,
+		us:  us,
+		cfg: cfg,
+	}
+}
+
+func (bot ChooseDigest) HasReact(u *api.Update) bool {
+	return hasAction(u, chooseDigest)
+}
+
+func (bot *ChooseDigest) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	if data.Selected {
+		period := data.ExternalData
+		// the first digest comes after the whole period since subscription
+		if err := bot.us.SetDigest(ctx, u.User.ID, period, time.Now()); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("set digest failed")
+			return
+		}
+		u.User.DigestPeriod = period
+	}
+
+	var toSave []*api.Button
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for _, period := range digestPeriods {
+		b := api.NewButton(chooseDigest, &api.CallbackData{Selected: true, ExternalData: period})
+		toSave = append(toSave, b)
+		text := digestPeriodText(u.User, period)
+		if period == u.User.DigestPeriod {
+			text = "✅ " + text
+		}
+		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(text, b.ID.Hex())})
+	}
+	backB := api.NewButton(userSetting, new(api.CallbackData))
+	toSave = append(toSave, backB)
+	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backB.ID.Hex())})
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, I18n(u.User, "scrn_choose_digest"), &keyboard)},
+		Send:      true,
+	}
+}
+
+// digestText returns spending, new operations and debts of the user in every room
+func digestText(user *api.User, digests []api.RoomDigest) string {
+	text := I18n(user, "scrn_digest_weekly")
+	if user.DigestPeriod == api.DigestMonthly {
+		text = I18n(user, "scrn_digest_monthly")
+	}
+	for _, d := range digests {
+		text += "\n\n*" + d.RoomName + "*\n"
+		if d.Spent > 0 || d.Paid > 0 {
+			text += I18n(user, "scrn_digest_spent", moneySpace(d.Spent), moneySpace(d.Paid))
+		}
+		if len(d.Operations) > 0 {
+			text += I18n(user, "scrn_digest_operations", len(d.Operations))
+			for i, o := range d.Operations {
+				if i == digestOperationsLimit {
+					text += "…\n"
+					break
+				}
+				text += "• " + o.Description + ": " + moneySpace(o.Sum) + " $\n"
+			}
+		}
+		for _, debt := range d.Debts {
+			text += debtLine(&debt)
+		}
+	}
+	return text
+}
+
+func digestPeriodText(user *api.User, period string) string {
+	if period == "" {
+		return I18n(user, "btn_digest_off")
+	}
+	return I18n(user, "btn_digest_"+period)
+}
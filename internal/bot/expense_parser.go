@@ -0,0 +1,358 @@
+package bot
+
+import (
+	"github.com/almaznur91/splitty/internal/api"
+	"github.com/pkg/errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// expenseDraft is an expense recognized in the free form message or in the receipt
+type expenseDraft struct {
+	description string
+	sum         int
+	recipients  []api.User
+	items       []api.Item
+}
+
+var (
+	amountStartRe    = regexp.MustCompile(`^[\d(][\d.,+\-*/xх×()]*$`)
+	amountContinueRe = regexp.MustCompile(`^[+\-*/xх×][\d.,+\-*/xх×()]*$`)
+	// "1 250,50" is a number with thousands separated by spaces
+	thousandsHeadRe  = regexp.MustCompile(`^\d{1,3}$`)
+	thousandsGroupRe = regexp.MustCompile(`^\d{3}([.,]\d{1,2})?$`)
+)
+
+var currencyWords = map[string]bool{
+	"$": true, "€": true, "₽": true, "usd": true, "eur": true, "euro": true, "rub": true,
+	"руб": true, "руб.": true, "р": true, "р.": true, "рублей": true, "евро": true,
+}
+
+var skippedWords = map[string]bool{"for": true, "для": true}
+
+// phrases choosing recipients, everything is lower case
+var (
+	allButMePhrases = [][]string{
+		{"all", "but", "me"}, {"all", "except", "me"}, {"everyone", "but", "me"}, {"without", "me"},
+		{"все", "кроме", "меня"}, {"всем", "кроме", "меня"}, {"без", "меня"},
+	}
+	allPhrases = [][]string{{"all"}, {"everyone"}, {"все"}, {"всем"}}
+)
+
+// parseExpense recognizes messages like "dinner 1250,50 for @anna @bob", "taxi 30 eur all but me" or "coffee 4.5x3".
+// The amount may be an arithmetic expression, it is rounded to the whole number. The trailing amount is taken,
+// the message is refused if it is not clear which number is the amount. The description is empty
+// if the message is the amount only, "120+35*2".
+// Mentioned members become recipients, otherwise all room members take part
+func parseExpense(m *api.Message, room *api.Room) (*expenseDraft, error) {
+	members := room.Members
+	text, mentioned, err := cutMentions(m, room)
+	if err != nil {
+		return nil, err
+	}
+
+	words, allButMe := cutRecipientPhrases(strings.Fields(text))
+	expression, description, err := cutAmount(words)
+	if err != nil {
+		return nil, errors.Wrapf(err, "amount not recognized in %q", m.Text)
+	}
+	value, err := evalExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+	sum := int(math.Round(value))
+	if sum < 1 {
+		return nil, errors.Errorf("sum can not be less than one %v", value)
+	}
+
+	recipients := *members
+	if len(mentioned) > 0 {
+		recipients = mentioned
+	}
+	if allButMe {
+		recipients = excludeUser(recipients, m.From.ID)
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("expense has no recipients")
+	}
+	return &expenseDraft{description: description, sum: sum, recipients: recipients}, nil
+}
+
+// cutRecipientPhrases removes phrases choosing recipients from the end of words, example = "for all but me".
+// Phrases in the middle of the message are a part of the description
+func cutRecipientPhrases(words []string) ([]string, bool) {
+	allButMe := false
+	for len(words) > 0 {
+		if n := matchSuffix(words, allButMePhrases); n > 0 {
+			allButMe = true
+			words = words[:len(words)-n]
+		} else if n := matchSuffix(words, allPhrases); n > 0 {
+			words = words[:len(words)-n]
+		} else if skippedWords[strings.ToLower(words[len(words)-1])] {
+			words = words[:len(words)-1]
+		} else {
+			break
+		}
+	}
+	return words, allButMe
+}
+
+// cutMentions removes mentions from the text and resolves them to room members
+func cutMentions(m *api.Message, room *api.Room) (string, []api.User, error) {
+	if m.Entities == nil {
+		return m.Text, nil, nil
+	}
+	// entity offsets are in UTF-16 code units
+	text := utf16.Encode([]rune(m.Text))
+	var ids []int
+	for _, e := range *m.Entities {
+		if e.Offset < 0 || e.Offset+e.Length > len(text) {
+			continue
+		}
+		var member *api.User
+		switch e.Type {
+		case "mention":
+			username := strings.TrimPrefix(string(utf16.Decode(text[e.Offset:e.Offset+e.Length])), "@")
+			member = findMemberByUsername(room.Members, username)
+		case "text_mention":
+			if e.User != nil {
+				member = room.FindMember(e.User.ID)
+			}
+		default:
+			continue
+		}
+		if member == nil {
+			return "", nil, errors.Errorf("mentioned user is not a member, offset:%d", e.Offset)
+		}
+		ids = append(ids, member.ID)
+		for i := e.Offset; i < e.Offset+e.Length; i++ {
+			text[i] = ' '
+		}
+	}
+
+	var mentioned []api.User
+	for _, member := range *room.Members {
+		if containsInt(ids, member.ID) {
+			mentioned = append(mentioned, member)
+		}
+	}
+	return string(utf16.Decode(text)), mentioned, nil
+}
+
+func findMemberByUsername(members *[]api.User, username string) *api.User {
+	for i, m := range *members {
+		if m.Username != "" && strings.EqualFold(m.Username, username) {
+			return &(*members)[i]
+		}
+	}
+	return nil
+}
+
+func excludeUser(users []api.User, id int) []api.User {
+	var result []api.User
+	for _, u := range users {
+		if u.ID != id {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+// matchSuffix returns count of words of the phrase matched at the end of words, zero if nothing matched
+func matchSuffix(words []string, phrases [][]string) int {
+	for _, phrase := range phrases {
+		if len(words) < len(phrase) {
+			continue
+		}
+		matched := true
+		for i, w := range phrase {
+			if strings.ToLower(words[len(words)-len(phrase)+i]) != w {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return len(phrase)
+		}
+	}
+	return 0
+}
+
+// amountSpan is words [start, end) forming the amount, currency words next to the amount are included
+type amountSpan struct {
+	start, end int
+	expression string
+}
+
+// cutAmount finds the amount in words and returns it with the rest of words as description.
+// The amount may be split by spaces only around operators "120 + 35" and between thousands "1 250". The trailing amount is taken,
+// the amount in the middle is taken only if it is the single one, numbers next to each other are ambiguous
+func cutAmount(words []string) (string, string, error) {
+	spans := findAmounts(words)
+	if len(spans) == 0 {
+		return "", "", errors.New("amount not found")
+	}
+	span := spans[len(spans)-1]
+	if span.end != len(words) && len(spans) > 1 {
+		return "", "", errors.New("amount is ambiguous")
+	}
+	if len(spans) > 1 && spans[len(spans)-2].end == span.start {
+		return "", "", errors.New("amount is ambiguous")
+	}
+	description := append(append([]string{}, words[:span.start]...), words[span.end:]...)
+	return span.expression, strings.Join(description, " "), nil
+}
+
+// findAmounts returns all amounts in words in order of appearance
+func findAmounts(words []string) []amountSpan {
+	var spans []amountSpan
+	for i := 0; i < len(words); i++ {
+		w := strings.Trim(words[i], "$€₽")
+		if !amountStartRe.MatchString(w) {
+			continue
+		}
+		span := amountSpan{start: i, end: i + 1, expression: w}
+		if thousandsHeadRe.MatchString(w) {
+			for span.end < len(words) && thousandsGroupRe.MatchString(strings.Trim(words[span.end], "$€₽")) &&
+				!strings.ContainsAny(span.expression, ".,") {
+				span.expression += strings.Trim(words[span.end], "$€₽")
+				span.end++
+			}
+		}
+		for ; span.end < len(words); span.end++ {
+			next := strings.Trim(words[span.end], "$€₽")
+			last, _ := utf8.DecodeLastRuneInString(span.expression)
+			if amountContinueRe.MatchString(next) || strings.ContainsRune("+-*/xх×(", last) && amountStartRe.MatchString(next) {
+				span.expression += next
+				continue
+			}
+			break
+		}
+		i = span.end - 1
+		if span.start > 0 && currencyWords[strings.ToLower(words[span.start-1])] &&
+			(len(spans) == 0 || spans[len(spans)-1].end < span.start-1) {
+			span.start--
+		}
+		if span.end < len(words) && currencyWords[strings.ToLower(words[span.end])] {
+			span.end++
+			i++
+		}
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+// evalExpression calculates expression with + - * / and brackets, x means multiplication.
+// Comma followed by exactly 3 digits separates thousands, otherwise it is a decimal separator
+func evalExpression(s string) (float64, error) {
+	s = strings.NewReplacer("x", "*", "х", "*", "×", "*").Replace(replaceCommas(s))
+	p := &exprParser{s: s}
+	v, err := p.parseSum()
+	if err != nil {
+		return 0, err
+	}
+	if p.pos != len(p.s) {
+		return 0, errors.Errorf("unexpected symbol in expression %q at %d", s, p.pos)
+	}
+	return v, nil
+}
+
+// replaceCommas removes thousands separators "12,000" and replaces decimal commas by points "4,5"
+func replaceCommas(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != ',' {
+			b.WriteByte(s[i])
+			continue
+		}
+		digits := 0
+		for digits < 4 && i+1+digits < len(s) && isDigit(s[i+1+digits]) {
+			digits++
+		}
+		if digits != 3 || i == 0 || !isDigit(s[i-1]) {
+			b.WriteByte('.')
+		}
+	}
+	return b.String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+type exprParser struct {
+	s   string
+	pos int
+}
+
+func (p *exprParser) parseSum() (float64, error) {
+	v, err := p.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+	for p.pos < len(p.s) && (p.s[p.pos] == '+' || p.s[p.pos] == '-') {
+		op := p.s[p.pos]
+		p.pos++
+		r, err := p.parseProduct()
+		if err != nil {
+			return 0, err
+		}
+		if op == '+' {
+			v += r
+		} else {
+			v -= r
+		}
+	}
+	return v, nil
+}
+
+func (p *exprParser) parseProduct() (float64, error) {
+	v, err := p.parseOperand()
+	if err != nil {
+		return 0, err
+	}
+	for p.pos < len(p.s) && (p.s[p.pos] == '*' || p.s[p.pos] == '/') {
+		op := p.s[p.pos]
+		p.pos++
+		r, err := p.parseOperand()
+		if err != nil {
+			return 0, err
+		}
+		if op == '*' {
+			v *= r
+		} else if r == 0 {
+			return 0, errors.New("division by zero")
+		} else {
+			v /= r
+		}
+	}
+	return v, nil
+}
+
+func (p *exprParser) parseOperand() (float64, error) {
+	if p.pos < len(p.s) && p.s[p.pos] == '(' {
+		p.pos++
+		v, err := p.parseSum()
+		if err != nil {
+			return 0, err
+		}
+		if p.pos >= len(p.s) || p.s[p.pos] != ')' {
+			return 0, errors.Errorf("closing bracket expected in %q", p.s)
+		}
+		p.pos++
+		return v, nil
+	}
+	start := p.pos
+	for p.pos < len(p.s) && (p.s[p.pos] >= '0' && p.s[p.pos] <= '9' || p.s[p.pos] == '.') {
+		p.pos++
+	}
+	v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+	if err != nil {
+		return 0, errors.Errorf("number expected in %q at %d", p.s, start)
+	}
+	return v, nil
+}
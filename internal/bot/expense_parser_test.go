@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/almaznur91/splitty/internal/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExpense(t *testing.T) {
+	members := []api.User{{ID: 1, DisplayName: "A", Username: "anna"}, {ID: 2, DisplayName: "B", Username: "bob"}, {ID: 3, DisplayName: "C"}}
+	room := &api.Room{Members: &members}
+
+	tests := []struct {
+		text        string
+		entities    []api.Entity
+		description string
+		sum         int
+		recipients  []int
+		err         bool
+	}{
+		{text: "lunch 12,000", description: "lunch", sum: 12000, recipients: []int{1, 2, 3}},
+		{text: "dinner 1,250", description: "dinner", sum: 1250, recipients: []int{1, 2, 3}},
+		{text: "coffee 4,5", description: "coffee", sum: 5, recipients: []int{1, 2, 3}},
+		{text: "rent 1,250,000.40", description: "rent", sum: 1250000, recipients: []int{1, 2, 3}},
+		{text: "100 20", err: true},
+		{text: "taxi 100 20", err: true},
+		{text: "taxi 100 200", description: "taxi", sum: 100200, recipients: []int{1, 2, 3}},
+		{text: "3 pizzas 1500", description: "3 pizzas", sum: 1500, recipients: []int{1, 2, 3}},
+		{text: "3 pizzas 1500 for 2 days", err: true},
+		{text: "1000 Gasoline expenses", description: "Gasoline expenses", sum: 1000, recipients: []int{1, 2, 3}},
+		{text: "pay all bills 300", description: "pay all bills", sum: 300, recipients: []int{1, 2, 3}},
+		{text: "taxi 30 eur all but me", description: "taxi", sum: 30, recipients: []int{2, 3}},
+		{text: "trip to euro zone 30 €", description: "trip to euro zone", sum: 30, recipients: []int{1, 2, 3}},
+		{text: "$30 taxi", description: "taxi", sum: 30, recipients: []int{1, 2, 3}},
+		{text: "coffee 4.5x3", description: "coffee", sum: 14, recipients: []int{1, 2, 3}},
+		{text: "snacks 120 + 35", description: "snacks", sum: 155, recipients: []int{1, 2, 3}},
+		{text: "dinner 600 for @anna @bob", entities: []api.Entity{{Type: "mention", Offset: 15, Length: 5}, {Type: "mention", Offset: 21, Length: 4}},
+			description: "dinner", sum: 600, recipients: []int{1, 2}},
+		{text: "dinner 1 250,50 for @anna @bob", entities: []api.Entity{{Type: "mention", Offset: 20, Length: 5}, {Type: "mention", Offset: 26, Length: 4}},
+			description: "dinner", sum: 1251, recipients: []int{1, 2}},
+		{text: "dinner 1 250 for @anna", entities: []api.Entity{{Type: "mention", Offset: 17, Length: 5}},
+			description: "dinner", sum: 1250, recipients: []int{1}},
+		{text: "3 pizzas 1 500", description: "3 pizzas", sum: 1500, recipients: []int{1, 2, 3}},
+		{text: "120+35*2", description: "", sum: 190, recipients: []int{1, 2, 3}},
+		{text: "pizza 500 -", err: true},
+		{text: "dinner 600 for @carl", entities: []api.Entity{{Type: "mention", Offset: 15, Length: 5}}, err: true},
+		{text: "12,000", description: "", sum: 12000, recipients: []int{1, 2, 3}},
+		{text: "dinner", err: true},
+		{text: "dinner 0", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			m := &api.Message{Text: tt.text, From: api.User{ID: 1}}
+			if tt.entities != nil {
+				m.Entities = &tt.entities
+			}
+			draft, err := parseExpense(m, room)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.description, draft.description)
+				assert.Equal(t, tt.sum, draft.sum)
+				var ids []int
+				for _, r := range draft.recipients {
+					ids = append(ids, r.ID)
+				}
+				assert.Equal(t, tt.recipients, ids)
+			}
+		})
+	}
+}
+
+func TestEvalExpression(t *testing.T) {
+	tests := []struct {
+		expression string
+		value      float64
+		err        bool
+	}{
+		{expression: "12,000", value: 12000},
+		{expression: "1,250.50", value: 1250.5},
+		{expression: "4,5", value: 4.5},
+		{expression: "1,2345", value: 1.2345},
+		{expression: "(100+20)/2", value: 60},
+		{expression: "4.5x2", value: 9},
+		{expression: "10/0", err: true},
+		{expression: "(10+2", err: true},
+		{expression: "10+", err: true},
+		{expression: "10-", err: true},
+		{expression: "*2", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			v, err := evalExpression(tt.expression)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.InDelta(t, tt.value, v, 0.0001)
+		})
+	}
+}
@@ -0,0 +1,528 @@
+package bot
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// linkChatPrefix is a start parameter of the link adding the bot to a group, example = ?startgroup=link600e68d102ddac9888d0193e
+const linkChatPrefix = "link"
+
+var operationRefRe = regexp.MustCompile(`#op([0-9a-f]{8})`)
+
+// LinkGroupChat links the room to the group chat, after the bot is added to the group by the link from room settings
+type LinkGroupChat struct {
+	rs  RoomService
+	cfg *Config
+}
+
+func NewLinkGroupChat(rs RoomService, cfg *Config) *LinkGroupChat {
+	return &LinkGroupChat{
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot LinkGroupChat) HasReact(u *api.Update) bool {
+	args, ok := groupCommand(u, bot.cfg.BotName, "start")
+	return ok && strings.HasPrefix(args, linkChatPrefix)
+}
+
+func (bot *LinkGroupChat) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	args, _ := groupCommand(u, bot.cfg.BotName, "start")
+	roomId := strings.TrimPrefix(args, linkChatPrefix)
+	room, err := bot.rs.FindById(ctx, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find room, id:%s", roomId)
+		return
+	}
+	if !containsUserId(room.Members, u.User.ID) {
+		return groupReply(u, I18n(u.User, "msg_not_be_in_rooms"))
+	}
+	if err := bot.rs.LinkChat(ctx, roomId, *u.Message.Chat); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("link chat failed, room:%s", roomId)
+		return
+	}
+	return groupReply(u, I18n(u.User, "scrn_group_linked", room.Name))
+}
+
+// GroupAddOperation adds operation from the group chat, example = /add 500 pizza @anna @bob
+type GroupAddOperation struct {
+	rs  RoomService
+	bs  ButtonService
+	cfg *Config
+}
+
+func NewGroupAddOperation(rs RoomService, bs ButtonService, cfg *Config) *GroupAddOperation {
+	return &GroupAddOperation{
+		rs:  rs,
+		bs:  bs,
+		cfg: cfg,
+	}
+}
+
+func (bot GroupAddOperation) HasReact(u *api.Update) bool {
+	_, ok := groupCommand(u, bot.cfg.BotName, "add")
+	return ok
+}
+
+func (bot *GroupAddOperation) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	room, resp := findGroupRoom(ctx, bot.rs, u)
+	if room == nil {
+		return resp
+	}
+	if len(room.RoomStates.FinishedAddOperation) == room.CountRealMembers() {
+		return groupReply(u, I18n(u.User, "msg_can_not_add_operations"))
+	}
+
+	draft, err := parseExpense(withoutCommand(u.Message), room)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("not parsed %v", u.Message.Text)
+		return groupReply(u, I18n(u.User, "msg_wrong_format")+I18n(u.User, "scrn_group_add_help", bot.cfg.BotName))
+	}
+
+	cancelBtn := api.NewButton(cancelExpense, &api.CallbackData{RoomId: room.ID.Hex(), UserId: u.User.ID})
+	keyboard, err := confirmExpenseKeyboard(ctx, bot.bs, u, room, draft, cancelBtn)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
+		return
+	}
+	msg := groupReplyMessage(u, expenseDraftText(u.User, draft))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{msg},
+		Send:      true,
+	}
+}
+
+// GroupDebts shows all debts of the room linked to the group chat
+type GroupDebts struct {
+	rs  RoomService
+	os  OperationService
+	cfg *Config
+}
+
+func NewGroupDebts(rs RoomService, os OperationService, cfg *Config) *GroupDebts {
+	return &GroupDebts{
+		rs:  rs,
+		os:  os,
+		cfg: cfg,
+	}
+}
+
+func (bot GroupDebts) HasReact(u *api.Update) bool {
+	_, ok := groupCommand(u, bot.cfg.BotName, "debts")
+	return ok
+}
+
+func (bot *GroupDebts) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	room, resp := findGroupRoom(ctx, bot.rs, u)
+	if room == nil {
+		return resp
+	}
+	debts, err := bot.os.GetAllDebts(ctx, room.ID.Hex())
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get debts failed")
+		return failedResponse(u, err)
+	}
+	if len(debts) == 0 {
+		return groupReply(u, I18n(u.User, "msg_have_not_debts"))
+	}
+	text := I18n(u.User, "scrn_all_debts") + "\n\n"
+	for _, d := range debts {
+		text += debtLine(&d)
+	}
+	return groupReply(u, text)
+}
+
+// GroupSettle records debt repayment from the group chat, example = /settle @anna 300.
+// The lender confirms or disputes the repayment by the buttons of the reply
+type GroupSettle struct {
+	bs  ButtonService
+	rs  RoomService
+	os  OperationService
+	rss RoomStateService
+	cfg *Config
+}
+
+func NewGroupSettle(bs ButtonService, rs RoomService, os OperationService, rss RoomStateService, cfg *Config) *GroupSettle {
+	return &GroupSettle{
+		bs:  bs,
+		rs:  rs,
+		os:  os,
+		rss: rss,
+		cfg: cfg,
+	}
+}
+
+func (bot GroupSettle) HasReact(u *api.Update) bool {
+	_, ok := groupCommand(u, bot.cfg.BotName, "settle")
+	return ok
+}
+
+func (bot *GroupSettle) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	room, resp := findGroupRoom(ctx, bot.rs, u)
+	if room == nil {
+		return resp
+	}
+	if len(room.RoomStates.FinishedAddOperation) != room.CountRealMembers() {
+		return groupReply(u, I18n(u.User, "msg_not_back_debt_operations_no_added"))
+	}
+	debts, err := bot.os.GetUserDebts(ctx, u.User.ID, room.ID.Hex())
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get user debts failed")
+		return failedResponse(u, err)
+	}
+	var ownDebts []api.Debt
+	for _, d := range *debts {
+		if d.Debtor.ID == u.User.ID {
+			ownDebts = append(ownDebts, d)
+		}
+	}
+	if len(ownDebts) == 0 {
+		return groupReply(u, I18n(u.User, "msg_have_not_user_debts"))
+	}
+
+	text, mentioned, err := cutMentions(withoutCommand(u.Message), room)
+	if err != nil || len(mentioned) > 1 {
+		return groupReply(u, I18n(u.User, "msg_wrong_format")+settleHelp(u.User, bot.cfg.BotName, ownDebts))
+	}
+	var debt *api.Debt
+	for i, d := range ownDebts {
+		if len(mentioned) == 1 && d.Lender.ID == mentioned[0].ID || len(mentioned) == 0 && len(ownDebts) == 1 {
+			debt = &ownDebts[i]
+		}
+	}
+	if debt == nil {
+		return groupReply(u, settleHelp(u.User, bot.cfg.BotName, ownDebts))
+	}
+
+	if debt.Unpaid() < 1 {
+		return groupReply(u, I18n(u.User, "msg_debt_waits_confirmation"))
+	}
+	sum := debt.Unpaid()
+	if expression := strings.Join(strings.Fields(text), ""); expression != "" {
+		value, err := evalExpression(expression)
+		sum = int(math.Round(value))
+		if err != nil || sum < 1 || sum > debt.Unpaid() {
+			return groupReply(u, I18n(u.User, "msg_wrong_format")+settleHelp(u.User, bot.cfg.BotName, ownDebts))
+		}
+	}
+
+	operation := &api.Operation{
+		ID:              primitive.NewObjectID(),
+		Sum:             sum,
+		Donor:           &u.Message.From,
+		Recipients:      &[]api.User{*debt.Lender},
+		IsDebtRepayment: true,
+		RepaymentStatus: repaymentStatus(debt.Lender, u.User),
+		CreateAt:        time.Now(),
+	}
+	if err = bot.os.UpsertOperation(ctx, operation, room.ID.Hex()); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("upsert operation failed")
+		return
+	}
+
+	if operation.IsConfirmedRepayment() {
+		//async calculate paidOfDebtsUserIds for room, after debt operation
+		go func() {
+			err := bot.rss.DefinePaidOfDebtsUserIdsAndSave(ctx, room)
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("")
+			}
+		}()
+		return groupReply(u, I18n(u.User, "scrn_debt_returned_lender", userLink(debt.Lender), moneySpace(sum)))
+	}
+
+	keyboard, err := createReviewRepaymentKeyboard(ctx, bot.bs, u.User, room.ID.Hex(), operation.ID)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	msg := groupReplyMessage(u, I18n(u.User, "scrn_debt_returned_pending", userLink(debt.Lender), moneySpace(sum)))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{msg},
+		Send:      true,
+	}
+}
+
+// GroupEditOperation edits operation by reply to the bot message in the group chat:
+// "sum <amount>" changes the sum, "rename <description>" changes the description, "delete" asks to confirm deleting.
+// Other replies are usual messages of the chat, they are ignored
+type GroupEditOperation struct {
+	bs  ButtonService
+	rs  RoomService
+	os  OperationService
+	us  UserService
+	cfg *Config
+}
+
+func NewGroupEditOperation(bs ButtonService, rs RoomService, os OperationService, us UserService, cfg *Config) *GroupEditOperation {
+	return &GroupEditOperation{
+		bs:  bs,
+		rs:  rs,
+		os:  os,
+		us:  us,
+		cfg: cfg,
+	}
+}
+
+func (bot GroupEditOperation) HasReact(u *api.Update) bool {
+	return !isPrivate(u) && hasMessage(u) && !strings.HasPrefix(u.Message.Text, "/") &&
+		u.Message.ReplyTo != nil && operationRefRe.MatchString(u.Message.ReplyTo.Text)
+}
+
+func (bot *GroupEditOperation) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	text := strings.TrimSpace(u.Message.Text)
+	var field, value string
+	if description, ok := cutKeyword(text, renameKeywords); ok {
+		field, value = operationDescriptionField, description
+	} else if sum, ok := cutKeyword(text, sumKeywords); ok {
+		field, value = operationSumField, sum
+	} else if !containsFold(deleteKeywords, text) {
+		log.Ctx(ctx).Debug().Msg("reply to operation is not an edit")
+		return
+	}
+
+	room, resp := findGroupRoom(ctx, bot.rs, u)
+	if room == nil {
+		return resp
+	}
+	if room.CountRealMembers() == len(room.RoomStates.FinishedAddOperation) {
+		return groupReply(u, I18n(u.User, "msg_not_editable_all_operations_added"))
+	}
+	ref := operationRefRe.FindStringSubmatch(u.Message.ReplyTo.Text)[1]
+	operation := findOperationByRef(room, ref)
+	if operation == nil {
+		log.Ctx(ctx).Error().Msgf("operation not found, ref:%s", ref)
+		return
+	}
+	if operation.Donor.ID != u.User.ID {
+		return groupReply(u, I18n(u.User, "msg_group_only_donor_can_edit"))
+	}
+
+	if field == "" {
+		deleteBtn := api.NewButton(groupDeleteOperation, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID, UserId: u.User.ID})
+		if _, err := bot.bs.SaveAll(ctx, deleteBtn); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+			return
+		}
+		msg := groupReplyMessage(u, I18n(u.User, "scrn_group_confirm_delete_operation", operation.Description, moneySpace(operation.Sum)))
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_delete_operation_confirm"), deleteBtn.ID.Hex())))
+		return api.TelegramMessage{
+			Chattable: []tgbotapi.Chattable{msg},
+			Send:      true,
+		}
+	}
+
+	old := *operation
+	if field == operationSumField {
+		if sum, err := evalExpression(strings.Join(strings.Fields(value), "")); err == nil {
+			value = strconv.Itoa(int(math.Round(sum)))
+		}
+	}
+	if err := setOperationField(operation, field, value); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("operation field not parsed %v", text)
+		return groupReply(u, I18n(u.User, "msg_wrong_format")+I18n(u.User, "scrn_group_reply_help"))
+	}
+	if err := bot.os.UpsertOperation(ctx, operation, room.ID.Hex()); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("upsert operation failed")
+		return
+	}
+	messages, err := operationChangedMessages(ctx, bot.bs, bot.us, u.User, room, &old, operation)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	msg := groupReplyMessage(u, I18n(u.User, "scrn_group_operation_changed", operation.Description)+operationChangesText(u.User, &old, operation)+"\n"+operationRef(operation))
+	return api.TelegramMessage{
+		Chattable: append([]tgbotapi.Chattable{msg}, messages...),
+		Send:      true,
+	}
+}
+
+// GroupDeleteOperation deletes the operation after the donor confirms deleting requested by reply in the group chat
+type GroupDeleteOperation struct {
+	rs  RoomService
+	os  OperationService
+	us  UserService
+	cfg *Config
+}
+
+func NewGroupDeleteOperation(rs RoomService, os OperationService, us UserService, cfg *Config) *GroupDeleteOperation {
+	return &GroupDeleteOperation{
+		rs:  rs,
+		os:  os,
+		us:  us,
+		cfg: cfg,
+	}
+}
+
+func (bot GroupDeleteOperation) HasReact(u *api.Update) bool {
+	return hasAction(u, groupDeleteOperation)
+}
+
+func (bot *GroupDeleteOperation) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	if data.UserId != u.User.ID {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_group_only_donor_can_edit"), true),
+			Send:           true,
+		}
+	}
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return failedResponse(u, err)
+	}
+	if room.CountRealMembers() == len(room.RoomStates.FinishedAddOperation) {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_not_editable_all_operations_added"), true),
+			Send:           true,
+		}
+	}
+	operation := findOperation(room, data.OperationId)
+	if operation == nil {
+		return api.TelegramMessage{
+			Chattable: []tgbotapi.Chattable{createScreen(u, I18n(u.User, "scrn_operation_deleted"), &[][]tgbotapi.InlineKeyboardButton{})},
+			Send:      true,
+		}
+	}
+	if err := bot.os.DeleteOperation(ctx, data.RoomId, data.OperationId); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("delete operation failed")
+		return failedResponse(u, err)
+	}
+	messages := []tgbotapi.Chattable{createScreen(u, I18n(u.User, "scrn_operation_deleted"), &[][]tgbotapi.InlineKeyboardButton{})}
+	return api.TelegramMessage{
+		Chattable: append(messages, operationDeletedMessages(ctx, bot.us, u.User, room, operation)...),
+		Send:      true,
+	}
+}
+
+// keywords starting the reply to the operation in the group chat
+var (
+	renameKeywords = []string{"rename", "переименовать"}
+	sumKeywords    = []string{"sum", "сумма"}
+	deleteKeywords = []string{"delete", "удалить"}
+)
+
+// cutKeyword returns the rest of the reply started with one of keywords, example = "rename taxi to airport"
+func cutKeyword(text string, keywords []string) (string, bool) {
+	text = strings.TrimSpace(text)
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return "", false
+	}
+	if containsFold(keywords, words[0]) {
+		return strings.TrimSpace(text[len(words[0]):]), true
+	}
+	return "", false
+}
+
+func containsFold(keywords []string, word string) bool {
+	for _, k := range keywords {
+		if strings.EqualFold(word, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupCommand returns arguments of the command sent to the group chat, example = /add@splitty_bot 500 pizza
+func groupCommand(u *api.Update, botName string, command string) (string, bool) {
+	if isPrivate(u) || !hasMessage(u) {
+		return "", false
+	}
+	text := u.Message.Text
+	for _, prefix := range []string{"/" + command + "@" + botName, "/" + command} {
+		if text == prefix || strings.HasPrefix(text, prefix+" ") {
+			return strings.TrimSpace(strings.TrimPrefix(text, prefix)), true
+		}
+	}
+	return "", false
+}
+
+// withoutCommand returns copy of the message with the command replaced by spaces, so entity offsets stay valid
+func withoutCommand(m *api.Message) *api.Message {
+	msg := *m
+	if i := strings.Index(msg.Text, " "); i > 0 {
+		msg.Text = strings.Repeat(" ", i) + msg.Text[i:]
+	} else {
+		msg.Text = ""
+	}
+	return &msg
+}
+
+// findGroupRoom returns room linked to the chat of the update and checks that sender is a member,
+// otherwise room is nil and response explains the reason
+func findGroupRoom(ctx context.Context, rs RoomService, u *api.Update) (*api.Room, api.TelegramMessage) {
+	room, err := rs.FindByChatId(ctx, u.Message.Chat.ID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, groupReply(u, I18n(u.User, "msg_group_not_linked"))
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find room by chat, id:%d", u.Message.Chat.ID)
+		return nil, failedResponse(u, err)
+	}
+	if !containsUserId(room.Members, u.User.ID) {
+		return nil, groupReply(u, I18n(u.User, "msg_not_be_in_rooms"))
+	}
+	return room, api.TelegramMessage{}
+}
+
+func groupReply(u *api.Update, text string) api.TelegramMessage {
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{groupReplyMessage(u, text)},
+		Send:      true,
+	}
+}
+
+func groupReplyMessage(u *api.Update, text string) tgbotapi.MessageConfig {
+	msg := tgbotapi.NewMessage(u.Message.Chat.ID, text)
+	msg.ParseMode = tgbotapi.ModeMarkdown
+	msg.ReplyToMessageID = u.Message.ID
+	return msg
+}
+
+func settleHelp(user *api.User, botName string, debts []api.Debt) string {
+	text := I18n(user, "scrn_group_settle_help", botName) + "\n\n"
+	for _, d := range debts {
+		text += debtLine(&d)
+	}
+	return text
+}
+
+func debtLine(d *api.Debt) string {
+	text := userLink(d.Debtor) + " ➡️ " + userLink(d.Lender) + ": *" + moneySpace(d.Sum) + " $*"
+	if d.Pending > 0 {
+		text += " (⏳ " + moneySpace(d.Pending) + " $)"
+	}
+	return text + "\n"
+}
+
+// operationRef returns short reference of the operation, it is used to find operation by reply to the bot message
+func operationRef(o *api.Operation) string {
+	hex := o.ID.Hex()
+	return "#op" + hex[len(hex)-8:]
+}
+
+func findOperationByRef(room *api.Room, ref string) *api.Operation {
+	for i := range *room.Operations {
+		if strings.HasSuffix((*room.Operations)[i].ID.Hex(), ref) {
+			return &(*room.Operations)[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCutKeyword(t *testing.T) {
+	tests := []struct {
+		text        string
+		keywords    []string
+		description string
+		ok          bool
+	}{
+		{keywords: renameKeywords, text: "rename taxi to airport", description: "taxi to airport", ok: true},
+		{keywords: renameKeywords, text: "  Rename   pizza ", description: "pizza", ok: true},
+		{keywords: renameKeywords, text: "переименовать такси", description: "такси", ok: true},
+		{keywords: renameKeywords, text: "Переименовать", description: "", ok: true},
+		{keywords: renameKeywords, text: "renamed it already", ok: false},
+		{keywords: renameKeywords, text: "thanks, I'll pay tomorrow", ok: false},
+		{keywords: renameKeywords, text: "500", ok: false},
+		{keywords: renameKeywords, text: "", ok: false},
+		{keywords: sumKeywords, text: "sum 500", description: "500", ok: true},
+		{keywords: sumKeywords, text: "Сумма 120 + 35", description: "120 + 35", ok: true},
+		{keywords: sumKeywords, text: "500", ok: false},
+		{keywords: sumKeywords, text: "summary please", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			description, ok := cutKeyword(tt.text, tt.keywords)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.description, description)
+		})
+	}
+}
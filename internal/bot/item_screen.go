@@ -0,0 +1,211 @@
+package bot
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// EditOperationItem screen with members who share the receipt item
+type EditOperationItem struct {
+	bs  ButtonService
+	os  OperationService
+	rs  RoomService
+	cfg *Config
+}
+
+func NewEditOperationItem(bs ButtonService, os OperationService, rs RoomService, cfg *Config) *EditOperationItem {
+	return &EditOperationItem{
+		bs:  bs,
+		os:  os,
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot EditOperationItem) HasReact(u *api.Update) bool {
+	return hasAction(u, editOperationItem)
+}
+
+func (bot *EditOperationItem) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
+	if room.CountRealMembers() == len(room.RoomStates.FinishedAddOperation) {
+		callback := createCallback(u, I18n(u.User, "msg_not_editable_all_operations_added"), true)
+		return api.TelegramMessage{
+			CallbackConfig: callback,
+			Send:           true,
+		}
+	}
+	operation := findOperation(room, data.OperationId)
+	if operation == nil || data.Item >= len(operation.Items) {
+		log.Ctx(ctx).Error().Msgf("operation item not found, operation:%s, item:%d", data.OperationId.Hex(), data.Item)
+		return
+	}
+	item := &operation.Items[data.Item]
+
+	if data.UserId != 0 {
+		recipients := toggleRecipient(item.Recipients, room.Members, data.UserId)
+		if len(recipients) < 1 {
+			callback := createCallback(u, I18n(u.User, "msg_choose_one_members"), true)
+			return api.TelegramMessage{
+				CallbackConfig: callback,
+				Send:           true,
+			}
+		}
+		item.Recipients = recipients
+		*operation.Recipients = itemsRecipients(room.Members, operation.Items)
+		if err := bot.os.UpsertOperation(ctx, operation, data.RoomId); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("upsert operation failed")
+			return
+		}
+	}
+
+	var toSave []*api.Button
+	var tgButtons []tgbotapi.InlineKeyboardButton
+	for _, m := range *room.Members {
+		b := api.NewButton(editOperationItem, &api.CallbackData{RoomId: data.RoomId, OperationId: data.OperationId, Item: data.Item, UserId: m.ID})
+		toSave = append(toSave, b)
+		tgButtons = append(tgButtons, tgbotapi.NewInlineKeyboardButtonData(setSmile(&item.Recipients, m.ID)+m.DisplayName, b.ID.Hex()))
+	}
+	backBtn := api.NewButton(editDonorOperation, &api.CallbackData{RoomId: data.RoomId, OperationId: data.OperationId})
+	toSave = append(toSave, backBtn)
+	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+
+	keyboard := optimizeKeyboardButtons(tgButtons)
+	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backBtn.ID.Hex())})
+	text := I18n(u.User, "scrn_operation_item", item.Name, moneySpace(item.Price))
+	text += I18n(u.User, "scrn_take_part")
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, text, &keyboard)},
+		Send:      true,
+	}
+}
+
+// toggleRecipient adds member to recipients or removes him if he is already there
+func toggleRecipient(recipients []api.User, members *[]api.User, userId int) []api.User {
+	var result []api.User
+	for _, r := range recipients {
+		if r.ID != userId {
+			result = append(result, r)
+		}
+	}
+	if len(result) != len(recipients) {
+		return result
+	}
+	for _, m := range *members {
+		if m.ID == userId {
+			return append(result, m)
+		}
+	}
+	return result
+}
+
+// itemsRecipients returns members who take part at least in one item, in the order of room members
+func itemsRecipients(members *[]api.User, items []api.Item) []api.User {
+	var result []api.User
+	for _, m := range *members {
+		for _, item := range items {
+			if containsUserId(&item.Recipients, m.ID) {
+				result = append(result, m)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// receiptItemRe is the receipt line with an item, example = "900 Pizza"
+var receiptItemRe = regexp.MustCompile(`^\d+\s+\S`)
+
+// isReceipt checks if the operation is sent as receipt with items on separate lines after the description
+func isReceipt(text string) bool {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for _, line := range lines[1:] {
+		if receiptItemRe.MatchString(strings.TrimSpace(line)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseReceipt parses multi-line message, the first line is description, then items in the format "price name".
+// Lines beginning with "+" are shared tax, service charge or tip: fixed "+300" or percent of items "+10%"
+func parseReceipt(text string) (string, []api.Item, int, error) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	description := strings.TrimSpace(lines[0])
+
+	var items []api.Item
+	var percents []int
+	var extra int
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "+") {
+			value := strings.TrimSpace(strings.TrimPrefix(line, "+"))
+			isPercent := strings.HasSuffix(value, "%")
+			n, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if err != nil || n < 0 {
+				return "", nil, 0, errors.Errorf("wrong extra charge %q", line)
+			}
+			if isPercent {
+				percents = append(percents, n)
+			} else {
+				extra += n
+			}
+			continue
+		}
+		words := strings.Fields(line)
+		price, err := strconv.Atoi(words[0])
+		if err != nil || price < 1 || len(words) < 2 {
+			return "", nil, 0, errors.Errorf("wrong item %q", line)
+		}
+		items = append(items, api.Item{Name: strings.Join(words[1:], " "), Price: price})
+	}
+	if description == "" || len(items) == 0 {
+		return "", nil, 0, errors.New("receipt has no items")
+	}
+
+	var itemsSum int
+	for _, item := range items {
+		itemsSum += item.Price
+	}
+	for _, p := range percents {
+		extra += itemsSum * p / 100
+	}
+	return description, items, extra, nil
+}
+
+// itemsText returns receipt items with their recipients
+func itemsText(u *api.User, o *api.Operation) string {
+	var text string
+	for _, item := range o.Items {
+		var names []string
+		for _, r := range item.Recipients {
+			names = append(names, userLink(&r))
+		}
+		text += "🍽 " + item.Name + " *" + moneySpace(item.Price) + " $*"
+		if len(names) > 0 {
+			text += ": " + strings.Join(names, ", ")
+		}
+		text += "\n"
+	}
+	if extra := o.Sum - o.ItemsSum(); extra > 0 {
+		text += I18n(u, "scrn_receipt_extra", moneySpace(extra))
+	}
+	return text
+}
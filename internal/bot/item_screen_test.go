@@ -0,0 +1,68 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/almaznur91/splitty/internal/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsReceipt(t *testing.T) {
+	tests := []struct {
+		text    string
+		receipt bool
+	}{
+		{text: "Dinner\n900 Pizza\n700 Pasta", receipt: true},
+		{text: "Dinner\n+10%\n900 Pizza", receipt: true},
+		{text: "dinner 600\nfor @anna @bob", receipt: false},
+		{text: "coffee 4.5x3", receipt: false},
+		{text: "900 Pizza\n", receipt: false},
+		{text: "Dinner\n900", receipt: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			assert.Equal(t, tt.receipt, isReceipt(tt.text))
+		})
+	}
+}
+
+func TestParseReceipt(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		description string
+		items       []api.Item
+		extra       int
+		err         bool
+	}{
+		{name: "items", text: "Dinner\n900 Pizza\n700 Pasta carbonara",
+			description: "Dinner", items: []api.Item{{Name: "Pizza", Price: 900}, {Name: "Pasta carbonara", Price: 700}}},
+		{name: "percent extra", text: "Dinner\n900 Pizza\n700 Pasta\n+10%",
+			description: "Dinner", items: []api.Item{{Name: "Pizza", Price: 900}, {Name: "Pasta", Price: 700}}, extra: 160},
+		{name: "fixed extra", text: "Dinner\n900 Pizza\n+ 300",
+			description: "Dinner", items: []api.Item{{Name: "Pizza", Price: 900}}, extra: 300},
+		{name: "percent and fixed extras", text: " Dinner \n\n900 Pizza\n+5%\n+100\n+10%",
+			description: "Dinner", items: []api.Item{{Name: "Pizza", Price: 900}}, extra: 235},
+		{name: "item without name", text: "Dinner\n900", err: true},
+		{name: "item without price", text: "Dinner\nPizza 900", err: true},
+		{name: "zero price", text: "Dinner\n0 Water", err: true},
+		{name: "wrong extra", text: "Dinner\n900 Pizza\n+tip", err: true},
+		{name: "negative extra", text: "Dinner\n900 Pizza\n+-5%", err: true},
+		{name: "no items", text: "Dinner\n+10%", err: true},
+		{name: "no description", text: "\n900 Pizza", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			description, items, extra, err := parseReceipt(tt.text)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.description, description)
+				assert.Equal(t, tt.items, items)
+				assert.Equal(t, tt.extra, extra)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package bot
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// editable fields of the operation, passed in CallbackData.ExternalData
+const (
+	operationSumField         = "sum"
+	operationDescriptionField = "description"
+	operationDateField        = "date"
+)
+
+const operationDateLayout = "02.01.2006"
+
+// WantEditOperationField asks new value of the operation field
+type WantEditOperationField struct {
+	bs  ButtonService
+	css ChatStateService
+	rs  RoomService
+	cfg *Config
+}
+
+func NewWantEditOperationField(bs ButtonService, css ChatStateService, rs RoomService, cfg *Config) *WantEditOperationField {
+	return &WantEditOperationField{
+		bs:  bs,
+		css: css,
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot WantEditOperationField) HasReact(u *api.Update) bool {
+	return hasAction(u, wantEditOperationField)
+}
+
+func (bot *WantEditOperationField) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
+	if room.CountRealMembers() == len(room.RoomStates.FinishedAddOperation) {
+		callback := createCallback(u, I18n(u.User, "msg_not_editable_all_operations_added"), true)
+		return api.TelegramMessage{
+			CallbackConfig: callback,
+			Send:           true,
+		}
+	}
+	operation := findOperation(room, data.OperationId)
+	if operation == nil {
+		log.Ctx(ctx).Error().Msgf("operation not found, id:%s", data.OperationId.Hex())
+		return
+	}
+
+	cs := &api.ChatState{UserId: int(getChatID(u)), Action: editOperationField, CallbackData: data}
+	if err := bot.css.Save(ctx, cs); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create chat state failed")
+		return
+	}
+	cancelBtn := api.NewButton(editDonorOperation, &api.CallbackData{RoomId: data.RoomId, OperationId: data.OperationId})
+	if _, err := bot.bs.SaveAll(ctx, cancelBtn); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, operationFieldPrompt(u.User, operation, data.ExternalData), &[][]tgbotapi.InlineKeyboardButton{
+			{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), cancelBtn.ID.Hex())},
+		})},
+		Send: true,
+	}
+}
+
+// EditOperationField saves new value of the operation field and notifies recipients about changes
+type EditOperationField struct {
+	bs  ButtonService
+	css ChatStateService
+	os  OperationService
+	rs  RoomService
+	us  UserService
+	cfg *Config
+}
+
+func NewEditOperationField(bs ButtonService, css ChatStateService, os OperationService, rs RoomService, us UserService, cfg *Config) *EditOperationField {
+	return &EditOperationField{
+		bs:  bs,
+		css: css,
+		os:  os,
+		rs:  rs,
+		us:  us,
+		cfg: cfg,
+	}
+}
+
+func (bot EditOperationField) HasReact(u *api.Update) bool {
+	return hasAction(u, editOperationField) && hasMessage(u)
+}
+
+func (bot *EditOperationField) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.ChatState.CallbackData
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
+	operation := findOperation(room, data.OperationId)
+	if operation == nil {
+		log.Ctx(ctx).Error().Msgf("operation not found, id:%s", data.OperationId.Hex())
+		return
+	}
+
+	old := *operation
+	if err := setOperationField(operation, data.ExternalData, u.Message.Text); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("operation field not parsed %v", u.Message.Text)
+		cancelBtn := api.NewButton(editDonorOperation, &api.CallbackData{RoomId: data.RoomId, OperationId: data.OperationId})
+		if _, err := bot.bs.SaveAll(ctx, cancelBtn); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+			return
+		}
+		return api.TelegramMessage{
+			Chattable: []tgbotapi.Chattable{NewMessage(getChatID(u), I18n(u.User, "msg_wrong_format")+operationFieldPrompt(u.User, operation, data.ExternalData),
+				[][]tgbotapi.InlineKeyboardButton{{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), cancelBtn.ID.Hex())}})},
+			Send: true,
+		}
+	}
+	defer bot.css.CleanChatState(ctx, u.ChatState)
+
+	if err := bot.os.UpsertOperation(ctx, operation, data.RoomId); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("upsert operation failed")
+		return
+	}
+
+	messages, err := operationChangedMessages(ctx, bot.bs, bot.us, u.User, room, &old, operation)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+
+	u.ChatState = nil
+	u.Button = api.NewButton(editDonorOperation, &api.CallbackData{RoomId: data.RoomId, OperationId: data.OperationId})
+	return api.TelegramMessage{
+		Chattable: messages,
+		Redirect:  u,
+		Send:      true,
+	}
+}
+
+// operationChangedMessages notifies recipients of the operation about changes made by the editor
+func operationChangedMessages(ctx context.Context, bs ButtonService, us UserService, editor *api.User, room *api.Room, old *api.Operation, operation *api.Operation) ([]tgbotapi.Chattable, error) {
+	if operation.Recipients == nil {
+		return nil, nil
+	}
+	var buttons []*api.Button
+	var messages []tgbotapi.Chattable
+	for _, r := range *operation.Recipients {
+		if r.IsVirtual || r.ID == editor.ID {
+			continue
+		}
+		user, err := us.FindById(ctx, r.ID)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("")
+			continue
+		}
+		if !*user.NotificationOn {
+			continue
+		}
+		viewBtn := api.NewButton(donorOperation, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID})
+		buttons = append(buttons, viewBtn)
+		text := I18n(user, "scrn_notification_operation_changed", userLink(user), operation.Description, room.Name)
+		text += operationChangesText(user, old, operation)
+		messages = append(messages, NewMessage(int64(user.ID), text, [][]tgbotapi.InlineKeyboardButton{
+			{tgbotapi.NewInlineKeyboardButtonData(I18n(user, "btn_view_operation"), viewBtn.ID.Hex())},
+		}))
+	}
+	if _, err := bs.SaveAll(ctx, buttons...); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// operationDeletedMessages notifies recipients of the operation that the editor deleted it
+func operationDeletedMessages(ctx context.Context, us UserService, editor *api.User, room *api.Room, operation *api.Operation) []tgbotapi.Chattable {
+	if operation.Recipients == nil {
+		return nil
+	}
+	var messages []tgbotapi.Chattable
+	for _, r := range *operation.Recipients {
+		if r.IsVirtual || r.ID == editor.ID {
+			continue
+		}
+		user, err := us.FindById(ctx, r.ID)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("")
+			continue
+		}
+		if !*user.NotificationOn {
+			continue
+		}
+		text := I18n(user, "scrn_notification_operation_deleted", userLink(user), operation.Description, moneySpace(operation.Sum), room.Name)
+		messages = append(messages, NewMessage(int64(user.ID), text, nil))
+	}
+	return messages
+}
+
+func operationFieldPrompt(user *api.User, o *api.Operation, field string) string {
+	switch field {
+	case operationSumField:
+		return I18n(user, "scrn_edit_operation_sum", moneySpace(o.Sum))
+	case operationDateField:
+		return I18n(user, "scrn_edit_operation_date", o.CreateAt.Format(operationDateLayout))
+	default:
+		return I18n(user, "scrn_edit_operation_description", o.Description)
+	}
+}
+
+// setOperationField validates the value and sets it to the operation field
+func setOperationField(o *api.Operation, field string, value string) error {
+	value = strings.TrimSpace(value)
+	switch field {
+	case operationSumField:
+		// the whole value is the sum, "500 without tips" is refused
+		sum, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.Wrapf(err, "sum %q is not a number", value)
+		}
+		if sum < 1 {
+			return errors.Errorf("sum %d is less than one", sum)
+		}
+		if sum <= o.CoPayersSum() {
+			return errors.Errorf("sum %d is not greater than co-payers sum %d", sum, o.CoPayersSum())
+		}
+		if sum < o.ItemsSum() {
+			return errors.Errorf("sum %d is less than items sum %d", sum, o.ItemsSum())
+		}
+		o.Sum = sum
+	case operationDescriptionField:
+		if value == "" {
+			return errors.New("description is empty")
+		}
+		o.Description = value
+	case operationDateField:
+		date, err := time.ParseInLocation(operationDateLayout, value, o.CreateAt.Location())
+		if err != nil {
+			return err
+		}
+		if date.After(time.Now()) {
+			return errors.Errorf("date %v is in the future", date)
+		}
+		// keep the time of day, only the date is moved
+		o.CreateAt = time.Date(date.Year(), date.Month(), date.Day(),
+			o.CreateAt.Hour(), o.CreateAt.Minute(), o.CreateAt.Second(), o.CreateAt.Nanosecond(), o.CreateAt.Location())
+	default:
+		return errors.Errorf("unknown operation field %q", field)
+	}
+	return nil
+}
+
+// operationChangesText returns changed fields of the operation and how the share of the user moved
+func operationChangesText(user *api.User, old *api.Operation, new *api.Operation) string {
+	var text string
+	if old.Description != new.Description {
+		text += I18n(user, "scrn_operation_changed_description", old.Description, new.Description)
+	}
+	if old.Sum != new.Sum {
+		text += I18n(user, "scrn_operation_changed_sum", moneySpace(old.Sum), moneySpace(new.Sum))
+	}
+	if !old.CreateAt.Equal(new.CreateAt) {
+		text += I18n(user, "scrn_operation_changed_date", old.CreateAt.Format(operationDateLayout), new.CreateAt.Format(operationDateLayout))
+	}
+	if oldShare, newShare := definePartSum(*old, user), definePartSum(*new, user); oldShare != newShare {
+		text += I18n(user, "scrn_operation_changed_share", moneySpace(oldShare), moneySpace(newShare))
+	}
+	return text
+}
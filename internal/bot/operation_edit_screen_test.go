@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/almaznur91/splitty/internal/api"
+	"github.com/gookit/i18n"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetOperationField(t *testing.T) {
+	createAt := time.Date(2021, 3, 10, 18, 30, 0, 0, time.UTC)
+	newOperation := func() *api.Operation {
+		return &api.Operation{
+			Description: "taxi",
+			Sum:         1000,
+			CoPayers:    []api.Payer{{User: &api.User{ID: 2}, Sum: 300}},
+			Items:       []api.Item{{Name: "pizza", Price: 400}},
+			CreateAt:    createAt,
+		}
+	}
+	tests := []struct {
+		name     string
+		field    string
+		value    string
+		expected func(o *api.Operation)
+		err      bool
+	}{
+		{name: "sum", field: operationSumField, value: " 1500 ", expected: func(o *api.Operation) { o.Sum = 1500 }},
+		{name: "sum with words", field: operationSumField, value: "500 without tips", err: true},
+		{name: "sum negative", field: operationSumField, value: "-500", err: true},
+		{name: "sum not a number", field: operationSumField, value: "thousand", err: true},
+		{name: "sum empty", field: operationSumField, value: "  ", err: true},
+		{name: "sum zero", field: operationSumField, value: "0", err: true},
+		{name: "sum of co-payers", field: operationSumField, value: "300", err: true},
+		{name: "sum less than items", field: operationSumField, value: "350", err: true},
+		{name: "description", field: operationDescriptionField, value: "  taxi to airport ", expected: func(o *api.Operation) { o.Description = "taxi to airport" }},
+		{name: "description empty", field: operationDescriptionField, value: " \n ", err: true},
+		{name: "date keeps time of day", field: operationDateField, value: "01.03.2021",
+			expected: func(o *api.Operation) { o.CreateAt = time.Date(2021, 3, 1, 18, 30, 0, 0, time.UTC) }},
+		{name: "date in future", field: operationDateField, value: time.Now().AddDate(0, 0, 2).Format(operationDateLayout), err: true},
+		{name: "date invalid", field: operationDateField, value: "2021-03-01", err: true},
+		{name: "unknown field", field: "donor", value: "1", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOperation()
+			err := setOperationField(o, tt.field, tt.value)
+			expected := newOperation()
+			if tt.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				tt.expected(expected)
+			}
+			assert.Equal(t, expected, o, "invalid value doesn't change the operation")
+		})
+	}
+}
+
+func TestOperationChangesText(t *testing.T) {
+	i18n.Init("../../conf/lang", "en", map[string]string{"en": "English", "ru": "Русский"})
+	user := &api.User{ID: 1, SelectedLang: "en"}
+	other := api.User{ID: 2}
+	createAt := time.Date(2021, 3, 10, 18, 30, 0, 0, time.UTC)
+	old := &api.Operation{Description: "taxi", Sum: 900, Recipients: &[]api.User{*user}, CreateAt: createAt}
+
+	tests := []struct {
+		name     string
+		change   func(o *api.Operation)
+		expected string
+	}{
+		{name: "nothing", change: func(o *api.Operation) {}, expected: ""},
+		{name: "description", change: func(o *api.Operation) { o.Description = "bus" },
+			expected: "📝 Description: _taxi_ ➡ _bus_\n"},
+		{name: "sum", change: func(o *api.Operation) { o.Sum = 1200 },
+			expected: "💰 Sum: *900 $* ➡ *1 200 $*\n🧮 Your share: *900 $* ➡ *1 200 $*\n"},
+		{name: "recipient added", change: func(o *api.Operation) { o.Recipients = &[]api.User{*user, other} },
+			expected: "🧮 Your share: *900 $* ➡ *450 $*\n"},
+		{name: "recipient removed", change: func(o *api.Operation) { o.Recipients = &[]api.User{other} },
+			expected: "🧮 Your share: *900 $* ➡ *0 $*\n"},
+		{name: "date", change: func(o *api.Operation) { o.CreateAt = createAt.AddDate(0, 0, -1) },
+			expected: "🗓 Date: *10.03.2021* ➡ *09.03.2021*\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := *old
+			tt.change(&changed)
+			assert.Equal(t, tt.expected, operationChangesText(user, old, &changed))
+		})
+	}
+}
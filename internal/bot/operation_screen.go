@@ -27,6 +27,13 @@ type OperationService interface {
 	GetUserInvolvedDebts(ctx context.Context, userId int, roomId string) (*[]api.Debt, error)
 	GetUserDebts(ctx context.Context, userId int, roomId string) (*[]api.Debt, error)
 	GetUserDebt(ctx context.Context, debtorId int, lenderId int, roomId string) (*api.Debt, error)
+	GetUserBalances(ctx context.Context, userId int) ([]api.Balance, error)
+	GetUserBalance(ctx context.Context, userId int, counterpartyId int) (*api.Balance, error)
+	SettleBalance(ctx context.Context, userId int, counterpartyId int, settlementId primitive.ObjectID) (*api.Balance, []api.Operation, error)
+	ReviewSettlement(ctx context.Context, settlementId primitive.ObjectID, status api.RepaymentStatus) ([]string, error)
+	SuggestCategory(room *api.Room, description string) string
+	BudgetStatuses(room *api.Room) []api.BudgetStatus
+	BudgetForecast(room *api.Room, now time.Time) api.BudgetForecast
 }
 
 // Operation show screen with my and all chooseOperations buttons
@@ -59,7 +66,7 @@ func (bot Operation) OnMessage(ctx context.Context, u *api.Update) (response api
 	roomId := u.Button.CallbackData.RoomId
 	room, err := bot.rs.FindById(ctx, roomId)
 	if err != nil {
-		log.Error().Err(err).Stack().Msgf("cannot find room, id:%s", roomId)
+		log.Ctx(ctx).Error().Err(err).Stack().Msgf("cannot find room, id:%s", roomId)
 		return
 	}
 	operations := room.Operations
@@ -97,7 +104,7 @@ func (bot Operation) OnMessage(ctx context.Context, u *api.Update) (response api
 	toSave = append(toSave, backB)
 	buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backB.ID.Hex()))
 	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 
@@ -149,7 +156,7 @@ func (s WantDonorOperation) OnMessage(ctx context.Context, u *api.Update) (respo
 	roomId := u.Button.CallbackData.RoomId
 	room, err := s.rs.FindById(ctx, roomId)
 	if err != nil {
-		log.Error().Err(err).Msg("get room failed")
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
 		return
 	}
 	if !containsUserId(room.Members, u.User.ID) {
@@ -160,7 +167,7 @@ func (s WantDonorOperation) OnMessage(ctx context.Context, u *api.Update) (respo
 	}
 
 	//validation, if all members finished added operation
-	if len(room.RoomStates.FinishedAddOperation) == len(*room.Members) {
+	if len(room.RoomStates.FinishedAddOperation) == room.CountRealMembers() {
 		callback := createCallback(u, I18n(u.User, "msg_can_not_add_operations"), true)
 		return api.TelegramMessage{
 			CallbackConfig: callback,
@@ -171,14 +178,14 @@ func (s WantDonorOperation) OnMessage(ctx context.Context, u *api.Update) (respo
 	cs := &api.ChatState{UserId: int(getChatID(u)), Action: addDonorOperation, CallbackData: &api.CallbackData{RoomId: roomId}}
 	err = s.css.Save(ctx, cs)
 	if err != nil {
-		log.Error().Err(err).Msg("create chat state failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create chat state failed")
 		return
 	}
 
 	b := api.NewButton(viewRoom, u.Button.CallbackData)
 	_, err = s.bs.Save(ctx, b)
 	if err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 
@@ -220,14 +227,21 @@ func (s AddDonorOperation) HasReact(u *api.Update) bool {
 
 // OnMessage returns one entry
 func (s AddDonorOperation) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
-	sum, err := defineSum(u.Message.Text)
-	purchaseText := s.defineText(u.Message.Text)
+	if isReceipt(u.Message.Text) {
+		return s.addReceipt(ctx, u)
+	}
+	room, err := s.rs.FindById(ctx, u.ChatState.CallbackData.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
 
 	rb := api.NewButton(viewRoom, &api.CallbackData{RoomId: u.ChatState.CallbackData.RoomId})
+	draft, err := parseExpense(u.Message, room)
 	if err != nil {
-		log.Error().Err(err).Msgf("not parsed %v", u.Message.Text)
+		log.Ctx(ctx).Error().Err(err).Msgf("not parsed %v", u.Message.Text)
 		if _, err := s.bs.SaveAll(ctx, rb); err != nil {
-			log.Error().Err(err).Msg("save buttons failed")
+			log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
 			return
 		}
 		return api.TelegramMessage{
@@ -239,84 +253,295 @@ func (s AddDonorOperation) OnMessage(ctx context.Context, u *api.Update) (respon
 	}
 	defer s.css.CleanChatState(ctx, u.ChatState)
 
-	room, err := s.rs.FindById(ctx, u.ChatState.CallbackData.RoomId)
+	keyboard, err := confirmExpenseKeyboard(ctx, s.bs, u, room, draft, rb)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
+		return
+	}
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{NewMessage(getChatID(u), expenseDraftText(u.User, draft), keyboard)},
+		Send:      true,
+	}
+}
+
+// confirmExpenseKeyboard saves the recognized expense in the confirm button, the operation is created only after
+// the author checks the amount, description and recipients. The expense without description gets the default one
+func confirmExpenseKeyboard(ctx context.Context, bs ButtonService, u *api.Update, room *api.Room, draft *expenseDraft, cancelBtn *api.Button) ([][]tgbotapi.InlineKeyboardButton, error) {
+	if draft.description == "" {
+		draft.description = I18n(u.User, "msg_expense_no_description")
+	}
+	var ids []int
+	for _, r := range draft.recipients {
+		ids = append(ids, r.ID)
+	}
+	confirmBtn := api.NewButton(confirmExpense, &api.CallbackData{RoomId: room.ID.Hex(), UserId: u.User.ID,
+		ExternalData: draft.description, Sum: draft.sum, UserIds: ids, Items: draft.items})
+	if _, err := bs.SaveAll(ctx, confirmBtn, cancelBtn); err != nil {
+		return nil, err
+	}
+	return [][]tgbotapi.InlineKeyboardButton{
+		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_save_expense"), confirmBtn.ID.Hex())},
+		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), cancelBtn.ID.Hex())},
+	}, nil
+}
+
+func expenseDraftText(user *api.User, draft *expenseDraft) string {
+	var recipients []string
+	for _, r := range draft.recipients {
+		recipients = append(recipients, userLink(&r))
+	}
+	text := I18n(user, "scrn_confirm_expense", draft.description, moneySpace(draft.sum), strings.Join(recipients, ", "))
+	if len(draft.items) > 0 {
+		text += "\n\n" + itemsText(user, &api.Operation{Sum: draft.sum, Items: draft.items})
+	}
+	return text
+}
+
+// ConfirmExpense saves the expense recognized in the message after the author confirms it
+type ConfirmExpense struct {
+	bs  ButtonService
+	os  OperationService
+	rs  RoomService
+	rss RoomStateService
+	cfg *Config
+}
+
+func NewConfirmExpense(bs ButtonService, os OperationService, rs RoomService, rss RoomStateService, cfg *Config) *ConfirmExpense {
+	return &ConfirmExpense{
+		bs:  bs,
+		os:  os,
+		rs:  rs,
+		rss: rss,
+		cfg: cfg,
+	}
+}
+
+func (s ConfirmExpense) HasReact(u *api.Update) bool {
+	return hasAction(u, confirmExpense)
+}
+
+func (s *ConfirmExpense) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	if data.UserId != u.User.ID {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_expense_not_yours"), true),
+			Send:           true,
+		}
+	}
+	room, err := s.rs.FindById(ctx, data.RoomId)
 	if err != nil {
-		log.Error().Err(err).Msg("get room failed")
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
+	if len(room.RoomStates.FinishedAddOperation) == room.CountRealMembers() {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_can_not_add_operations"), true),
+			Send:           true,
+		}
+	}
+	// the operation gets id of the button, so repeated presses don't add the expense twice
+	if room.Operations != nil && findOperationByRef(room, u.Button.ID.Hex()) != nil {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_expense_already_added"), false),
+			Send:           true,
+		}
+	}
+	var recipients []api.User
+	for _, m := range *room.Members {
+		if containsInt(data.UserIds, m.ID) {
+			recipients = append(recipients, m)
+		}
+	}
+	if len(recipients) == 0 {
+		log.Ctx(ctx).Error().Msgf("recipients of the expense left room %s", room.ID.Hex())
 		return
 	}
 
+	items := append([]api.Item{}, data.Items...)
+	for i := range items {
+		items[i].Recipients = recipients
+	}
 	operation := &api.Operation{
-		ID:               primitive.NewObjectID(),
-		Description:      purchaseText,
-		Sum:              sum,
-		Donor:            &u.Message.From,
-		Recipients:       room.Members,
+		ID:               u.Button.ID,
+		Description:      data.ExternalData,
+		Category:         s.os.SuggestCategory(room, data.ExternalData),
+		Sum:              data.Sum,
+		Donor:            getFrom(u),
+		Recipients:       &recipients,
+		Items:            items,
 		CreateAt:         time.Now(),
 		NotificationSent: []int{},
 		Files:            []api.File{},
 	}
 	if err = s.os.UpsertOperation(ctx, operation, room.ID.Hex()); err != nil {
-		log.Error().Err(err).Msg("upsert operation failed")
+		log.Ctx(ctx).Error().Err(err).Msg("upsert operation failed")
 		return
 	}
-
 	//async calculate paidOfDebtsUserIds for room, after added operation
 	go func() {
 		err := s.rss.DefinePaidOfDebtsUserIdsAndSave(ctx, room)
 		if err != nil {
-			log.Error().Err(err).Msg("")
+			log.Ctx(ctx).Error().Err(err).Msg("")
 		}
 	}()
 
+	if !isPrivate(u) {
+		var links []string
+		for _, r := range recipients {
+			links = append(links, userLink(&r))
+		}
+		text := I18n(u.User, "scrn_group_operation_added", operation.Description, moneySpace(operation.Sum), userLink(operation.Donor))
+		text += I18n(u.User, "scrn_operation_recipients", strings.Join(links, ", "))
+		text += "\n" + operationRef(operation) + "\n"
+		text += I18n(u.User, "scrn_group_reply_help")
+		return api.TelegramMessage{
+			Chattable: []tgbotapi.Chattable{createScreen(u, text, &[][]tgbotapi.InlineKeyboardButton{})},
+			Send:      true,
+		}
+	}
+
+	if len(operation.Items) > 0 {
+		// receipt items are shared by all members, the screen of the operation allows to edit them
+		u.Button = api.NewButton(editDonorOperation, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID})
+		return api.TelegramMessage{
+			Redirect: u,
+			Send:     true,
+		}
+	}
+	text, keyboard, err := operationAddedScreen(ctx, s.bs, u, room, operation)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
+		return
+	}
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, text, &keyboard)},
+		Send:      true,
+	}
+}
+
+// CancelExpense drops the expense recognized in the group message, the author can send it again
+type CancelExpense struct {
+	cfg *Config
+}
+
+func NewCancelExpense(cfg *Config) *CancelExpense {
+	return &CancelExpense{cfg: cfg}
+}
+
+func (s CancelExpense) HasReact(u *api.Update) bool {
+	return hasAction(u, cancelExpense)
+}
+
+func (s *CancelExpense) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	if u.Button.CallbackData.UserId != u.User.ID {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_expense_not_yours"), true),
+			Send:           true,
+		}
+	}
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, I18n(u.User, "msg_expense_canceled"), &[][]tgbotapi.InlineKeyboardButton{})},
+		Send:      true,
+	}
+}
+
+// operationAddedScreen returns the screen of the added operation, members can be marked as recipients on it
+func operationAddedScreen(ctx context.Context, bs ButtonService, u *api.Update, room *api.Room, operation *api.Operation) (string, [][]tgbotapi.InlineKeyboardButton, error) {
 	var buttons []*api.Button
 	var tgButtons []tgbotapi.InlineKeyboardButton
 	for _, v := range *room.Members {
 		b := &api.Button{ID: primitive.NewObjectID(),
 			Action:       editDonorOperation,
-			Text:         setSmile(room.Members, v.ID) + v.DisplayName,
+			Text:         setSmile(operation.Recipients, v.ID) + v.DisplayName,
 			CallbackData: &api.CallbackData{RoomId: room.ID.Hex(), UserId: v.ID, OperationId: operation.ID}}
 		buttons = append(buttons, b)
 		tgButtons = append(tgButtons, tgbotapi.NewInlineKeyboardButtonData(b.Text, b.ID.Hex()))
 	}
 
 	ob := api.NewButton(deleteDonorOperation, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID})
-	db := api.NewButton(addedOperation, &api.CallbackData{RoomId: u.ChatState.CallbackData.RoomId, OperationId: operation.ID})
-	buttons = append(buttons, rb, ob, db)
+	db := api.NewButton(addedOperation, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID})
+	cpb := api.NewButton(wantAddCoPayer, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID})
+	ctb := api.NewButton(chooseCategory, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID})
+	buttons = append(buttons, ob, db, cpb, ctb)
 
-	if _, err = s.bs.SaveAll(ctx, buttons...); err != nil {
-		log.Error().Err(err).Msg("save buttons failed")
-		return
+	if _, err := bs.SaveAll(ctx, buttons...); err != nil {
+		return "", nil, err
 	}
 
 	keyboardButtons := optimizeKeyboardButtons(tgButtons)
 	keyboardButtons = append(keyboardButtons,
+		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_choose_category"), ctb.ID.Hex())},
+		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_add_co_payer"), cpb.ID.Hex())},
 		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_rm_operation"), ob.ID.Hex())},
 		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_done"), db.ID.Hex())})
 
-	text := I18n(u.User, "scrn_operation_added", purchaseText, moneySpace(sum))
-	text += "🗓 " + operation.CreateAt.Format("02 January 2006") + "\n\n"
+	var recipients []string
+	for _, r := range *operation.Recipients {
+		recipients = append(recipients, userLink(&r))
+	}
+	text := I18n(u.User, "scrn_operation_added", operation.Description, moneySpace(operation.Sum))
+	text += "🗓 " + operation.CreateAt.Format("02 January 2006") + "\n"
+	text += categoryLine(u.User, operation)
+	text += I18n(u.User, "scrn_operation_recipients", strings.Join(recipients, ", ")) + "\n"
 	text += I18n(u.User, "scrn_mark_members")
 	text += I18n(u.User, "scrn_take_part")
+	return text, keyboardButtons, nil
+}
+
+// addReceipt recognizes operation with items and asks to confirm it, every item is shared by all members until edited
+func (s AddDonorOperation) addReceipt(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	roomId := u.ChatState.CallbackData.RoomId
+	rb := api.NewButton(viewRoom, &api.CallbackData{RoomId: roomId})
+	description, items, extra, err := parseReceipt(u.Message.Text)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("receipt not parsed %v", u.Message.Text)
+		if _, err := s.bs.SaveAll(ctx, rb); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
+			return
+		}
+		return api.TelegramMessage{
+			Chattable: []tgbotapi.Chattable{NewMessage(getChatID(u),
+				I18n(u.User, "msg_wrong_format")+I18n(u.User, "scrn_add_operation"),
+				[][]tgbotapi.InlineKeyboardButton{{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), rb.ID.Hex())}})},
+			Send: true,
+		}
+	}
+	defer s.css.CleanChatState(ctx, u.ChatState)
+
+	room, err := s.rs.FindById(ctx, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
+
+	sum := extra
+	for _, item := range items {
+		sum += item.Price
+	}
+	draft := &expenseDraft{description: description, sum: sum, recipients: *room.Members, items: items}
+	keyboard, err := confirmExpenseKeyboard(ctx, s.bs, u, room, draft, rb)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
+		return
+	}
 	return api.TelegramMessage{
-		Chattable: []tgbotapi.Chattable{NewMessage(getChatID(u), text, keyboardButtons)},
+		Chattable: []tgbotapi.Chattable{NewMessage(getChatID(u), expenseDraftText(u.User, draft), keyboard)},
 		Send:      true,
 	}
 }
 
-func (s AddDonorOperation) defineText(text string) string {
-	words := strings.Fields(text)
-	return strings.Join(words[1:], " ")
-}
-
 func defineSum(text string) (int, error) {
 	words := strings.Fields(text)
+	if len(words) == 0 {
+		return 0, errors.New("sum is empty")
+	}
 	sum, err := strconv.Atoi(words[0])
 	if err != nil {
 		log.Error().Err(err).Msg("text to int not parsed")
 		return 0, err
 	}
 	if sum < 1 {
-		log.Error().Err(err).Msgf("sum can not be les zero $v", sum)
+		log.Error().Err(err).Msgf("sum can not be les zero %v", sum)
 		return 0, errors.New("sum can not be les zero")
 	}
 	return sum, nil
@@ -349,13 +574,13 @@ func (s EditDonorOperation) HasReact(u *api.Update) bool {
 func (s EditDonorOperation) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
 	room, err := s.rs.FindById(ctx, u.Button.CallbackData.RoomId)
 	if err != nil {
-		log.Error().Err(err).Msg("get room failed")
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
 		return
 	}
 
 	//validation, if all users finished adding operations
 	countUsersFinishedAddOperation := len(room.RoomStates.FinishedAddOperation)
-	if len(*room.Members) == countUsersFinishedAddOperation {
+	if room.CountRealMembers() == countUsersFinishedAddOperation {
 		callback := createCallback(u, I18n(u.User, "msg_not_editable_all_operations_added"), true)
 		return api.TelegramMessage{
 			CallbackConfig: callback,
@@ -370,54 +595,83 @@ func (s EditDonorOperation) OnMessage(ctx context.Context, u *api.Update) (respo
 		}
 	}
 
-	*operation.Recipients = s.addOrDeleteRecipient(operation.Recipients, room.Members, u.Button.CallbackData.UserId)
+	hasItems := len(operation.Items) > 0
+	if !hasItems {
+		*operation.Recipients = s.addOrDeleteRecipient(operation.Recipients, room.Members, u.Button.CallbackData.UserId)
 
-	if len(*operation.Recipients) < 1 {
-		callback := createCallback(u, I18n(u.User, "msg_choose_one_members"), true)
-		return api.TelegramMessage{
-			CallbackConfig: callback,
-			Send:           true,
+		if len(*operation.Recipients) < 1 {
+			callback := createCallback(u, I18n(u.User, "msg_choose_one_members"), true)
+			return api.TelegramMessage{
+				CallbackConfig: callback,
+				Send:           true,
+			}
 		}
-	}
 
-	if err = s.os.UpsertOperation(ctx, &operation, room.ID.Hex()); err != nil {
-		log.Error().Err(err).Msg("upsert operation failed")
-		return
+		if err = s.os.UpsertOperation(ctx, &operation, room.ID.Hex()); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("upsert operation failed")
+			return
+		}
 	}
 
 	var buttons []*api.Button
 	var tgButtons []tgbotapi.InlineKeyboardButton
-	for _, v := range *room.Members {
-		b := &api.Button{ID: primitive.NewObjectID(),
-			Action:       editDonorOperation,
-			Text:         setSmile(operation.Recipients, v.ID) + v.DisplayName,
-			CallbackData: &api.CallbackData{RoomId: room.ID.Hex(), UserId: v.ID, OperationId: operation.ID}}
-		buttons = append(buttons, b)
-		tgButtons = append(tgButtons, tgbotapi.NewInlineKeyboardButtonData(b.Text, b.ID.Hex()))
+	if hasItems {
+		for i, item := range operation.Items {
+			b := api.NewButton(editOperationItem, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID, Item: i})
+			buttons = append(buttons, b)
+			tgButtons = append(tgButtons, tgbotapi.NewInlineKeyboardButtonData("🍽 "+item.Name, b.ID.Hex()))
+		}
+	} else {
+		for _, v := range *room.Members {
+			b := &api.Button{ID: primitive.NewObjectID(),
+				Action:       editDonorOperation,
+				Text:         setSmile(operation.Recipients, v.ID) + v.DisplayName,
+				CallbackData: &api.CallbackData{RoomId: room.ID.Hex(), UserId: v.ID, OperationId: operation.ID}}
+			buttons = append(buttons, b)
+			tgButtons = append(tgButtons, tgbotapi.NewInlineKeyboardButtonData(b.Text, b.ID.Hex()))
+		}
 	}
 
 	doneBtn := api.NewButton(addedOperation, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID})
 	deleteBtn := api.NewButton(deleteDonorOperation, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID})
 	addFileBtn := api.NewButton(wantAddFileToOperation, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID})
-	buttons = append(buttons, doneBtn, deleteBtn, addFileBtn)
+	coPayerBtn := api.NewButton(wantAddCoPayer, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID})
+	sumBtn := api.NewButton(wantEditOperationField, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID, ExternalData: operationSumField})
+	descriptionBtn := api.NewButton(wantEditOperationField, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID, ExternalData: operationDescriptionField})
+	dateBtn := api.NewButton(wantEditOperationField, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID, ExternalData: operationDateField})
+	categoryBtn := api.NewButton(chooseCategory, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: operation.ID})
+	buttons = append(buttons, doneBtn, deleteBtn, addFileBtn, coPayerBtn, sumBtn, descriptionBtn, dateBtn, categoryBtn)
 
 	keyboardButtons := optimizeKeyboardButtons(tgButtons)
 	keyboardButtons = append(keyboardButtons,
+		[]tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_edit_sum"), sumBtn.ID.Hex()),
+			tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_edit_description"), descriptionBtn.ID.Hex()),
+			tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_edit_date"), dateBtn.ID.Hex()),
+		},
+		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_choose_category"), categoryBtn.ID.Hex())},
+		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_add_co_payer"), coPayerBtn.ID.Hex())},
 		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_add_file"), addFileBtn.ID.Hex())},
 		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_rm_operation"), deleteBtn.ID.Hex())},
 		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData("🏁 "+I18n(u.User, "btn_done"), doneBtn.ID.Hex())})
 
 	if _, err = s.bs.SaveAll(ctx, buttons...); err != nil {
-		log.Error().Err(err).Msg("save buttons failed")
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
 		return
 	}
 
 	partSum := definePartSum(operation, u.User)
 	text := I18n(u.User, "scrn_operation_on_sum", operation.Description, moneySpace(operation.Sum), moneySpace(partSum))
 	text += "🗓 " + operation.CreateAt.Format("02 January 2006") + "\n"
+	text += categoryLine(u.User, &operation)
 	text += s.defineFileMessage(u.User, operation) + "\n"
-	text += I18n(u.User, "scrn_mark_members")
-	text += I18n(u.User, "scrn_take_part")
+	if hasItems {
+		text += itemsText(u.User, &operation) + "\n"
+		text += I18n(u.User, "scrn_choose_item")
+	} else {
+		text += I18n(u.User, "scrn_mark_members")
+		text += I18n(u.User, "scrn_take_part")
+	}
 	return api.TelegramMessage{
 		Chattable: []tgbotapi.Chattable{createScreen(u, text, &keyboardButtons)},
 		Send:      true,
@@ -501,7 +755,7 @@ func (s OperationAdded) HasReact(u *api.Update) bool {
 func (s OperationAdded) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
 	room, err := s.rs.FindById(ctx, u.Button.CallbackData.RoomId)
 	if err != nil {
-		log.Error().Err(err).Msg("get room failed")
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
 		return
 	}
 
@@ -515,9 +769,13 @@ func (s OperationAdded) OnMessage(ctx context.Context, u *api.Update) (response
 	var buttons []*api.Button
 	var messages []tgbotapi.Chattable
 	for _, user := range *opn.Recipients {
+		if user.IsVirtual {
+			continue
+		}
 		user, err := s.us.FindById(ctx, user.ID)
 		if err != nil {
-			log.Error().Err(err).Msg("")
+			log.Ctx(ctx).Error().Err(err).Msg("")
+			continue
 		}
 		if !containsInt(opn.NotificationSent, user.ID) && *user.NotificationOn && user.ID != u.User.ID {
 			rb := api.NewButton(donorOperation, &api.CallbackData{RoomId: room.ID.Hex(), OperationId: opn.ID})
@@ -531,16 +789,18 @@ func (s OperationAdded) OnMessage(ctx context.Context, u *api.Update) (response
 				})
 			opn.NotificationSent = append(opn.NotificationSent, user.ID)
 			if err := s.os.UpsertOperation(ctx, &opn, room.ID.Hex()); err != nil {
-				log.Error().Err(err).Msg("")
+				log.Ctx(ctx).Error().Err(err).Msg("")
 			}
 			messages = append(messages, msg)
 		}
 	}
 
+	messages = append(messages, createBudgetAlerts(ctx, s.rs, s.os, s.us, room)...)
+
 	viewRoomBtn := api.NewButton(viewRoom, &api.CallbackData{RoomId: u.Button.CallbackData.RoomId})
 	buttons = append(buttons, viewRoomBtn)
 	if _, err := s.bs.SaveAll(ctx, buttons...); err != nil {
-		log.Error().Err(err).Msg("save buttons failed")
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
 		return
 	}
 
@@ -579,7 +839,7 @@ func (s ViewDonorOperation) HasReact(u *api.Update) bool {
 func (s ViewDonorOperation) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
 	room, err := s.rs.FindById(ctx, u.Button.CallbackData.RoomId)
 	if err != nil {
-		log.Error().Err(err).Msg("get room failed")
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
 		return
 	}
 
@@ -605,14 +865,18 @@ func (s ViewDonorOperation) OnMessage(ctx context.Context, u *api.Update) (respo
 	btns = append(btns, cb)
 	_, err = s.bs.SaveAll(ctx, btns...)
 	if err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 	partSum := definePartSum(operation, u.User)
 	text := I18n(u.User, "scrn_operation_on_sum", operation.Description, moneySpace(operation.Sum), moneySpace(partSum))
-	text += I18n(u.User, "scrn_user_paid", userLink(operation.Donor))
-	for _, v := range *operation.Recipients {
-		text += "- " + userLink(&v) + "\n"
+	text += I18n(u.User, "scrn_user_paid", paidByText(&operation))
+	if len(operation.Items) > 0 {
+		text += itemsText(u.User, &operation)
+	} else {
+		for _, v := range *operation.Recipients {
+			text += "- " + userLink(&v) + "\n"
+		}
 	}
 	text += "\n🗓 " + operation.CreateAt.Format("02 January 2006") + "\n"
 	text += s.defineFileMessage(u.User, operation)
@@ -651,15 +915,17 @@ type DeleteDonorOperation struct {
 	bs  ButtonService
 	os  OperationService
 	rs  RoomService
+	us  UserService
 	cfg *Config
 }
 
-func NewDeleteDonorOperation(s ChatStateService, bs ButtonService, os OperationService, rs RoomService, cfg *Config) *DeleteDonorOperation {
+func NewDeleteDonorOperation(s ChatStateService, bs ButtonService, os OperationService, rs RoomService, us UserService, cfg *Config) *DeleteDonorOperation {
 	return &DeleteDonorOperation{
 		css: s,
 		bs:  bs,
 		os:  os,
 		rs:  rs,
+		us:  us,
 		cfg: cfg,
 	}
 }
@@ -672,30 +938,36 @@ func (s DeleteDonorOperation) HasReact(u *api.Update) bool {
 }
 
 func (s DeleteDonorOperation) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
-	if err := s.os.DeleteOperation(ctx, u.Button.CallbackData.RoomId, u.Button.CallbackData.OperationId); err != nil {
-		log.Error().Err(err).Msg("")
-		return
-	}
 	room, err := s.rs.FindById(ctx, u.Button.CallbackData.RoomId)
 	if err != nil {
-		log.Error().Err(err).Msg("get room failed")
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
+	operation := findOperation(room, u.Button.CallbackData.OperationId)
+	if operation == nil {
+		log.Ctx(ctx).Error().Msgf("operation not found, id:%s", u.Button.CallbackData.OperationId.Hex())
+		return
+	}
+	if err := s.os.DeleteOperation(ctx, u.Button.CallbackData.RoomId, u.Button.CallbackData.OperationId); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("")
 		return
 	}
 	var action api.Action
-	if room.Operations != nil && len(*room.Operations) > 0 {
+	if len(*room.Operations) > 1 {
 		action = viewAllOperations
 	} else {
 		action = viewRoom
 	}
 	rb := api.NewButton(action, &api.CallbackData{RoomId: u.Button.CallbackData.RoomId})
 	if _, err := s.bs.SaveAll(ctx, rb); err != nil {
-		log.Error().Err(err).Msg("save buttons failed")
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
 		return
 	}
 
 	keyboard := &[][]tgbotapi.InlineKeyboardButton{{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_done"), rb.ID.Hex())}}
+	messages := []tgbotapi.Chattable{createScreen(u, I18n(u.User, "scrn_operation_deleted"), keyboard)}
 	return api.TelegramMessage{
-		Chattable: []tgbotapi.Chattable{createScreen(u, I18n(u.User, "scrn_operation_deleted"), keyboard)},
+		Chattable: append(messages, operationDeletedMessages(ctx, s.us, u.User, room, operation)...),
 		Send:      true,
 	}
 }
@@ -729,7 +1001,7 @@ func (s WantAddFileToOperation) OnMessage(ctx context.Context, u *api.Update) (r
 	cancelBtn := api.NewButton(viewRoom, &api.CallbackData{RoomId: u.Button.CallbackData.RoomId})
 	_, err := s.bs.SaveAll(ctx, cancelBtn)
 	if err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 
@@ -738,7 +1010,7 @@ func (s WantAddFileToOperation) OnMessage(ctx context.Context, u *api.Update) (r
 		CallbackData: &api.CallbackData{RoomId: u.Button.CallbackData.RoomId, OperationId: u.Button.CallbackData.OperationId}}
 	err = s.css.Save(ctx, cs)
 	if err != nil {
-		log.Error().Err(err).Msg("create chat state failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create chat state failed")
 		return
 	}
 
@@ -780,7 +1052,7 @@ func (s AddFileToOperation) HasReact(u *api.Update) bool {
 func (s AddFileToOperation) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
 	room, err := s.rs.FindById(ctx, u.ChatState.CallbackData.RoomId)
 	if err != nil {
-		log.Error().Err(err).Msg("get room failed")
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
 		return
 	}
 	var operation api.Operation
@@ -799,7 +1071,7 @@ func (s AddFileToOperation) OnMessage(ctx context.Context, u *api.Update) (respo
 	}
 
 	if err = s.os.UpsertOperation(ctx, &operation, room.ID.Hex()); err != nil {
-		log.Error().Err(err).Msg("upsert operation failed")
+		log.Ctx(ctx).Error().Err(err).Msg("upsert operation failed")
 		return
 	}
 	defer s.css.CleanChatState(ctx, u.ChatState)
@@ -840,7 +1112,7 @@ func (s ViewFileOperation) HasReact(u *api.Update) bool {
 func (s ViewFileOperation) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
 	room, err := s.rs.FindById(ctx, u.Button.CallbackData.RoomId)
 	if err != nil {
-		log.Error().Err(err).Msg("get room failed")
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
 		return
 	}
 	var operation api.Operation
@@ -871,7 +1143,7 @@ func (s ViewFileOperation) OnMessage(ctx context.Context, u *api.Update) (respon
 	viewRoomBtn := api.NewButton(donorOperation, u.Button.CallbackData)
 	_, err = s.bs.SaveAll(ctx, viewRoomBtn)
 	if err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 	keyboard := &[][]tgbotapi.InlineKeyboardButton{{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), viewRoomBtn.ID.Hex())}}
@@ -913,11 +1185,11 @@ func (s WantReturnDebt) OnMessage(ctx context.Context, u *api.Update) (response
 
 	room, err := s.rs.FindById(ctx, u.Button.CallbackData.RoomId)
 	if err != nil {
-		log.Error().Err(err).Msg("get room failed")
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
 		return
 	}
 	countUsersFinishedAddOperation := len(room.RoomStates.FinishedAddOperation)
-	if len(*room.Members) != countUsersFinishedAddOperation {
+	if room.CountRealMembers() != countUsersFinishedAddOperation {
 		callback := createCallback(u, I18n(u.User, "msg_not_back_debt_operations_no_added"), true)
 		return api.TelegramMessage{
 			CallbackConfig: callback,
@@ -925,34 +1197,53 @@ func (s WantReturnDebt) OnMessage(ctx context.Context, u *api.Update) (response
 		}
 	}
 
-	debt, err := s.os.GetUserDebt(ctx, u.User.ID, lenderUserId, roomId)
-	if err != nil || debt == nil {
-		log.Error().Err(err).Msg("get user debts failed")
+	debtor := defineDebtor(room, u.User, u.Button.CallbackData.DebtorId)
+	if debtor == nil {
+		log.Ctx(ctx).Error().Msgf("user %d can not repay debt of %d", u.User.ID, u.Button.CallbackData.DebtorId)
 		return
 	}
-	debtReturnedBtn := api.NewButton(debtReturned, &api.CallbackData{RoomId: roomId, UserId: lenderUserId, ExternalId: strconv.Itoa(debt.Sum)})
-	setSumBtn := api.NewButton(setDebtSum, &api.CallbackData{RoomId: roomId, UserId: lenderUserId})
+	debt, err := s.os.GetUserDebt(ctx, debtor.ID, lenderUserId, roomId)
+	if err != nil || debt == nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get user debts failed")
+		return failedResponse(u, err)
+	}
+	if debt.Unpaid() < 1 {
+		callback := createCallback(u, I18n(u.User, "msg_debt_waits_confirmation"), true)
+		return api.TelegramMessage{
+			CallbackConfig: callback,
+			Send:           true,
+		}
+	}
+	debtReturnedBtn := api.NewButton(debtReturned, &api.CallbackData{RoomId: roomId, UserId: lenderUserId, DebtorId: debtor.ID, ExternalId: strconv.Itoa(debt.Unpaid())})
+	setSumBtn := api.NewButton(setDebtSum, &api.CallbackData{RoomId: roomId, UserId: lenderUserId, DebtorId: debtor.ID})
 	cancelBtn := api.NewButton(viewRoom, &api.CallbackData{RoomId: roomId})
 	_, err = s.bs.SaveAll(ctx, debtReturnedBtn, setSumBtn, cancelBtn)
 	if err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 
 	text := I18n(u.User, "scrn_debt_repayment")
-	text += I18n(u.User, "scrn_debt_returning", userLink(debt.Lender), moneySpace(debt.Sum))
+	if debtor.IsVirtual {
+		text += I18n(u.User, "scrn_debt_returning_for", userLink(debtor))
+	}
+	text += I18n(u.User, "scrn_debt_returning", userLink(debt.Lender), moneySpace(debt.Unpaid()))
+	if debt.Pending > 0 {
+		text += I18n(u.User, "scrn_debt_pending", moneySpace(debt.Pending))
+	}
 
-	lender, err := s.us.FindById(ctx, debt.Lender.ID)
-	if err == nil && lender != nil && lender.BankDetails != "" {
-		text += I18n(u.User, "scrn_debt_returning_bank", lender.BankDetails)
+	var qrCodes []tgbotapi.Chattable
+	if lender := lenderPaymentDetails(ctx, s.us, u.User, debt); lender != nil {
+		text += I18n(u.User, "scrn_debt_returning_bank", paymentDetailsText(u, s.cfg, u.User, lender))
+		qrCodes = paymentQRCodes(ctx, u, s.cfg, lender, debt.Unpaid(), room.Currency, "Splitty: "+room.Name)
 	}
 	text += I18n(u.User, "scrn_send_message_choose_user")
 
 	msg := createScreen(u, text, &[][]tgbotapi.InlineKeyboardButton{
-		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_debt_sum_return", moneySpace(debt.Sum)), debtReturnedBtn.ID.Hex())},
+		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_debt_sum_return", moneySpace(debt.Unpaid())), debtReturnedBtn.ID.Hex())},
 		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_debt_custom_sum_return"), setSumBtn.ID.Hex())},
 		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), cancelBtn.ID.Hex())}})
-	return api.TelegramMessage{Chattable: []tgbotapi.Chattable{msg},
+	return api.TelegramMessage{Chattable: append([]tgbotapi.Chattable{msg}, qrCodes...),
 		Send: true,
 	}
 }
@@ -975,7 +1266,7 @@ func (s DebtReturned) OnMessage(ctx context.Context, u *api.Update) (response ap
 	u.ChatState = &api.ChatState{
 		UserId:       u.User.ID,
 		Action:       addRecipientOperation,
-		CallbackData: &api.CallbackData{UserId: u.Button.CallbackData.UserId, RoomId: u.Button.CallbackData.RoomId}}
+		CallbackData: &api.CallbackData{UserId: u.Button.CallbackData.UserId, RoomId: u.Button.CallbackData.RoomId, DebtorId: u.Button.CallbackData.DebtorId}}
 	u.Message = &api.Message{Text: u.Button.CallbackData.ExternalId, Chat: &api.Chat{Type: "private"}}
 	return api.TelegramMessage{
 		Send:     true,
@@ -1014,34 +1305,44 @@ func (s ChooseRecepientOperation) OnMessage(ctx context.Context, u *api.Update)
 	roomId := u.Button.CallbackData.RoomId
 	lenderUserId := u.Button.CallbackData.UserId
 
-	debt, err := s.os.GetUserDebt(ctx, u.User.ID, lenderUserId, roomId)
-	if err != nil || debt == nil {
-		log.Error().Err(err).Msg("get user debts failed")
+	room, err := s.rs.FindById(ctx, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
 		return
 	}
+	debtor := defineDebtor(room, u.User, u.Button.CallbackData.DebtorId)
+	if debtor == nil {
+		log.Ctx(ctx).Error().Msgf("user %d can not repay debt of %d", u.User.ID, u.Button.CallbackData.DebtorId)
+		return
+	}
+
+	debt, err := s.os.GetUserDebt(ctx, debtor.ID, lenderUserId, roomId)
+	if err != nil || debt == nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get user debts failed")
+		return failedResponse(u, err)
+	}
 
 	cs := &api.ChatState{UserId: int(getChatID(u)),
 		Action:       addRecipientOperation,
-		CallbackData: &api.CallbackData{RoomId: roomId, UserId: lenderUserId}}
+		CallbackData: &api.CallbackData{RoomId: roomId, UserId: lenderUserId, DebtorId: debtor.ID}}
 	err = s.css.Save(ctx, cs)
 	if err != nil {
-		log.Error().Err(err).Msg("create chat state failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create chat state failed")
 		return
 	}
 
 	b := api.NewButton(viewRoom, &api.CallbackData{RoomId: roomId})
 	_, err = s.bs.Save(ctx, b)
 	if err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 
 	text := I18n(u.User, "scrn_debt_repayment")
-	text += I18n(u.User, "scrn_debt_returning_operation", userLink(debt.Lender), moneySpace(debt.Sum))
+	text += I18n(u.User, "scrn_debt_returning_operation", userLink(debt.Lender), moneySpace(debt.Unpaid()))
 
-	lender, err := s.us.FindById(ctx, debt.Lender.ID)
-	if err == nil && lender != nil && lender.BankDetails != "" {
-		text += I18n(u.User, "scrn_debt_returning_bank", lender.BankDetails)
+	if bank := lenderBankDetails(ctx, s.us, s.cfg, u, u.User, debt); bank != "" {
+		text += I18n(u.User, "scrn_debt_returning_bank", bank)
 	}
 
 	text += I18n(u.User, "scrn_send_message_choose_user")
@@ -1087,32 +1388,36 @@ func (s AddRecepientOperation) HasReact(u *api.Update) bool {
 func (s AddRecepientOperation) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
 	room, err := s.rs.FindById(ctx, u.ChatState.CallbackData.RoomId)
 	if err != nil {
-		log.Error().Err(err).Msg("get room failed")
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
 		return
 	}
 
 	lenderUserId := u.ChatState.CallbackData.UserId
-	debt, err := s.os.GetUserDebt(ctx, u.User.ID, lenderUserId, room.ID.Hex())
-	if err != nil || debt == nil {
-		log.Error().Err(err).Msg("get user debts failed")
+	debtor := defineDebtor(room, u.User, u.ChatState.CallbackData.DebtorId)
+	if debtor == nil {
+		log.Ctx(ctx).Error().Msgf("user %d can not repay debt of %d", u.User.ID, u.ChatState.CallbackData.DebtorId)
 		return
 	}
+	debt, err := s.os.GetUserDebt(ctx, debtor.ID, lenderUserId, room.ID.Hex())
+	if err != nil || debt == nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get user debts failed")
+		return failedResponse(u, err)
+	}
 
 	rb := api.NewButton(viewRoom, &api.CallbackData{RoomId: u.ChatState.CallbackData.RoomId})
 	if _, err = s.bs.SaveAll(ctx, rb); err != nil {
-		log.Error().Err(err).Msg("save buttons failed")
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
 		return
 	}
 
 	sum, err := defineSum(u.Message.Text)
-	if err != nil || sum > debt.Sum {
-		log.Error().Err(err).Msgf("not parsed %v", u.Message.Text)
+	if err != nil || sum > debt.Unpaid() {
+		log.Ctx(ctx).Error().Err(err).Msgf("not parsed %v", u.Message.Text)
 		text := I18n(u.User, "msg_wrong_format")
-		text += I18n(u.User, "scrn_debt_returning_operation", userLink(debt.Lender), moneySpace(debt.Sum))
+		text += I18n(u.User, "scrn_debt_returning_operation", userLink(debt.Lender), moneySpace(debt.Unpaid()))
 
-		lender, err := s.us.FindById(ctx, debt.Debtor.ID)
-		if err == nil && lender != nil && lender.BankDetails != "" {
-			text += I18n(u.User, "scrn_debt_returning_bank", lender.BankDetails)
+		if bank := lenderBankDetails(ctx, s.us, s.cfg, u, u.User, debt); bank != "" {
+			text += I18n(u.User, "scrn_debt_returning_bank", bank)
 		}
 
 		text += I18n(u.User, "scrn_send_message_choose_user")
@@ -1124,36 +1429,60 @@ func (s AddRecepientOperation) OnMessage(ctx context.Context, u *api.Update) (re
 	}
 	defer s.css.CleanChatState(ctx, u.ChatState)
 
-	recipient, err := s.us.FindById(ctx, u.ChatState.CallbackData.UserId)
-	if err != nil {
-		log.Error().Err(err).Msgf("find user failed %v", u.ChatState.CallbackData.UserId)
-		return
+	recipient := room.FindMember(lenderUserId)
+	if recipient == nil || !recipient.IsVirtual {
+		recipient, err = s.us.FindById(ctx, lenderUserId)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("find user failed %v", lenderUserId)
+			return
+		}
 	}
 	donor := getFrom(u)
+	if debtor.IsVirtual {
+		donor = debtor
+	}
 	operation := &api.Operation{
 		ID:              primitive.NewObjectID(),
 		Sum:             sum,
 		Donor:           donor,
 		Recipients:      &[]api.User{*recipient},
 		IsDebtRepayment: true,
+		RepaymentStatus: repaymentStatus(recipient, u.User),
 		CreateAt:        time.Now(),
 	}
 	if err = s.os.UpsertOperation(ctx, operation, room.ID.Hex()); err != nil {
-		log.Error().Err(err).Msg("upsert operation failed")
+		log.Ctx(ctx).Error().Err(err).Msg("upsert operation failed")
 		return
 	}
 
-	//async calculate paidOfDebtsUserIds for room, after debt operation
-	go func() {
-		err := s.rss.DefinePaidOfDebtsUserIdsAndSave(ctx, room)
-		if err != nil {
-			log.Error().Err(err).Msg("")
+	keyboard := [][]tgbotapi.InlineKeyboardButton{{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_done"), rb.ID.Hex())}}
+	if operation.IsConfirmedRepayment() {
+		//async calculate paidOfDebtsUserIds for room, after debt operation
+		go func() {
+			err := s.rss.DefinePaidOfDebtsUserIdsAndSave(ctx, room)
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("")
+			}
+		}()
+		return api.TelegramMessage{
+			Chattable: []tgbotapi.Chattable{createScreen(u, I18n(u.User, "scrn_debt_returned_lender", userLink(recipient), moneySpace(sum)), &keyboard)},
+			Send:      true,
 		}
-	}()
+	}
 
-	keyboard := [][]tgbotapi.InlineKeyboardButton{{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_done"), rb.ID.Hex())}}
-	forDonorMsg := createScreen(u, I18n(u.User, "scrn_debt_returned_lender", userLink(recipient), moneySpace(sum)), &keyboard)
-	forRecipientMsg := NewMessage(int64(recipient.ID), I18n(u.User, "scrn_debt_returned_recepient", recipient.DisplayName, moneySpace(sum), userLink(donor)), keyboard)
+	lender, err := s.us.FindById(ctx, notifiedUserId(recipient))
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("find user failed %v", notifiedUserId(recipient))
+		return
+	}
+	reviewKeyboard, err := createReviewRepaymentKeyboard(ctx, s.bs, lender, room.ID.Hex(), operation.ID)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	forDonorMsg := createScreen(u, I18n(u.User, "scrn_debt_returned_pending", userLink(recipient), moneySpace(sum)), &keyboard)
+	forRecipientMsg := NewMessage(int64(lender.ID), I18n(lender, "scrn_debt_returned_recepient", recipient.DisplayName, moneySpace(sum), userLink(donor))+
+		I18n(lender, "scrn_confirm_repayment"), reviewKeyboard)
 
 	return api.TelegramMessage{
 		Chattable: []tgbotapi.Chattable{forDonorMsg, forRecipientMsg},
@@ -1227,7 +1556,7 @@ func (bot ViewAllOperations) OnMessage(ctx context.Context, u *api.Update) (resp
 	keyboard = append(keyboard, navRow)
 
 	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
-		log.Error().Err(err).Msg("save buttons failed")
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
 		return
 	}
 
@@ -1310,7 +1639,7 @@ func (bot ViewMyOperations) OnMessage(ctx context.Context, u *api.Update) (respo
 	keyboard = append(keyboard, navRow)
 
 	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
-		log.Error().Err(err).Msg("save buttons failed")
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
 		return
 	}
 
@@ -1393,7 +1722,7 @@ func (bot ViewOperationsWithMe) OnMessage(ctx context.Context, u *api.Update) (r
 	keyboard = append(keyboard, navRow)
 
 	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
-		log.Error().Err(err).Msg("save buttons failed")
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
 		return
 	}
 
@@ -1405,8 +1734,5 @@ func (bot ViewOperationsWithMe) OnMessage(ctx context.Context, u *api.Update) (r
 }
 
 func definePartSum(operation api.Operation, user *api.User) int {
-	if containsUserId(operation.Recipients, user.ID) {
-		return operation.Sum / len(*operation.Recipients)
-	}
-	return 0
+	return int(operation.Shares()[user.ID])
 }
@@ -0,0 +1,229 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+	"github.com/skip2/go-qrcode"
+)
+
+// PaymentMethods shows structured payment methods of the user, every method can be deleted
+type PaymentMethods struct {
+	bs  ButtonService
+	us  UserService
+	cfg *Config
+}
+
+func NewPaymentMethods(bs ButtonService, us UserService, cfg *Config) *PaymentMethods {
+	return &PaymentMethods{
+		bs:  bs,
+		us:  us,
+		cfg: cfg,
+	}
+}
+
+func (bot PaymentMethods) HasReact(u *api.Update) bool {
+	return isPrivate(u) && hasAction(u, paymentMethods)
+}
+
+func (bot *PaymentMethods) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	user, err := bot.us.FindById(ctx, u.User.ID)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("find user failed %v", u.User.ID)
+		return
+	}
+
+	text := I18n(u.User, "scrn_payment_methods")
+	var buttons []*api.Button
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for _, m := range user.Payments {
+		text += "\n" + paymentMethodText(u.User, m, false)
+		b := api.NewButton(deletePaymentMethod, &api.CallbackData{ExternalId: m.ID.Hex()})
+		buttons = append(buttons, b)
+		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_delete_payment_method", I18n(u.User, "payment_"+m.Kind), m.Currency), b.ID.Hex())})
+	}
+	if len(user.Payments) == 0 {
+		text += I18n(u.User, "scrn_payment_methods_empty")
+	}
+	addBtn := api.NewButton(wantAddPaymentMethod, new(api.CallbackData))
+	backBtn := api.NewButton(bankDetailsView, new(api.CallbackData))
+	if _, err := bot.bs.SaveAll(ctx, append(buttons, addBtn, backBtn)...); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	keyboard = append(keyboard,
+		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_add_payment_method"), addBtn.ID.Hex())},
+		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backBtn.ID.Hex())})
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, text, &keyboard)},
+		Send:      true,
+	}
+}
+
+// WantAddPaymentMethod asks the kind of the new payment method, then waits the method in the format of the kind,
+// the kind is in CallbackData.ExternalData
+type WantAddPaymentMethod struct {
+	bs  ButtonService
+	css ChatStateService
+	cfg *Config
+}
+
+func NewWantAddPaymentMethod(bs ButtonService, css ChatStateService, cfg *Config) *WantAddPaymentMethod {
+	return &WantAddPaymentMethod{
+		bs:  bs,
+		css: css,
+		cfg: cfg,
+	}
+}
+
+func (bot WantAddPaymentMethod) HasReact(u *api.Update) bool {
+	return isPrivate(u) && hasAction(u, wantAddPaymentMethod)
+}
+
+func (bot *WantAddPaymentMethod) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	backBtn := api.NewButton(paymentMethods, new(api.CallbackData))
+	kind := u.Button.CallbackData.ExternalData
+	if kind == "" {
+		buttons := []*api.Button{backBtn}
+		var keyboard [][]tgbotapi.InlineKeyboardButton
+		for _, k := range api.PaymentMethods {
+			b := api.NewButton(wantAddPaymentMethod, &api.CallbackData{ExternalData: k})
+			buttons = append(buttons, b)
+			keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "payment_"+k), b.ID.Hex())})
+		}
+		if _, err := bot.bs.SaveAll(ctx, buttons...); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+			return
+		}
+		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backBtn.ID.Hex())})
+		return api.TelegramMessage{
+			Chattable: []tgbotapi.Chattable{createScreen(u, I18n(u.User, "scrn_choose_payment_method"), &keyboard)},
+			Send:      true,
+		}
+	}
+
+	cs := &api.ChatState{UserId: int(getChatID(u)), Action: addPaymentMethod, CallbackData: &api.CallbackData{ExternalData: kind}}
+	if err := bot.css.Save(ctx, cs); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create chat state failed")
+		return
+	}
+	if _, err := bot.bs.SaveAll(ctx, backBtn); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	screen := createScreen(u, I18n(u.User, "scrn_add_payment_"+kind), &[][]tgbotapi.InlineKeyboardButton{
+		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), backBtn.ID.Hex())},
+	})
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{screen},
+		Send:      true,
+	}
+}
+
+// AddPaymentMethod saves the payment method entered by the user, the chat state is kept until the method is valid
+type AddPaymentMethod struct {
+	bs  ButtonService
+	us  UserService
+	css ChatStateService
+	cfg *Config
+}
+
+func NewAddPaymentMethod(bs ButtonService, us UserService, css ChatStateService, cfg *Config) *AddPaymentMethod {
+	return &AddPaymentMethod{
+		bs:  bs,
+		us:  us,
+		css: css,
+		cfg: cfg,
+	}
+}
+
+func (bot AddPaymentMethod) HasReact(u *api.Update) bool {
+	return hasAction(u, addPaymentMethod) && hasMessage(u)
+}
+
+func (bot *AddPaymentMethod) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	kind := u.ChatState.CallbackData.ExternalData
+	m, err := bot.us.AddPaymentMethod(ctx, u.User.ID, kind, u.Message.Text)
+	if errors.Is(err, api.ErrInvalidPaymentMethod) {
+		log.Ctx(ctx).Info().Err(err).Msg("not parsed payment method")
+		backBtn := api.NewButton(paymentMethods, new(api.CallbackData))
+		if _, err := bot.bs.SaveAll(ctx, backBtn); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+			return
+		}
+		return api.TelegramMessage{
+			Chattable: []tgbotapi.Chattable{NewMessage(getChatID(u), I18n(u.User, "msg_wrong_format")+I18n(u.User, "scrn_add_payment_"+kind),
+				[][]tgbotapi.InlineKeyboardButton{{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), backBtn.ID.Hex())}})},
+			Send: true,
+		}
+	}
+	defer bot.css.CleanChatState(ctx, u.ChatState)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("add payment method failed")
+		return
+	}
+	log.Ctx(ctx).Info().Msgf("payment method %s is added by user %d", m.ID.Hex(), u.User.ID)
+
+	u.Button = api.NewButton(paymentMethods, new(api.CallbackData))
+	u.ChatState = nil
+	return api.TelegramMessage{
+		Send:     true,
+		Redirect: u,
+	}
+}
+
+// DeletePaymentMethod deletes the payment method, its id is in CallbackData.ExternalId
+type DeletePaymentMethod struct {
+	us  UserService
+	cfg *Config
+}
+
+func NewDeletePaymentMethod(us UserService, cfg *Config) *DeletePaymentMethod {
+	return &DeletePaymentMethod{
+		us:  us,
+		cfg: cfg,
+	}
+}
+
+func (bot DeletePaymentMethod) HasReact(u *api.Update) bool {
+	return isPrivate(u) && hasAction(u, deletePaymentMethod)
+}
+
+func (bot *DeletePaymentMethod) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	if err := bot.us.DeletePaymentMethod(ctx, u.User.ID, u.Button.CallbackData.ExternalId); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("delete payment method failed")
+		return
+	}
+	u.Button = api.NewButton(paymentMethods, new(api.CallbackData))
+	return api.TelegramMessage{
+		CallbackConfig: createCallback(u, I18n(u.User, "msg_payment_method_deleted"), false),
+		Redirect:       u,
+		Send:           true,
+	}
+}
+
+// paymentQRCodes returns images of payment QR codes of the lender for the sum in the currency of the room,
+// codes contain full accounts, so they are sent only where bank details are shown unmasked
+func paymentQRCodes(ctx context.Context, u *api.Update, cfg *Config, lender *api.User, sum int, currency string, reference string) []tgbotapi.Chattable {
+	if !showFullDetails(u, cfg) {
+		return nil
+	}
+	var images []tgbotapi.Chattable
+	for _, m := range lender.Payments {
+		payload, ok := m.QRPayload(lender.DisplayName, sum, currency, reference)
+		if !ok {
+			continue
+		}
+		png, err := qrcode.Encode(payload, qrcode.Medium, 512)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("cannot encode QR code of payment method %s", m.ID.Hex())
+			continue
+		}
+		caption := I18n(u.User, "msg_payment_qr", I18n(u.User, "payment_"+m.Kind), m.Currency, moneySpace(sum))
+		images = append(images, NewPhotoUpload(getChatID(u), caption, "payment-"+m.ID.Hex()+".png", png))
+	}
+	return images
+}
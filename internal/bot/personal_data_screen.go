@@ -0,0 +1,244 @@
+package bot
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"time"
+)
+
+// formats of the exported personal data, the format is in CallbackData.ExternalData
+const (
+	exportJson = "json"
+	exportZip  = "zip"
+)
+
+// deleteConfirmed in CallbackData.ExternalData means that the user agreed to delete the account with outstanding debts
+const deleteConfirmed = "confirmed"
+
+// PersonalData shows actions with personal data of the user: export and deletion of the account
+type PersonalData struct {
+	bs  ButtonService
+	cfg *Config
+}
+
+func NewPersonalData(bs ButtonService, cfg *Config) *PersonalData {
+	return &PersonalData{
+		bs:  bs,
+		cfg: cfg,
+	}
+}
+
+func (bot PersonalData) HasReact(u *api.Update) bool {
+	return isPrivate(u) && hasAction(u, personalData)
+}
+
+func (bot *PersonalData) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	jsonBtn := api.NewButton(exportPersonalData, &api.CallbackData{ExternalData: exportJson})
+	zipBtn := api.NewButton(exportPersonalData, &api.CallbackData{ExternalData: exportZip})
+	deleteBtn := api.NewButton(wantDeleteAccount, new(api.CallbackData))
+	backBtn := api.NewButton(userSetting, new(api.CallbackData))
+	if _, err := bot.bs.SaveAll(ctx, jsonBtn, zipBtn, deleteBtn, backBtn); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	screen := createScreen(u, I18n(u.User, "scrn_personal_data"), &[][]tgbotapi.InlineKeyboardButton{
+		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_export_json"), jsonBtn.ID.Hex()),
+			tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_export_zip"), zipBtn.ID.Hex())},
+		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_delete_account"), deleteBtn.ID.Hex())},
+		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backBtn.ID.Hex())},
+	})
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{screen},
+		Send:      true,
+	}
+}
+
+// ExportPersonalData sends the profile, bank details, rooms and operations of the user as the file
+type ExportPersonalData struct {
+	us  UserService
+	cfg *Config
+}
+
+func NewExportPersonalData(us UserService, cfg *Config) *ExportPersonalData {
+	return &ExportPersonalData{
+		us:  us,
+		cfg: cfg,
+	}
+}
+
+func (bot ExportPersonalData) HasReact(u *api.Update) bool {
+	return isPrivate(u) && hasAction(u, exportPersonalData)
+}
+
+func (bot *ExportPersonalData) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	export, err := bot.us.ExportUserData(ctx, u.User.ID, time.Now())
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("export personal data failed")
+		return failedResponse(u, err)
+	}
+
+	name := "splitty-" + strconv.Itoa(u.User.ID)
+	var data []byte
+	switch u.Button.CallbackData.ExternalData {
+	case exportJson:
+		name += ".json"
+		data, err = json.MarshalIndent(export, "", "  ")
+	case exportZip:
+		name += ".zip"
+		data, err = exportArchive(export)
+	default:
+		log.Ctx(ctx).Error().Msgf("unknown export format %v", u.Button.CallbackData.ExternalData)
+		return
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("encode personal data failed")
+		return
+	}
+	log.Ctx(ctx).Info().Msgf("personal data of user %d is exported, rooms: %d", u.User.ID, len(export.Rooms))
+	return api.TelegramMessage{
+		Chattable:      []tgbotapi.Chattable{NewDocumentUpload(getChatID(u), I18n(u.User, "msg_personal_data_exported"), name, data)},
+		CallbackConfig: createCallback(u, "", false),
+		Send:           true,
+	}
+}
+
+// exportArchive writes the profile and every room to separate json files of the zip archive
+func exportArchive(export *api.UserExport) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	profile := *export
+	profile.Rooms = nil
+	if err := writeJson(w, "profile.json", profile); err != nil {
+		return nil, err
+	}
+	for _, r := range export.Rooms {
+		if err := writeJson(w, "rooms/"+r.ID+".json", r); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJson(w *zip.Writer, name string, v interface{}) error {
+	f, err := w.Create(name)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	return err
+}
+
+// WantDeleteAccount asks to confirm deletion of the account, outstanding debts of the user are listed and
+// the deletion needs the explicit agreement to leave them
+type WantDeleteAccount struct {
+	bs  ButtonService
+	us  UserService
+	cfg *Config
+}
+
+func NewWantDeleteAccount(bs ButtonService, us UserService, cfg *Config) *WantDeleteAccount {
+	return &WantDeleteAccount{
+		bs:  bs,
+		us:  us,
+		cfg: cfg,
+	}
+}
+
+func (bot WantDeleteAccount) HasReact(u *api.Update) bool {
+	return isPrivate(u) && hasAction(u, wantDeleteAccount)
+}
+
+func (bot *WantDeleteAccount) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	debts, err := bot.us.OutstandingDebts(ctx, u.User.ID)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("find outstanding debts failed")
+		return failedResponse(u, err)
+	}
+
+	text := I18n(u.User, "scrn_delete_account")
+	deleteBtn := api.NewButton(deleteAccount, new(api.CallbackData))
+	btnText := I18n(u.User, "btn_delete_account_confirm")
+	if len(debts) > 0 {
+		text += "\n\n" + I18n(u.User, "scrn_delete_account_debts")
+		for _, d := range debts {
+			text += "*" + d.RoomName + "*: " + debtLine(&d.Debt)
+		}
+		deleteBtn.CallbackData.ExternalData = deleteConfirmed
+		btnText = I18n(u.User, "btn_delete_account_anyway")
+	}
+	backBtn := api.NewButton(personalData, new(api.CallbackData))
+	if _, err := bot.bs.SaveAll(ctx, deleteBtn, backBtn); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	screen := createScreen(u, text, &[][]tgbotapi.InlineKeyboardButton{
+		{tgbotapi.NewInlineKeyboardButtonData(btnText, deleteBtn.ID.Hex())},
+		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), backBtn.ID.Hex())},
+	})
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{screen},
+		Send:      true,
+	}
+}
+
+// DeleteAccount replaces the user by anonymous members in all rooms and deletes his profile and chat state
+type DeleteAccount struct {
+	us  UserService
+	css ChatStateService
+	cfg *Config
+}
+
+func NewDeleteAccount(us UserService, css ChatStateService, cfg *Config) *DeleteAccount {
+	return &DeleteAccount{
+		us:  us,
+		css: css,
+		cfg: cfg,
+	}
+}
+
+func (bot DeleteAccount) HasReact(u *api.Update) bool {
+	return isPrivate(u) && hasAction(u, deleteAccount)
+}
+
+func (bot *DeleteAccount) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	debts, err := bot.us.OutstandingDebts(ctx, u.User.ID)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("find outstanding debts failed")
+		return failedResponse(u, err)
+	}
+	// debts could appear after the confirmation, the user has to see them
+	if len(debts) > 0 && u.Button.CallbackData.ExternalData != deleteConfirmed {
+		u.Button = api.NewButton(wantDeleteAccount, new(api.CallbackData))
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_delete_account_debts"), true),
+			Redirect:       u,
+			Send:           true,
+		}
+	}
+
+	if err := bot.us.DeleteUser(ctx, u.User.ID); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("delete user %d failed", u.User.ID)
+		return
+	}
+	if err := bot.css.DeleteByUserId(ctx, u.User.ID); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("delete chat state failed")
+	}
+	log.Ctx(ctx).Info().Msgf("user %d is deleted, outstanding debts: %d", u.User.ID, len(debts))
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, I18n(u.User, "msg_account_deleted"), &[][]tgbotapi.InlineKeyboardButton{})},
+		Send:      true,
+	}
+}
@@ -0,0 +1,338 @@
+package bot
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"time"
+)
+
+// reminderNudgePause is the minimal pause between reminders sent to the debtor by lenders
+const reminderNudgePause = time.Hour
+
+// reminderUrgentLevel is the count of sent reminders after which the debtor is reminded in the linked group chat too
+const reminderUrgentLevel = 2
+
+var reminderIntervals = []int{1, 3, 7, api.ReminderOff}
+
+// Reminder is the reminder due to the debtor in the room. It is saved as sent by DebtReminder.Sent only after
+// its messages are enqueued, so the reminder, which could not be sent, is due again at the next check
+type Reminder struct {
+	RoomId   string
+	Sent     api.ReminderSent
+	Messages []tgbotapi.Chattable
+}
+
+// DebtReminder sends scheduled reminders to debtors, it is not a bot
+type DebtReminder struct {
+	bs  ButtonService
+	rs  RoomService
+	os  OperationService
+	us  UserService
+	cfg *Config
+}
+
+func NewDebtReminder(bs ButtonService, rs RoomService, os OperationService, us UserService, cfg *Config) *DebtReminder {
+	return &DebtReminder{
+		bs:  bs,
+		rs:  rs,
+		os:  os,
+		us:  us,
+		cfg: cfg,
+	}
+}
+
+// Due returns reminders which should be sent at the moment, every next reminder to the same debtor is more insistent.
+// Reminders of debtors, who have repaid their debts, are forgotten, so the next debt is reminded from the beginning
+func (r *DebtReminder) Due(ctx context.Context, now time.Time) ([]Reminder, error) {
+	if r.cfg.IsQuietTime(now) {
+		return nil, nil
+	}
+	rooms, err := r.rs.FindRoomsDistributingDebts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reminders []Reminder
+	for i := range *rooms {
+		room := &(*rooms)[i]
+		interval := room.Reminder.IntervalDays
+		if interval == 0 {
+			interval = r.cfg.ReminderIntervalDays
+		}
+		if interval == api.ReminderOff || room.CountRealMembers() != len(room.RoomStates.FinishedAddOperation) {
+			continue
+		}
+		debts, err := r.os.GetAllDebts(ctx, room.ID.Hex())
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("get debts failed, room:%s", room.ID.Hex())
+			continue
+		}
+		debtors := debtsByNotifiedDebtor(debts)
+		for _, sent := range room.Reminder.Sent {
+			if _, ok := debtors[sent.UserId]; !ok {
+				if err := r.rs.DeleteReminderSent(ctx, room.ID.Hex(), sent.UserId); err != nil {
+					log.Ctx(ctx).Error().Err(err).Msg("delete reminder failed")
+				}
+			}
+		}
+		for userId, userDebts := range debtors {
+			sent := room.Reminder.FindReminderSent(userId)
+			if sent == nil {
+				// all members have been notified when debts appeared, the schedule starts from now
+				reminders = append(reminders, Reminder{RoomId: room.ID.Hex(), Sent: api.ReminderSent{UserId: userId, SentAt: now}})
+				continue
+			}
+			if now.Sub(sent.SentAt) < time.Duration(interval)*24*time.Hour {
+				continue
+			}
+			user, err := r.us.FindById(ctx, userId)
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Msgf("find user failed %v", userId)
+				continue
+			}
+			if !*user.NotificationOn {
+				continue
+			}
+
+			header := I18n(user, "scrn_reminder", room.Name)
+			if sent.Count >= reminderUrgentLevel {
+				header = I18n(user, "scrn_reminder_urgent", room.Name)
+			} else if sent.Count > 0 {
+				header = I18n(user, "scrn_reminder_second", room.Name)
+			}
+			msg, err := createReminderMessage(ctx, r.bs, r.us, room, user, header, userDebts)
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("create reminder failed")
+				continue
+			}
+			reminder := Reminder{
+				RoomId:   room.ID.Hex(),
+				Sent:     api.ReminderSent{UserId: userId, Count: sent.Count + 1, SentAt: now},
+				Messages: []tgbotapi.Chattable{msg},
+			}
+			if sent.Count >= reminderUrgentLevel && room.Chat.ID != 0 {
+				text := I18n(user, "scrn_reminder_group", userLink(user))
+				for _, d := range userDebts {
+					text += debtLine(&d)
+				}
+				reminder.Messages = append(reminder.Messages, NewMessage(room.Chat.ID, text, nil))
+			}
+			reminders = append(reminders, reminder)
+		}
+	}
+	return reminders, nil
+}
+
+// Sent saves the reminder as sent, it is called after messages of the reminder are enqueued
+func (r *DebtReminder) Sent(ctx context.Context, reminder Reminder) error {
+	return r.rs.SetReminderSent(ctx, reminder.RoomId, reminder.Sent)
+}
+
+// NudgeDebtor lender reminds the debtor about the debt from the debt list
+type NudgeDebtor struct {
+	bs  ButtonService
+	rs  RoomService
+	os  OperationService
+	us  UserService
+	cfg *Config
+}
+
+func NewNudgeDebtor(bs ButtonService, rs RoomService, os OperationService, us UserService, cfg *Config) *NudgeDebtor {
+	return &NudgeDebtor{
+		bs:  bs,
+		rs:  rs,
+		os:  os,
+		us:  us,
+		cfg: cfg,
+	}
+}
+
+func (bot NudgeDebtor) HasReact(u *api.Update) bool {
+	return hasAction(u, nudgeDebtor)
+}
+
+func (bot *NudgeDebtor) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
+	lender, debtor := room.FindMember(data.UserId), room.FindMember(data.DebtorId)
+	if lender == nil || debtor == nil || notifiedUserId(lender) != u.User.ID {
+		log.Ctx(ctx).Error().Msgf("user %d can not nudge debtor %d", u.User.ID, data.DebtorId)
+		return
+	}
+	debt, err := bot.os.GetUserDebt(ctx, debtor.ID, lender.ID, data.RoomId)
+	if err != nil || debt == nil || debt.Unpaid() < 1 {
+		log.Ctx(ctx).Error().Err(err).Msg("get user debt failed")
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_have_not_debts"), true),
+			Send:           true,
+		}
+	}
+
+	userId := notifiedUserId(debtor)
+	sent := room.Reminder.FindReminderSent(userId)
+	if sent != nil && time.Since(sent.SentAt) < reminderNudgePause {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_reminder_recently_sent"), true),
+			Send:           true,
+		}
+	}
+	user, err := bot.us.FindById(ctx, userId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("find user failed %v", userId)
+		return
+	}
+	if !*user.NotificationOn {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_reminder_notifications_off"), true),
+			Send:           true,
+		}
+	}
+
+	msg, err := createReminderMessage(ctx, bot.bs, bot.us, room, user, I18n(user, "scrn_reminder_nudge", userLink(lender), room.Name), []api.Debt{*debt})
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create reminder failed")
+		return
+	}
+	// manual reminder postpones the scheduled one, but does not make it more insistent
+	reminder := api.ReminderSent{UserId: userId, SentAt: time.Now()}
+	if sent != nil {
+		reminder.Count = sent.Count
+	}
+	if err := bot.rs.SetReminderSent(ctx, data.RoomId, reminder); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("save reminder failed")
+	}
+	return api.TelegramMessage{
+		Chattable:      []tgbotapi.Chattable{msg},
+		CallbackConfig: createCallback(u, I18n(u.User, "msg_reminder_sent"), false),
+		Send:           true,
+	}
+}
+
+// ReminderSetting screen with the interval of reminders in the room, the chosen interval is in CallbackData.ExternalData
+type ReminderSetting struct {
+	bs  ButtonService
+	rs  RoomService
+	cfg *Config
+}
+
+func NewReminderSetting(bs ButtonService, rs RoomService, cfg *Config) *ReminderSetting {
+	return &ReminderSetting{
+		bs:  bs,
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot ReminderSetting) HasReact(u *api.Update) bool {
+	return hasAction(u, reminderSetting)
+}
+
+func (bot *ReminderSetting) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	if data.ExternalData != "" {
+		days, err := strconv.Atoi(data.ExternalData)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("wrong reminder interval %v", data.ExternalData)
+			return
+		}
+		if err := bot.rs.SetReminderInterval(ctx, data.RoomId, days); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("set reminder interval failed")
+			return
+		}
+	}
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
+	current := room.Reminder.IntervalDays
+	if current == 0 {
+		current = bot.cfg.ReminderIntervalDays
+	}
+
+	var toSave []*api.Button
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, days := range reminderIntervals {
+		b := api.NewButton(reminderSetting, &api.CallbackData{RoomId: data.RoomId, ExternalData: strconv.Itoa(days)})
+		toSave = append(toSave, b)
+		text := reminderIntervalText(u.User, days)
+		if days == current {
+			text = "✅ " + text
+		}
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(text, b.ID.Hex()))
+	}
+	backB := api.NewButton(roomSetting, &api.CallbackData{RoomId: data.RoomId})
+	toSave = append(toSave, backB)
+	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+
+	keyboard := splitKeyboardButtons(buttons, 2)
+	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backB.ID.Hex())})
+	text := I18n(u.User, "scrn_reminder_setting", reminderIntervalText(u.User, current), bot.cfg.QuietHoursFrom, bot.cfg.QuietHoursTo)
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, text, &keyboard)},
+		Send:      true,
+	}
+}
+
+// createReminderMessage returns reminder with one tap repay buttons and bank details of lenders
+func createReminderMessage(ctx context.Context, bs ButtonService, us UserService, room *api.Room, user *api.User, header string, debts []api.Debt) (tgbotapi.Chattable, error) {
+	text := header
+	var toSave []*api.Button
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for _, d := range debts {
+		text += debtLine(&d)
+		if bank := lenderBankDetails(ctx, us, nil, nil, user, &d); bank != "" {
+			text += I18n(user, "scrn_reminder_bank", bank)
+		}
+		b := api.NewButton(wantReturnDebt, &api.CallbackData{RoomId: room.ID.Hex(), UserId: d.Lender.ID, DebtorId: d.Debtor.ID})
+		toSave = append(toSave, b)
+		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(I18n(user, "btn_repay_debt", moneySpace(d.Unpaid()), shortName(d.Lender)), b.ID.Hex()),
+		})
+	}
+	if _, err := bs.SaveAll(ctx, toSave...); err != nil {
+		return nil, err
+	}
+	return NewMessage(int64(user.ID), text, keyboard), nil
+}
+
+// debtsByNotifiedDebtor groups unpaid debts by users who receive reminders, debts of virtual members go to their managers
+func debtsByNotifiedDebtor(debts []api.Debt) map[int][]api.Debt {
+	result := map[int][]api.Debt{}
+	for _, d := range debts {
+		if d.Unpaid() < 1 {
+			continue
+		}
+		id := notifiedUserId(d.Debtor)
+		result[id] = append(result[id], d)
+	}
+	return result
+}
+
+// isQuietHour checks that the hour is in quiet hours, the range may go over midnight, example = from 22 to 9
+func isQuietHour(hour int, from int, to int) bool {
+	if from == to {
+		return false
+	}
+	if from < to {
+		return hour >= from && hour < to
+	}
+	return hour >= from || hour < to
+}
+
+func reminderIntervalText(user *api.User, days int) string {
+	if days == api.ReminderOff {
+		return I18n(user, "btn_reminder_off")
+	}
+	return I18n(user, "btn_reminder_days", days)
+}
@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/almaznur91/splitty/internal/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsQuietHour(t *testing.T) {
+	tests := []struct {
+		hour, from, to int
+		quiet          bool
+	}{
+		{hour: 23, from: 22, to: 9, quiet: true},
+		{hour: 0, from: 22, to: 9, quiet: true},
+		{hour: 8, from: 22, to: 9, quiet: true},
+		{hour: 9, from: 22, to: 9, quiet: false},
+		{hour: 21, from: 22, to: 9, quiet: false},
+		{hour: 13, from: 13, to: 15, quiet: true},
+		{hour: 15, from: 13, to: 15, quiet: false},
+		{hour: 12, from: 13, to: 15, quiet: false},
+		{hour: 10, from: 10, to: 10, quiet: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.quiet, isQuietHour(tt.hour, tt.from, tt.to), "%d in %d-%d", tt.hour, tt.from, tt.to)
+	}
+}
+
+func TestIsQuietTime(t *testing.T) {
+	now := time.Date(2021, 3, 1, 20, 0, 0, 0, time.UTC)
+	cfg := &Config{QuietHoursFrom: 22, QuietHoursTo: 9}
+	assert.False(t, cfg.IsQuietTime(now), "utc is used without the location")
+
+	cfg.QuietHoursLocation = time.FixedZone("MSK", 3*60*60)
+	assert.True(t, cfg.IsQuietTime(now), "it is 23 o'clock in the location")
+	assert.False(t, cfg.IsQuietTime(now.Add(-2*time.Hour)))
+}
+
+func TestDebtsByNotifiedDebtor(t *testing.T) {
+	lender := &api.User{ID: 1}
+	debtor := &api.User{ID: 2}
+	virtual := &api.User{ID: -3, IsVirtual: true, ManagerId: 2}
+	other := &api.User{ID: 4}
+
+	debts := []api.Debt{
+		{Lender: lender, Debtor: debtor, Sum: 100},
+		{Lender: lender, Debtor: virtual, Sum: 50, Pending: 20},
+		{Lender: lender, Debtor: other, Sum: 70, Pending: 70},
+		{Lender: lender, Debtor: other, Sum: 0},
+	}
+	result := debtsByNotifiedDebtor(debts)
+
+	assert.Len(t, result, 1, "repaid debts aren't reminded")
+	assert.Equal(t, []api.Debt{debts[0], debts[1]}, result[2], "debts of the virtual member are reminded to the manager")
+	assert.Empty(t, debtsByNotifiedDebtor(nil))
+}
@@ -0,0 +1,226 @@
+package bot
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReviewRepayment lender confirms or disputes the debt repayment from the notification
+type ReviewRepayment struct {
+	bs  ButtonService
+	os  OperationService
+	rs  RoomService
+	rss RoomStateService
+	us  UserService
+	cfg *Config
+}
+
+func NewReviewRepayment(bs ButtonService, os OperationService, rs RoomService, rss RoomStateService, us UserService, cfg *Config) *ReviewRepayment {
+	return &ReviewRepayment{
+		bs:  bs,
+		os:  os,
+		rs:  rs,
+		rss: rss,
+		us:  us,
+		cfg: cfg,
+	}
+}
+
+func (bot ReviewRepayment) HasReact(u *api.Update) bool {
+	return hasAction(u, confirmRepayment) || hasAction(u, disputeRepayment)
+}
+
+func (bot *ReviewRepayment) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	room, err := bot.rs.FindById(ctx, data.RoomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
+	operation := findOperation(room, data.OperationId)
+	if operation == nil || !operation.IsDebtRepayment {
+		log.Ctx(ctx).Error().Msgf("repayment not found, id:%s", data.OperationId.Hex())
+		return
+	}
+	lender := &(*operation.Recipients)[0]
+	if notifiedUserId(lender) != u.User.ID {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_only_lender_can_review"), true),
+			Send:           true,
+		}
+	}
+	if !operation.IsPendingRepayment() {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_repayment_already_reviewed"), true),
+			Send:           true,
+		}
+	}
+
+	confirmed := hasAction(u, confirmRepayment)
+	operation.RepaymentStatus = api.RepaymentDisputed
+	if confirmed {
+		operation.RepaymentStatus = api.RepaymentConfirmed
+	}
+	if err := bot.os.UpsertOperation(ctx, operation, data.RoomId); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("upsert operation failed")
+		return
+	}
+	if confirmed {
+		//async calculate paidOfDebtsUserIds for room, after debt operation is confirmed
+		go func() {
+			err := bot.rss.DefinePaidOfDebtsUserIdsAndSave(ctx, room)
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("")
+			}
+		}()
+	}
+
+	lenderText, debtorText := "scrn_repayment_disputed", "scrn_repayment_disputed_debtor"
+	if confirmed {
+		lenderText, debtorText = "scrn_repayment_confirmed", "scrn_repayment_confirmed_debtor"
+	}
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	var toSave []*api.Button
+	if isPrivate(u) {
+		roomB := api.NewButton(viewRoom, &api.CallbackData{RoomId: data.RoomId})
+		toSave = append(toSave, roomB)
+		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_view_room"), roomB.ID.Hex())})
+	}
+	messages := []tgbotapi.Chattable{createScreen(u, I18n(u.User, lenderText, userLink(operation.Donor), moneySpace(operation.Sum)), &keyboard)}
+
+	debtor, err := bot.us.FindById(ctx, notifiedUserId(operation.Donor))
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("find user failed %v", notifiedUserId(operation.Donor))
+	} else {
+		debtorRoomB := api.NewButton(viewRoom, &api.CallbackData{RoomId: data.RoomId})
+		toSave = append(toSave, debtorRoomB)
+		messages = append(messages, NewMessage(int64(debtor.ID), I18n(debtor, debtorText, userLink(lender), moneySpace(operation.Sum), room.Name),
+			[][]tgbotapi.InlineKeyboardButton{{tgbotapi.NewInlineKeyboardButtonData(I18n(debtor, "btn_view_room"), debtorRoomB.ID.Hex())}}))
+	}
+	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	return api.TelegramMessage{
+		Chattable: messages,
+		Send:      true,
+	}
+}
+
+// ReviewSettlement counterparty confirms or disputes all repayments of the settled balance at once,
+// the settlement id is in CallbackData.OperationId, the reviewer in CallbackData.UserId and the payer in CallbackData.DebtorId
+type ReviewSettlement struct {
+	os  OperationService
+	rs  RoomService
+	rss RoomStateService
+	us  UserService
+	cfg *Config
+}
+
+func NewReviewSettlement(os OperationService, rs RoomService, rss RoomStateService, us UserService, cfg *Config) *ReviewSettlement {
+	return &ReviewSettlement{
+		os:  os,
+		rs:  rs,
+		rss: rss,
+		us:  us,
+		cfg: cfg,
+	}
+}
+
+func (bot ReviewSettlement) HasReact(u *api.Update) bool {
+	return hasAction(u, confirmSettlement) || hasAction(u, disputeSettlement)
+}
+
+func (bot *ReviewSettlement) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	data := u.Button.CallbackData
+	if data.UserId != u.User.ID {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_only_lender_can_review"), true),
+			Send:           true,
+		}
+	}
+	confirmed := hasAction(u, confirmSettlement)
+	status := api.RepaymentDisputed
+	if confirmed {
+		status = api.RepaymentConfirmed
+	}
+	roomIds, err := bot.os.ReviewSettlement(ctx, data.OperationId, status)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("review settlement %s failed", data.OperationId.Hex())
+		return
+	}
+	if len(roomIds) == 0 {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_repayment_already_reviewed"), true),
+			Send:           true,
+		}
+	}
+	if confirmed {
+		//async calculate paidOfDebtsUserIds for rooms, after repayments are confirmed
+		go func() {
+			for _, id := range roomIds {
+				room, err := bot.rs.FindById(ctx, id)
+				if err != nil {
+					log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+					continue
+				}
+				if err := bot.rss.DefinePaidOfDebtsUserIdsAndSave(ctx, room); err != nil {
+					log.Ctx(ctx).Error().Err(err).Msg("")
+				}
+			}
+		}()
+	}
+
+	lenderText, debtorText := "scrn_settlement_disputed", "scrn_settlement_disputed_debtor"
+	if confirmed {
+		lenderText, debtorText = "scrn_settlement_confirmed", "scrn_settlement_confirmed_debtor"
+	}
+	debtor, err := bot.us.FindById(ctx, data.DebtorId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("find user failed %v", data.DebtorId)
+		return
+	}
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{
+			createScreen(u, I18n(u.User, lenderText, userLink(debtor), moneySpace(data.Sum)), &[][]tgbotapi.InlineKeyboardButton{}),
+			NewMessage(int64(debtor.ID), I18n(debtor, debtorText, userLink(u.User), moneySpace(data.Sum)), nil),
+		},
+		Send: true,
+	}
+}
+
+// repaymentStatus returns status of the new repayment, it is confirmed at once when the lender records it himself
+func repaymentStatus(lender *api.User, user *api.User) api.RepaymentStatus {
+	if notifiedUserId(lender) == user.ID {
+		return api.RepaymentConfirmed
+	}
+	return api.RepaymentPending
+}
+
+func repaymentStatusSmile(o *api.Operation) string {
+	switch o.RepaymentStatus {
+	case api.RepaymentPending:
+		return " ⏳"
+	case api.RepaymentDisputed:
+		return " ❌"
+	default:
+		return ""
+	}
+}
+
+// createReviewRepaymentKeyboard returns buttons confirming or disputing the repayment
+func createReviewRepaymentKeyboard(ctx context.Context, bs ButtonService, lender *api.User, roomId string, operationId primitive.ObjectID) ([][]tgbotapi.InlineKeyboardButton, error) {
+	data := &api.CallbackData{RoomId: roomId, OperationId: operationId}
+	confirmB := api.NewButton(confirmRepayment, data)
+	disputeB := api.NewButton(disputeRepayment, data)
+	if _, err := bs.SaveAll(ctx, confirmB, disputeB); err != nil {
+		return nil, err
+	}
+	return [][]tgbotapi.InlineKeyboardButton{{
+		tgbotapi.NewInlineKeyboardButtonData(I18n(lender, "btn_confirm_repayment"), confirmB.ID.Hex()),
+		tgbotapi.NewInlineKeyboardButtonData(I18n(lender, "btn_dispute_repayment"), disputeB.ID.Hex()),
+	}}, nil
+}
@@ -40,14 +40,14 @@ func (s RoomCreating) OnMessage(ctx context.Context, u *api.Update) (response ap
 	cs := &api.ChatState{UserId: int(getChatID(u)), Action: createRoom}
 	err := s.css.Save(ctx, cs)
 	if err != nil {
-		log.Error().Err(err).Msg("create chat state failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create chat state failed")
 		return
 	}
 
 	b := api.NewButton(viewStart, nil)
 	id, err := s.bs.Save(ctx, b)
 	if err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 	screen := createScreen(u, I18n(u.User, "scrn_write_room_name"),
@@ -98,7 +98,7 @@ func (rs RoomSetName) OnMessage(ctx context.Context, u *api.Update) (response ap
 
 	room, err := rs.rs.CreateRoom(ctx, r)
 	if err != nil {
-		log.Error().Err(err).Msg("crete room failed")
+		log.Ctx(ctx).Error().Err(err).Msg("crete room failed")
 		return api.TelegramMessage{}
 	}
 
@@ -110,7 +110,7 @@ func (rs RoomSetName) OnMessage(ctx context.Context, u *api.Update) (response ap
 	cb := api.NewButton(viewStart, nil)
 	cancelId, err := rs.bs.Save(ctx, cb)
 	if err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 	cancelBtn := []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), cancelId.Hex())}
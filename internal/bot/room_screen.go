@@ -38,20 +38,34 @@ func (bot JoinRoom) HasReact(u *api.Update) bool {
 func (bot JoinRoom) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
 	roomId := u.Button.CallbackData.RoomId
 
-	err := bot.rs.JoinToRoom(ctx, u.CallbackQuery.From, roomId)
+	room, err := bot.rs.FindById(ctx, roomId)
 	if err != nil {
-		log.Error().Err(err).Msgf("join room failed %v", roomId)
+		log.Ctx(ctx).Error().Err(err).Msgf("get room failed %v", roomId)
 		return
 	}
+	isNewMember := !containsUserId(room.Members, u.CallbackQuery.From.ID)
 
-	room, err := bot.rs.FindById(ctx, roomId)
+	// room shared by inline query is edited together with the room summary
+	if isInline(u) {
+		if err := bot.rs.AddSummaryInlineMessage(ctx, roomId, getInlineId(u)); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("add summary inline message failed %v", roomId)
+		}
+	}
+
+	err = bot.rs.JoinToRoom(ctx, u.CallbackQuery.From, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("join room failed %v", roomId)
+		return
+	}
+
+	room, err = bot.rs.FindById(ctx, roomId)
 	if err != nil {
-		log.Error().Err(err).Msgf("get room failed %v", roomId)
+		log.Ctx(ctx).Error().Err(err).Msgf("get room failed %v", roomId)
 		return
 	}
 
 	//validation, if all members finished added operation you cant joining
-	if len(room.RoomStates.FinishedAddOperation) == len(*room.Members) {
+	if len(room.RoomStates.FinishedAddOperation) == room.CountRealMembers() {
 		callback := createCallback(u, I18n(u.User, "msg_can_not_join"), true)
 		return api.TelegramMessage{
 			CallbackConfig: callback,
@@ -66,7 +80,7 @@ func (bot JoinRoom) OnMessage(ctx context.Context, u *api.Update) (response api.
 	viewDbtB := api.NewButton(viewAllDebts, data)
 
 	if _, err := bot.bs.SaveAll(ctx, joinB, viewOpsB, viewDbtB); err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 
@@ -76,12 +90,43 @@ func (bot JoinRoom) OnMessage(ctx context.Context, u *api.Update) (response api.
 		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_join"), joinB.ID.Hex())},
 		{tgbotapi.NewInlineKeyboardButtonURL(I18n(u.User, "btn_start"), link)},
 	}
+	messages := []tgbotapi.Chattable{createScreen(u, text, &keyboard)}
+	if isNewMember {
+		if msg := bot.createMergeVirtualMemberMessage(ctx, room, u); msg != nil {
+			messages = append(messages, msg)
+		}
+	}
 	return api.TelegramMessage{
-		Chattable: []tgbotapi.Chattable{createScreen(u, text, &keyboard)},
+		Chattable: messages,
 		Send:      true,
 	}
 }
 
+// createMergeVirtualMemberMessage offers the joined user to take over operations of a virtual member
+func (bot JoinRoom) createMergeVirtualMemberMessage(ctx context.Context, room *api.Room, u *api.Update) tgbotapi.Chattable {
+	var toSave []*api.Button
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for _, m := range *room.Members {
+		if !m.IsMergeable() {
+			continue
+		}
+		b := api.NewButton(mergeVirtualMember, &api.CallbackData{RoomId: room.ID.Hex(), UserId: m.ID})
+		toSave = append(toSave, b)
+		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_i_am", m.DisplayName), b.ID.Hex())})
+	}
+	if len(toSave) == 0 {
+		return nil
+	}
+	roomB := api.NewButton(viewRoom, &api.CallbackData{RoomId: room.ID.Hex()})
+	toSave = append(toSave, roomB)
+	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_not_me"), roomB.ID.Hex())})
+	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return nil
+	}
+	return NewMessage(int64(u.User.ID), I18n(u.User, "scrn_merge_virtual_member", room.Name), keyboard)
+}
+
 // send /room, after click on the button 'Присоединиться'
 type ViewRoom struct {
 	bs  ButtonService
@@ -119,7 +164,7 @@ func (bot *ViewRoom) OnMessage(ctx context.Context, u *api.Update) (response api
 
 	room, err := bot.rs.FindById(ctx, roomId)
 	if err != nil {
-		log.Error().Err(err).Stack().Msgf("cannot find room, id:%s", roomId)
+		log.Ctx(ctx).Error().Err(err).Stack().Msgf("cannot find room, id:%s", roomId)
 		return
 	}
 
@@ -150,7 +195,7 @@ func (bot *ViewRoom) OnMessage(ctx context.Context, u *api.Update) (response api
 	}
 
 	if _, err = bot.bs.SaveAll(ctx, viewOpsB, viewDbtB, viewRoomsB, startOpB, staticsB, settB); err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 	return api.TelegramMessage{
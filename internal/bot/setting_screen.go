@@ -35,7 +35,7 @@ func (bot *RoomSetting) OnMessage(ctx context.Context, u *api.Update) (response
 	roomId := u.Button.CallbackData.RoomId
 	room, err := bot.rs.FindById(ctx, roomId)
 	if err != nil {
-		log.Error().Err(err).Stack().Msgf("cannot find room, id:%s", roomId)
+		log.Ctx(ctx).Error().Err(err).Stack().Msgf("cannot find room, id:%s", roomId)
 		return
 	}
 
@@ -54,6 +54,32 @@ func (bot *RoomSetting) OnMessage(ctx context.Context, u *api.Update) (response
 		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_do_archive"), btn.ID.Hex()))
 	}
 
+	virtualMemberBtn := api.NewButton(wantAddVirtualMember, &api.CallbackData{RoomId: roomId})
+	toSave = append(toSave, virtualMemberBtn)
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_add_virtual_member"), virtualMemberBtn.ID.Hex()))
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonURL(I18n(u.User, "btn_link_group_chat"), "http://t.me/"+bot.cfg.BotName+"?startgroup="+linkChatPrefix+roomId))
+	if room.Chat.ID != 0 {
+		summaryBtn := api.NewButton(postRoomSummary, &api.CallbackData{RoomId: roomId})
+		toSave = append(toSave, summaryBtn)
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_post_summary"), summaryBtn.ID.Hex()))
+	}
+
+	categoriesBtn := api.NewButton(roomCategories, &api.CallbackData{RoomId: roomId})
+	toSave = append(toSave, categoriesBtn)
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_room_categories"), categoriesBtn.ID.Hex()))
+
+	budgetBtn := api.NewButton(budgetSetting, &api.CallbackData{RoomId: roomId})
+	toSave = append(toSave, budgetBtn)
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_budget_setting"), budgetBtn.ID.Hex()))
+
+	currencyBtn := api.NewButton(roomCurrency, &api.CallbackData{RoomId: roomId})
+	toSave = append(toSave, currencyBtn)
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_room_currency", currencyText(u.User, room.Currency)), currencyBtn.ID.Hex()))
+
+	reminderBtn := api.NewButton(reminderSetting, &api.CallbackData{RoomId: roomId})
+	toSave = append(toSave, reminderBtn)
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_reminder_setting"), reminderBtn.ID.Hex()))
+
 	exitRoomBtn := api.NewButton(exitRoom, &api.CallbackData{RoomId: roomId})
 	toSave = append(toSave, exitRoomBtn)
 	buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_exit"), exitRoomBtn.ID.Hex()))
@@ -63,7 +89,7 @@ func (bot *RoomSetting) OnMessage(ctx context.Context, u *api.Update) (response
 	buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backB.ID.Hex()))
 
 	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 	keyboard := splitKeyboardButtons(buttons, 1)
@@ -105,11 +131,11 @@ func (bot *ArchiveRoom) OnMessage(ctx context.Context, u *api.Update) (response
 	var errCallback *tgbotapi.CallbackConfig = nil
 	if hasAction(u, archiveRoom) {
 		if err := bot.rss.ArchiveRoom(ctx, getFrom(u).ID, roomId); err != nil {
-			log.Error().Err(err).Msg("")
+			log.Ctx(ctx).Error().Err(err).Msg("")
 		}
 	} else {
 		if err := bot.rss.UnArchiveRoom(ctx, getFrom(u).ID, roomId); err != nil {
-			log.Error().Err(err).Msg("")
+			log.Ctx(ctx).Error().Err(err).Msg("")
 		}
 	}
 
@@ -149,16 +175,18 @@ func (bot *UserSetting) OnMessage(ctx context.Context, u *api.Update) (response
 		lang = u.Button.CallbackData.ExternalId
 		u.User.SelectedLang = lang
 		if err := bot.us.SetUserLang(ctx, u.User.ID, lang); err != nil {
-			log.Error().Err(err).Msg("upsert lang failed btn failed")
+			log.Ctx(ctx).Error().Err(err).Msg("upsert lang failed btn failed")
 		}
 	}
 	langBtn := api.NewButton(chooseLanguage, new(api.CallbackData))
 	notificationBtn := api.NewButton(chooseNotification, new(api.CallbackData))
 	countInPageBtn := api.NewButton(countInPage, new(api.CallbackData))
 	bankDetailsBtn := api.NewButton(bankDetailsView, new(api.CallbackData))
+	digestBtn := api.NewButton(chooseDigest, new(api.CallbackData))
+	personalDataBtn := api.NewButton(personalData, new(api.CallbackData))
 	backBtn := api.NewButton(viewStart, new(api.CallbackData))
-	if _, err := bot.bs.SaveAll(ctx, langBtn, notificationBtn, backBtn, bankDetailsBtn, countInPageBtn); err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+	if _, err := bot.bs.SaveAll(ctx, langBtn, notificationBtn, backBtn, bankDetailsBtn, countInPageBtn, digestBtn, personalDataBtn); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 	screen := createScreen(u, I18n(u.User, "scrn_user_setting"), &[][]tgbotapi.InlineKeyboardButton{
@@ -166,6 +194,8 @@ func (bot *UserSetting) OnMessage(ctx context.Context, u *api.Update) (response
 		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_notification", bot.defineNotification(u.User)), notificationBtn.ID.Hex())},
 		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_count_in_page", bot.defineNumberEmoji(u)), countInPageBtn.ID.Hex())},
 		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_bank_details_view"), bankDetailsBtn.ID.Hex())},
+		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_digest", digestPeriodText(u.User, u.User.DigestPeriod)), digestBtn.ID.Hex())},
+		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_personal_data"), personalDataBtn.ID.Hex())},
 		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backBtn.ID.Hex())},
 	})
 	return api.TelegramMessage{
@@ -223,7 +253,7 @@ func (bot *ChooseLanguage) OnMessage(ctx context.Context, u *api.Update) (respon
 	backBtn := api.NewButton(userSetting, new(api.CallbackData))
 
 	if _, err := bot.bs.SaveAll(ctx, enBtn, ruBtn, backBtn); err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 	text := I18n(u.User, "scrn_choose_lang")
@@ -261,7 +291,7 @@ func (bot *ChooseNotification) OnMessage(ctx context.Context, u *api.Update) (re
 	backBtn := api.NewButton(userSetting, new(api.CallbackData))
 
 	if _, err := bot.bs.SaveAll(ctx, turnBtn, backBtn); err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 
@@ -316,7 +346,7 @@ func (bot SelectedNotification) HasReact(u *api.Update) bool {
 func (bot *SelectedNotification) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
 	*u.User.NotificationOn = *u.User.NotificationOn == false
 	if err := bot.us.SetNotificationUser(ctx, u.User.ID, *u.User.NotificationOn); err != nil {
-		log.Error().Err(err).Msg("")
+		log.Ctx(ctx).Error().Err(err).Msg("")
 	}
 
 	u.Button = api.NewButton(userSetting, u.Button.CallbackData)
@@ -351,12 +381,21 @@ func (bot SelectedLeaveRoom) HasReact(u *api.Update) bool {
 func (bot *SelectedLeaveRoom) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
 	room, err := bot.rs.FindById(ctx, u.Button.CallbackData.RoomId)
 	if err != nil {
-		log.Error().Err(err).Msg("get room failed")
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
 		return
 	}
 	userID := u.User.ID
+	for _, m := range *room.Members {
+		if m.IsManagedBy(userID) {
+			callback := createCallback(u, I18n(u.User, "msg_you_can_not_leave_manager"), true)
+			return api.TelegramMessage{
+				CallbackConfig: callback,
+				Send:           true,
+			}
+		}
+	}
 	for _, o := range *room.Operations {
-		if o.Donor.ID == userID || containsUserId(o.Recipients, userID) {
+		if o.IsPayer(userID) || containsUserId(o.Recipients, userID) {
 			callback := createCallback(u, I18n(u.User, "msg_you_can_not_leave"), true)
 			return api.TelegramMessage{
 				CallbackConfig: callback,
@@ -367,7 +406,7 @@ func (bot *SelectedLeaveRoom) OnMessage(ctx context.Context, u *api.Update) (res
 
 	err = bot.rs.LeaveRoom(ctx, userID, u.Button.CallbackData.RoomId)
 	if err != nil {
-		log.Error().Err(err).Msg("")
+		log.Ctx(ctx).Error().Err(err).Msg("")
 		return
 	}
 	u.Button = api.NewButton(viewStart, u.Button.CallbackData)
@@ -403,13 +442,13 @@ func (bot *ChooseCountInPage) OnMessage(ctx context.Context, u *api.Update) (res
 	if u.User.CountInPage == 10 {
 		u.User.CountInPage = 5
 		if err := bot.us.SetCountInPage(ctx, u.User.ID, u.User.CountInPage); err != nil {
-			log.Error().Err(err).Msg("")
+			log.Ctx(ctx).Error().Err(err).Msg("")
 			return
 		}
 	} else {
 		u.User.CountInPage = u.User.CountInPage + 1
 		if err := bot.us.SetCountInPage(ctx, u.User.ID, u.User.CountInPage); err != nil {
-			log.Error().Err(err).Msg("")
+			log.Ctx(ctx).Error().Err(err).Msg("")
 			return
 		}
 	}
@@ -447,11 +486,11 @@ func (bot FinishedAddOperation) HasReact(u *api.Update) bool {
 func (bot *FinishedAddOperation) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
 	room, err := bot.rs.FindById(ctx, u.Button.CallbackData.RoomId)
 	if err != nil {
-		log.Error().Err(err).Msg("get room failed")
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
 		return
 	}
 	countUsersFinishedAddOperation := len(room.RoomStates.FinishedAddOperation)
-	if len(*room.Members) == countUsersFinishedAddOperation && u.Button.CallbackData.ExternalData == "false" {
+	if room.CountRealMembers() == countUsersFinishedAddOperation && u.Button.CallbackData.ExternalData == "false" {
 		callback := createCallback(u, I18n(u.User, "msg_all_members_add_operation"), true)
 		return api.TelegramMessage{
 			CallbackConfig: callback,
@@ -462,19 +501,22 @@ func (bot *FinishedAddOperation) OnMessage(ctx context.Context, u *api.Update) (
 	if u.Button.CallbackData.ExternalData == "true" {
 		countUsersFinishedAddOperation++
 		if err = bot.rss.FinishedAddOperation(ctx, u.User.ID, u.Button.CallbackData.RoomId); err != nil {
-			log.Error().Err(err).Msg("")
+			log.Ctx(ctx).Error().Err(err).Msg("")
 		}
 	} else {
 		countUsersFinishedAddOperation--
 		if err = bot.rss.UnFinishedAddOperation(ctx, u.User.ID, u.Button.CallbackData.RoomId); err != nil {
-			log.Error().Err(err).Msg("")
+			log.Ctx(ctx).Error().Err(err).Msg("")
 		}
 	}
 
 	var buttons []*api.Button
 	var messages []tgbotapi.Chattable
-	if len(*room.Members) == countUsersFinishedAddOperation {
+	if room.CountRealMembers() == countUsersFinishedAddOperation {
 		for _, user := range *room.Members {
+			if user.IsVirtual {
+				continue
+			}
 			rb := api.NewButton(viewRoom, &api.CallbackData{RoomId: room.ID.Hex()})
 			viewUserOpsB := api.NewButton(viewUserDebts, &api.CallbackData{RoomId: room.ID.Hex()})
 			setBankBtn := api.NewButton(bankDetailsWantSet, &api.CallbackData{RoomId: room.ID.Hex(), ExternalData: string(viewRoom)})
@@ -483,7 +525,7 @@ func (bot *FinishedAddOperation) OnMessage(ctx context.Context, u *api.Update) (
 
 			user, err := bot.us.FindById(ctx, user.ID)
 			if err != nil {
-				log.Error().Err(err).Msg("")
+				log.Ctx(ctx).Error().Err(err).Msg("")
 				continue
 			}
 			text := I18n(user, "scrn_all_operations_added", userLink(user), room.Name)
@@ -506,7 +548,7 @@ func (bot *FinishedAddOperation) OnMessage(ctx context.Context, u *api.Update) (
 	viewRoomBtn := api.NewButton(viewRoom, &api.CallbackData{RoomId: u.Button.CallbackData.RoomId})
 	buttons = append(buttons, viewRoomBtn)
 	if _, err := bot.bs.SaveAll(ctx, buttons...); err != nil {
-		log.Error().Err(err).Msg("save buttons failed")
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
 		return
 	}
 
@@ -538,15 +580,17 @@ func (bot ViewBankDetails) HasReact(u *api.Update) bool {
 
 func (bot *ViewBankDetails) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
 	setBankBtn := api.NewButton(bankDetailsWantSet, &api.CallbackData{})
+	paymentsBtn := api.NewButton(paymentMethods, new(api.CallbackData))
 	backBtn := api.NewButton(userSetting, new(api.CallbackData))
 
-	if _, err := bot.bs.SaveAll(ctx, setBankBtn, backBtn); err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+	if _, err := bot.bs.SaveAll(ctx, setBankBtn, paymentsBtn, backBtn); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 	text := I18n(u.User, "scrn_bank_details_view", u.User.BankDetails)
 	screen := createScreen(u, text, &[][]tgbotapi.InlineKeyboardButton{
 		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_edit_operation_edit"), setBankBtn.ID.Hex())},
+		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_payment_methods"), paymentsBtn.ID.Hex())},
 		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backBtn.ID.Hex())},
 	})
 
@@ -582,13 +626,13 @@ func (bot *WantSetBankDetails) OnMessage(ctx context.Context, u *api.Update) (re
 	}
 	err := bot.css.Save(ctx, cs)
 	if err != nil {
-		log.Error().Err(err).Msg("create chat state failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create chat state failed")
 		return
 	}
 
 	backBtn := api.NewButton(userSetting, new(api.CallbackData))
 	if _, err := bot.bs.SaveAll(ctx, backBtn); err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 	text := I18n(u.User, "scrn_bank_details_set")
@@ -626,12 +670,12 @@ func (bot *SetBankDetails) OnMessage(ctx context.Context, u *api.Update) (respon
 	defer bot.css.CleanChatState(ctx, u.ChatState)
 
 	if err := bot.us.SetUserBankDetails(ctx, u.User.ID, u.Message.Text); err != nil {
-		log.Error().Err(err).Msg("")
+		log.Ctx(ctx).Error().Err(err).Msg("")
 		return api.TelegramMessage{}
 	}
 	user, err := bot.us.FindById(ctx, u.User.ID)
 	if err != nil {
-		log.Error().Err(err).Msg("")
+		log.Ctx(ctx).Error().Err(err).Msg("")
 		return api.TelegramMessage{}
 	}
 	u.User = user
@@ -648,3 +692,72 @@ func (bot *SetBankDetails) OnMessage(ctx context.Context, u *api.Update) (respon
 		Redirect: u,
 	}
 }
+
+// roomCurrencies can be chosen as the currency of the room, the empty currency means that it is unknown
+var roomCurrencies = []string{"", "RUB", "USD", "EUR", "GBP", "KZT", "UAH", "BYN", "GEL", "TRY"}
+
+// RoomCurrency screen with currencies of room sums, the currency is needed for payment QR codes with the sum.
+// The chosen currency is in CallbackData.ExternalData of the setRoomCurrency button
+type RoomCurrency struct {
+	bs  ButtonService
+	rs  RoomService
+	cfg *Config
+}
+
+func NewRoomCurrency(bs ButtonService, rs RoomService, cfg *Config) *RoomCurrency {
+	return &RoomCurrency{
+		bs:  bs,
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot RoomCurrency) HasReact(u *api.Update) bool {
+	return hasAction(u, roomCurrency) || hasAction(u, setRoomCurrency)
+}
+
+func (bot *RoomCurrency) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	roomId := u.Button.CallbackData.RoomId
+	if u.Button.Action == setRoomCurrency {
+		if err := bot.rs.SetCurrency(ctx, roomId, u.Button.CallbackData.ExternalData); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("set currency failed")
+			return
+		}
+	}
+	room, err := bot.rs.FindById(ctx, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find room, id:%s", roomId)
+		return
+	}
+
+	var toSave []*api.Button
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, c := range roomCurrencies {
+		b := api.NewButton(setRoomCurrency, &api.CallbackData{RoomId: roomId, ExternalData: c})
+		toSave = append(toSave, b)
+		text := currencyText(u.User, c)
+		if c == room.Currency {
+			text = "✅ " + text
+		}
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(text, b.ID.Hex()))
+	}
+	backB := api.NewButton(roomSetting, &api.CallbackData{RoomId: roomId})
+	toSave = append(toSave, backB)
+	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	keyboard := splitKeyboardButtons(buttons, 3)
+	keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), backB.ID.Hex())})
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{createScreen(u, I18n(u.User, "scrn_room_currency", room.Name), &keyboard)},
+		Send:      true,
+	}
+}
+
+func currencyText(user *api.User, currency string) string {
+	if currency == "" {
+		return I18n(user, "btn_currency_unknown")
+	}
+	return currency
+}
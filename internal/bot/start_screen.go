@@ -13,6 +13,7 @@ type ChatStateService interface {
 	DeleteById(ctx context.Context, id primitive.ObjectID) error
 	FindByUserId(ctx context.Context, userId int) (*api.ChatState, error)
 	CleanChatState(ctx context.Context, state *api.ChatState)
+	DeleteByUserId(ctx context.Context, id int) error
 }
 
 type ButtonService interface {
@@ -56,19 +57,21 @@ func (s *StartScreen) OnMessage(ctx context.Context, u *api.Update) (response ap
 		cb := api.NewButton(createRoom, new(api.CallbackData))
 		arb := api.NewButton(viewAllRooms, new(api.CallbackData))
 		archRB := api.NewButton(viewArchivedRooms, new(api.CallbackData))
+		balanceBtn := api.NewButton(viewBalances, new(api.CallbackData))
 		settingBtn := api.NewButton(userSetting, nil)
-		if _, err := s.bs.SaveAll(ctx, cb, arb, archRB, settingBtn); err != nil {
-			log.Error().Err(err).Msg("save btn failed")
+		if _, err := s.bs.SaveAll(ctx, cb, arb, archRB, balanceBtn, settingBtn); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("save btn failed")
 			return
 		}
 		screen = createScreen(u, I18n(u.User, "scrn_main"), &[][]tgbotapi.InlineKeyboardButton{
 			{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_create_room"), cb.ID.Hex())},
 			{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_all_rooms"), arb.ID.Hex())},
 			{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_archive"), archRB.ID.Hex())},
+			{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_balances"), balanceBtn.ID.Hex())},
 			{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_user_settings"), settingBtn.ID.Hex())},
 		})
 	} else {
-		screen = createScreen(u, I18n(u.User, "scrn_main"), &[][]tgbotapi.InlineKeyboardButton{
+		screen = createScreen(u, I18n(u.User, "scrn_main")+"\n\n"+I18n(u.User, "scrn_group_commands"), &[][]tgbotapi.InlineKeyboardButton{
 			{NewButtonSwitchCurrent(I18n(u.User, "btn_all_rooms"), "")},
 			{tgbotapi.NewInlineKeyboardButtonURL(I18n(u.User, "btn_create_room"), "http://t.me/"+s.cfg.BotName+"?start=create_room")},
 		})
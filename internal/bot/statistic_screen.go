@@ -7,6 +7,7 @@ import (
 	"github.com/enescakir/emoji"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/rs/zerolog/log"
+	"time"
 )
 
 type StatisticService interface {
@@ -14,6 +15,11 @@ type StatisticService interface {
 	GetUserCostsSum(ctx context.Context, userId int, roomId string) (int, error)
 	GetAllCostsSum(ctx context.Context, roomId string) (int, error)
 	GetAllDebtsSum(ctx context.Context, roomId string) (int, error)
+	GetCategoryCostsSums(ctx context.Context, roomId string) ([]api.CategoryCosts, error)
+	GetMembersCostsSums(ctx context.Context, roomId string) ([]api.MemberCosts, error)
+	BudgetStatuses(room *api.Room) []api.BudgetStatus
+	BudgetForecast(room *api.Room, now time.Time) api.BudgetForecast
+	GetUserDigest(ctx context.Context, userId int, since time.Time) ([]api.RoomDigest, error)
 }
 
 // Statistic screen w
@@ -48,7 +54,7 @@ func (bot *Statistic) OnMessage(ctx context.Context, u *api.Update) (response ap
 	roomId := u.Button.CallbackData.RoomId
 	room, err := bot.rs.FindById(ctx, roomId)
 	if err != nil {
-		log.Error().Err(err).Stack().Msgf("cannot find room, userId:%s", roomId)
+		log.Ctx(ctx).Error().Err(err).Stack().Msgf("cannot find room, userId:%s", roomId)
 		return
 	}
 
@@ -62,12 +68,24 @@ func (bot *Statistic) OnMessage(ctx context.Context, u *api.Update) (response ap
 	}
 	totalDebtSum, err := bot.ss.GetAllDebtsSum(ctx, roomId)
 	if err != nil {
-		return
+		log.Ctx(ctx).Error().Err(err).Msg("get debts sum failed")
+		return failedResponse(u, err)
 	}
 
 	debtorSum, lenderSum, err := bot.ss.GetUserDebtAndLendSum(ctx, getFrom(u).ID, room.ID.Hex())
 	if err != nil {
-		return
+		log.Ctx(ctx).Error().Err(err).Msg("get user debts sum failed")
+		return failedResponse(u, err)
+	}
+	categoryCosts, err := bot.ss.GetCategoryCostsSums(ctx, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get category costs sums failed")
+		return failedResponse(u, err)
+	}
+	membersCosts, err := bot.ss.GetMembersCostsSums(ctx, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get members costs sums failed")
+		return failedResponse(u, err)
 	}
 	var debtText string
 	if debtorSum != 0 {
@@ -82,7 +100,7 @@ func (bot *Statistic) OnMessage(ctx context.Context, u *api.Update) (response ap
 	startB := api.NewButton(viewRoom, data)
 	debtOperationsB := api.NewButton(viewAllDebtOperations, data)
 	if _, err = bot.bs.SaveAll(ctx, debtOperationsB); err != nil {
-		log.Error().Err(err).Msg("save buttons failed")
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
 		return
 	}
 
@@ -91,13 +109,31 @@ func (bot *Statistic) OnMessage(ctx context.Context, u *api.Update) (response ap
 	text += fmt.Sprintf(I18n(u.User, "msg_you_spend", moneySpace(totalUserSpendSum)) + "\n\n")
 	text += debtText + "\n\n"
 	text += fmt.Sprintf(I18n(u.User, "msg_common_debt", moneySpace(totalDebtSum)) + "\n\n")
-	keyboard := [][]tgbotapi.InlineKeyboardButton{
-		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_paid_debt"), debtOperationsB.ID.Hex())},
-		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), startB.ID.Hex())},
+	if totalSpendSum > 0 {
+		text += I18n(u.User, "scrn_statistic_categories") + "\n"
+		for _, c := range categoryCosts {
+			text += costsLine(categoryName(u.User, c.Category), c.Sum, totalSpendSum)
+		}
+		text += "\n" + I18n(u.User, "scrn_statistic_members") + "\n"
+		for _, m := range membersCosts {
+			text += costsLine(userLink(m.Member), m.Sum, totalSpendSum)
+		}
+	}
+	if budgetStatuses := bot.ss.BudgetStatuses(room); len(budgetStatuses) > 0 || room.Budget.Days > 0 {
+		text += "\n" + I18n(u.User, "scrn_statistic_budget") + "\n"
+		text += budgetText(u.User, room, budgetStatuses, bot.ss.BudgetForecast(room, time.Now()))
+	}
+	keyboard, err := createChartKeyboard(ctx, bot.bs, u.User, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
 	}
+	keyboard = append(keyboard,
+		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_paid_debt"), debtOperationsB.ID.Hex())},
+		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_back"), startB.ID.Hex())})
 
 	if _, err := bot.bs.SaveAll(ctx, startB); err != nil {
-		log.Error().Err(err).Msg("create btn failed")
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
 		return
 	}
 	return api.TelegramMessage{
@@ -106,6 +142,11 @@ func (bot *Statistic) OnMessage(ctx context.Context, u *api.Update) (response ap
 	}
 }
 
+// costsLine returns the sum with its percent of total costs, example = • Food: 1 500 $ (30%)
+func costsLine(name string, sum int, total int) string {
+	return fmt.Sprintf("• %s: %s $ (%d%%)\n", name, moneySpace(sum), sum*100/total)
+}
+
 // ViewAllDebtOperations show screen with donar/recepient buttons
 type ViewAllDebtOperations struct {
 	css ChatStateService
@@ -145,7 +186,8 @@ func (bot ViewAllDebtOperations) OnMessage(ctx context.Context, u *api.Update) (
 
 	for i := skip; i < skip+size && i < len(*ops); i++ {
 		op := (*ops)[i]
-		text += fmt.Sprintf("%s *%s ₽* ➡ ️%s", userLink(op.Donor), moneySpace(op.Sum), userLink(&(*op.Recipients)[0])+"\n\n")
+		text += fmt.Sprintf("%s *%s ₽* ➡ ️%s", userLink(op.Donor), moneySpace(op.Sum), userLink(&(*op.Recipients)[0]))
+		text += repaymentStatusSmile(&op) + "\n\n"
 	}
 
 	var navRow []tgbotapi.InlineKeyboardButton
@@ -165,7 +207,7 @@ func (bot ViewAllDebtOperations) OnMessage(ctx context.Context, u *api.Update) (
 	keyboard = append(keyboard, navRow)
 
 	if _, err := bot.bs.SaveAll(ctx, toSave...); err != nil {
-		log.Error().Err(err).Msg("save buttons failed")
+		log.Ctx(ctx).Error().Err(err).Msg("save buttons failed")
 		return
 	}
 
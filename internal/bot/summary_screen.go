@@ -0,0 +1,209 @@
+package bot
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const summaryTimeLayout = "02.01.2006 15:04"
+
+// RoomSummary keeps room summaries posted to chats up to date, it is not a bot
+type RoomSummary struct {
+	bs  ButtonService
+	rs  RoomService
+	os  OperationService
+	ss  StatisticService
+	cfg *Config
+}
+
+func NewRoomSummary(bs ButtonService, rs RoomService, os OperationService, ss StatisticService, cfg *Config) *RoomSummary {
+	return &RoomSummary{
+		bs:  bs,
+		rs:  rs,
+		os:  os,
+		ss:  ss,
+		cfg: cfg,
+	}
+}
+
+// Posted saves the sent message as summary of the room, the summary is written in the language of the user
+func (s *RoomSummary) Posted(ctx context.Context, roomId string, u *api.User, m tgbotapi.Message) error {
+	return s.rs.SetSummaryMessage(ctx, roomId, m.Chat.ID, m.MessageID, api.DefineLang(u))
+}
+
+// Edits returns edits of the summary message and of the room messages posted by inline query
+func (s *RoomSummary) Edits(ctx context.Context, roomId string) ([]tgbotapi.Chattable, error) {
+	room, err := s.rs.FindById(ctx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	if room.Summary.MessageId == 0 && len(room.Summary.InlineMessageIds) == 0 {
+		return nil, nil
+	}
+	user := &api.User{SelectedLang: room.Summary.Lang}
+	text, err := createSummaryText(ctx, s.os, s.ss, room, user)
+	if err != nil {
+		return nil, err
+	}
+
+	var edits []tgbotapi.Chattable
+	if room.Summary.MessageId != 0 {
+		edits = append(edits, NewEditMessage(room.Summary.ChatId, room.Summary.MessageId, text, summaryKeyboard(user, room, s.cfg)))
+	}
+	if len(room.Summary.InlineMessageIds) > 0 {
+		joinId, err := s.joinButton(ctx, room)
+		if err != nil {
+			return nil, err
+		}
+		keyboard := [][]tgbotapi.InlineKeyboardButton{
+			{tgbotapi.NewInlineKeyboardButtonData(I18n(user, "btn_join"), joinId)},
+			{tgbotapi.NewInlineKeyboardButtonURL(I18n(user, "btn_start"), roomLink(room, s.cfg))},
+		}
+		for _, id := range room.Summary.InlineMessageIds {
+			edits = append(edits, NewEditInlineMessage(id, text, keyboard))
+		}
+	}
+	return edits, nil
+}
+
+// joinButton returns id of the join button of the summary, the button is saved on the first edit and reused then
+func (s *RoomSummary) joinButton(ctx context.Context, room *api.Room) (string, error) {
+	if room.Summary.JoinButtonId != "" {
+		return room.Summary.JoinButtonId, nil
+	}
+	joinB := api.NewButton(joinRoom, &api.CallbackData{RoomId: room.ID.Hex()})
+	if _, err := s.bs.SaveAll(ctx, joinB); err != nil {
+		return "", err
+	}
+	if err := s.rs.SetSummaryJoinButton(ctx, room.ID.Hex(), joinB.ID.Hex()); err != nil {
+		return "", err
+	}
+	return joinB.ID.Hex(), nil
+}
+
+// GroupSummary posts the room summary to the group chat and pins it, example = /summary
+type GroupSummary struct {
+	rs  RoomService
+	os  OperationService
+	ss  StatisticService
+	cfg *Config
+}
+
+func NewGroupSummary(rs RoomService, os OperationService, ss StatisticService, cfg *Config) *GroupSummary {
+	return &GroupSummary{
+		rs:  rs,
+		os:  os,
+		ss:  ss,
+		cfg: cfg,
+	}
+}
+
+func (bot GroupSummary) HasReact(u *api.Update) bool {
+	_, ok := groupCommand(u, bot.cfg.BotName, "summary")
+	return ok
+}
+
+func (bot *GroupSummary) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	room, resp := findGroupRoom(ctx, bot.rs, u)
+	if room == nil {
+		return resp
+	}
+	text, err := createSummaryText(ctx, bot.os, bot.ss, room, u.User)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create summary failed")
+		return failedResponse(u, err)
+	}
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{NewMessage(u.Message.Chat.ID, text, summaryKeyboard(u.User, room, bot.cfg))},
+		Pin:       true,
+		SummaryOf: room.ID.Hex(),
+		Send:      true,
+	}
+}
+
+// PostRoomSummary posts the room summary from room settings to the linked group chat and pins it
+type PostRoomSummary struct {
+	rs  RoomService
+	os  OperationService
+	ss  StatisticService
+	cfg *Config
+}
+
+func NewPostRoomSummary(rs RoomService, os OperationService, ss StatisticService, cfg *Config) *PostRoomSummary {
+	return &PostRoomSummary{
+		rs:  rs,
+		os:  os,
+		ss:  ss,
+		cfg: cfg,
+	}
+}
+
+func (bot PostRoomSummary) HasReact(u *api.Update) bool {
+	return hasAction(u, postRoomSummary)
+}
+
+func (bot *PostRoomSummary) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	roomId := u.Button.CallbackData.RoomId
+	room, err := bot.rs.FindById(ctx, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find room, id:%s", roomId)
+		return
+	}
+	if room.Chat.ID == 0 {
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_room_not_linked"), true),
+			Send:           true,
+		}
+	}
+	text, err := createSummaryText(ctx, bot.os, bot.ss, room, u.User)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create summary failed")
+		return failedResponse(u, err)
+	}
+	return api.TelegramMessage{
+		Chattable:      []tgbotapi.Chattable{NewMessage(room.Chat.ID, text, summaryKeyboard(u.User, room, bot.cfg))},
+		CallbackConfig: createCallback(u, I18n(u.User, "msg_summary_posted"), false),
+		Pin:            true,
+		SummaryOf:      roomId,
+		Send:           true,
+	}
+}
+
+// createSummaryText returns room status with total spent and current debts
+func createSummaryText(ctx context.Context, os OperationService, ss StatisticService, room *api.Room, user *api.User) (string, error) {
+	roomId := room.ID.Hex()
+	spent, err := ss.GetAllCostsSum(ctx, roomId)
+	if err != nil {
+		return "", err
+	}
+	debts, err := os.GetAllDebts(ctx, roomId)
+	if err != nil {
+		return "", err
+	}
+
+	text := "📌 " + createRoomInfoText(room, &api.Update{User: user}) + "\n"
+	text += I18n(user, "msg_common_spend", moneySpace(spent)) + "\n\n"
+	if len(debts) == 0 {
+		text += I18n(user, "msg_have_not_debts") + "\n"
+	} else {
+		text += I18n(user, "scrn_all_debts") + "\n"
+		for _, d := range debts {
+			text += debtLine(&d)
+		}
+	}
+	text += "\n" + I18n(user, "scrn_summary_updated", time.Now().Format(summaryTimeLayout))
+	return text, nil
+}
+
+func summaryKeyboard(user *api.User, room *api.Room, cfg *Config) [][]tgbotapi.InlineKeyboardButton {
+	return [][]tgbotapi.InlineKeyboardButton{
+		{tgbotapi.NewInlineKeyboardButtonURL(I18n(user, "btn_view_room"), roomLink(room, cfg))},
+	}
+}
+
+func roomLink(room *api.Room, cfg *Config) string {
+	return "http://t.me/" + cfg.BotName + "?start=" + string(viewRoom) + room.ID.Hex()
+}
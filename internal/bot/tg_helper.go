@@ -2,14 +2,17 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/almaznur91/splitty/internal/api"
 	"github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/gookit/i18n"
+	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type UserService interface {
@@ -18,6 +21,17 @@ type UserService interface {
 	SetCountInPage(ctx context.Context, userId int, count int) error
 	SetNotificationUser(ctx context.Context, userId int, notification bool) error
 	SetUserBankDetails(ctx context.Context, userId int, bankDerails string) error
+	FindWithDigest(ctx context.Context) (*[]api.User, error)
+	SetDigest(ctx context.Context, userId int, period string, sentAt time.Time) error
+	FindAll(ctx context.Context) (*[]api.User, error)
+	FindByUsername(ctx context.Context, userName string) (*api.User, error)
+	SetBanned(ctx context.Context, userId int, banned bool) error
+	ExportUserData(ctx context.Context, userId int, now time.Time) (*api.UserExport, error)
+	OutstandingDebts(ctx context.Context, userId int) ([]api.RoomDebt, error)
+	DeleteUser(ctx context.Context, userId int) error
+	PaymentDetailsFor(ctx context.Context, ownerId int, viewerId int) (*api.User, error)
+	AddPaymentMethod(ctx context.Context, userId int, kind string, text string) (*api.PaymentMethod, error)
+	DeletePaymentMethod(ctx context.Context, userId int, id string) error
 }
 
 type RoomService interface {
@@ -28,6 +42,20 @@ type RoomService interface {
 	FindRoomsByUserId(ctx context.Context, id int) (*[]api.Room, error)
 	FindArchivedRoomsByUserId(ctx context.Context, id int) (*[]api.Room, error)
 	FindRoomsByLikeName(ctx context.Context, userId int, name string) (*[]api.Room, error)
+	AddVirtualMember(ctx context.Context, roomId string, name string, manager *api.User) (*api.User, error)
+	MergeVirtualMember(ctx context.Context, roomId string, virtualId int, u api.User) error
+	FindByChatId(ctx context.Context, chatId int64) (*api.Room, error)
+	LinkChat(ctx context.Context, roomId string, chat api.Chat) error
+	SetSummaryMessage(ctx context.Context, roomId string, chatId int64, messageId int, lang string) error
+	AddSummaryInlineMessage(ctx context.Context, roomId string, inlineMessageId string) error
+	SetSummaryJoinButton(ctx context.Context, roomId string, buttonId string) error
+	FindRoomsDistributingDebts(ctx context.Context) (*[]api.Room, error)
+	SetReminderInterval(ctx context.Context, roomId string, days int) error
+	SetReminderSent(ctx context.Context, roomId string, sent api.ReminderSent) error
+	DeleteReminderSent(ctx context.Context, roomId string, userId int) error
+	SetCategories(ctx context.Context, roomId string, categories []string) error
+	SetBudget(ctx context.Context, roomId string, budget api.Budget) error
+	SetCurrency(ctx context.Context, roomId string, currency string) error
 }
 
 type RoomStateService interface {
@@ -40,8 +68,27 @@ type RoomStateService interface {
 }
 
 type Config struct {
-	BotName    string
-	SuperUsers []string
+	BotName              string
+	SuperUsers           []string
+	ReminderIntervalDays int
+	QuietHoursFrom       int
+	QuietHoursTo         int
+	QuietHoursLocation   *time.Location // quiet hours are in this time zone, not in the time zone of the server
+	MaskBankDetails      bool           // bank details are masked in group chats
+}
+
+// IsQuietTime checks that reminders and digests should not be sent at the moment
+func (c *Config) IsQuietTime(now time.Time) bool {
+	loc := c.QuietHoursLocation
+	if loc == nil {
+		loc = time.UTC
+	}
+	return isQuietHour(now.In(loc).Hour(), c.QuietHoursFrom, c.QuietHoursTo)
+}
+
+// IsSuper checks that the user name is in the list of super users
+func (c *Config) IsSuper(userName string) bool {
+	return userName != "" && contains(c.SuperUsers, userName)
 }
 
 func NewInlineResultArticle(title, descr, text string, keyboard [][]tgbotapi.InlineKeyboardButton) tgbotapi.InlineQueryResultArticle {
@@ -103,6 +150,22 @@ func NewPhotoMessage(chatId int64, text string, fileId string) tgbotapi.PhotoCon
 	return imageMsg
 }
 
+// NewPhotoUpload returns the photo message uploading the image from memory, example = rendered chart
+func NewPhotoUpload(chatId int64, text string, name string, data []byte) tgbotapi.PhotoConfig {
+	imageMsg := tgbotapi.NewPhotoUpload(chatId, tgbotapi.FileBytes{Name: name, Bytes: data})
+	imageMsg.ParseMode = tgbotapi.ModeMarkdown
+	imageMsg.Caption = text
+	return imageMsg
+}
+
+// NewDocumentUpload returns the document message uploading the file from memory, example = exported personal data
+func NewDocumentUpload(chatId int64, text string, name string, data []byte) tgbotapi.DocumentConfig {
+	docMsg := tgbotapi.NewDocumentUpload(chatId, tgbotapi.FileBytes{Name: name, Bytes: data})
+	docMsg.ParseMode = tgbotapi.ModeMarkdown
+	docMsg.Caption = text
+	return docMsg
+}
+
 func NewVideoMessage(chatId int64, text string, fileId string) tgbotapi.VideoConfig {
 	imageMsg := tgbotapi.NewVideoShare(chatId, fileId)
 	imageMsg.ParseMode = tgbotapi.ModeMarkdown
@@ -150,6 +213,11 @@ func hasAction(update *api.Update, action api.Action) bool {
 		(update.ChatState != nil && update.ChatState.Action == action)
 }
 
+// HasSecretInput checks that the message of the update is bank details or the payment method typed by the user
+func HasSecretInput(u *api.Update) bool {
+	return hasMessage(u) && (hasAction(u, bankDetailsSet) || hasAction(u, addPaymentMethod))
+}
+
 func hasMessage(update *api.Update) bool {
 	return update.Message != nil &&
 		update.Message.Text != ""
@@ -178,6 +246,25 @@ func createScreen(u *api.Update, text string, keyboard *[][]tgbotapi.InlineKeybo
 	}
 }
 
+// failedResponse alerts the user that debts of the room can't be calculated because of broken data,
+// the response is empty for other failures
+func failedResponse(u *api.Update, err error) api.TelegramMessage {
+	if !errors.Is(err, api.ErrInconsistentRoom) {
+		return api.TelegramMessage{}
+	}
+	text := I18n(u.User, "msg_room_inconsistent")
+	switch {
+	case u.CallbackQuery != nil:
+		return api.TelegramMessage{CallbackConfig: createCallback(u, text, true), Send: true}
+	case u.Message != nil:
+		msg := tgbotapi.NewMessage(u.Message.Chat.ID, text)
+		msg.ReplyToMessageID = u.Message.ID
+		return api.TelegramMessage{Chattable: []tgbotapi.Chattable{msg}, Send: true}
+	default:
+		return api.TelegramMessage{}
+	}
+}
+
 func createCallback(u *api.Update, text string, showAlert bool) *tgbotapi.CallbackConfig {
 	return &tgbotapi.CallbackConfig{
 		CallbackQueryID: u.CallbackQuery.ID,
@@ -203,10 +290,102 @@ func shortName(user *api.User) string {
 	return string(sn)
 }
 
+// lenderBankDetails returns bank details and payment methods of the lender, they are shown only to the user who owes him.
+// The update is nil for scheduled messages, they are sent to private chats
+func lenderBankDetails(ctx context.Context, us UserService, cfg *Config, u *api.Update, viewer *api.User, debt *api.Debt) string {
+	lender := lenderPaymentDetails(ctx, us, viewer, debt)
+	if lender == nil {
+		return ""
+	}
+	return paymentDetailsText(u, cfg, viewer, lender)
+}
+
+// lenderPaymentDetails returns the lender with bank details and payment methods, nil if the viewer doesn't owe him
+func lenderPaymentDetails(ctx context.Context, us UserService, viewer *api.User, debt *api.Debt) *api.User {
+	lender, err := us.PaymentDetailsFor(ctx, notifiedUserId(debt.Lender), viewer.ID)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot find bank details of user %d", debt.Lender.ID)
+		return nil
+	}
+	return lender
+}
+
+// paymentDetailsText returns bank details and payment methods of the owner in the language of the viewer
+func paymentDetailsText(u *api.Update, cfg *Config, viewer *api.User, owner *api.User) string {
+	var lines []string
+	if owner.BankDetails != "" {
+		lines = append(lines, bankDetailsText(u, cfg, owner.BankDetails))
+	}
+	for _, m := range owner.Payments {
+		lines = append(lines, paymentMethodText(viewer, m, !showFullDetails(u, cfg)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// paymentMethodText returns the payment method in one line, example = IBAN, EUR: DE89370400440532013000 COBADEFFXXX
+func paymentMethodText(user *api.User, m api.PaymentMethod, masked bool) string {
+	account := m.Account
+	if masked {
+		account = maskBankDetails(account)
+	}
+	text := I18n(user, "payment_"+m.Kind) + ", " + m.Currency + ": " + account
+	if m.Bic != "" {
+		text += " " + m.Bic
+	}
+	if m.Bank != "" {
+		text += " (" + m.Bank + ")"
+	}
+	return text
+}
+
+// bankDetailsText returns bank details to show in the chat of the update, in group chats they can be masked
+func bankDetailsText(u *api.Update, cfg *Config, details string) string {
+	if showFullDetails(u, cfg) {
+		return details
+	}
+	return maskBankDetails(details)
+}
+
+// showFullDetails checks that bank details can be shown unmasked in the chat of the update
+func showFullDetails(u *api.Update, cfg *Config) bool {
+	return u == nil || isPrivate(u) || !cfg.MaskBankDetails
+}
+
+// maskBankDetails hides all but the last 4 characters of bank details
+func maskBankDetails(details string) string {
+	r := []rune(strings.TrimSpace(details))
+	if len(r) <= 4 {
+		return "••••"
+	}
+	return "••••" + string(r[len(r)-4:])
+}
+
 func userLink(user *api.User) string {
+	if user.IsVirtual {
+		return user.DisplayName + " 👻"
+	}
 	return fmt.Sprintf("[%s](tg://user?id=%d)", user.DisplayName, user.ID)
 }
 
+// notifiedUserId returns id of the user who receives notifications for member, virtual members are notified through their manager
+func notifiedUserId(user *api.User) int {
+	if user.IsVirtual {
+		return user.ManagerId
+	}
+	return user.ID
+}
+
+// defineDebtor returns room member who repays the debt, it is the user himself or virtual member managed by him
+func defineDebtor(room *api.Room, user *api.User, debtorId int) *api.User {
+	if debtorId == 0 || debtorId == user.ID {
+		return room.FindMember(user.ID)
+	}
+	if debtor := room.FindMember(debtorId); debtor != nil && debtor.IsManagedBy(user.ID) {
+		return debtor
+	}
+	return nil
+}
+
 func moneySpace(sum int) string {
 	s := strconv.Itoa(sum)
 	re := regexp.MustCompile("(\\d+)(\\d{3})")
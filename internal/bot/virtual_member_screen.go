@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"github.com/almaznur91/splitty/internal/api"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+	"strings"
+)
+
+// WantAddVirtualMember screen asks the name of the member without telegram
+type WantAddVirtualMember struct {
+	bs  ButtonService
+	css ChatStateService
+	cfg *Config
+}
+
+func NewWantAddVirtualMember(bs ButtonService, css ChatStateService, cfg *Config) *WantAddVirtualMember {
+	return &WantAddVirtualMember{
+		bs:  bs,
+		css: css,
+		cfg: cfg,
+	}
+}
+
+func (bot WantAddVirtualMember) HasReact(u *api.Update) bool {
+	return hasAction(u, wantAddVirtualMember)
+}
+
+func (bot *WantAddVirtualMember) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	roomId := u.Button.CallbackData.RoomId
+	cs := &api.ChatState{UserId: int(getChatID(u)), Action: addVirtualMember, CallbackData: &api.CallbackData{RoomId: roomId}}
+	if err := bot.css.Save(ctx, cs); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create chat state failed")
+		return
+	}
+
+	cancelBtn := api.NewButton(roomSetting, &api.CallbackData{RoomId: roomId})
+	if _, err := bot.bs.SaveAll(ctx, cancelBtn); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	screen := createScreen(u, I18n(u.User, "scrn_virtual_member_name"), &[][]tgbotapi.InlineKeyboardButton{
+		{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_cancel"), cancelBtn.ID.Hex())},
+	})
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{screen},
+		Send:      true,
+	}
+}
+
+// AddVirtualMember adds member without telegram, sender becomes manager of his balance
+type AddVirtualMember struct {
+	bs  ButtonService
+	rs  RoomService
+	css ChatStateService
+	cfg *Config
+}
+
+func NewAddVirtualMember(bs ButtonService, rs RoomService, css ChatStateService, cfg *Config) *AddVirtualMember {
+	return &AddVirtualMember{
+		bs:  bs,
+		rs:  rs,
+		css: css,
+		cfg: cfg,
+	}
+}
+
+func (bot AddVirtualMember) HasReact(u *api.Update) bool {
+	return hasAction(u, addVirtualMember) && hasMessage(u)
+}
+
+func (bot *AddVirtualMember) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	defer bot.css.CleanChatState(ctx, u.ChatState)
+
+	roomId := u.ChatState.CallbackData.RoomId
+	member, err := bot.rs.AddVirtualMember(ctx, roomId, strings.TrimSpace(u.Message.Text), u.User)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("add virtual member failed, room:%s", roomId)
+		return
+	}
+	room, err := bot.rs.FindById(ctx, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("get room failed")
+		return
+	}
+
+	roomBtn := api.NewButton(viewRoom, &api.CallbackData{RoomId: roomId})
+	settingBtn := api.NewButton(roomSetting, &api.CallbackData{RoomId: roomId})
+	if _, err := bot.bs.SaveAll(ctx, roomBtn, settingBtn); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("create btn failed")
+		return
+	}
+	return api.TelegramMessage{
+		Chattable: []tgbotapi.Chattable{NewMessage(getChatID(u), I18n(u.User, "scrn_virtual_member_added", member.DisplayName, room.Name),
+			[][]tgbotapi.InlineKeyboardButton{
+				{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_view_room"), roomBtn.ID.Hex())},
+				{tgbotapi.NewInlineKeyboardButtonData(I18n(u.User, "btn_room_settings"), settingBtn.ID.Hex())},
+			})},
+		Send: true,
+	}
+}
+
+// MergeVirtualMember moves operations of virtual member to the joined user
+type MergeVirtualMember struct {
+	rs  RoomService
+	cfg *Config
+}
+
+func NewMergeVirtualMember(rs RoomService, cfg *Config) *MergeVirtualMember {
+	return &MergeVirtualMember{
+		rs:  rs,
+		cfg: cfg,
+	}
+}
+
+func (bot MergeVirtualMember) HasReact(u *api.Update) bool {
+	return hasAction(u, mergeVirtualMember)
+}
+
+func (bot *MergeVirtualMember) OnMessage(ctx context.Context, u *api.Update) (response api.TelegramMessage) {
+	roomId := u.Button.CallbackData.RoomId
+	if err := bot.rs.MergeVirtualMember(ctx, roomId, u.Button.CallbackData.UserId, *getFrom(u)); errors.Is(err, api.ErrMergeConflict) {
+		log.Ctx(ctx).Info().Err(err).Msg("virtual member is not merged")
+		return api.TelegramMessage{
+			CallbackConfig: createCallback(u, I18n(u.User, "msg_merge_conflict"), true),
+			Send:           true,
+		}
+	} else if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("merge virtual member failed, room:%s", roomId)
+		return
+	}
+
+	callback := createCallback(u, I18n(u.User, "msg_virtual_member_merged"), true)
+	u.Button = api.NewButton(viewRoom, &api.CallbackData{RoomId: roomId})
+	return api.TelegramMessage{
+		CallbackConfig: callback,
+		Redirect:       u,
+		Send:           true,
+	}
+}
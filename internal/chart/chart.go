@@ -0,0 +1,386 @@
+// Package chart renders statistics of rooms to PNG images without external services
+package chart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	width  = 800
+	height = 500
+	margin = 40
+)
+
+var (
+	background = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	foreground = color.RGBA{R: 40, G: 40, B: 40, A: 255}
+	grid       = color.RGBA{R: 220, G: 220, B: 220, A: 255}
+	palette    = []color.RGBA{
+		{R: 66, G: 133, B: 244, A: 255},
+		{R: 234, G: 67, B: 53, A: 255},
+		{R: 251, G: 188, B: 5, A: 255},
+		{R: 52, G: 168, B: 83, A: 255},
+		{R: 255, G: 109, B: 1, A: 255},
+		{R: 70, G: 189, B: 198, A: 255},
+		{R: 171, G: 71, B: 188, A: 255},
+		{R: 158, G: 157, B: 36, A: 255},
+	}
+)
+
+var (
+	fontOnce sync.Once
+	fontErr  error
+	regular  *opentype.Font
+)
+
+// Value is a labeled value of pie and bar charts
+type Value struct {
+	Label string
+	Value float64
+}
+
+// Point is a value of the line chart at the moment
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Edge is an arrow of the graph from one node to another
+type Edge struct {
+	From  string
+	To    string
+	Label string
+}
+
+// Pie renders shares of values as a pie with the legend
+func Pie(title string, values []Value) ([]byte, error) {
+	c, err := newCanvas(title)
+	if err != nil {
+		return nil, err
+	}
+	var total float64
+	for _, v := range values {
+		total += v.Value
+	}
+	cx, cy, r := 230, 280, 180
+	if total > 0 {
+		for y := cy - r; y <= cy+r; y++ {
+			for x := cx - r; x <= cx+r; x++ {
+				dx, dy := float64(x-cx), float64(y-cy)
+				if dx*dx+dy*dy > float64(r*r) {
+					continue
+				}
+				// sectors go clockwise from the top
+				angle := math.Atan2(dx, -dy)
+				if angle < 0 {
+					angle += 2 * math.Pi
+				}
+				c.img.Set(x, y, palette[sector(values, total, angle/(2*math.Pi))%len(palette)])
+			}
+		}
+	}
+
+	y := 120
+	for i, v := range values {
+		c.fillRect(460, y-14, 478, y+4, palette[i%len(palette)])
+		percent := 0
+		if total > 0 {
+			percent = int(math.Round(v.Value * 100 / total))
+		}
+		c.text(488, y, printable(v.Label)+": "+formatNumber(v.Value)+" ("+strconv.Itoa(percent)+"%)", foreground)
+		y += 30
+	}
+	return c.png()
+}
+
+// Bar renders values as horizontal bars, the longest bar is the biggest value
+func Bar(title string, values []Value) ([]byte, error) {
+	c, err := newCanvas(title)
+	if err != nil {
+		return nil, err
+	}
+	var max float64
+	for _, v := range values {
+		max = math.Max(max, v.Value)
+	}
+	left, right, top := 220, width-margin-100, 90
+	row := (height - top - margin) / maxInt(len(values), 1)
+	if row > 50 {
+		row = 50
+	}
+	for i, v := range values {
+		y := top + i*row
+		c.text(left-10-c.textWidth(v.Label), y+row/2+5, v.Label, foreground)
+		length := 0
+		if max > 0 {
+			length = int(float64(right-left) * v.Value / max)
+		}
+		c.fillRect(left, y+row/6, left+length, y+row-row/6, palette[i%len(palette)])
+		c.text(left+length+8, y+row/2+5, formatNumber(v.Value), foreground)
+	}
+	return c.png()
+}
+
+// Line renders values over time with axes, points are sorted by time
+func Line(title string, points []Point) ([]byte, error) {
+	c, err := newCanvas(title)
+	if err != nil {
+		return nil, err
+	}
+	left, right, top, bottom := 100, width-margin, 80, height-60
+	var max float64
+	for _, p := range points {
+		max = math.Max(max, p.Value)
+	}
+	if max == 0 {
+		max = 1
+	}
+	for i := 0; i <= 4; i++ {
+		y := bottom - (bottom-top)*i/4
+		c.line(left, y, right, y, 1, grid)
+		label := formatNumber(max * float64(i) / 4)
+		c.text(left-10-c.textWidth(label), y+5, label, foreground)
+	}
+	c.line(left, top, left, bottom, 2, foreground)
+	c.line(left, bottom, right, bottom, 2, foreground)
+	if len(points) == 0 {
+		return c.png()
+	}
+
+	start, end := points[0].Time, points[len(points)-1].Time
+	span := end.Sub(start).Seconds()
+	position := func(p Point) (int, int) {
+		x := left
+		if span > 0 {
+			x = left + int(float64(right-left)*p.Time.Sub(start).Seconds()/span)
+		}
+		return x, bottom - int(float64(bottom-top)*p.Value/max)
+	}
+	px, py := position(points[0])
+	for _, p := range points[1:] {
+		x, y := position(p)
+		c.line(px, py, x, y, 3, palette[0])
+		px, py = x, y
+	}
+	for _, p := range points {
+		x, y := position(p)
+		c.disk(x, y, 5, palette[1])
+	}
+	c.text(left, bottom+30, start.Format("02.01.2006"), foreground)
+	endLabel := end.Format("02.01.2006")
+	c.text(right-c.textWidth(endLabel), bottom+30, endLabel, foreground)
+	return c.png()
+}
+
+// Graph renders nodes on a circle and arrows between them with labels
+func Graph(title string, edges []Edge) ([]byte, error) {
+	c, err := newCanvas(title)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []string
+	index := map[string]int{}
+	for _, e := range edges {
+		for _, n := range []string{e.From, e.To} {
+			if _, ok := index[n]; !ok {
+				index[n] = len(nodes)
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	cx, cy, r, nodeR := width/2, height/2+20, 170, 28
+	positions := make([][2]int, len(nodes))
+	for i := range nodes {
+		angle := 2*math.Pi*float64(i)/float64(len(nodes)) - math.Pi/2
+		positions[i] = [2]int{cx + int(float64(r)*math.Cos(angle)), cy + int(float64(r)*math.Sin(angle))}
+	}
+
+	for _, e := range edges {
+		from, to := positions[index[e.From]], positions[index[e.To]]
+		dx, dy := float64(to[0]-from[0]), float64(to[1]-from[1])
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			continue
+		}
+		ux, uy := dx/length, dy/length
+		x0, y0 := from[0]+int(ux*float64(nodeR)), from[1]+int(uy*float64(nodeR))
+		x1, y1 := to[0]-int(ux*float64(nodeR)), to[1]-int(uy*float64(nodeR))
+		c.arrow(x0, y0, x1, y1, palette[1])
+		mx, my := (x0+x1)/2, (y0+y1)/2
+		c.text(mx-c.textWidth(e.Label)/2, my-8, e.Label, foreground)
+	}
+	for i, n := range nodes {
+		p := positions[i]
+		c.disk(p[0], p[1], nodeR, palette[0])
+		c.text(p[0]-c.textWidth(n)/2, p[1]+nodeR+20, n, foreground)
+	}
+	return c.png()
+}
+
+// sector returns index of the value whose share contains the position, position is from 0 to 1
+func sector(values []Value, total float64, position float64) int {
+	var sum float64
+	for i, v := range values {
+		sum += v.Value / total
+		if position <= sum {
+			return i
+		}
+	}
+	return len(values) - 1
+}
+
+type canvas struct {
+	img  *image.RGBA
+	face font.Face
+}
+
+func newCanvas(title string) (*canvas, error) {
+	fontOnce.Do(func() {
+		regular, fontErr = opentype.Parse(goregular.TTF)
+	})
+	if fontErr != nil {
+		return nil, fontErr
+	}
+	face, err := opentype.NewFace(regular, &opentype.FaceOptions{Size: 16, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	titleFace, err := opentype.NewFace(regular, &opentype.FaceOptions{Size: 24, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &canvas{img: image.NewRGBA(image.Rect(0, 0, width, height)), face: titleFace}
+	draw.Draw(c.img, c.img.Bounds(), &image.Uniform{C: background}, image.Point{}, draw.Src)
+	c.text((width-c.textWidth(title))/2, margin, title, foreground)
+	c.face = face
+	return c, nil
+}
+
+func (c *canvas) png() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *canvas) fillRect(x0, y0, x1, y1 int, col color.RGBA) {
+	draw.Draw(c.img, image.Rect(x0, y0, x1, y1), &image.Uniform{C: col}, image.Point{}, draw.Src)
+}
+
+func (c *canvas) disk(cx, cy, r int, col color.RGBA) {
+	for y := -r; y <= r; y++ {
+		for x := -r; x <= r; x++ {
+			if x*x+y*y <= r*r {
+				c.img.Set(cx+x, cy+y, col)
+			}
+		}
+	}
+}
+
+func (c *canvas) line(x0, y0, x1, y1, thickness int, col color.RGBA) {
+	steps := maxInt(absInt(x1-x0), absInt(y1-y0))
+	for i := 0; i <= steps; i++ {
+		t := 0.0
+		if steps > 0 {
+			t = float64(i) / float64(steps)
+		}
+		x := x0 + int(math.Round(t*float64(x1-x0)))
+		y := y0 + int(math.Round(t*float64(y1-y0)))
+		if thickness <= 1 {
+			c.img.Set(x, y, col)
+		} else {
+			c.disk(x, y, thickness/2, col)
+		}
+	}
+}
+
+// arrow draws the line with a filled head at the end
+func (c *canvas) arrow(x0, y0, x1, y1 int, col color.RGBA) {
+	c.line(x0, y0, x1, y1, 3, col)
+	angle := math.Atan2(float64(y1-y0), float64(x1-x0))
+	size := 16.0
+	ax, ay := float64(x1)-size*math.Cos(angle-0.4), float64(y1)-size*math.Sin(angle-0.4)
+	bx, by := float64(x1)-size*math.Cos(angle+0.4), float64(y1)-size*math.Sin(angle+0.4)
+	minX, maxX := int(math.Min(float64(x1), math.Min(ax, bx))), int(math.Max(float64(x1), math.Max(ax, bx)))
+	minY, maxY := int(math.Min(float64(y1), math.Min(ay, by))), int(math.Max(float64(y1), math.Max(ay, by)))
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			if inTriangle(float64(x), float64(y), float64(x1), float64(y1), ax, ay, bx, by) {
+				c.img.Set(x, y, col)
+			}
+		}
+	}
+}
+
+// text draws the string from the baseline point, symbols missing in the font are skipped
+func (c *canvas) text(x, y int, s string, col color.RGBA) {
+	d := &font.Drawer{Dst: c.img, Src: &image.Uniform{C: col}, Face: c.face, Dot: fixed.P(x, y)}
+	d.DrawString(printable(s))
+}
+
+func (c *canvas) textWidth(s string) int {
+	return font.MeasureString(c.face, printable(s)).Round()
+}
+
+func inTriangle(px, py, ax, ay, bx, by, cx, cy float64) bool {
+	d1 := (px-bx)*(ay-by) - (ax-bx)*(py-by)
+	d2 := (px-cx)*(by-cy) - (bx-cx)*(py-cy)
+	d3 := (px-ax)*(cy-ay) - (cx-ax)*(py-ay)
+	hasNeg := d1 < 0 || d2 < 0 || d3 < 0
+	hasPos := d1 > 0 || d2 > 0 || d3 > 0
+	return !(hasNeg && hasPos)
+}
+
+// printable removes emoji and other symbols, which the font can not draw
+func printable(s string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || unicode.IsPunct(r) || strings.ContainsRune("$₽+-=<>%", r) {
+			return r
+		}
+		return -1
+	}, s))
+}
+
+// formatNumber returns the rounded number with spaces between thousands, example = 12 500
+func formatNumber(v float64) string {
+	s := strconv.Itoa(int(math.Round(v)))
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + " " + s[i:]
+	}
+	return sign + s
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
@@ -0,0 +1,50 @@
+package chart
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCharts(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	values := []Value{{Label: "Food", Value: 1500}, {Label: "Taxi 🚕", Value: 700}, {Label: "Hotel", Value: 4200}}
+	zero := []Value{{Label: "Food", Value: 0}, {Label: "Taxi", Value: 0}}
+	points := []Point{{Time: now, Value: 100}, {Time: now.Add(24 * time.Hour), Value: 250}, {Time: now.Add(72 * time.Hour), Value: 50}}
+
+	tests := []struct {
+		name   string
+		render func() ([]byte, error)
+	}{
+		{name: "pie", render: func() ([]byte, error) { return Pie("Spent by categories", values) }},
+		{name: "pie empty", render: func() ([]byte, error) { return Pie("Spent by categories", nil) }},
+		{name: "pie zero sum", render: func() ([]byte, error) { return Pie("Spent by categories", zero) }},
+		{name: "bar", render: func() ([]byte, error) { return Bar("Spent by members", values) }},
+		{name: "bar empty", render: func() ([]byte, error) { return Bar("Spent by members", nil) }},
+		{name: "bar zero sum", render: func() ([]byte, error) { return Bar("Spent by members", zero) }},
+		{name: "line", render: func() ([]byte, error) { return Line("Spent by days", points) }},
+		{name: "line empty", render: func() ([]byte, error) { return Line("Spent by days", nil) }},
+		{name: "line one point", render: func() ([]byte, error) { return Line("Spent by days", points[:1]) }},
+		{name: "line zero sum", render: func() ([]byte, error) {
+			return Line("Spent by days", []Point{{Time: now}, {Time: now.Add(time.Hour)}})
+		}},
+		{name: "graph", render: func() ([]byte, error) {
+			return Graph("Debts", []Edge{{From: "Ann", To: "Bob", Label: "300"}, {From: "Bob", To: "Ann", Label: "100"}, {From: "Kim", To: "Kim", Label: "0"}})
+		}},
+		{name: "graph empty", render: func() ([]byte, error) { return Graph("Debts", nil) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.render()
+			assert.NoError(t, err)
+			assert.NotEmpty(t, b)
+			img, err := png.Decode(bytes.NewReader(b))
+			assert.NoError(t, err)
+			assert.Equal(t, width, img.Bounds().Dx())
+			assert.Equal(t, height, img.Bounds().Dy())
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package events
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/bot"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+type ConsistencyService interface {
+	Due(ctx context.Context, now time.Time) (*bot.Report, error)
+	Sent(ctx context.Context, r *bot.Report) error
+}
+
+// ConsistencyScheduler periodically validates rooms and reports violations to super users
+type ConsistencyScheduler struct {
+	Outbox             *Outbox
+	ConsistencyService ConsistencyService
+	Interval           time.Duration
+}
+
+// Do validates rooms every interval until context is done, blocked call
+func (s *ConsistencyScheduler) Do(ctx context.Context) error {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			s.send(ctx, now)
+		}
+	}
+}
+
+func (s *ConsistencyScheduler) send(ctx context.Context, now time.Time) {
+	report, err := s.ConsistencyService.Due(ctx, now)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("can't validate rooms")
+		return
+	}
+	if err := s.Outbox.EnqueueAll(ctx, report.Messages); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("violations are not reported, they are due again")
+		return
+	}
+	if err := s.ConsistencyService.Sent(ctx, report); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("save reported violations failed")
+	}
+}
@@ -0,0 +1,51 @@
+package events
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/bot"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+type DigestService interface {
+	Due(ctx context.Context, now time.Time) ([]bot.Digest, error)
+	Sent(ctx context.Context, d bot.Digest) error
+}
+
+// DigestScheduler periodically sends spending digests to subscribed users
+type DigestScheduler struct {
+	Outbox        *Outbox
+	DigestService DigestService
+	Interval      time.Duration
+}
+
+// Do checks digests every interval until context is done, blocked call
+func (s *DigestScheduler) Do(ctx context.Context) error {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			s.send(ctx, now)
+		}
+	}
+}
+
+func (s *DigestScheduler) send(ctx context.Context, now time.Time) {
+	digests, err := s.DigestService.Due(ctx, now)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("can't create digests")
+		return
+	}
+	for _, d := range digests {
+		if err := s.Outbox.EnqueueAll(ctx, d.Messages); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("digest to user %d is not sent, it is due again", d.UserId)
+			continue
+		}
+		if err := s.DigestService.Sent(ctx, d); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("save digest failed")
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/almaznur91/splitty/internal/api"
+	tbapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"os"
+	"sync"
+	"time"
+)
+
+// journalLineLimit is the max size of one journaled update, updates are much smaller
+const journalLineLimit = 16 * 1024 * 1024
+
+// redacted replaces texts, which may contain bank details or payment methods
+const redacted = "<redacted>"
+
+// JournalEntry is the raw update received from telegram at the moment with the pressed button,
+// the button is restored by the replay, because the scratch database has no buttons of the bot
+type JournalEntry struct {
+	Time   time.Time    `json:"time"`
+	Update tbapi.Update `json:"update"`
+	Button *api.Button  `json:"button,omitempty"`
+}
+
+// NewJournalEntry returns the entry without secrets: the text is redacted when the user types bank details or
+// a payment method, texts of bot messages are redacted in callbacks and private replies, bots do not read them there
+func NewJournalEntry(u tbapi.Update, btn *api.Button, secret bool, now time.Time) JournalEntry {
+	u.Message = redactMessage(u.Message, secret)
+	u.EditedMessage = redactMessage(u.EditedMessage, secret)
+	if u.CallbackQuery != nil {
+		q := *u.CallbackQuery
+		q.Message = redactMessage(q.Message, true)
+		u.CallbackQuery = &q
+	}
+	return JournalEntry{Time: now, Update: u, Button: btn}
+}
+
+// redactMessage returns the copy of the message with redacted texts, the original message is not changed
+func redactMessage(m *tbapi.Message, text bool) *tbapi.Message {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	if text {
+		c.Text, c.Entities = redacted, nil
+	}
+	if c.ReplyToMessage != nil && c.Chat != nil && c.Chat.IsPrivate() {
+		c.ReplyToMessage = redactMessage(c.ReplyToMessage, true)
+	}
+	return &c
+}
+
+// Journal writes raw updates to the file as json lines. The file is rotated, when it grows over MaxSize:
+// the file becomes file.1, file.1 becomes file.2 and so on, MaxFiles rotated files are kept
+type Journal struct {
+	Path     string
+	MaxSize  int64
+	MaxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Write appends the entry to the journal
+func (j *Journal) Write(e JournalEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file != nil && j.MaxSize > 0 && j.size+int64(len(line)) > j.MaxSize {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+	if j.file == nil {
+		if err := j.open(); err != nil {
+			return err
+		}
+	}
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	return err
+}
+
+// Close closes the current file of the journal
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+func (j *Journal) open() error {
+	f, err := os.OpenFile(j.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	j.file, j.size = f, info.Size()
+	return nil
+}
+
+func (j *Journal) rotate() error {
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	j.file = nil
+	if j.MaxFiles <= 0 {
+		return os.Remove(j.Path)
+	}
+	for i := j.MaxFiles; i > 0; i-- {
+		from := j.Path
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", j.Path, i-1)
+		}
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", j.Path, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadJournal returns entries of the journal file in order of receiving
+func ReadJournal(path string) ([]JournalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), journalLineLimit)
+	for line := 1; scanner.Scan(); line++ {
+		var e JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("journal %s, line %d: %w", path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
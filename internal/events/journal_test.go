@@ -0,0 +1,68 @@
+package events
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/almaznur91/splitty/internal/api"
+	tbapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestJournalRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "updates.jsonl")
+	line := func(id int) JournalEntry {
+		return JournalEntry{Time: time.Unix(0, 0).UTC(), Update: tbapi.Update{UpdateID: id}}
+	}
+	j := &Journal{Path: path, MaxSize: 100, MaxFiles: 2}
+	for id := 1; id <= 6; id++ {
+		assert.NoError(t, j.Write(line(id)))
+	}
+	assert.NoError(t, j.Close())
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	var ids []int
+	for _, p := range []string{path + ".2", path + ".1", path} {
+		entries, err := ReadJournal(p)
+		assert.NoError(t, err)
+		for _, e := range entries {
+			ids = append(ids, e.Update.UpdateID)
+		}
+	}
+	// every entry is about 80 bytes, so each file keeps one entry and the oldest ones are removed
+	assert.Equal(t, []int{4, 5, 6}, ids)
+}
+
+func TestJournalEntryRedaction(t *testing.T) {
+	private := &tbapi.Chat{ID: 1, Type: "private"}
+	group := &tbapi.Chat{ID: -1, Type: "group"}
+	reply := &tbapi.Message{Text: "IBAN DE89 3704 0044 0532 0130 00", Chat: private}
+	u := tbapi.Update{
+		Message: &tbapi.Message{Text: "DE89 3704 0044 0532 0130 00", Chat: private, ReplyToMessage: reply},
+	}
+	e := NewJournalEntry(u, nil, true, time.Now())
+	assert.Equal(t, redacted, e.Update.Message.Text)
+	assert.Equal(t, redacted, e.Update.Message.ReplyToMessage.Text)
+	assert.Equal(t, "DE89 3704 0044 0532 0130 00", u.Message.Text, "the processed update is not changed")
+	assert.Equal(t, "IBAN DE89 3704 0044 0532 0130 00", reply.Text)
+
+	u.Message.Chat, reply.Chat = group, group
+	e = NewJournalEntry(u, nil, false, time.Now())
+	assert.Equal(t, "DE89 3704 0044 0532 0130 00", e.Update.Message.Text)
+	assert.Equal(t, "IBAN DE89 3704 0044 0532 0130 00", e.Update.Message.ReplyToMessage.Text, "operations are replied in groups")
+
+	btn := api.NewButton("view_room", &api.CallbackData{RoomId: primitive.NewObjectID().Hex()})
+	u = tbapi.Update{CallbackQuery: &tbapi.CallbackQuery{Data: btn.ID.Hex(), Message: &tbapi.Message{Text: "pay to IBAN DE89", Chat: private}}}
+	e = NewJournalEntry(u, btn, false, time.Now())
+	assert.Equal(t, redacted, e.Update.CallbackQuery.Message.Text)
+	assert.Equal(t, btn, e.Button)
+	assert.Equal(t, "pay to IBAN DE89", u.CallbackQuery.Message.Text)
+}
@@ -0,0 +1,132 @@
+package events
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is the count of chat buckets, after which buckets of idle chats are removed
+const maxIdleBuckets = 10000
+
+// Limiter limits sending to telegram by token buckets, one global and one for every chat.
+// Group chats have their own rate, telegram allows less messages to groups than to users
+type Limiter struct {
+	mu        sync.Mutex
+	global    *bucket
+	chats     map[int64]*bucket
+	chatRate  float64
+	groupRate float64
+	lastHeld  int64 // the chat held last time, until lastUntil
+	lastUntil time.Time
+}
+
+// bucket gets rate tokens every second up to burst, every message takes one token
+type bucket struct {
+	rate      float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+	holdUntil time.Time
+}
+
+// NewLimiter makes the limiter, rates are messages per second
+func NewLimiter(globalRate float64, chatRate float64, groupRate float64) *Limiter {
+	return &Limiter{
+		global:    newBucket(globalRate),
+		chats:     map[int64]*bucket{},
+		chatRate:  chatRate,
+		groupRate: groupRate,
+	}
+}
+
+func newBucket(rate float64) *bucket {
+	burst := math.Max(rate, 1)
+	return &bucket{rate: rate, burst: burst, tokens: burst}
+}
+
+// Allow takes tokens for the message to the chat, if the global and chat buckets both have them.
+// Otherwise nothing is taken and the delay is returned, after which tokens are expected
+func (l *Limiter) Allow(chatId int64, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	chat := l.chat(chatId, now)
+	wait := math.Max(l.global.wait(now), chat.wait(now))
+	if wait > 0 {
+		return false, time.Duration(wait * float64(time.Second))
+	}
+	l.global.tokens--
+	chat.tokens--
+	return true, 0
+}
+
+// Hold stops sending to the chat for the delay, telegram asks it with retry_after. Telegram doesn't tell which
+// limit is exceeded, so sending to all chats is held, if the message has no chat or another chat is already held
+func (l *Limiter) Hold(chatId int64, now time.Time, delay time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until := now.Add(delay)
+	if chatId == 0 || (chatId != l.lastHeld && now.Before(l.lastUntil)) {
+		l.global.hold(until)
+	}
+	if chatId == 0 {
+		return
+	}
+	l.chat(chatId, now).hold(until)
+	l.lastHeld, l.lastUntil = chatId, until
+}
+
+func (l *Limiter) chat(chatId int64, now time.Time) *bucket {
+	b, ok := l.chats[chatId]
+	if ok {
+		return b
+	}
+	if len(l.chats) >= maxIdleBuckets {
+		for id, c := range l.chats {
+			if c.idle(now) {
+				delete(l.chats, id)
+			}
+		}
+	}
+	rate := l.chatRate
+	if chatId < 0 {
+		rate = l.groupRate
+	}
+	b = newBucket(rate)
+	l.chats[chatId] = b
+	return b
+}
+
+func (b *bucket) hold(until time.Time) {
+	if until.After(b.holdUntil) {
+		b.holdUntil = until
+	}
+}
+
+// idle checks that the bucket is full and not held, the bucket is not changed
+func (b *bucket) idle(now time.Time) bool {
+	tokens := b.tokens
+	if !b.last.IsZero() && now.After(b.last) {
+		tokens += now.Sub(b.last).Seconds() * b.rate
+	}
+	return !now.Before(b.holdUntil) && (b.rate <= 0 || tokens >= b.burst)
+}
+
+// wait refills the bucket and returns seconds until it has a token, the bucket without rate is unlimited
+func (b *bucket) wait(now time.Time) float64 {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+	switch {
+	case now.Before(b.holdUntil):
+		return b.holdUntil.Sub(now).Seconds()
+	case b.rate <= 0, b.tokens >= 1:
+		return 0
+	default:
+		return (1 - b.tokens) / b.rate
+	}
+}
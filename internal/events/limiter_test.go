@@ -0,0 +1,84 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLimiter(30, 1, 0.5)
+
+	ok, _ := l.Allow(1, now)
+	assert.True(t, ok)
+	ok, wait := l.Allow(1, now)
+	assert.False(t, ok, "the chat gets one message per second")
+	assert.Equal(t, time.Second, wait)
+	ok, _ = l.Allow(2, now)
+	assert.True(t, ok, "other chats have their own buckets")
+	ok, _ = l.Allow(1, now.Add(time.Second))
+	assert.True(t, ok)
+
+	ok, _ = l.Allow(-1, now)
+	assert.True(t, ok)
+	ok, wait = l.Allow(-1, now.Add(time.Second))
+	assert.False(t, ok, "groups get one message in two seconds")
+	assert.Equal(t, time.Second, wait)
+}
+
+func TestLimiterGlobalRate(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLimiter(2, 10, 10)
+	for chatId := int64(1); chatId <= 2; chatId++ {
+		ok, _ := l.Allow(chatId, now)
+		assert.True(t, ok)
+	}
+	ok, wait := l.Allow(3, now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+}
+
+func TestLimiterHold(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLimiter(30, 1, 1)
+
+	l.Hold(1, now, 10*time.Second)
+	ok, wait := l.Allow(1, now.Add(time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, 9*time.Second, wait)
+	ok, _ = l.Allow(2, now.Add(time.Second))
+	assert.True(t, ok, "the limit of one chat doesn't hold others")
+	ok, _ = l.Allow(1, now.Add(10*time.Second))
+	assert.True(t, ok)
+
+	l.Hold(3, now.Add(20*time.Second), 5*time.Second)
+	l.Hold(4, now.Add(21*time.Second), 5*time.Second)
+	ok, wait = l.Allow(5, now.Add(22*time.Second))
+	assert.False(t, ok, "two chats are limited, so the limit is global")
+	assert.Equal(t, 4*time.Second, wait)
+
+	l.Hold(0, now.Add(40*time.Second), 3*time.Second)
+	ok, _ = l.Allow(6, now.Add(41*time.Second))
+	assert.False(t, ok, "the message without chat is limited globally")
+	ok, _ = l.Allow(6, now.Add(43*time.Second))
+	assert.True(t, ok)
+}
+
+func TestLimiterEviction(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLimiter(0, 1, 0.5)
+	for chatId := int64(1); chatId < maxIdleBuckets; chatId++ {
+		l.Allow(chatId, now)
+	}
+	l.Allow(-1, now)
+	l.Hold(maxIdleBuckets, now, time.Minute)
+	assert.Len(t, l.chats, maxIdleBuckets+1, "chats without tokens aren't idle")
+
+	ok, _ := l.Allow(-1, now.Add(2*time.Second))
+	assert.True(t, ok, "the hold doesn't stall other chats")
+
+	l.Allow(maxIdleBuckets+1, now.Add(3*time.Second))
+	assert.Len(t, l.chats, 3, "idle chats are removed, the held one and the group without tokens are kept")
+}
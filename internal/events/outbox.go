@@ -0,0 +1,325 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/almaznur91/splitty/internal/api"
+	"github.com/almaznur91/splitty/internal/metrics"
+	tbapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+const (
+	outboxBatch    = 100
+	backoffBase    = time.Second
+	backoffMaximum = 10 * time.Minute
+)
+
+// kinds of telegram configs, which can be saved in the outbox
+const (
+	kindMessage  = "message"
+	kindEditText = "edit_text"
+	kindPhoto    = "photo"
+	kindDocument = "document"
+	kindVideo    = "video"
+)
+
+type OutboxService interface {
+	Push(ctx context.Context, m *api.OutgoingMessage) error
+	FindDue(ctx context.Context, now time.Time, limit int64) ([]api.OutgoingMessage, error)
+	Retry(ctx context.Context, id primitive.ObjectID, attempts int, next time.Time, lastError string) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	Bury(ctx context.Context, m *api.OutgoingMessage) error
+	Count(ctx context.Context) (int64, error)
+}
+
+// Outbox sends messages to telegram within rate limits. Messages, which can not be sent at once, are saved
+// and retried later with exponential backoff, undeliverable messages are moved to dead letters
+type Outbox struct {
+	TbAPI         tbAPI
+	OutboxService OutboxService
+	Limiter       *Limiter
+	Interval      time.Duration
+	MaxAttempts   int
+}
+
+// Do sends saved messages every interval until context is done, blocked call
+func (o *Outbox) Do(ctx context.Context) error {
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			o.sendDue(ctx, now)
+		}
+	}
+}
+
+// ErrPostponed is returned by Send, when the message is saved to the outbox because of rate limits
+var ErrPostponed = errors.New("message is postponed by rate limits")
+
+// Send sends the message at once, the response is needed by the caller. Send never waits for rate limits, updates
+// are handled one by one and waiting would stop replies to all users: the limited message is saved to the outbox
+// and ErrPostponed is returned. The failed message is saved for retry, if it can be delivered later
+func (o *Outbox) Send(ctx context.Context, c tbapi.Chattable) (tbapi.Message, error) {
+	chatId := chatOf(c)
+	if ok, _ := o.Limiter.Allow(chatId, time.Now()); !ok {
+		m, err := encode(c)
+		if err == nil {
+			err = o.OutboxService.Push(ctx, m)
+		}
+		if err == nil {
+			return tbapi.Message{}, ErrPostponed
+		}
+		log.Ctx(ctx).Warn().Err(err).Msgf("limited message to chat %d can't be postponed, it is sent at once", chatId)
+	}
+	msg, err := o.TbAPI.Send(c)
+	if err == nil {
+		return msg, nil
+	}
+	m, encErr := encode(c)
+	if encErr != nil {
+		o.hold(chatId, err)
+		return msg, err
+	}
+	o.failed(ctx, m, err, false)
+	return msg, err
+}
+
+// Enqueue saves the message for sending by the outbox, the message which can not be saved is sent at once.
+// The error is returned, if the message is neither saved nor sent
+func (o *Outbox) Enqueue(ctx context.Context, c tbapi.Chattable) error {
+	m, err := encode(c)
+	if err == nil {
+		err = o.OutboxService.Push(ctx, m)
+	}
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("can't save message to outbox, it is sent at once")
+		if _, err := o.Send(ctx, c); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("can't send message to telegram %v", c)
+			return err
+		}
+	}
+	return nil
+}
+
+// EnqueueAll enqueues messages in order, it stops at the first message, which is neither saved nor sent
+func (o *Outbox) EnqueueAll(ctx context.Context, messages []tbapi.Chattable) error {
+	for _, c := range messages {
+		if err := o.Enqueue(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendDue sends saved messages in order of creation, messages to the chat are postponed after the first limited one
+func (o *Outbox) sendDue(ctx context.Context, now time.Time) {
+	if depth, err := o.OutboxService.Count(ctx); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("can't count outgoing messages")
+	} else {
+		metrics.OutboxDepth.Set(float64(depth))
+	}
+	messages, err := o.OutboxService.FindDue(ctx, now, outboxBatch)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("can't find outgoing messages")
+		return
+	}
+	limited := map[int64]bool{}
+	for i := range messages {
+		m := &messages[i]
+		if limited[m.ChatId] {
+			continue
+		}
+		c, err := decode(m)
+		if err != nil {
+			m.LastError = err.Error()
+			o.bury(ctx, m)
+			continue
+		}
+		if ok, _ := o.Limiter.Allow(m.ChatId, time.Now()); !ok {
+			limited[m.ChatId] = true
+			continue
+		}
+		if _, err := o.TbAPI.Send(c); err != nil {
+			limited[m.ChatId] = true
+			o.failed(ctx, m, err, true)
+			continue
+		}
+		if err := o.OutboxService.Delete(ctx, m.ID); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("can't delete sent message %s", m.ID.Hex())
+		}
+	}
+}
+
+// failed schedules the next attempt of the message or buries it, saved is false for messages not in the outbox yet
+func (o *Outbox) failed(ctx context.Context, m *api.OutgoingMessage, sendErr error, saved bool) {
+	if isNotModified(sendErr) {
+		// the edit has nothing to change, the message is as it should be
+		if saved {
+			if err := o.OutboxService.Delete(ctx, m.ID); err != nil {
+				log.Ctx(ctx).Error().Err(err).Msgf("can't delete sent message %s", m.ID.Hex())
+			}
+		}
+		return
+	}
+	m.Attempts++
+	m.LastError = sendErr.Error()
+	delay, retry := retryDelay(sendErr, m.Attempts)
+	o.hold(m.ChatId, sendErr)
+	if !retry || m.Attempts >= o.MaxAttempts {
+		log.Ctx(ctx).Error().Err(sendErr).Msgf("message to chat %d is undeliverable after %d attempts", m.ChatId, m.Attempts)
+		o.bury(ctx, m)
+		return
+	}
+	log.Ctx(ctx).Warn().Err(sendErr).Msgf("message to chat %d is postponed for %v", m.ChatId, delay)
+	m.NextAttemptAt = time.Now().Add(delay)
+	var err error
+	if saved {
+		err = o.OutboxService.Retry(ctx, m.ID, m.Attempts, m.NextAttemptAt, m.LastError)
+	} else {
+		err = o.OutboxService.Push(ctx, m)
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("can't save message for retry")
+	}
+}
+
+// hold stops sending, if telegram asks it with retry_after
+func (o *Outbox) hold(chatId int64, sendErr error) {
+	if e, ok := sendErr.(tbapi.Error); ok && e.RetryAfter > 0 {
+		o.Limiter.Hold(chatId, time.Now(), time.Duration(e.RetryAfter)*time.Second)
+	}
+}
+
+func (o *Outbox) bury(ctx context.Context, m *api.OutgoingMessage) {
+	if err := o.OutboxService.Bury(ctx, m); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("can't save dead letter")
+	}
+}
+
+// retryDelay returns the delay before the next attempt, false if the message will never be delivered.
+// Telegram tells the delay on flood limits, client errors are permanent, other errors are retried with exponential backoff
+func retryDelay(err error, attempts int) (time.Duration, bool) {
+	if e, ok := err.(tbapi.Error); ok {
+		if e.RetryAfter > 0 {
+			return time.Duration(e.RetryAfter) * time.Second, true
+		}
+		if e.Code >= 400 && e.Code < 500 {
+			return 0, false
+		}
+	}
+	delay := backoffBase
+	for i := 1; i < attempts && delay < backoffMaximum; i++ {
+		delay *= 2
+	}
+	if delay > backoffMaximum {
+		delay = backoffMaximum
+	}
+	return delay, true
+}
+
+func isNotModified(err error) bool {
+	e, ok := err.(tbapi.Error)
+	return ok && strings.Contains(e.Message, "message is not modified")
+}
+
+// encode converts the telegram config to the message saved in the outbox, uploaded files are saved separately
+func encode(c tbapi.Chattable) (*api.OutgoingMessage, error) {
+	m := &api.OutgoingMessage{ChatId: chatOf(c), CreateAt: time.Now()}
+	m.NextAttemptAt = m.CreateAt
+	var err error
+	switch v := c.(type) {
+	case tbapi.MessageConfig:
+		m.Kind = kindMessage
+		m.Payload, err = json.Marshal(v)
+	case tbapi.EditMessageTextConfig:
+		m.Kind = kindEditText
+		m.Payload, err = json.Marshal(v)
+	case tbapi.PhotoConfig:
+		m.Kind = kindPhoto
+		m.File, v.File = outgoingFile(v.BaseFile)
+		m.Payload, err = json.Marshal(v)
+	case tbapi.DocumentConfig:
+		m.Kind = kindDocument
+		m.File, v.File = outgoingFile(v.BaseFile)
+		m.Payload, err = json.Marshal(v)
+	case tbapi.VideoConfig:
+		m.Kind = kindVideo
+		m.File, v.File = outgoingFile(v.BaseFile)
+		m.Payload, err = json.Marshal(v)
+	default:
+		return nil, fmt.Errorf("unsupported outgoing message %T", c)
+	}
+	return m, err
+}
+
+func decode(m *api.OutgoingMessage) (tbapi.Chattable, error) {
+	switch m.Kind {
+	case kindMessage:
+		var v tbapi.MessageConfig
+		err := json.Unmarshal(m.Payload, &v)
+		return v, err
+	case kindEditText:
+		var v tbapi.EditMessageTextConfig
+		err := json.Unmarshal(m.Payload, &v)
+		return v, err
+	case kindPhoto:
+		var v tbapi.PhotoConfig
+		err := json.Unmarshal(m.Payload, &v)
+		v.BaseFile = withFile(v.BaseFile, m.File)
+		return v, err
+	case kindDocument:
+		var v tbapi.DocumentConfig
+		err := json.Unmarshal(m.Payload, &v)
+		v.BaseFile = withFile(v.BaseFile, m.File)
+		return v, err
+	case kindVideo:
+		var v tbapi.VideoConfig
+		err := json.Unmarshal(m.Payload, &v)
+		v.BaseFile = withFile(v.BaseFile, m.File)
+		return v, err
+	default:
+		return nil, fmt.Errorf("unknown outgoing message kind %q", m.Kind)
+	}
+}
+
+// outgoingFile returns the uploaded file of the config, files shared by id need nothing but FileID
+func outgoingFile(f tbapi.BaseFile) (*api.OutgoingFile, interface{}) {
+	if b, ok := f.File.(tbapi.FileBytes); ok {
+		return &api.OutgoingFile{Name: b.Name, Bytes: b.Bytes}, nil
+	}
+	return nil, f.File
+}
+
+func withFile(f tbapi.BaseFile, file *api.OutgoingFile) tbapi.BaseFile {
+	if file != nil {
+		f.File = tbapi.FileBytes{Name: file.Name, Bytes: file.Bytes}
+	}
+	return f
+}
+
+// chatOf returns the chat of the message, it is zero for edits of inline messages
+func chatOf(c tbapi.Chattable) int64 {
+	switch v := c.(type) {
+	case tbapi.MessageConfig:
+		return v.ChatID
+	case tbapi.EditMessageTextConfig:
+		return v.ChatID
+	case tbapi.PhotoConfig:
+		return v.ChatID
+	case tbapi.DocumentConfig:
+		return v.ChatID
+	case tbapi.VideoConfig:
+		return v.ChatID
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,108 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/almaznur91/splitty/internal/api"
+	tbapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+		delay    time.Duration
+		retry    bool
+	}{
+		{name: "flood", err: tbapi.Error{Code: 429, ResponseParameters: tbapi.ResponseParameters{RetryAfter: 7}}, attempts: 1, delay: 7 * time.Second, retry: true},
+		{name: "blocked", err: tbapi.Error{Code: 403, Message: "Forbidden: bot was blocked by the user"}, attempts: 1, retry: false},
+		{name: "server", err: tbapi.Error{Code: 502}, attempts: 1, delay: backoffBase, retry: true},
+		{name: "network", err: errors.New("connection reset"), attempts: 3, delay: 4 * backoffBase, retry: true},
+		{name: "maximum", err: errors.New("connection reset"), attempts: 30, delay: backoffMaximum, retry: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, retry := retryDelay(tt.err, tt.attempts)
+			assert.Equal(t, tt.retry, retry)
+			assert.Equal(t, tt.delay, delay)
+		})
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	msg := tbapi.NewMessage(1, "*hello*")
+	msg.ParseMode = tbapi.ModeMarkdown
+	msg.ReplyMarkup = tbapi.NewInlineKeyboardMarkup(tbapi.NewInlineKeyboardRow(tbapi.NewInlineKeyboardButtonData("ok", "id")))
+	edit := tbapi.NewEditMessageText(-2, 3, "edited")
+	photo := tbapi.NewPhotoUpload(4, tbapi.FileBytes{Name: "chart.png", Bytes: []byte{1, 2, 3}})
+	photo.Caption = "chart"
+	document := tbapi.NewDocumentShare(5, "file-id")
+
+	for _, c := range []tbapi.Chattable{msg, edit, photo, document} {
+		m, err := encode(c)
+		assert.NoError(t, err)
+		assert.Equal(t, chatOf(c), m.ChatId)
+		decoded, err := decode(m)
+		assert.NoError(t, err)
+		assert.IsType(t, c, decoded)
+		expected, _ := json.Marshal(c)
+		actual, _ := json.Marshal(decoded)
+		assert.JSONEq(t, string(expected), string(actual))
+	}
+	m, _ := encode(photo)
+	assert.Equal(t, &api.OutgoingFile{Name: "chart.png", Bytes: []byte{1, 2, 3}}, m.File, "the uploaded file is saved apart")
+
+	_, err := encode(tbapi.NewChatAction(1, tbapi.ChatTyping))
+	assert.Error(t, err)
+	_, err = decode(&api.OutgoingMessage{Kind: "sticker"})
+	assert.Error(t, err)
+}
+
+type fakeTbAPI struct {
+	tbAPI
+	sent []tbapi.Chattable
+}
+
+func (f *fakeTbAPI) Send(c tbapi.Chattable) (tbapi.Message, error) {
+	f.sent = append(f.sent, c)
+	return tbapi.Message{MessageID: len(f.sent)}, nil
+}
+
+type fakeOutboxService struct {
+	OutboxService
+	pushed []*api.OutgoingMessage
+}
+
+func (f *fakeOutboxService) Push(_ context.Context, m *api.OutgoingMessage) error {
+	f.pushed = append(f.pushed, m)
+	return nil
+}
+
+func TestOutboxSendNeverWaits(t *testing.T) {
+	tb, svc := &fakeTbAPI{}, &fakeOutboxService{}
+	o := &Outbox{TbAPI: tb, OutboxService: svc, Limiter: NewLimiter(30, 1, 1)}
+	o.Limiter.Hold(1, time.Now(), time.Hour)
+
+	start := time.Now()
+	_, err := o.Send(context.Background(), tbapi.NewMessage(1, "held"))
+	assert.Equal(t, ErrPostponed, err)
+	assert.Len(t, svc.pushed, 1, "the reply to the held chat waits in the outbox")
+	assert.Equal(t, int64(1), svc.pushed[0].ChatId)
+
+	msg, err := o.Send(context.Background(), tbapi.NewMessage(2, "reply"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, msg.MessageID)
+	assert.Len(t, tb.sent, 1, "the held chat doesn't block replies to other chats")
+	assert.True(t, time.Since(start) < time.Second)
+
+	o.Limiter.Hold(0, time.Now(), time.Hour)
+	_, err = o.Send(context.Background(), tbapi.NewMessage(3, "global"))
+	assert.Equal(t, ErrPostponed, err, "the global hold postpones replies too")
+	assert.Len(t, svc.pushed, 2)
+}
@@ -0,0 +1,51 @@
+package events
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/bot"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+type ReminderService interface {
+	Due(ctx context.Context, now time.Time) ([]bot.Reminder, error)
+	Sent(ctx context.Context, r bot.Reminder) error
+}
+
+// ReminderScheduler periodically sends reminders to debtors
+type ReminderScheduler struct {
+	Outbox          *Outbox
+	ReminderService ReminderService
+	Interval        time.Duration
+}
+
+// Do checks reminders every interval until context is done, blocked call
+func (s *ReminderScheduler) Do(ctx context.Context) error {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			s.send(ctx, now)
+		}
+	}
+}
+
+func (s *ReminderScheduler) send(ctx context.Context, now time.Time) {
+	reminders, err := s.ReminderService.Due(ctx, now)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("can't create reminders")
+		return
+	}
+	for _, r := range reminders {
+		if err := s.Outbox.EnqueueAll(ctx, r.Messages); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("reminder to user %d is not sent, it is due again", r.Sent.UserId)
+			continue
+		}
+		if err := s.ReminderService.Sent(ctx, r); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("save reminder failed")
+		}
+	}
+}
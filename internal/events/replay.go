@@ -0,0 +1,259 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/almaznur91/splitty/internal/api"
+	"github.com/almaznur91/splitty/internal/bot"
+	tbapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// objectIdRe matches ids of buttons and other documents, they are new in every replay
+var objectIdRe = regexp.MustCompile(`\b[0-9a-f]{24}\b`)
+
+// Response is the request of the bot to telegram made while the update was processed
+type Response struct {
+	UpdateID int             `json:"update_id"`
+	Kind     string          `json:"kind"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+func (r Response) String() string {
+	return r.Kind + " " + string(r.Payload)
+}
+
+// Recorder is the fake telegram api, which records requests of the bot instead of sending them
+type Recorder struct {
+	mu        sync.Mutex
+	updateID  int
+	messageID int
+	responses []Response
+}
+
+func (r *Recorder) record(v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		payload, _ = json.Marshal(err.Error())
+	}
+	// ids differ between replays, they are replaced to compare responses
+	payload = objectIdRe.ReplaceAll(payload, []byte("<id>"))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responses = append(r.responses, Response{UpdateID: r.updateID, Kind: fmt.Sprintf("%T", v), Payload: payload})
+}
+
+func (r *Recorder) start(updateID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updateID = updateID
+}
+
+func (r *Recorder) GetUpdatesChan(tbapi.UpdateConfig) (tbapi.UpdatesChannel, error) {
+	return nil, fmt.Errorf("updates are replayed from the journal")
+}
+
+// Send records the message and responds with the next message id, as telegram does
+func (r *Recorder) Send(c tbapi.Chattable) (tbapi.Message, error) {
+	r.record(c)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messageID++
+	return tbapi.Message{MessageID: r.messageID, Chat: &tbapi.Chat{ID: chatOf(c)}}, nil
+}
+
+func (r *Recorder) PinChatMessage(c tbapi.PinChatMessageConfig) (tbapi.APIResponse, error) {
+	r.record(c)
+	return tbapi.APIResponse{Ok: true}, nil
+}
+
+func (r *Recorder) UnpinChatMessage(c tbapi.UnpinChatMessageConfig) (tbapi.APIResponse, error) {
+	r.record(c)
+	return tbapi.APIResponse{Ok: true}, nil
+}
+
+func (r *Recorder) GetChat(c tbapi.ChatConfig) (tbapi.Chat, error) {
+	r.record(c)
+	return tbapi.Chat{ID: c.ChatID, UserName: c.SuperGroupUsername}, nil
+}
+
+func (r *Recorder) RestrictChatMember(c tbapi.RestrictChatMemberConfig) (tbapi.APIResponse, error) {
+	r.record(c)
+	return tbapi.APIResponse{Ok: true}, nil
+}
+
+func (r *Recorder) AnswerInlineQuery(c tbapi.InlineConfig) (tbapi.APIResponse, error) {
+	r.record(c)
+	return tbapi.APIResponse{Ok: true}, nil
+}
+
+func (r *Recorder) AnswerCallbackQuery(c tbapi.CallbackConfig) (tbapi.APIResponse, error) {
+	r.record(c)
+	return tbapi.APIResponse{Ok: true}, nil
+}
+
+// ReplayButtonService restores buttons of journaled callbacks in the scratch database
+type ReplayButtonService interface {
+	ButtonService
+	Save(ctx context.Context, b *api.Button) (primitive.ObjectID, error)
+}
+
+// Replayer feeds journaled updates through the bot pipeline and records responses instead of sending them
+type Replayer struct {
+	listener *TelegramListener
+	outbox   *Outbox
+	recorder *Recorder
+	buttons  ReplayButtonService
+}
+
+func NewReplayer(bots []bot.Interface, bs ReplayButtonService, us UserService, cs ChatStateService, ss SummaryService,
+	obs OutboxService) *Replayer {
+	recorder := &Recorder{}
+	outbox := &Outbox{
+		TbAPI:         recorder,
+		OutboxService: obs,
+		Limiter:       NewLimiter(0, 0, 0),
+		MaxAttempts:   1,
+	}
+	return &Replayer{
+		listener: &TelegramListener{
+			TbAPI:            recorder,
+			Bots:             bot.MultiBot(bots),
+			ChatStateService: cs,
+			ButtonService:    bs,
+			UserService:      us,
+			SummaryService:   ss,
+			Outbox:           outbox,
+		},
+		outbox:   outbox,
+		recorder: recorder,
+		buttons:  bs,
+	}
+}
+
+// Replay processes entries one by one, messages queued in the outbox are sent right after their update.
+// Pressed buttons are saved under their original ids before the callback, unless the database already has them
+func (r *Replayer) Replay(ctx context.Context, entries []JournalEntry) []Response {
+	for _, e := range entries {
+		if err := r.restoreButton(ctx, e.Button); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msgf("can't restore button of update %d", e.Update.UpdateID)
+		}
+		r.recorder.start(e.Update.UpdateID)
+		r.listener.handleUpdate(ctx, e.Update)
+		r.outbox.sendDue(ctx, time.Now())
+	}
+	return r.recorder.responses
+}
+
+func (r *Replayer) restoreButton(ctx context.Context, b *api.Button) error {
+	if b == nil {
+		return nil
+	}
+	if _, err := r.buttons.FindById(ctx, b.ID.Hex()); err == nil {
+		return nil
+	}
+	_, err := r.buttons.Save(ctx, b)
+	return err
+}
+
+// WriteResponses writes responses to the file as json lines
+func WriteResponses(path string, responses []Response) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	// payloads are kept as recorded, otherwise replaced ids differ from ids of the current replay
+	enc.SetEscapeHTML(false)
+	for _, r := range responses {
+		if err := enc.Encode(r); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadResponses reads responses written by WriteResponses
+func ReadResponses(path string) ([]Response, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var responses []Response
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var r Response
+		if err := dec.Decode(&r); err != nil {
+			return nil, err
+		}
+		responses = append(responses, r)
+	}
+	return responses, nil
+}
+
+// DiffResponses returns responses of two replays, which differ, grouped by updates. It is empty if replays are equal
+func DiffResponses(before []Response, after []Response) string {
+	beforeByUpdate, order := groupResponses(before, nil)
+	afterByUpdate, order := groupResponses(after, order)
+
+	var b strings.Builder
+	for _, id := range order {
+		o, n := beforeByUpdate[id], afterByUpdate[id]
+		if equalResponses(o, n) {
+			continue
+		}
+		_, _ = fmt.Fprintf(&b, "update %d\n", id)
+		for _, r := range o {
+			_, _ = fmt.Fprintf(&b, "- %s\n", r)
+		}
+		for _, r := range n {
+			_, _ = fmt.Fprintf(&b, "+ %s\n", r)
+		}
+	}
+	return b.String()
+}
+
+// groupResponses groups responses by updates and appends new updates to the order
+func groupResponses(responses []Response, order []int) (map[int][]Response, []int) {
+	seen := map[int]bool{}
+	for _, id := range order {
+		seen[id] = true
+	}
+	grouped := map[int][]Response{}
+	for _, r := range responses {
+		if !seen[r.UpdateID] {
+			seen[r.UpdateID] = true
+			order = append(order, r.UpdateID)
+		}
+		grouped[r.UpdateID] = append(grouped[r.UpdateID], r)
+	}
+	return grouped, order
+}
+
+func equalResponses(a []Response, b []Response) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].String() != b[i].String() {
+			return false
+		}
+	}
+	return true
+}
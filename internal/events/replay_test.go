@@ -0,0 +1,47 @@
+package events
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffResponses(t *testing.T) {
+	response := func(updateID int, text string) Response {
+		payload, _ := json.Marshal(text)
+		return Response{UpdateID: updateID, Kind: "tgbotapi.MessageConfig", Payload: payload}
+	}
+	before := []Response{response(1, "hello"), response(2, "menu"), response(2, "room")}
+
+	assert.Empty(t, DiffResponses(before, before))
+	assert.Empty(t, DiffResponses(nil, nil))
+
+	after := []Response{response(1, "hello"), response(2, "menu"), response(2, "rooms"), response(3, "new")}
+	assert.Equal(t, "update 2\n"+
+		"- tgbotapi.MessageConfig \"menu\"\n"+
+		"- tgbotapi.MessageConfig \"room\"\n"+
+		"+ tgbotapi.MessageConfig \"menu\"\n"+
+		"+ tgbotapi.MessageConfig \"rooms\"\n"+
+		"update 3\n"+
+		"+ tgbotapi.MessageConfig \"new\"\n", DiffResponses(before, after))
+
+	assert.Equal(t, "update 2\n"+
+		"- tgbotapi.MessageConfig \"menu\"\n"+
+		"- tgbotapi.MessageConfig \"room\"\n", DiffResponses(before, before[:1]))
+}
+
+func TestWriteReadResponses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "responses.jsonl")
+	r := &Recorder{}
+	r.start(7)
+	r.record(map[string]string{"button": "5f1b2c3d4e5f6a7b8c9d0e1f"})
+	assert.Equal(t, `{"button":"<id>"}`, string(r.responses[0].Payload))
+
+	assert.NoError(t, WriteResponses(path, r.responses))
+	responses, err := ReadResponses(path)
+	assert.NoError(t, err)
+	assert.Equal(t, r.responses, responses)
+	assert.Empty(t, DiffResponses(r.responses, responses))
+}
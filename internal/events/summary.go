@@ -0,0 +1,49 @@
+package events
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+)
+
+// SummaryUpdater edits room summaries posted to chats, when rooms are changed. Edits are sent by the outbox,
+// so they are within rate limits of groups and are retried
+type SummaryUpdater struct {
+	Outbox         *Outbox
+	SummaryService SummaryService
+	Changes        <-chan string
+}
+
+// Do edits summaries until context is done, blocked call
+func (s *SummaryUpdater) Do(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case roomId := <-s.Changes:
+			// several changes of the same room in a row are merged into one edit
+			changed := map[string]bool{roomId: true}
+			for pending := true; pending; {
+				select {
+				case id := <-s.Changes:
+					changed[id] = true
+				default:
+					pending = false
+				}
+			}
+			for id := range changed {
+				s.update(ctx, id)
+			}
+		}
+	}
+}
+
+func (s *SummaryUpdater) update(ctx context.Context, roomId string) {
+	edits, err := s.SummaryService.Edits(ctx, roomId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("can't create summary of room %s", roomId)
+		return
+	}
+	if err := s.Outbox.EnqueueAll(ctx, edits); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("can't edit summary of room %s", roomId)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"github.com/almaznur91/splitty/internal/api"
 	"github.com/almaznur91/splitty/internal/bot"
+	"github.com/almaznur91/splitty/internal/metrics"
+	"github.com/almaznur91/splitty/internal/tracing"
 	tbapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
+	"time"
 )
 
 type ChatStateService interface {
@@ -21,6 +24,11 @@ type UserService interface {
 	UpsertUser(ctx context.Context, u api.User) (*api.User, error)
 }
 
+type SummaryService interface {
+	Posted(ctx context.Context, roomId string, u *api.User, m tbapi.Message) error
+	Edits(ctx context.Context, roomId string) ([]tbapi.Chattable, error)
+}
+
 // TelegramListener listens to tg update, forward to bots and send back responses
 // Not thread safe
 type TelegramListener struct {
@@ -30,6 +38,9 @@ type TelegramListener struct {
 	ButtonService    ButtonService
 	upds             chan tbapi.Update
 	UserService      UserService
+	SummaryService   SummaryService
+	Outbox           *Outbox
+	Journal          *Journal
 }
 
 type tbAPI interface {
@@ -66,44 +77,69 @@ func (l *TelegramListener) Do(ctx context.Context) (err error) {
 				return errors.Errorf("telegram update chan closed")
 			}
 
-			upd := transformUpdate(update)
-
-			user, err := getFrom(upd)
-			if err != nil {
-				log.Error().Err(err).Stack().Msg("failed define user")
-				break
-			}
+			l.handleUpdate(ctx, update)
+		}
+	}
+}
 
-			upd.User, err = l.UserService.UpsertUser(ctx, *user)
-			if err != nil {
-				log.Error().Err(err).Stack().Msgf("failed to upsert user, %v", err)
-				break
-			}
+// handleUpdate processes the update within its span, the logger of the context carries ids of the update
+func (l *TelegramListener) handleUpdate(ctx context.Context, update tbapi.Update) {
+	ctx, span := tracing.Start(ctx, "update")
+	defer span.End()
 
-			if err := l.populateBtn(ctx, upd); err != nil {
-				log.Error().Err(err).Stack().Msgf("failed to populateBtn, %v", err)
-			}
+	start := time.Now()
+	upd := transformUpdate(update)
+	// the update is observed once, redirects are part of it, the action is known after the button is populated
+	defer func() { metrics.ObserveUpdate(tracing.Action(upd), start) }()
+	// user, button and chat state are not known yet
+	uctx := tracing.WithUpdate(ctx, upd)
 
-			if err := l.populateChatState(ctx, upd); err != nil {
-				log.Error().Err(err).Stack().Msgf("failed to populateChatState")
-			}
+	if err := l.populateBtn(uctx, upd); err != nil {
+		log.Ctx(uctx).Error().Err(err).Stack().Msgf("failed to populateBtn, %v", err)
+	}
 
-			log.Debug().Msgf("incoming msg: %+v; btn:%+v", upd.Message, upd.Button)
+	if err := l.populateChatState(uctx, upd); err != nil {
+		log.Ctx(uctx).Error().Err(err).Stack().Msgf("failed to populateChatState")
+	}
 
-			l.processUpdate(ctx, upd)
+	if l.Journal != nil {
+		if err := l.Journal.Write(NewJournalEntry(update, upd.Button, bot.HasSecretInput(upd), time.Now())); err != nil {
+			log.Ctx(uctx).Warn().Err(err).Msgf("can't write update %d to journal", update.UpdateID)
 		}
 	}
+
+	user, err := getFrom(upd)
+	if err != nil {
+		log.Ctx(uctx).Error().Err(err).Stack().Msg("failed define user")
+		return
+	}
+
+	upd.User, err = l.UserService.UpsertUser(uctx, *user)
+	if err != nil {
+		log.Ctx(uctx).Error().Err(err).Stack().Msgf("failed to upsert user, %v", err)
+		return
+	}
+	if upd.User.Banned {
+		log.Ctx(uctx).Debug().Msg("update of banned user is skipped")
+		return
+	}
+
+	ctx = tracing.WithUpdate(ctx, upd)
+	log.Ctx(ctx).Debug().Msgf("incoming msg: %+v; btn:%+v", upd.Message, upd.Button)
+
+	l.processUpdate(ctx, upd)
 }
 
 func (l *TelegramListener) processUpdate(ctx context.Context, upd *api.Update) {
 	resp := l.Bots.OnMessage(ctx, upd)
-	l.sendBotResponse(ctx, resp)
+	l.sendBotResponse(ctx, upd, resp)
 }
 
 func (l *TelegramListener) populateBtn(ctx context.Context, upd *api.Update) error {
 	if upd.CallbackQuery != nil {
 		btn, err := l.ButtonService.FindById(ctx, upd.CallbackQuery.Data)
 		if err != nil {
+			metrics.ButtonMisses.Inc()
 			return errors.Wrapf(err, "failed to find Button by id %q", err)
 		}
 		upd.Button = btn
@@ -128,14 +164,14 @@ func (l *TelegramListener) populateChatState(ctx context.Context, upd *api.Updat
 }
 
 // sendBotResponse sends bot'service answer to tg channel and saves it to log
-func (l *TelegramListener) sendBotResponse(ctx context.Context, resp api.TelegramMessage) {
+func (l *TelegramListener) sendBotResponse(ctx context.Context, upd *api.Update, resp api.TelegramMessage) {
 	if !resp.Send {
 		return
 	}
 
 	if resp.Redirect != nil {
 		if resp.Redirect.FromRedirect {
-			log.Error().Stack().Msg("recursive multiple redirection")
+			log.Ctx(ctx).Error().Stack().Msg("recursive multiple redirection")
 		} else {
 			resp.Redirect.FromRedirect = true
 			l.processUpdate(ctx, resp.Redirect)
@@ -145,29 +181,59 @@ func (l *TelegramListener) sendBotResponse(ctx context.Context, resp api.Telegra
 	if resp.InlineConfig != nil {
 		response, err := l.TbAPI.AnswerInlineQuery(*resp.InlineConfig)
 		if err != nil {
-			log.Error().Err(err).Msgf("can't send query to telegram %v", response)
+			log.Ctx(ctx).Error().Err(err).Msgf("can't send query to telegram %v", response)
 		}
-		log.Debug().Msgf("bot response - %q", resp.InlineConfig)
+		log.Ctx(ctx).Debug().Msgf("bot response - %+v", resp.InlineConfig)
 	}
 
 	if len(resp.Chattable) > 0 {
-		for _, v := range resp.Chattable {
+		for i, v := range resp.Chattable {
 			if v == nil {
 				continue
 			}
-			response, err := l.TbAPI.Send(v)
+			// the reply is sent at once, notifications of other users wait in the outbox
+			if i > 0 {
+				l.Outbox.Enqueue(ctx, v)
+				continue
+			}
+			response, err := l.Outbox.Send(ctx, v)
+			if errors.Is(err, ErrPostponed) {
+				log.Ctx(ctx).Warn().Msg("reply is postponed by rate limits, it is not pinned")
+				continue
+			}
 			if err != nil {
-				log.Error().Err(err).Msgf("can't send message to telegram %v", v)
+				log.Ctx(ctx).Error().Err(err).Msgf("can't send message to telegram %v", v)
+				continue
 			}
-			log.Debug().Msgf("bot response chat - %v, text - %v, messageId - %v", response.Chat, response.Text, response.MessageID)
+			log.Ctx(ctx).Debug().Msgf("bot response chat - %v, text - %v, messageId - %v", response.Chat, response.Text, response.MessageID)
+			l.afterFirstSent(ctx, upd, resp, response)
+		}
+	}
+	for _, r := range resp.Restrict {
+		if _, err := l.TbAPI.RestrictChatMember(r); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("can't restrict user %d in chat %d", r.UserID, r.ChatID)
 		}
 	}
 	if resp.CallbackConfig != nil {
 		response, err := l.TbAPI.AnswerCallbackQuery(*resp.CallbackConfig)
 		if err != nil {
-			log.Error().Err(err).Msgf("can't send calback to telegram %v", resp.CallbackConfig)
+			log.Ctx(ctx).Error().Err(err).Msgf("can't send calback to telegram %v", resp.CallbackConfig)
+		}
+		log.Ctx(ctx).Debug().Msgf("bot response - %+v", response)
+	}
+}
+
+// afterFirstSent pins the first sent message and saves it as room summary, if the response asks for it
+func (l *TelegramListener) afterFirstSent(ctx context.Context, upd *api.Update, resp api.TelegramMessage, msg tbapi.Message) {
+	if resp.Pin && msg.Chat != nil {
+		if _, err := l.TbAPI.PinChatMessage(tbapi.PinChatMessageConfig{ChatID: msg.Chat.ID, MessageID: msg.MessageID, DisableNotification: true}); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("can't pin message %d in chat %d", msg.MessageID, msg.Chat.ID)
+		}
+	}
+	if resp.SummaryOf != "" && msg.Chat != nil && l.SummaryService != nil {
+		if err := l.SummaryService.Posted(ctx, resp.SummaryOf, upd.User, msg); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("can't save summary of room %s", resp.SummaryOf)
 		}
-		log.Debug().Msgf("bot response - %+v", response)
 	}
 }
 
@@ -186,6 +252,10 @@ func transform(msg *tbapi.Message) *api.Message {
 		Type: msg.Chat.Type,
 	}
 
+	if msg.ReplyToMessage != nil {
+		message.ReplyTo = transform(msg.ReplyToMessage)
+	}
+
 	if msg.From != nil {
 		message.From = transformUser(msg.From)
 	}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/event"
+	"net/http"
+	"path"
+	"time"
+)
+
+const namespace = "splitty"
+
+var (
+	// Updates counts telegram updates processed by the action of the button or the chat state
+	Updates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_total",
+		Help:      "Telegram updates processed by action.",
+	}, []string{"action"})
+
+	// HandlerDuration is the time of bots reaction and sending the response
+	HandlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "handler_duration_seconds",
+		Help:      "Latency of update handling by action.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"action"})
+
+	// MongoDuration is the time of mongo commands
+	MongoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_duration_seconds",
+		Help:      "Latency of mongo operations by command.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"command", "status"})
+
+	// TelegramErrors counts failed requests to telegram api
+	TelegramErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_errors_total",
+		Help:      "Telegram api errors by method.",
+	}, []string{"method"})
+
+	// ButtonMisses counts callbacks, whose button is not found, e.g. expired or removed
+	ButtonMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "button_misses_total",
+		Help:      "Callbacks with buttons not found in the storage.",
+	})
+
+	// OutboxDepth is the count of messages waiting in the outbox
+	OutboxDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "outbox_depth",
+		Help:      "Messages waiting for sending in the outbox.",
+	})
+)
+
+// ObserveUpdate counts the processed update and its latency
+func ObserveUpdate(action string, start time.Time) {
+	Updates.WithLabelValues(action).Inc()
+	HandlerDuration.WithLabelValues(action).Observe(time.Since(start).Seconds())
+}
+
+// MongoMonitor returns the monitor of the mongo client, which measures latency of every command
+func MongoMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			MongoDuration.WithLabelValues(e.CommandName, "ok").Observe(time.Duration(e.DurationNanos).Seconds())
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			MongoDuration.WithLabelValues(e.CommandName, "failed").Observe(time.Duration(e.DurationNanos).Seconds())
+		},
+	}
+}
+
+// Transport counts errors of telegram api requests, the method is the last part of the request path
+func Transport(next http.RoundTripper) http.RoundTripper {
+	return roundTripper(func(r *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(r)
+		if err != nil || resp.StatusCode >= http.StatusBadRequest {
+			TelegramErrors.WithLabelValues(path.Base(r.URL.Path)).Inc()
+		}
+		return resp, err
+	})
+}
+
+type roundTripper func(r *http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	checkTimeout    = 5 * time.Second
+	shutdownTimeout = 5 * time.Second
+)
+
+// Check returns the error, if the dependency is not available
+type Check func(ctx context.Context) error
+
+// Server serves metrics on /metrics, liveness of the process on /healthz and readiness of dependencies on /readyz
+type Server struct {
+	Listen string
+	Checks map[string]Check
+}
+
+// Do serves http until context is done, blocked call
+func (s *Server) Do(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", health)
+	mux.HandleFunc("/readyz", s.ready)
+	srv := &http.Server{Addr: s.Listen, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("http server shutdown failed")
+		}
+	}()
+
+	log.Ctx(ctx).Info().Msgf("http server listens on %s", s.Listen)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return ctx.Err()
+}
+
+// health responds ok while the process serves http, dependencies are not checked,
+// so the process isn't restarted because of the unavailable database or telegram
+func health(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// ready runs all checks and responds 503 with failed ones, if any
+func (s *Server) ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	names := make([]string, 0, len(s.Checks))
+	for name := range s.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	status := http.StatusOK
+	for _, name := range names {
+		if err := s.Checks[name](ctx); err != nil {
+			status = http.StatusServiceUnavailable
+			lines = append(lines, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		lines = append(lines, name+": ok")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintln(w, strings.Join(lines, "\n"))
+}
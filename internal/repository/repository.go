@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/almaznur91/splitty/internal/api"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
@@ -9,6 +10,9 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+	"strconv"
+	"time"
 )
 
 const descParameter = -1
@@ -19,8 +23,17 @@ type UserRepository interface {
 	SetUserLang(ctx context.Context, userId int, lang string) error
 	SetNotificationUser(ctx context.Context, userId int, notification bool) error
 	SetUserBankDetails(ctx context.Context, userId int, bankDerails string) error
+	SetPaymentMethods(ctx context.Context, userId int, methods []api.PaymentMethod) error
 	SetCountInPage(ctx context.Context, userId int, count int) error
 	FindById(ctx context.Context, id int) (*api.User, error)
+	FindAll(ctx context.Context) (*[]api.User, error)
+	FindWithDigest(ctx context.Context) (*[]api.User, error)
+	SetDigest(ctx context.Context, userId int, period string, sentAt time.Time) error
+	FindByUsername(ctx context.Context, userName string) (*api.User, error)
+	SetBanned(ctx context.Context, userId int, banned bool) error
+	CountUsers(ctx context.Context, since time.Time) (int64, error)
+	DeleteUser(ctx context.Context, userId int) error
+	ResealUsers(ctx context.Context) (int, error)
 }
 
 type RoomRepository interface {
@@ -33,11 +46,32 @@ type RoomRepository interface {
 	FindRoomsByLikeName(ctx context.Context, userId int, name string) (*[]api.Room, error)
 	UpsertOperation(ctx context.Context, o *api.Operation, roomId string) error
 	DeleteOperation(ctx context.Context, roomId string, operationId primitive.ObjectID) error
+	AddSettlementRepayment(ctx context.Context, roomId string, o *api.Operation) error
+	SetSettlementStatus(ctx context.Context, settlementId primitive.ObjectID, status api.RepaymentStatus) ([]string, error)
 	ArchiveRoom(ctx context.Context, userId int, roomId string) error
 	UnArchiveRoom(ctx context.Context, userId int, roomId string) error
 	FinishedAddOperation(ctx context.Context, userId int, roomId string) error
 	UnFinishedAddOperation(ctx context.Context, userId int, roomId string) error
 	PaidOfDebts(ctx context.Context, userIds []int, roomId string) error
+	UpdateUserInRooms(ctx context.Context, u api.User) error
+	UpdateRoom(ctx context.Context, r *api.Room) error
+	FindByChatId(ctx context.Context, chatId int64) (*api.Room, error)
+	LinkChat(ctx context.Context, roomId string, chat api.Chat) error
+	SetSummaryMessage(ctx context.Context, roomId string, chatId int64, messageId int, lang string) error
+	AddSummaryInlineMessage(ctx context.Context, roomId string, inlineMessageId string) error
+	SetSummaryJoinButton(ctx context.Context, roomId string, buttonId string) error
+	FindRoomsDistributingDebts(ctx context.Context) (*[]api.Room, error)
+	SetReminderInterval(ctx context.Context, roomId string, days int) error
+	SetReminderSent(ctx context.Context, roomId string, sent api.ReminderSent) error
+	DeleteReminderSent(ctx context.Context, roomId string, userId int) error
+	SetCategories(ctx context.Context, roomId string, categories []string) error
+	SetBudget(ctx context.Context, roomId string, budget api.Budget) error
+	SetCurrency(ctx context.Context, roomId string, currency string) error
+	CountRooms(ctx context.Context, since time.Time) (int64, error)
+	CountOperations(ctx context.Context, since time.Time) (int64, error)
+	FindAll(ctx context.Context) (*[]api.Room, error)
+	RestoreRoom(ctx context.Context, r *api.Room) error
+	FindRoomsWithUser(ctx context.Context, userId int) (*[]api.Room, error)
 }
 
 type ChatStateRepository interface {
@@ -46,16 +80,45 @@ type ChatStateRepository interface {
 	FindByUserId(ctx context.Context, userId int) (*api.ChatState, error)
 	DeleteById(ctx context.Context, id primitive.ObjectID) error
 	DeleteByUserId(ctx context.Context, id int) error
+	CountNotInRooms(ctx context.Context, roomIds []string) (int64, error)
+	DeleteNotInRooms(ctx context.Context, roomIds []string) (int64, error)
 }
 
 type ButtonRepository interface {
 	Save(ctx context.Context, b *api.Button) (primitive.ObjectID, error)
 	SaveAll(ctx context.Context, b ...*api.Button) ([]*api.Button, error)
 	FindById(ctx context.Context, id string) (*api.Button, error)
+	CountNotInRooms(ctx context.Context, roomIds []string) (int64, error)
+	DeleteNotInRooms(ctx context.Context, roomIds []string) (int64, error)
 }
 
+type OutboxRepository interface {
+	Push(ctx context.Context, m *api.OutgoingMessage) error
+	FindDue(ctx context.Context, now time.Time, limit int64) ([]api.OutgoingMessage, error)
+	Retry(ctx context.Context, id primitive.ObjectID, attempts int, next time.Time, lastError string) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	Bury(ctx context.Context, m *api.OutgoingMessage) error
+	Count(ctx context.Context) (int64, error)
+}
+
+type AuditRepository interface {
+	Save(ctx context.Context, r *api.AuditRecord) error
+	FindReportedViolations(ctx context.Context) ([]string, error)
+	SetReportedViolations(ctx context.Context, keys []string) error
+}
+
+// Cipher encrypts sensitive fields of documents, aad binds the encrypted value to the document and the field
+type Cipher interface {
+	Current() string
+	Seal(plaintext string, aad string) (*api.Sealed, error)
+	Open(s *api.Sealed, aad string) (string, error)
+	Rewrap(s *api.Sealed) (*api.Sealed, error)
+}
+
+// MongoUserRepository keeps bank details encrypted, they are decrypted into User.BankDetails on reading
 type MongoUserRepository struct {
-	col *mongo.Collection
+	col    *mongo.Collection
+	cipher Cipher
 }
 
 type MongoRoomRepository struct {
@@ -69,8 +132,24 @@ type MongoButtonRepository struct {
 	col *mongo.Collection
 }
 
-func NewUserRepository(col *mongo.Database) *MongoUserRepository {
-	return &MongoUserRepository{col: col.Collection("user")}
+// MongoOutboxRepository keeps outgoing messages, undeliverable messages are moved to dead letters
+type MongoOutboxRepository struct {
+	col    *mongo.Collection
+	dead   *mongo.Collection
+	cipher Cipher
+}
+
+// MongoAuditRepository keeps actions of super users and violations of room invariants reported to them
+type MongoAuditRepository struct {
+	col        *mongo.Collection
+	violations *mongo.Collection
+}
+
+// reportedViolationsId is the id of the only document with keys of reported violations
+const reportedViolationsId = "reported"
+
+func NewUserRepository(col *mongo.Database, c Cipher) *MongoUserRepository {
+	return &MongoUserRepository{col: col.Collection("user"), cipher: c}
 }
 
 func NewRoomRepository(col *mongo.Database) *MongoRoomRepository {
@@ -85,6 +164,14 @@ func NewButtonRepository(col *mongo.Database) *MongoButtonRepository {
 	return &MongoButtonRepository{col: col.Collection("button")}
 }
 
+func NewOutboxRepository(col *mongo.Database, c Cipher) *MongoOutboxRepository {
+	return &MongoOutboxRepository{col: col.Collection("outbox"), dead: col.Collection("outbox_dead"), cipher: c}
+}
+
+func NewAuditRepository(col *mongo.Database) *MongoAuditRepository {
+	return &MongoAuditRepository{col: col.Collection("audit"), violations: col.Collection("violation")}
+}
+
 func (rr MongoRoomRepository) FindById(ctx context.Context, id string) (*api.Room, error) {
 	hex, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -112,7 +199,7 @@ func (rr MongoRoomRepository) JoinToRoom(ctx context.Context, u api.User, roomId
 	}
 
 	filter := bson.D{{"_id", bson.D{{"$eq", hex}}}}
-	_, err = rr.col.UpdateOne(ctx, filter, bson.D{{"$push", bson.D{{"users", u}}}})
+	_, err = rr.col.UpdateOne(ctx, filter, versioned(bson.D{{"$push", bson.D{{"users", u}}}}))
 	return err
 }
 
@@ -122,7 +209,7 @@ func (rr MongoRoomRepository) LeaveRoom(ctx context.Context, userId int, roomId
 		return err
 	}
 	filter := bson.D{{"_id", bson.D{{"$eq", hex}}}}
-	_, err = rr.col.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"users": bson.M{"_id": userId}}})
+	_, err = rr.col.UpdateOne(ctx, filter, versioned(bson.M{"$pull": bson.M{"users": bson.M{"_id": userId}}}))
 	if err != nil {
 		return err
 	}
@@ -132,7 +219,7 @@ func (rr MongoRoomRepository) LeaveRoom(ctx context.Context, userId int, roomId
 func (rr MongoRoomRepository) SaveRoom(ctx context.Context, r *api.Room) (primitive.ObjectID, error) {
 	res, err := rr.col.InsertOne(ctx, r)
 	if err != nil {
-		log.Error().Err(err).Msg("insert failed")
+		log.Ctx(ctx).Error().Err(err).Msg("insert failed")
 	}
 	if res != nil && res.InsertedID == nil {
 		return primitive.NewObjectID(), errors.New("insert failed")
@@ -140,6 +227,243 @@ func (rr MongoRoomRepository) SaveRoom(ctx context.Context, r *api.Room) (primit
 	return res.InsertedID.(primitive.ObjectID), err
 }
 
+// UpdateRoom replaces the room, if nobody has changed it since it was read, otherwise api.ErrRoomChanged is returned.
+// The version of the room is incremented on success
+func (rr MongoRoomRepository) UpdateRoom(ctx context.Context, r *api.Room) error {
+	filter := bson.D{{"_id", r.ID}, {"version", r.Version}}
+	if r.Version == 0 {
+		filter = bson.D{{"_id", r.ID}, {"version", bson.D{{"$exists", false}}}}
+	}
+	next := *r
+	next.Version++
+	res, err := rr.col.ReplaceOne(ctx, filter, next)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errors.Wrapf(api.ErrRoomChanged, "room %s, version %d", r.ID.Hex(), r.Version)
+	}
+	r.Version = next.Version
+	return nil
+}
+
+// versioned adds the increment of the room version to the update, so UpdateRoom does not overwrite the change
+func versioned(update interface{}) interface{} {
+	switch u := update.(type) {
+	case bson.M:
+		m := bson.M{"$inc": bson.M{"version": 1}}
+		for k, v := range u {
+			m[k] = v
+		}
+		return m
+	case bson.D:
+		return append(append(bson.D{}, u...), bson.E{Key: "$inc", Value: bson.D{{"version", 1}}})
+	}
+	return update
+}
+
+// RestoreRoom saves the room with its id, the existing room with the id is replaced.
+// The replaced room gets the next version, so handlers holding the room read before don't overwrite the restored one
+func (rr MongoRoomRepository) RestoreRoom(ctx context.Context, r *api.Room) error {
+	current := &api.Room{}
+	err := rr.col.FindOne(ctx, bson.M{"_id": r.ID}, options.FindOne().SetProjection(bson.M{"version": 1})).Decode(current)
+	if err == mongo.ErrNoDocuments {
+		_, err = rr.col.InsertOne(ctx, r)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	r.Version = current.Version
+	return rr.UpdateRoom(ctx, r)
+}
+
+func (rr MongoRoomRepository) FindAll(ctx context.Context) (*[]api.Room, error) {
+	cur, err := rr.col.Find(ctx, bson.M{}, getOrderOptions("create_at", ascParameter))
+	if err != nil {
+		return nil, err
+	}
+	var m []api.Room
+	if err = cur.All(ctx, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// FindByChatId returns room linked to the group chat
+func (rr MongoRoomRepository) FindByChatId(ctx context.Context, chatId int64) (*api.Room, error) {
+	res := rr.col.FindOne(ctx, bson.D{{"chat.id", bson.D{{"$eq", chatId}}}})
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+	rm := &api.Room{}
+	if err := res.Decode(rm); err != nil {
+		return nil, err
+	}
+	return rm, nil
+}
+
+// LinkChat links room to the group chat, the chat can be linked only to one room.
+// Summary posted to the previous chat is not edited anymore
+func (rr MongoRoomRepository) LinkChat(ctx context.Context, roomId string, chat api.Chat) error {
+	hex, err := primitive.ObjectIDFromHex(roomId)
+	if err != nil {
+		return err
+	}
+	unsetSummary := bson.M{"summary.chat_id": "", "summary.message_id": ""}
+	_, err = rr.col.UpdateMany(ctx, bson.D{{"chat.id", bson.D{{"$eq", chat.ID}}}}, versioned(bson.M{"$set": bson.M{"chat": api.Chat{}}, "$unset": unsetSummary}))
+	if err != nil {
+		return err
+	}
+	_, err = rr.col.UpdateOne(ctx, bson.D{{"_id", bson.D{{"$eq", hex}}}}, versioned(bson.M{"$set": bson.M{"chat": chat}, "$unset": unsetSummary}))
+	return err
+}
+
+// SetSummaryMessage saves the message with room summary posted to the group chat
+func (rr MongoRoomRepository) SetSummaryMessage(ctx context.Context, roomId string, chatId int64, messageId int, lang string) error {
+	hex, err := primitive.ObjectIDFromHex(roomId)
+	if err != nil {
+		return err
+	}
+	_, err = rr.col.UpdateOne(ctx, bson.D{{"_id", bson.D{{"$eq", hex}}}}, versioned(bson.M{"$set": bson.M{
+		"summary.chat_id":    chatId,
+		"summary.message_id": messageId,
+		"summary.lang":       lang,
+	}}))
+	return err
+}
+
+// AddSummaryInlineMessage saves the room message posted by inline query, it is edited together with the summary
+func (rr MongoRoomRepository) AddSummaryInlineMessage(ctx context.Context, roomId string, inlineMessageId string) error {
+	hex, err := primitive.ObjectIDFromHex(roomId)
+	if err != nil {
+		return err
+	}
+	_, err = rr.col.UpdateOne(ctx, bson.D{{"_id", bson.D{{"$eq", hex}}}}, versioned(bson.M{"$addToSet": bson.M{"summary.inline_message_ids": inlineMessageId}}))
+	return err
+}
+
+// SetSummaryJoinButton saves the join button of room messages posted by inline query, edits of them reuse it
+func (rr MongoRoomRepository) SetSummaryJoinButton(ctx context.Context, roomId string, buttonId string) error {
+	hex, err := primitive.ObjectIDFromHex(roomId)
+	if err != nil {
+		return err
+	}
+	_, err = rr.col.UpdateOne(ctx, bson.D{{"_id", bson.D{{"$eq", hex}}}}, versioned(bson.M{"$set": bson.M{"summary.join_button_id": buttonId}}))
+	return err
+}
+
+// FindRoomsDistributingDebts returns rooms where somebody finished to add operations, debts may be distributed there
+func (rr MongoRoomRepository) FindRoomsDistributingDebts(ctx context.Context) (*[]api.Room, error) {
+	cur, err := rr.col.Find(ctx, bson.M{"room_states.finished_add_operation.0": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, err
+	}
+	var m []api.Room
+	err = cur.All(ctx, &m)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (rr MongoRoomRepository) SetReminderInterval(ctx context.Context, roomId string, days int) error {
+	hex, err := primitive.ObjectIDFromHex(roomId)
+	if err != nil {
+		return err
+	}
+	_, err = rr.col.UpdateOne(ctx, bson.D{{"_id", bson.D{{"$eq", hex}}}}, versioned(bson.M{"$set": bson.M{"reminder.interval_days": days}}))
+	return err
+}
+
+// DeleteReminderSent forgets reminders sent to the user, the next debt is reminded from the beginning
+func (rr MongoRoomRepository) DeleteReminderSent(ctx context.Context, roomId string, userId int) error {
+	hex, err := primitive.ObjectIDFromHex(roomId)
+	if err != nil {
+		return err
+	}
+	_, err = rr.col.UpdateOne(ctx, bson.D{{"_id", bson.D{{"$eq", hex}}}}, versioned(bson.M{"$pull": bson.M{"reminder.sent": bson.M{"user_id": userId}}}))
+	return err
+}
+
+// SetReminderSent replaces the last reminder sent to the user
+func (rr MongoRoomRepository) SetReminderSent(ctx context.Context, roomId string, sent api.ReminderSent) error {
+	hex, err := primitive.ObjectIDFromHex(roomId)
+	if err != nil {
+		return err
+	}
+	filter := bson.D{{"_id", bson.D{{"$eq", hex}}}}
+	_, err = rr.col.UpdateOne(ctx, filter, versioned(bson.M{"$pull": bson.M{"reminder.sent": bson.M{"user_id": sent.UserId}}}))
+	if err != nil {
+		return err
+	}
+	_, err = rr.col.UpdateOne(ctx, filter, versioned(bson.M{"$push": bson.M{"reminder.sent": sent}}))
+	return err
+}
+
+func (rr MongoRoomRepository) SetCategories(ctx context.Context, roomId string, categories []string) error {
+	hex, err := primitive.ObjectIDFromHex(roomId)
+	if err != nil {
+		return err
+	}
+	_, err = rr.col.UpdateOne(ctx, bson.D{{"_id", bson.D{{"$eq", hex}}}}, versioned(bson.M{"$set": bson.M{"categories": categories}}))
+	return err
+}
+
+func (rr MongoRoomRepository) SetBudget(ctx context.Context, roomId string, budget api.Budget) error {
+	hex, err := primitive.ObjectIDFromHex(roomId)
+	if err != nil {
+		return err
+	}
+	_, err = rr.col.UpdateOne(ctx, bson.D{{"_id", bson.D{{"$eq", hex}}}}, versioned(bson.M{"$set": bson.M{"budget": budget}}))
+	return err
+}
+
+func (rr MongoRoomRepository) SetCurrency(ctx context.Context, roomId string, currency string) error {
+	hex, err := primitive.ObjectIDFromHex(roomId)
+	if err != nil {
+		return err
+	}
+	_, err = rr.col.UpdateOne(ctx, bson.D{{"_id", bson.D{{"$eq", hex}}}}, versioned(bson.M{"$set": bson.M{"currency": currency}}))
+	return err
+}
+
+// CountRooms counts rooms created since the time, all rooms are counted if the time is zero
+func (rr MongoRoomRepository) CountRooms(ctx context.Context, since time.Time) (int64, error) {
+	return rr.col.CountDocuments(ctx, sinceFilter(since))
+}
+
+// CountOperations counts operations of all rooms created since the time, all operations are counted if the time is zero
+func (rr MongoRoomRepository) CountOperations(ctx context.Context, since time.Time) (int64, error) {
+	pipeline := mongo.Pipeline{
+		{{"$unwind", "$operations"}},
+		{{"$replaceRoot", bson.M{"newRoot": "$operations"}}},
+		{{"$match", sinceFilter(since)}},
+		{{"$count", "count"}},
+	}
+	cur, err := rr.col.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	var res []struct {
+		Count int64 `bson:"count"`
+	}
+	if err := cur.All(ctx, &res); err != nil {
+		return 0, err
+	}
+	if len(res) == 0 {
+		return 0, nil
+	}
+	return res[0].Count, nil
+}
+
+func sinceFilter(since time.Time) bson.M {
+	if since.IsZero() {
+		return bson.M{}
+	}
+	return bson.M{"create_at": bson.M{"$gte": since}}
+}
+
 func (rr MongoRoomRepository) ArchiveRoom(ctx context.Context, userId int, roomId string) error {
 	hex, err := primitive.ObjectIDFromHex(roomId)
 	if err != nil {
@@ -147,7 +471,7 @@ func (rr MongoRoomRepository) ArchiveRoom(ctx context.Context, userId int, roomI
 	}
 
 	filter := bson.M{"_id": hex, "users._id": userId}
-	_, err = rr.col.UpdateOne(ctx, filter, bson.M{"$addToSet": bson.M{"room_states.archived": userId}})
+	_, err = rr.col.UpdateOne(ctx, filter, versioned(bson.M{"$addToSet": bson.M{"room_states.archived": userId}}))
 	return err
 }
 
@@ -158,8 +482,10 @@ func (rr MongoRoomRepository) UnArchiveRoom(ctx context.Context, userId int, roo
 	}
 
 	filter := bson.M{"_id": hex, "users._id": userId}
-	_, err = rr.col.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"room_states.archived": userId}})
-	log.Error().Err(err).Msg("")
+	_, err = rr.col.UpdateOne(ctx, filter, versioned(bson.M{"$pull": bson.M{"room_states.archived": userId}}))
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("unarchive room %s failed", roomId)
+	}
 	return err
 }
 
@@ -170,7 +496,7 @@ func (rr MongoRoomRepository) FinishedAddOperation(ctx context.Context, userId i
 	}
 
 	filter := bson.M{"_id": hex, "users._id": userId}
-	_, err = rr.col.UpdateOne(ctx, filter, bson.M{"$addToSet": bson.M{"room_states.finished_add_operation": userId}})
+	_, err = rr.col.UpdateOne(ctx, filter, versioned(bson.M{"$addToSet": bson.M{"room_states.finished_add_operation": userId}}))
 	return err
 }
 
@@ -181,8 +507,10 @@ func (rr MongoRoomRepository) UnFinishedAddOperation(ctx context.Context, userId
 	}
 
 	filter := bson.M{"_id": hex, "users._id": userId}
-	_, err = rr.col.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"room_states.finished_add_operation": userId}})
-	log.Error().Err(err).Msg("")
+	_, err = rr.col.UpdateOne(ctx, filter, versioned(bson.M{"$pull": bson.M{"room_states.finished_add_operation": userId}}))
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("unfinish adding operation in room %s failed", roomId)
+	}
 	return err
 }
 
@@ -194,11 +522,93 @@ func (rr MongoRoomRepository) PaidOfDebts(ctx context.Context, userIds []int, ro
 
 	filter := bson.M{"_id": hex}
 	update := bson.D{{"$set", bson.M{"room_states.paid_off_debts": userIds}}}
-	_, err = rr.col.UpdateOne(ctx, filter, update)
-	log.Error().Err(err).Msg("")
+	_, err = rr.col.UpdateOne(ctx, filter, versioned(update))
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("set paid off debts in room %s failed", roomId)
+	}
 	return err
 }
 
+// UpdateUserInRooms refreshes profile fields of the user copies embedded in members, donors, co-payers, recipients and items,
+// rooms where all copies are fresh are not written and keep their version
+func (rr MongoRoomRepository) UpdateUserInRooms(ctx context.Context, u api.User) error {
+	update, arrayFilters := userInRoomsUpdate(u)
+	opts := options.Update().SetArrayFilters(arrayFilters)
+	_, err := rr.col.UpdateMany(ctx, staleUserFilter(u), versioned(update), opts)
+	return err
+}
+
+// userInRoomsUpdate sets profile fields of the user copies. Every array is matched by the filter on the user,
+// so operations and items without recipients or co-payers are skipped instead of failing the update
+func userInRoomsUpdate(u api.User) (bson.M, options.ArrayFilters) {
+	update := bson.M{"$set": bson.M{
+		"users.$[u].display_name":                                  u.DisplayName,
+		"users.$[u].user_name":                                     u.Username,
+		"operations.$[o].donor.display_name":                       u.DisplayName,
+		"operations.$[o].donor.user_name":                          u.Username,
+		"operations.$[ro].recipients.$[r].display_name":            u.DisplayName,
+		"operations.$[ro].recipients.$[r].user_name":               u.Username,
+		"operations.$[c].co_payers.$[p].user.display_name":         u.DisplayName,
+		"operations.$[c].co_payers.$[p].user.user_name":            u.Username,
+		"operations.$[i].items.$[it].recipients.$[r].display_name": u.DisplayName,
+		"operations.$[i].items.$[it].recipients.$[r].user_name":    u.Username,
+	}}
+	return update, options.ArrayFilters{Filters: []interface{}{
+		bson.M{"u._id": u.ID},
+		bson.M{"o.donor._id": u.ID},
+		bson.M{"ro.recipients._id": u.ID},
+		bson.M{"c.co_payers.user._id": u.ID},
+		bson.M{"p.user._id": u.ID},
+		bson.M{"i.items.recipients._id": u.ID},
+		bson.M{"it.recipients._id": u.ID},
+		bson.M{"r._id": u.ID},
+	}}
+}
+
+// FindRoomsWithUser returns all rooms including archived ones, where the user is a member or takes part in operations
+func (rr MongoRoomRepository) FindRoomsWithUser(ctx context.Context, userId int) (*[]api.Room, error) {
+	cur, err := rr.col.Find(ctx, userInRoomFilter(userId), getOrderOptions("create_at", ascParameter))
+	if err != nil {
+		return nil, err
+	}
+	var m []api.Room
+	if err = cur.All(ctx, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// userInRoomFilter matches rooms with the user copy in members, donors, co-payers, recipients or items
+func userInRoomFilter(userId int) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"users._id": userId},
+		bson.M{"operations.donor._id": userId},
+		bson.M{"operations.recipients._id": userId},
+		bson.M{"operations.co_payers.user._id": userId},
+		bson.M{"operations.items.recipients._id": userId},
+	}}
+}
+
+// staleUserFilter matches rooms with the user copy, whose name differs from the user, rooms with fresh copies aren't updated
+func staleUserFilter(u api.User) bson.M {
+	stale := func(prefix string) bson.M {
+		return bson.M{
+			prefix + "_id": u.ID,
+			"$or": bson.A{
+				bson.M{prefix + "display_name": bson.M{"$ne": u.DisplayName}},
+				bson.M{prefix + "user_name": bson.M{"$ne": u.Username}},
+			},
+		}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"users": bson.M{"$elemMatch": stale("")}},
+		bson.M{"operations": bson.M{"$elemMatch": stale("donor.")}},
+		bson.M{"operations.recipients": bson.M{"$elemMatch": stale("")}},
+		bson.M{"operations.co_payers": bson.M{"$elemMatch": stale("user.")}},
+		bson.M{"operations.items.recipients": bson.M{"$elemMatch": stale("")}},
+	}}
+}
+
 func (rr MongoRoomRepository) hasRoom(ctx context.Context, u *api.User) (bool, error) {
 	resp, err := rr.col.CountDocuments(ctx, bson.D{{"_id", bson.D{{"$eq", u.ID}}}})
 	return resp > 0, err
@@ -270,22 +680,65 @@ func (rr MongoRoomRepository) UpsertOperation(ctx context.Context, o *api.Operat
 		return err
 	}
 	filter := bson.D{{"_id", bson.D{{"$eq", hex}}}}
-	_, err = rr.col.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"operations": bson.M{"_id": o.ID}}})
+	_, err = rr.col.UpdateOne(ctx, filter, versioned(bson.M{"$pull": bson.M{"operations": bson.M{"_id": o.ID}}}))
 	if err != nil {
 		return err
 	}
 
-	_, err = rr.col.UpdateOne(ctx, filter, bson.D{{"$push", bson.D{{"operations", o}}}})
+	_, err = rr.col.UpdateOne(ctx, filter, versioned(bson.D{{"$push", bson.D{{"operations", o}}}}))
+	return err
+}
+
+// AddSettlementRepayment adds the repayment unless the room already has a repayment of the same settlement
+func (rr MongoRoomRepository) AddSettlementRepayment(ctx context.Context, roomId string, o *api.Operation) error {
+	hex, err := primitive.ObjectIDFromHex(roomId)
+	if err != nil {
+		return err
+	}
+	filter := bson.D{{"_id", hex}, {"operations.settlement_id", bson.D{{"$ne", o.SettlementId}}}}
+	_, err = rr.col.UpdateOne(ctx, filter, versioned(bson.D{{"$push", bson.D{{"operations", o}}}}))
 	return err
 }
 
+// SetSettlementStatus sets the status of pending repayments of the settlement, returns ids of rooms with such repayments
+func (rr MongoRoomRepository) SetSettlementStatus(ctx context.Context, settlementId primitive.ObjectID, status api.RepaymentStatus) ([]string, error) {
+	filter := bson.D{{"operations", bson.D{{"$elemMatch", bson.D{
+		{"settlement_id", settlementId},
+		{"repayment_status", api.RepaymentPending},
+	}}}}}
+	cursor, err := rr.col.Find(ctx, filter, options.Find().SetProjection(bson.D{{"_id", 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var rooms []api.Room
+	if err := cursor.All(ctx, &rooms); err != nil {
+		return nil, err
+	}
+	if len(rooms) == 0 {
+		return nil, nil
+	}
+	update := bson.D{{"$set", bson.D{{"operations.$[o].repayment_status", status}}}}
+	arrayFilters := options.ArrayFilters{Filters: []interface{}{bson.D{
+		{"o.settlement_id", settlementId},
+		{"o.repayment_status", api.RepaymentPending},
+	}}}
+	if _, err := rr.col.UpdateMany(ctx, filter, versioned(update), options.Update().SetArrayFilters(arrayFilters)); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(rooms))
+	for _, r := range rooms {
+		ids = append(ids, r.ID.Hex())
+	}
+	return ids, nil
+}
+
 func (rr MongoRoomRepository) DeleteOperation(ctx context.Context, roomId string, operationId primitive.ObjectID) error {
 	hex, err := primitive.ObjectIDFromHex(roomId)
 	if err != nil {
 		return err
 	}
 	filter := bson.D{{"_id", bson.D{{"$eq", hex}}}}
-	_, err = rr.col.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"operations": bson.M{"_id": operationId}}})
+	_, err = rr.col.UpdateOne(ctx, filter, versioned(bson.M{"$pull": bson.M{"operations": bson.M{"_id": operationId}}}))
 	if err != nil {
 		return err
 	}
@@ -301,6 +754,7 @@ func (r MongoUserRepository) FindById(ctx context.Context, id int) (*api.User, e
 	if err := res.Decode(cs); err != nil {
 		return nil, err
 	}
+	r.open(ctx, cs)
 	if cs.CountInPage == 0 {
 		cs.CountInPage = 5
 	}
@@ -310,10 +764,80 @@ func (r MongoUserRepository) FindById(ctx context.Context, id int) (*api.User, e
 	return cs, nil
 }
 
+func (r MongoUserRepository) FindAll(ctx context.Context) (*[]api.User, error) {
+	cur, err := r.col.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var m []api.User
+	if err = cur.All(ctx, &m); err != nil {
+		return nil, err
+	}
+	for i := range m {
+		r.open(ctx, &m[i])
+	}
+	return &m, nil
+}
+
+// FindWithDigest returns users subscribed to the spending digest
+func (r MongoUserRepository) FindWithDigest(ctx context.Context) (*[]api.User, error) {
+	cur, err := r.col.Find(ctx, bson.M{"digest_period": bson.M{"$exists": true, "$ne": ""}})
+	if err != nil {
+		return nil, err
+	}
+	var m []api.User
+	if err = cur.All(ctx, &m); err != nil {
+		return nil, err
+	}
+	for i := range m {
+		r.open(ctx, &m[i])
+	}
+	return &m, nil
+}
+
+func (r MongoUserRepository) SetDigest(ctx context.Context, userId int, period string, sentAt time.Time) error {
+	f := bson.D{{"_id", bson.D{{"$eq", userId}}}}
+	update := bson.D{{"$set", bson.M{"digest_period": period, "digest_sent_at": sentAt}}}
+	_, err := r.col.UpdateOne(ctx, f, update)
+	return err
+}
+
+// FindByUsername finds the user by telegram user name, the name is case insensitive
+func (r MongoUserRepository) FindByUsername(ctx context.Context, userName string) (*api.User, error) {
+	res := r.col.FindOne(ctx, bson.M{"user_name": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(userName) + "$", Options: "i"}})
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+	u := &api.User{}
+	if err := res.Decode(u); err != nil {
+		return nil, err
+	}
+	r.open(ctx, u)
+	return u, nil
+}
+
+func (r MongoUserRepository) SetBanned(ctx context.Context, userId int, banned bool) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": userId}, bson.M{"$set": bson.M{"banned": banned}})
+	return err
+}
+
+// CountUsers counts users created since the time, all users are counted if the time is zero
+func (r MongoUserRepository) CountUsers(ctx context.Context, since time.Time) (int64, error) {
+	return r.col.CountDocuments(ctx, sinceFilter(since))
+}
+
+func (r MongoUserRepository) DeleteUser(ctx context.Context, userId int) error {
+	_, err := r.col.DeleteOne(ctx, bson.M{"_id": userId})
+	return err
+}
+
 func (r MongoUserRepository) UpsertUser(ctx context.Context, u api.User) (*api.User, error) {
 	opts := options.Update().SetUpsert(true)
 	f := bson.D{{"_id", bson.D{{"$eq", u.ID}}}}
-	update := bson.D{{"$set", bson.M{"_id": u.ID, "user_lang": u.UserLang, "display_name": u.DisplayName, "user_name": u.Username}}}
+	update := bson.D{
+		{"$set", bson.M{"_id": u.ID, "user_lang": u.UserLang, "display_name": u.DisplayName, "user_name": u.Username}},
+		{"$setOnInsert", bson.M{"create_at": time.Now()}},
+	}
 	_, err := r.col.UpdateOne(ctx, f, update, opts)
 	if err != nil {
 		return nil, err
@@ -357,7 +881,14 @@ func (r MongoUserRepository) SetNotificationUser(ctx context.Context, userId int
 func (r MongoUserRepository) SetUserBankDetails(ctx context.Context, userId int, bankDerails string) error {
 	opts := options.Update().SetUpsert(true)
 	f := bson.D{{"_id", bson.D{{"$eq", userId}}}}
-	update := bson.D{{"$set", bson.M{"bank_details": bankDerails}}}
+	update := bson.D{{"$unset", bson.M{"bank_details": "", "sealed_bank_details": ""}}}
+	if bankDerails != "" {
+		sealed, err := r.cipher.Seal(bankDerails, bankDetailsAad(userId))
+		if err != nil {
+			return err
+		}
+		update = bson.D{{"$set", bson.M{"sealed_bank_details": sealed}}, {"$unset", bson.M{"bank_details": ""}}}
+	}
 	_, err := r.col.UpdateOne(ctx, f, update, opts)
 	if err != nil {
 		return err
@@ -365,10 +896,114 @@ func (r MongoUserRepository) SetUserBankDetails(ctx context.Context, userId int,
 	return nil
 }
 
+// SetPaymentMethods replaces payment methods of the user, the list is encrypted as one json value
+func (r MongoUserRepository) SetPaymentMethods(ctx context.Context, userId int, methods []api.PaymentMethod) error {
+	f := bson.D{{"_id", bson.D{{"$eq", userId}}}}
+	update := bson.D{{"$unset", bson.M{"sealed_payment_methods": ""}}}
+	if len(methods) > 0 {
+		b, err := json.Marshal(methods)
+		if err != nil {
+			return err
+		}
+		sealed, err := r.cipher.Seal(string(b), paymentMethodsAad(userId))
+		if err != nil {
+			return err
+		}
+		update = bson.D{{"$set", bson.M{"sealed_payment_methods": sealed}}}
+	}
+	_, err := r.col.UpdateOne(ctx, f, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// ResealUsers encrypts bank details stored in plaintext and re-encrypts data keys of the rotated master keys,
+// it returns count of updated users
+func (r MongoUserRepository) ResealUsers(ctx context.Context) (int, error) {
+	cur, err := r.col.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"bank_details": bson.M{"$exists": true}},
+		bson.M{"sealed_bank_details": bson.M{"$exists": true}, "sealed_bank_details.key_id": bson.M{"$ne": r.cipher.Current()}},
+		bson.M{"sealed_payment_methods": bson.M{"$exists": true}, "sealed_payment_methods.key_id": bson.M{"$ne": r.cipher.Current()}},
+	}})
+	if err != nil {
+		return 0, err
+	}
+	var users []api.User
+	if err = cur.All(ctx, &users); err != nil {
+		return 0, err
+	}
+	var count int
+	for _, u := range users {
+		sealed := u.SealedBank
+		if u.LegacyBank != "" {
+			sealed, err = r.cipher.Seal(u.LegacyBank, bankDetailsAad(u.ID))
+		} else if sealed != nil {
+			sealed, err = r.cipher.Rewrap(sealed)
+		}
+		if err != nil {
+			return count, errors.Wrapf(err, "cannot encrypt bank details of user %d", u.ID)
+		}
+		set := bson.M{}
+		if sealed != nil {
+			set["sealed_bank_details"] = sealed
+		}
+		if p := u.SealedPayments; p != nil && p.KeyId != r.cipher.Current() {
+			if set["sealed_payment_methods"], err = r.cipher.Rewrap(p); err != nil {
+				return count, errors.Wrapf(err, "cannot encrypt payment methods of user %d", u.ID)
+			}
+		}
+		update := bson.D{{"$unset", bson.M{"bank_details": ""}}}
+		if len(set) > 0 {
+			update = append(update, bson.E{Key: "$set", Value: set})
+		}
+		if _, err := r.col.UpdateOne(ctx, bson.M{"_id": u.ID}, update); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// open decrypts bank details and payment methods of the user, the encrypted and plaintext fields are cleared,
+// so copies of the user saved in rooms never contain bank details
+func (r MongoUserRepository) open(ctx context.Context, u *api.User) {
+	if u.SealedBank != nil {
+		bank, err := r.cipher.Open(u.SealedBank, bankDetailsAad(u.ID))
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("cannot decrypt bank details of user %d", u.ID)
+		}
+		u.BankDetails = bank
+	} else {
+		u.BankDetails = u.LegacyBank
+	}
+	if u.SealedPayments != nil {
+		if err := r.openPaymentMethods(u); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("cannot decrypt payment methods of user %d", u.ID)
+		}
+	}
+	u.SealedBank = nil
+	u.LegacyBank = ""
+	u.SealedPayments = nil
+}
+
+func (r MongoUserRepository) openPaymentMethods(u *api.User) error {
+	methods, err := r.cipher.Open(u.SealedPayments, paymentMethodsAad(u.ID))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(methods), &u.Payments)
+}
+
+func bankDetailsAad(userId int) string {
+	return "user:" + strconv.Itoa(userId) + ":bank_details"
+}
+
+func paymentMethodsAad(userId int) string {
+	return "user:" + strconv.Itoa(userId) + ":payment_methods"
+}
+
 func (csr MongoChatStateRepository) Save(ctx context.Context, cs *api.ChatState) error {
 	res, err := csr.col.InsertOne(ctx, cs)
 	if err != nil {
-		log.Error().Err(err).Msg("insert failed")
+		log.Ctx(ctx).Error().Err(err).Msg("insert failed")
 	}
 	if res != nil && res.InsertedID == nil {
 		return errors.New("insert failed")
@@ -379,7 +1014,7 @@ func (csr MongoChatStateRepository) Save(ctx context.Context, cs *api.ChatState)
 func (csr MongoChatStateRepository) FindById(ctx context.Context, id int) (*api.ChatState, error) {
 	res := csr.col.FindOne(ctx, bson.D{{"_id", bson.D{{"$eq", id}}}})
 	if res.Err() == mongo.ErrNoDocuments {
-		log.Warn().Err(res.Err()).Msgf("chat_state not found by id %v", id)
+		log.Ctx(ctx).Warn().Err(res.Err()).Msgf("chat_state not found by id %v", id)
 		return nil, nil
 	}
 	if res.Err() != nil {
@@ -395,7 +1030,7 @@ func (csr MongoChatStateRepository) FindById(ctx context.Context, id int) (*api.
 func (csr MongoChatStateRepository) FindByUserId(ctx context.Context, userId int) (*api.ChatState, error) {
 	res := csr.col.FindOne(ctx, bson.D{{"user_id", bson.D{{"$eq", userId}}}})
 	if res.Err() == mongo.ErrNoDocuments {
-		log.Debug().Err(res.Err()).Msgf("chat_state not found by user_id %v", userId)
+		log.Ctx(ctx).Debug().Err(res.Err()).Msgf("chat_state not found by user_id %v", userId)
 		return nil, nil
 	}
 	if res.Err() != nil {
@@ -411,7 +1046,7 @@ func (csr MongoChatStateRepository) FindByUserId(ctx context.Context, userId int
 func (csr MongoChatStateRepository) DeleteById(ctx context.Context, id primitive.ObjectID) error {
 	_, err := csr.col.DeleteOne(ctx, bson.D{{"_id", bson.D{{"$eq", id}}}})
 	if err != nil {
-		log.Error().Err(err).Msg("delete failed")
+		log.Ctx(ctx).Error().Err(err).Msg("delete failed")
 		return err
 	}
 	return nil
@@ -419,16 +1054,35 @@ func (csr MongoChatStateRepository) DeleteById(ctx context.Context, id primitive
 
 func (csr MongoChatStateRepository) DeleteByUserId(ctx context.Context, id int) error {
 	if _, err := csr.col.DeleteMany(ctx, bson.M{"user_id": id}); err != nil {
-		log.Error().Err(err).Msg("delete failed")
+		log.Ctx(ctx).Error().Err(err).Msg("delete failed")
 		return err
 	}
 	return nil
 }
 
+// notInRoomsFilter matches documents of rooms out of the list, documents without room are not matched
+func notInRoomsFilter(roomIds []string) bson.M {
+	return bson.M{"callback_data.room_id": bson.M{"$exists": true, "$nin": roomIds}}
+}
+
+// CountNotInRooms counts chat states of rooms out of the list, e.g. of deleted rooms
+func (csr MongoChatStateRepository) CountNotInRooms(ctx context.Context, roomIds []string) (int64, error) {
+	return csr.col.CountDocuments(ctx, notInRoomsFilter(roomIds))
+}
+
+// DeleteNotInRooms deletes chat states of rooms out of the list and returns count of deleted ones
+func (csr MongoChatStateRepository) DeleteNotInRooms(ctx context.Context, roomIds []string) (int64, error) {
+	res, err := csr.col.DeleteMany(ctx, notInRoomsFilter(roomIds))
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
 func (br MongoButtonRepository) Save(ctx context.Context, b *api.Button) (primitive.ObjectID, error) {
 	res, err := br.col.InsertOne(ctx, b)
 	if err != nil || res == nil || res.InsertedID == nil {
-		log.Error().Err(err).Stack().Msg("insert failed")
+		log.Ctx(ctx).Error().Err(err).Stack().Msg("insert failed")
 		return primitive.NilObjectID, err
 	}
 	return res.InsertedID.(primitive.ObjectID), nil
@@ -441,7 +1095,7 @@ func (br MongoButtonRepository) SaveAll(ctx context.Context, b ...*api.Button) (
 	}
 	res, err := br.col.InsertMany(ctx, i)
 	if err != nil || res == nil || res.InsertedIDs == nil {
-		log.Error().Err(err).Stack().Msg("insert failed")
+		log.Ctx(ctx).Error().Err(err).Stack().Msg("insert failed")
 		return b, err
 	}
 	for idx, id := range res.InsertedIDs {
@@ -466,3 +1120,132 @@ func (br MongoButtonRepository) FindById(ctx context.Context, id string) (*api.B
 	}
 	return btn, nil
 }
+
+// CountNotInRooms counts buttons of rooms out of the list, e.g. of deleted rooms
+func (br MongoButtonRepository) CountNotInRooms(ctx context.Context, roomIds []string) (int64, error) {
+	return br.col.CountDocuments(ctx, notInRoomsFilter(roomIds))
+}
+
+// DeleteNotInRooms deletes buttons of rooms out of the list and returns count of deleted ones
+func (br MongoButtonRepository) DeleteNotInRooms(ctx context.Context, roomIds []string) (int64, error) {
+	res, err := br.col.DeleteMany(ctx, notInRoomsFilter(roomIds))
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// outgoingContent is the sealed part of the outgoing message
+type outgoingContent struct {
+	Payload []byte            `json:"payload"`
+	File    *api.OutgoingFile `json:"file,omitempty"`
+}
+
+// Push saves the message with the encrypted payload and file
+func (or MongoOutboxRepository) Push(ctx context.Context, m *api.OutgoingMessage) error {
+	m.ID = primitive.NewObjectID()
+	content, err := json.Marshal(outgoingContent{Payload: m.Payload, File: m.File})
+	if err != nil {
+		return err
+	}
+	sealed := *m
+	sealed.Payload, sealed.File = nil, nil
+	if sealed.Sealed, err = or.cipher.Seal(string(content), outgoingAad(m.ID)); err != nil {
+		return err
+	}
+	_, err = or.col.InsertOne(ctx, sealed)
+	return err
+}
+
+// open decrypts the payload and the file of the message, the message without them can't be decoded and is buried
+func (or MongoOutboxRepository) open(ctx context.Context, m *api.OutgoingMessage) {
+	if m.Sealed == nil {
+		return
+	}
+	content, err := or.cipher.Open(m.Sealed, outgoingAad(m.ID))
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot decrypt outgoing message %s", m.ID.Hex())
+		return
+	}
+	var c outgoingContent
+	if err := json.Unmarshal([]byte(content), &c); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot decode outgoing message %s", m.ID.Hex())
+		return
+	}
+	m.Payload, m.File, m.Sealed = c.Payload, c.File, nil
+}
+
+func outgoingAad(id primitive.ObjectID) string {
+	return "outbox:" + id.Hex()
+}
+
+// FindDue returns messages whose next attempt has come, the oldest first
+func (or MongoOutboxRepository) FindDue(ctx context.Context, now time.Time, limit int64) ([]api.OutgoingMessage, error) {
+	opts := options.Find().SetSort(bson.D{{"create_at", ascParameter}}).SetLimit(limit)
+	cur, err := or.col.Find(ctx, bson.M{"next_attempt_at": bson.M{"$lte": now}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var m []api.OutgoingMessage
+	if err = cur.All(ctx, &m); err != nil {
+		return nil, err
+	}
+	for i := range m {
+		or.open(ctx, &m[i])
+	}
+	return m, nil
+}
+
+func (or MongoOutboxRepository) Retry(ctx context.Context, id primitive.ObjectID, attempts int, next time.Time, lastError string) error {
+	_, err := or.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"attempts": attempts, "next_attempt_at": next, "last_error": lastError}})
+	return err
+}
+
+func (or MongoOutboxRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := or.col.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// Bury moves the message to dead letters, the message may be not in the outbox yet.
+// Dead letters keep only the chat, the kind and the error, the content is dropped, because nobody is going to send it
+func (or MongoOutboxRepository) Bury(ctx context.Context, m *api.OutgoingMessage) error {
+	if m.ID.IsZero() {
+		m.ID = primitive.NewObjectID()
+	}
+	dead := *m
+	dead.Payload, dead.File, dead.Sealed = nil, nil, nil
+	if _, err := or.dead.InsertOne(ctx, dead); err != nil {
+		return err
+	}
+	return or.Delete(ctx, m.ID)
+}
+
+// Count returns the estimated count of messages in the outbox, it is cheap enough to be called on every poll
+func (or MongoOutboxRepository) Count(ctx context.Context) (int64, error) {
+	return or.col.EstimatedDocumentCount(ctx)
+}
+
+func (ar MongoAuditRepository) Save(ctx context.Context, r *api.AuditRecord) error {
+	r.ID = primitive.NewObjectID()
+	_, err := ar.col.InsertOne(ctx, r)
+	return err
+}
+
+// FindReportedViolations returns keys of violations, which have been reported to super users
+func (ar MongoAuditRepository) FindReportedViolations(ctx context.Context) ([]string, error) {
+	var doc struct {
+		Keys []string `bson:"keys"`
+	}
+	err := ar.violations.FindOne(ctx, bson.M{"_id": reportedViolationsId}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return doc.Keys, err
+}
+
+// SetReportedViolations replaces keys of reported violations, fixed violations are removed from them
+func (ar MongoAuditRepository) SetReportedViolations(ctx context.Context, keys []string) error {
+	_, err := ar.violations.UpdateOne(ctx, bson.M{"_id": reportedViolationsId},
+		bson.M{"$set": bson.M{"keys": keys, "update_at": time.Now()}}, options.Update().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/almaznur91/splitty/internal/api"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestStaleUserFilter(t *testing.T) {
+	u := api.User{ID: 7, DisplayName: "Anna", Username: "anna"}
+	filter := staleUserFilter(u)
+
+	conditions, ok := filter["$or"].(bson.A)
+	if !assert.True(t, ok) {
+		return
+	}
+	paths := map[string]string{}
+	for _, c := range conditions {
+		for path, match := range c.(bson.M) {
+			stale := match.(bson.M)["$elemMatch"].(bson.M)
+			for field, value := range stale {
+				if field != "$or" {
+					paths[path] = field
+					assert.Equal(t, 7, value, path)
+					continue
+				}
+				// the copy is stale if any profile field differs
+				names := value.(bson.A)
+				assert.Len(t, names, 2, path)
+				for _, n := range names {
+					for _, ne := range n.(bson.M) {
+						assert.Contains(t, []interface{}{"Anna", "anna"}, ne.(bson.M)["$ne"], path)
+					}
+				}
+			}
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"users":                       "_id",
+		"operations":                  "donor._id",
+		"operations.recipients":       "_id",
+		"operations.co_payers":        "user._id",
+		"operations.items.recipients": "_id",
+	}, paths)
+}
+
+func TestUserInRoomsUpdate(t *testing.T) {
+	u := api.User{ID: 7, DisplayName: "Anna", Username: "anna"}
+	update, arrayFilters := userInRoomsUpdate(u)
+
+	declared := map[string]bool{}
+	for _, f := range arrayFilters.Filters {
+		for field, value := range f.(bson.M) {
+			assert.Equal(t, 7, value, field)
+			declared[regexp.MustCompile(`^\w+`).FindString(field)] = true
+		}
+	}
+
+	identifierRe := regexp.MustCompile(`\$\[(\w*)]`)
+	used := map[string]bool{}
+	for path, value := range update["$set"].(bson.M) {
+		assert.Contains(t, []interface{}{"Anna", "anna"}, value, path)
+		for _, m := range identifierRe.FindAllStringSubmatch(path, -1) {
+			// "$[]" fails on operations without recipients, every array must be filtered
+			assert.NotEmpty(t, m[1], path)
+			assert.True(t, declared[m[1]], "%s has no array filter for %s", path, m[1])
+			used[m[1]] = true
+		}
+	}
+	// mongo refuses array filters, which are not used in the update
+	assert.Equal(t, declared, used)
+}
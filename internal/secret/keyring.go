@@ -0,0 +1,148 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/almaznur91/splitty/internal/api"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+)
+
+const dataKeySize = 32
+
+// Keyring encrypts values by envelope encryption: every value has its own data key encrypted by the master key.
+// The first master key encrypts new values, the rest ones only decrypt values sealed before the rotation
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring parses master keys from the spec "id:base64key,id:base64key", keys are AES-256 keys
+func NewKeyring(spec string) (*Keyring, error) {
+	k := &Keyring{keys: map[string]cipher.AEAD{}}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("master key %q is not in format id:base64key", item)
+		}
+		id := parts[0]
+		if _, ok := k.keys[id]; ok {
+			return nil, errors.Errorf("master key %s is duplicated", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "master key %s is not base64", id)
+		}
+		if len(key) != dataKeySize {
+			return nil, errors.Errorf("master key %s has %d bytes, expected %d", id, len(key), dataKeySize)
+		}
+		if k.keys[id], err = newAEAD(key); err != nil {
+			return nil, err
+		}
+		if k.current == "" {
+			k.current = id
+		}
+	}
+	if k.current == "" {
+		return nil, errors.New("no master keys")
+	}
+	return k, nil
+}
+
+// Current returns id of the master key, which encrypts new values
+func (k *Keyring) Current() string {
+	return k.current
+}
+
+// Seal encrypts the value by the new data key, aad binds the value to its owner, the same aad is needed to open it
+func (k *Keyring) Seal(plaintext string, aad string) (*api.Sealed, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	s := &api.Sealed{KeyId: k.current}
+	if s.DataKey, err = seal(k.keys[k.current], dataKey, []byte(k.current)); err != nil {
+		return nil, err
+	}
+	if s.Data, err = seal(data, []byte(plaintext), []byte(aad)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Open decrypts the value sealed by any master key of the keyring
+func (k *Keyring) Open(s *api.Sealed, aad string) (string, error) {
+	dataKey, err := k.openDataKey(s)
+	if err != nil {
+		return "", err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(data, s.Data, []byte(aad))
+	if err != nil {
+		return "", errors.Wrap(err, "cannot decrypt value")
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap encrypts the data key of the value by the current master key, the value itself is not changed
+func (k *Keyring) Rewrap(s *api.Sealed) (*api.Sealed, error) {
+	dataKey, err := k.openDataKey(s)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(k.keys[k.current], dataKey, []byte(k.current))
+	if err != nil {
+		return nil, err
+	}
+	return &api.Sealed{KeyId: k.current, DataKey: wrapped, Data: s.Data}, nil
+}
+
+func (k *Keyring) openDataKey(s *api.Sealed) ([]byte, error) {
+	master, ok := k.keys[s.KeyId]
+	if !ok {
+		return nil, errors.Errorf("unknown master key %s", s.KeyId)
+	}
+	dataKey, err := open(master, s.DataKey, []byte(s.KeyId))
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decrypt data key by master key %s", s.KeyId)
+	}
+	return dataKey, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns the random nonce followed by the ciphertext
+func seal(a cipher.AEAD, plaintext []byte, aad []byte) ([]byte, error) {
+	nonce := make([]byte, a.NonceSize(), a.NonceSize()+len(plaintext)+a.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return a.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(a cipher.AEAD, sealed []byte, aad []byte) ([]byte, error) {
+	if len(sealed) < a.NonceSize() {
+		return nil, errors.New("sealed value is too short")
+	}
+	return a.Open(nil, sealed[:a.NonceSize()], sealed[a.NonceSize():], aad)
+}
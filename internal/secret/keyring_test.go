@@ -0,0 +1,69 @@
+package secret
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func masterKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), dataKeySize)))
+}
+
+func TestKeyringSealOpen(t *testing.T) {
+	k, err := NewKeyring("v1:" + masterKey('a'))
+	assert.NoError(t, err)
+
+	s, err := k.Seal("DE89 3704 0044 0532 0130 00", "user:1:bank_details")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", s.KeyId)
+	assert.NotContains(t, string(s.Data), "DE89")
+
+	plaintext, err := k.Open(s, "user:1:bank_details")
+	assert.NoError(t, err)
+	assert.Equal(t, "DE89 3704 0044 0532 0130 00", plaintext)
+
+	_, err = k.Open(s, "user:2:bank_details")
+	assert.Error(t, err, "the value of another user can't be opened")
+
+	other, _ := k.Seal("DE89 3704 0044 0532 0130 00", "user:1:bank_details")
+	assert.NotEqual(t, s.DataKey, other.DataKey, "every value has its own data key")
+}
+
+func TestKeyringRotation(t *testing.T) {
+	old, err := NewKeyring("v1:" + masterKey('a'))
+	assert.NoError(t, err)
+	s, err := old.Seal("card 4111 1111 1111 1111", "user:1:payment_methods")
+	assert.NoError(t, err)
+
+	k, err := NewKeyring("v2:" + masterKey('b') + ", v1:" + masterKey('a'))
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", k.Current())
+	plaintext, err := k.Open(s, "user:1:payment_methods")
+	assert.NoError(t, err)
+	assert.Equal(t, "card 4111 1111 1111 1111", plaintext)
+
+	rewrapped, err := k.Rewrap(s)
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", rewrapped.KeyId)
+	assert.Equal(t, s.Data, rewrapped.Data)
+
+	// the old key is removed after the rotation
+	k, err = NewKeyring("v2:" + masterKey('b'))
+	assert.NoError(t, err)
+	plaintext, err = k.Open(rewrapped, "user:1:payment_methods")
+	assert.NoError(t, err)
+	assert.Equal(t, "card 4111 1111 1111 1111", plaintext)
+	_, err = k.Open(s, "user:1:payment_methods")
+	assert.Error(t, err)
+}
+
+func TestNewKeyring(t *testing.T) {
+	for _, spec := range []string{"", "v1", "v1:not base64", "v1:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"v1:" + masterKey('a') + ",v1:" + masterKey('b')} {
+		_, err := NewKeyring(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -2,19 +2,27 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"github.com/almaznur91/splitty/internal/api"
 	"github.com/almaznur91/splitty/internal/repository"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"math"
+	"math/rand"
 	"sort"
+	"strings"
+	"time"
+	"unicode"
 )
 
-func NewUserService(r repository.UserRepository) *UserService {
-	return &UserService{r}
+func NewUserService(r repository.UserRepository, rr repository.RoomRepository) *UserService {
+	return &UserService{r, rr}
 }
 
-func NewRoomService(r repository.RoomRepository) *RoomService {
-	return &RoomService{r}
+func NewRoomService(r repository.RoomRepository, c RoomChanges) *RoomService {
+	return &RoomService{r, c}
 }
 
 func NewChatStateService(r repository.ChatStateRepository) *ChatStateService {
@@ -25,8 +33,20 @@ func NewButtonService(r repository.ButtonRepository) *ButtonService {
 	return &ButtonService{r}
 }
 
-func NewOperationService(r repository.RoomRepository) *OperationService {
-	return &OperationService{r}
+func NewOperationService(r repository.RoomRepository, c RoomChanges) *OperationService {
+	return &OperationService{r, c}
+}
+
+func NewOutboxService(r repository.OutboxRepository) *OutboxService {
+	return &OutboxService{r}
+}
+
+func NewAdminService(a repository.AuditRepository, r repository.UserRepository, rr repository.RoomRepository) *AdminService {
+	return &AdminService{a, r, rr}
+}
+
+func NewRoomChanges() RoomChanges {
+	return make(RoomChanges, roomChangesBuffer)
 }
 
 func NewStatisticService(r *RoomService, s *OperationService) *StatisticService {
@@ -39,10 +59,12 @@ func NewRoomStateService(s *OperationService, rr repository.RoomRepository) *Roo
 
 type UserService struct {
 	repository.UserRepository
+	rr repository.RoomRepository
 }
 
 type RoomService struct {
 	repository.RoomRepository
+	changes RoomChanges
 }
 
 type ChatStateService struct {
@@ -53,8 +75,37 @@ type ButtonService struct {
 	repository.ButtonRepository
 }
 
+type OutboxService struct {
+	repository.OutboxRepository
+}
+
+// AdminService serves commands of super users and audits them
+type AdminService struct {
+	repository.AuditRepository
+	ur repository.UserRepository
+	rr repository.RoomRepository
+}
+
 type OperationService struct {
 	repository.RoomRepository
+	changes RoomChanges
+}
+
+// RoomChanges delivers ids of rooms whose operations or states have been changed
+type RoomChanges chan string
+
+const roomChangesBuffer = 100
+
+// notify does not block, the change is skipped if the buffer is full
+func (c RoomChanges) notify(roomId string) {
+	if c == nil {
+		return
+	}
+	select {
+	case c <- roomId:
+	default:
+		log.Warn().Msgf("room change skipped, id:%s", roomId)
+	}
 }
 
 type StatisticService struct {
@@ -67,24 +118,733 @@ type RoomStateService struct {
 	OperationService
 }
 
+// ValidateRooms checks invariants of all rooms and returns found violations
+func (rs *RoomService) ValidateRooms(ctx context.Context) ([]api.Violation, error) {
+	rooms, err := rs.RoomRepository.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var violations []api.Violation
+	for _, r := range *rooms {
+		violations = append(violations, ValidateRoom(r)...)
+	}
+	return violations, nil
+}
+
 func (rs *RoomService) CreateRoom(ctx context.Context, r *api.Room) (*api.Room, error) {
 	rId, err := rs.RoomRepository.SaveRoom(ctx, r)
 	r.ID = rId
 	return r, err
 }
 
-func (css *ChatStateService) CleanChatState(ctx context.Context, state *api.ChatState) {
-	if state == nil {
-		return
-	} else if err := (*css).DeleteByUserId(ctx, state.UserId); err != nil {
-		log.Error().Err(err).Msg("")
+// AddVirtualMember adds member without telegram account, his balance managed by manager
+func (rs *RoomService) AddVirtualMember(ctx context.Context, roomId string, name string, manager *api.User) (*api.User, error) {
+	room, err := rs.RoomRepository.FindById(ctx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	if !containsUserId(room.Members, manager.ID) {
+		return nil, errors.Errorf("user %d is not a member of room %s", manager.ID, roomId)
+	}
+	id := newVirtualUserId()
+	for containsUserId(room.Members, id) {
+		id = newVirtualUserId()
+	}
+	u := api.User{ID: id, DisplayName: name, IsVirtual: true, ManagerId: manager.ID}
+	if err := rs.RoomRepository.JoinToRoom(ctx, u, roomId); err != nil {
+		return nil, err
+	}
+	rs.changes.notify(roomId)
+	return &u, nil
+}
+
+// MergeVirtualMember replaces virtual member with real user in the room and reassigns all his operations
+func (rs *RoomService) MergeVirtualMember(ctx context.Context, roomId string, virtualId int, u api.User) error {
+	err := updateRoom(ctx, rs.RoomRepository, roomId, func(room *api.Room) (bool, error) {
+		return true, mergeMember(room, virtualId, u)
+	})
+	if err != nil {
+		return err
+	}
+	rs.changes.notify(roomId)
+	return nil
+}
+
+func (rs *RoomService) JoinToRoom(ctx context.Context, u api.User, roomId string) error {
+	if err := rs.RoomRepository.JoinToRoom(ctx, u, roomId); err != nil {
+		return err
+	}
+	rs.changes.notify(roomId)
+	return nil
+}
+
+func (rs *RoomService) LeaveRoom(ctx context.Context, userId int, roomId string) error {
+	if err := rs.RoomRepository.LeaveRoom(ctx, userId, roomId); err != nil {
+		return err
+	}
+	rs.changes.notify(roomId)
+	return nil
+}
+
+// updateRoomAttempts limits retries of updateRoom, when the room is changed by others all the time
+const updateRoomAttempts = 5
+
+// updateRoom reads the room, applies the change and saves the room as a whole. The change is applied to the fresh room
+// again, if the room has been changed concurrently. The room is not saved, if the change reports that nothing changed
+func updateRoom(ctx context.Context, rr repository.RoomRepository, roomId string, change func(room *api.Room) (bool, error)) error {
+	for attempt := 1; ; attempt++ {
+		room, err := rr.FindById(ctx, roomId)
+		if err != nil {
+			return err
+		}
+		changed, err := change(room)
+		if err != nil || !changed {
+			return err
+		}
+		err = rr.UpdateRoom(ctx, room)
+		if !errors.Is(err, api.ErrRoomChanged) || attempt == updateRoomAttempts {
+			return err
+		}
+		log.Ctx(ctx).Debug().Err(err).Msgf("room %s is changed concurrently, attempt %d", roomId, attempt)
+	}
+}
+
+// newVirtualUserId returns negative id, so it never intersects with telegram user ids
+func newVirtualUserId() int {
+	return -(rand.Intn(math.MaxInt32-1) + 1)
+}
+
+func mergeMember(room *api.Room, virtualId int, u api.User) error {
+	virtual := room.FindMember(virtualId)
+	if virtual == nil || !virtual.IsMergeable() {
+		return errors.Errorf("virtual member %d not found in room %s", virtualId, room.ID.Hex())
+	}
+	if o := sharedOperation(room, virtualId, u.ID); o != nil {
+		return errors.Wrapf(api.ErrMergeConflict, "operation %s of room %s", o.ID.Hex(), room.ID.Hex())
+	}
+	if containsUserId(room.Members, u.ID) {
+		*room.Members = deleteUser(*room.Members, virtualId)
+	} else {
+		*virtual = u
+	}
+	replaceInOperations(room, virtualId, u)
+	replaceInStates(room, virtualId, u.ID)
+	return nil
+}
+
+// anonymizeMember replaces the user by the anonymous member everywhere in the room, sums of operations are kept,
+// so debts of other members stay the same
+func anonymizeMember(room *api.Room, userId int, anonymous api.User) {
+	if m := room.FindMember(userId); m != nil {
+		*m = anonymous
+	}
+	for i := range *room.Members {
+		if m := &(*room.Members)[i]; m.IsManagedBy(userId) {
+			m.ManagerId = 0
+		}
+	}
+	replaceInOperations(room, userId, anonymous)
+	if room.Operations != nil {
+		for i := range *room.Operations {
+			op := &(*room.Operations)[i]
+			op.NotificationSent = replaceInt(op.NotificationSent, userId, anonymous.ID)
+		}
+	}
+	replaceInStates(room, userId, anonymous.ID)
+	for i := range room.Reminder.Sent {
+		if room.Reminder.Sent[i].UserId == userId {
+			room.Reminder.Sent[i].UserId = anonymous.ID
+		}
+	}
+}
+
+// replaceInOperations replaces copies of the user with id by u in donors, recipients, items and co-payers
+func replaceInOperations(room *api.Room, id int, u api.User) {
+	if room.Operations == nil {
+		return
+	}
+	for i := range *room.Operations {
+		op := &(*room.Operations)[i]
+		if op.Donor != nil && op.Donor.ID == id {
+			donor := u
+			op.Donor = &donor
+		}
+		if op.Recipients != nil {
+			*op.Recipients = replaceUser(*op.Recipients, id, u)
+		}
+		for j := range op.Items {
+			op.Items[j].Recipients = replaceUser(op.Items[j].Recipients, id, u)
+		}
+		for j := range op.CoPayers {
+			if op.CoPayers[j].User.ID == id {
+				coPayer := u
+				op.CoPayers[j].User = &coPayer
+			}
+		}
+	}
+}
+
+func replaceInStates(room *api.Room, id int, newId int) {
+	states := &room.RoomStates
+	states.FinishedAddOperation = replaceInt(states.FinishedAddOperation, id, newId)
+	states.PaidOffDebt = replaceInt(states.PaidOffDebt, id, newId)
+	states.Archived = replaceInt(states.Archived, id, newId)
+}
+
+// sharedOperation returns the operation, where both users are payers or both are recipients of the operation or
+// its item. Replacing one of them by the other there would drop a share, so such members are not merged
+func sharedOperation(room *api.Room, id int, otherId int) *api.Operation {
+	if room.Operations == nil {
+		return nil
+	}
+	both := func(users []api.User) bool {
+		return containsUserId(&users, id) && containsUserId(&users, otherId)
+	}
+	for i := range *room.Operations {
+		o := &(*room.Operations)[i]
+		var payers []api.User
+		for _, p := range o.Payers() {
+			if p.User != nil {
+				payers = append(payers, *p.User)
+			}
+		}
+		if both(payers) || (o.Recipients != nil && both(*o.Recipients)) {
+			return o
+		}
+		for _, item := range o.Items {
+			if both(item.Recipients) {
+				return o
+			}
+		}
+	}
+	return nil
+}
+
+// replaceUser replaces user with id by u, callers check that u is not in users yet
+func replaceUser(users []api.User, id int, u api.User) []api.User {
+	result := make([]api.User, 0, len(users))
+	for _, v := range users {
+		if v.ID == id {
+			v = u
+		}
+		result = append(result, v)
+	}
+	return result
+}
+
+func replaceInt(s []int, old int, new int) []int {
+	var result []int
+	for _, v := range s {
+		if v == old {
+			v = new
+		}
+		if !containsInt(result, v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func containsInt(s []int, e int) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
+
+// UpsertUser saves user profile and propagates changed name to the user copies stored in rooms
+func (us *UserService) UpsertUser(ctx context.Context, u api.User) (*api.User, error) {
+	old, err := us.UserRepository.FindById(ctx, u.ID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	user, err := us.UserRepository.UpsertUser(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if old != nil && (old.DisplayName != user.DisplayName || old.Username != user.Username) {
+		if err := us.rr.UpdateUserInRooms(ctx, *user); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("cannot update user in rooms, id:%d", user.ID)
+		}
+	}
+	return user, nil
+}
+
+// SecureBankDetails encrypts bank details left in plaintext by old versions, re-encrypts them after rotation of
+// the master key and removes plaintext bank details from user copies stored in rooms
+func (us *UserService) SecureBankDetails(ctx context.Context) error {
+	count, err := us.UserRepository.ResealUsers(ctx)
+	if err != nil {
+		return err
+	}
+	log.Ctx(ctx).Info().Msgf("bank details of %d users are encrypted", count)
+
+	rooms, err := us.rr.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range *rooms {
+		if !stripBankDetails(&r) {
+			continue
+		}
+		if err := updateRoom(ctx, us.rr, r.ID.Hex(), func(room *api.Room) (bool, error) {
+			return stripBankDetails(room), nil
+		}); err != nil {
+			return errors.Wrapf(err, "cannot remove bank details from room %s", r.ID.Hex())
+		}
+	}
+	return nil
+}
+
+// PaymentDetailsFor returns the owner with bank details and payment methods, if the viewer or his virtual member owes
+// the owner or his virtual member in any room. It returns nil for everybody else
+func (us *UserService) PaymentDetailsFor(ctx context.Context, ownerId int, viewerId int) (*api.User, error) {
+	owner, err := us.UserRepository.FindById(ctx, ownerId)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil || (owner.BankDetails == "" && len(owner.Payments) == 0) {
+		return nil, err
+	}
+	rooms, err := us.rr.FindRoomsWithUser(ctx, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	owes, err := OwesTo(*rooms, ownerId, viewerId)
+	if err != nil || !owes {
+		return nil, err
+	}
+	return owner, nil
+}
+
+// AddPaymentMethod parses the payment method of the kind from the text and appends it to the methods of the user,
+// the error wraps api.ErrInvalidPaymentMethod if the text can't be parsed
+func (us *UserService) AddPaymentMethod(ctx context.Context, userId int, kind string, text string) (*api.PaymentMethod, error) {
+	m, err := ParsePaymentMethod(kind, text)
+	if err != nil {
+		return nil, err
+	}
+	user, err := us.UserRepository.FindById(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if len(user.Payments) >= maxPaymentMethods {
+		return nil, errors.Errorf("user %d has %d payment methods already", userId, len(user.Payments))
+	}
+	m.ID = primitive.NewObjectID()
+	return m, us.UserRepository.SetPaymentMethods(ctx, userId, append(user.Payments, *m))
+}
+
+// DeletePaymentMethod deletes the payment method of the user by id
+func (us *UserService) DeletePaymentMethod(ctx context.Context, userId int, id string) error {
+	user, err := us.UserRepository.FindById(ctx, userId)
+	if err != nil {
+		return err
+	}
+	var methods []api.PaymentMethod
+	for _, m := range user.Payments {
+		if m.ID.Hex() != id {
+			methods = append(methods, m)
+		}
+	}
+	return us.UserRepository.SetPaymentMethods(ctx, userId, methods)
+}
+
+// OwesTo checks that the debtor has unpaid debts to the lender, debts of virtual members belong to their managers
+func OwesTo(rooms []api.Room, lenderId int, debtorId int) (bool, error) {
+	for _, room := range rooms {
+		debts, err := GetRoomDebts(room)
+		if err != nil {
+			return false, errors.Wrapf(err, "cannot calculate debts of room %s", room.ID.Hex())
+		}
+		for _, d := range debts {
+			if d.Unpaid() < 1 {
+				continue
+			}
+			if (d.Lender.ID == lenderId || d.Lender.IsManagedBy(lenderId)) &&
+				(d.Debtor.ID == debtorId || d.Debtor.IsManagedBy(debtorId)) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+const maxPaymentMethods = 10
+
+// ParsePaymentMethod parses the payment method of the kind from lines: currency, account and optional BIC or bank.
+// Accounts are validated by their check digits where they exist
+func ParsePaymentMethod(kind string, text string) (*api.PaymentMethod, error) {
+	var lines []string
+	for _, l := range strings.Split(text, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) < 2 || len(lines) > 3 {
+		return nil, errors.Wrap(api.ErrInvalidPaymentMethod, "currency and account are expected")
+	}
+	m := &api.PaymentMethod{Kind: kind, Currency: strings.ToUpper(lines[0])}
+	if len(m.Currency) != 3 || strings.IndexFunc(m.Currency, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+		return nil, errors.Wrapf(api.ErrInvalidPaymentMethod, "currency %q is not ISO 4217 code", lines[0])
+	}
+	var extra string
+	if len(lines) == 3 {
+		extra = lines[2]
+	}
+
+	switch kind {
+	case api.PaymentIban:
+		m.Account = strings.ToUpper(strings.Join(strings.Fields(lines[1]), ""))
+		if !validIban(m.Account) {
+			return nil, errors.Wrapf(api.ErrInvalidPaymentMethod, "%s is not valid IBAN", m.Account)
+		}
+		m.Bic = strings.ToUpper(extra)
+		if m.Bic != "" && !validBic(m.Bic) {
+			return nil, errors.Wrapf(api.ErrInvalidPaymentMethod, "%s is not valid BIC", m.Bic)
+		}
+	case api.PaymentCard:
+		m.Account = strings.Join(strings.FieldsFunc(lines[1], func(r rune) bool { return r == ' ' || r == '-' }), "")
+		if len(m.Account) < 13 || len(m.Account) > 19 || !luhn(m.Account) {
+			return nil, errors.Wrapf(api.ErrInvalidPaymentMethod, "%s is not valid card number", lines[1])
+		}
+		m.Bank = extra
+	case api.PaymentPhone:
+		m.Account = strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) || r == '+' {
+				return r
+			}
+			if strings.ContainsRune(" -()", r) {
+				return -1
+			}
+			return '?'
+		}, lines[1])
+		digits := strings.TrimPrefix(m.Account, "+")
+		if !strings.HasPrefix(m.Account, "+") || len(digits) < 7 || len(digits) > 15 || !isDigits(digits) {
+			return nil, errors.Wrapf(api.ErrInvalidPaymentMethod, "%s is not valid phone number in international format", lines[1])
+		}
+		m.Bank = extra
+	case api.PaymentPaypal:
+		handle := strings.TrimRight(lines[1], "/")
+		for _, prefix := range []string{"https://", "http://", "www.", "paypal.me/", "@"} {
+			if strings.HasPrefix(strings.ToLower(handle), prefix) {
+				handle = handle[len(prefix):]
+			}
+		}
+		if handle == "" || len(handle) > 20 || strings.IndexFunc(handle, func(r rune) bool {
+			return r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r))
+		}) >= 0 {
+			return nil, errors.Wrapf(api.ErrInvalidPaymentMethod, "%s is not valid paypal.me handle", lines[1])
+		}
+		if extra != "" {
+			return nil, errors.Wrap(api.ErrInvalidPaymentMethod, "paypal.me handle has no bank")
+		}
+		m.Account = handle
+	default:
+		return nil, errors.Wrapf(api.ErrInvalidPaymentMethod, "unknown payment method %s", kind)
+	}
+	return m, nil
+}
+
+// validIban checks the country code, the length and the mod-97 check digits of the IBAN
+func validIban(iban string) bool {
+	if len(iban) < 15 || len(iban) > 34 || !unicode.IsLetter(rune(iban[0])) || !unicode.IsLetter(rune(iban[1])) ||
+		!isDigits(iban[2:4]) {
+		return false
+	}
+	var mod int
+	for _, r := range iban[4:] + iban[:4] {
+		switch {
+		case r >= '0' && r <= '9':
+			mod = (mod*10 + int(r-'0')) % 97
+		case r >= 'A' && r <= 'Z':
+			mod = (mod*100 + int(r-'A') + 10) % 97
+		default:
+			return false
+		}
+	}
+	return mod == 1
+}
+
+// validBic checks the format of the BIC: bank, country, location and optional branch codes
+func validBic(bic string) bool {
+	if len(bic) != 8 && len(bic) != 11 {
+		return false
+	}
+	for i, r := range bic {
+		letter := r >= 'A' && r <= 'Z'
+		if !letter && (i < 6 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// luhn checks the check digit of the card number
+func luhn(number string) bool {
+	if !isDigits(number) {
+		return false
+	}
+	var sum int
+	for i := range number {
+		d := int(number[len(number)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+func isDigits(s string) bool {
+	return s != "" && strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' }) < 0
+}
+
+// stripBankDetails clears plaintext bank details in all user copies of the room, it returns true if any was found
+func stripBankDetails(room *api.Room) bool {
+	var found bool
+	strip := func(u *api.User) {
+		if u != nil && u.LegacyBank != "" {
+			u.LegacyBank = ""
+			found = true
+		}
+	}
+	for i := range *room.Members {
+		strip(&(*room.Members)[i])
+	}
+	if room.Operations == nil {
+		return found
+	}
+	for i := range *room.Operations {
+		op := &(*room.Operations)[i]
+		strip(op.Donor)
+		if op.Recipients != nil {
+			for j := range *op.Recipients {
+				strip(&(*op.Recipients)[j])
+			}
+		}
+		for j := range op.CoPayers {
+			strip(op.CoPayers[j].User)
+		}
+		for j := range op.Items {
+			for k := range op.Items[j].Recipients {
+				strip(&op.Items[j].Recipients[k])
+			}
+		}
+	}
+	return found
+}
+
+// ExportUserData collects the profile of the user and all rooms, in which he takes part
+func (us *UserService) ExportUserData(ctx context.Context, userId int, now time.Time) (*api.UserExport, error) {
+	user, err := us.UserRepository.FindById(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	rooms, err := us.rr.FindRoomsWithUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	return NewUserExport(*user, *rooms, now)
+}
+
+// OutstandingDebts returns unpaid debts of the user and to the user in all rooms including archived ones
+func (us *UserService) OutstandingDebts(ctx context.Context, userId int) ([]api.RoomDebt, error) {
+	rooms, err := us.rr.FindRoomsWithUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	return UserOutstandingDebts(userId, *rooms)
+}
+
+// DeleteUser replaces the user by anonymous members in all rooms and deletes his profile.
+// Every room gets its own anonymous member, so rooms of the deleted user can not be linked by his id
+func (us *UserService) DeleteUser(ctx context.Context, userId int) error {
+	rooms, err := us.rr.FindRoomsWithUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	for _, r := range *rooms {
+		if err := updateRoom(ctx, us.rr, r.ID.Hex(), func(room *api.Room) (bool, error) {
+			id := newVirtualUserId()
+			for containsUserId(room.Members, id) {
+				id = newVirtualUserId()
+			}
+			anonymizeMember(room, userId, api.User{ID: id, DisplayName: api.DeletedUserName, IsVirtual: true, Deleted: true})
+			return true, nil
+		}); err != nil {
+			return errors.Wrapf(err, "cannot anonymize user in room %s", r.ID.Hex())
+		}
+	}
+	return us.UserRepository.DeleteUser(ctx, userId)
+}
+
+// NewUserExport returns the profile of the user and rooms with his operations and debts
+func NewUserExport(user api.User, rooms []api.Room, now time.Time) (*api.UserExport, error) {
+	export := &api.UserExport{ExportedAt: now, Profile: user, BankDetails: user.BankDetails, Rooms: []api.RoomExport{}}
+	for _, room := range rooms {
+		r := api.RoomExport{
+			ID:         room.ID.Hex(),
+			Name:       room.Name,
+			CreateAt:   room.CreateAt,
+			Members:    []string{},
+			Operations: []api.Operation{},
+			Debts:      []api.Debt{},
+		}
+		for _, m := range *room.Members {
+			r.Members = append(r.Members, m.DisplayName)
+		}
+		if room.Operations != nil {
+			for _, o := range *room.Operations {
+				if o.IsPayer(user.ID) || isRecipient(&o, user.ID) {
+					r.Operations = append(r.Operations, o)
+				}
+			}
+		}
+		debts, err := GetRoomDebts(room)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot calculate debts of room %s", room.ID.Hex())
+		}
+		for _, d := range debts {
+			if d.Debtor.ID == user.ID || d.Lender.ID == user.ID {
+				r.Debts = append(r.Debts, d)
+			}
+		}
+		export.Rooms = append(export.Rooms, r)
+	}
+	return export, nil
+}
+
+// UserOutstandingDebts returns debts of the user and to the user, which are not repaid even without confirmation
+func UserOutstandingDebts(userId int, rooms []api.Room) ([]api.RoomDebt, error) {
+	var result []api.RoomDebt
+	for _, room := range rooms {
+		debts, err := GetRoomDebts(room)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot calculate debts of room %s", room.ID.Hex())
+		}
+		for _, d := range debts {
+			if d.Unpaid() >= 1 && (d.Debtor.ID == userId || d.Lender.ID == userId) {
+				result = append(result, api.RoomDebt{RoomId: room.ID.Hex(), RoomName: room.Name, Debt: d})
+			}
+		}
+	}
+	return result, nil
+}
+
+// isRecipient checks that the user is the recipient of the operation or of any its item
+func isRecipient(o *api.Operation, userId int) bool {
+	if o.Recipients != nil && containsUserId(o.Recipients, userId) {
+		return true
+	}
+	for _, item := range o.Items {
+		if containsUserId(&item.Recipients, userId) {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncUsersInRooms backfills user copies stored in rooms with the actual profiles. Only rooms with stale copies
+// are written, so the sync on every start changes nothing after the first one.
+// The user failed to update is logged and skipped, the rest of users are synced anyway
+func (us *UserService) SyncUsersInRooms(ctx context.Context) error {
+	users, err := us.UserRepository.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, u := range *users {
+		if err := us.rr.UpdateUserInRooms(ctx, u); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("cannot update user in rooms, id:%d", u.ID)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d users are not updated in rooms", failed, len(*users))
+	}
+	return nil
+}
+
+// adminStatsPeriods are periods in days, users, rooms and operations created within them are counted
+var adminStatsPeriods = []int{1, 7, 30}
+
+// Stats counts users, rooms and operations in total and created within last days
+func (as *AdminService) Stats(ctx context.Context, now time.Time) (*api.AdminStats, error) {
+	total, err := as.statsSince(ctx, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	stats := &api.AdminStats{Users: total.Users, Rooms: total.Rooms, Operations: total.Operations}
+	for _, days := range adminStatsPeriods {
+		p, err := as.statsSince(ctx, now.AddDate(0, 0, -days))
+		if err != nil {
+			return nil, err
+		}
+		p.Days = days
+		stats.Periods = append(stats.Periods, *p)
+	}
+	return stats, nil
+}
+
+func (as *AdminService) statsSince(ctx context.Context, since time.Time) (*api.AdminStatsPeriod, error) {
+	users, err := as.ur.CountUsers(ctx, since)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot count users")
+	}
+	rooms, err := as.rr.CountRooms(ctx, since)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot count rooms")
+	}
+	operations, err := as.rr.CountOperations(ctx, since)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot count operations")
+	}
+	return &api.AdminStatsPeriod{Users: users, Rooms: rooms, Operations: operations}, nil
+}
+
+// Audit saves the action of the super user, the failure is logged only and does not stop the action
+func (as *AdminService) Audit(ctx context.Context, r *api.AuditRecord) {
+	if r.CreateAt.IsZero() {
+		r.CreateAt = time.Now()
+	}
+	if err := as.AuditRepository.Save(ctx, r); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("cannot audit action %s of admin %d", r.Action, r.AdminId)
+	}
+}
+
+func (css *ChatStateService) CleanChatState(ctx context.Context, state *api.ChatState) {
+	if state == nil {
+		return
+	} else if err := (*css).DeleteByUserId(ctx, state.UserId); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("")
+	}
+}
+
+func (s *OperationService) UpsertOperation(ctx context.Context, o *api.Operation, roomId string) error {
+	if err := s.RoomRepository.UpsertOperation(ctx, o, roomId); err != nil {
+		return err
+	}
+	s.changes.notify(roomId)
+	return nil
+}
+
+func (s *OperationService) DeleteOperation(ctx context.Context, roomId string, operationId primitive.ObjectID) error {
+	if err := s.RoomRepository.DeleteOperation(ctx, roomId, operationId); err != nil {
+		return err
 	}
+	s.changes.notify(roomId)
+	return nil
 }
 
 func (s *OperationService) GetAllOperations(ctx context.Context, roomId string) (*[]api.Operation, error) {
 	room, err := s.RoomRepository.FindById(ctx, roomId)
 	if err != nil {
-		log.Err(err).Msgf("cannot find room id:%s", roomId)
+		log.Ctx(ctx).Err(err).Msgf("cannot find room id:%s", roomId)
 		return nil, err
 	}
 	return room.Operations, nil
@@ -93,7 +853,7 @@ func (s *OperationService) GetAllOperations(ctx context.Context, roomId string)
 func (s *OperationService) GetAllDebtOperations(ctx context.Context, roomId string) (*[]api.Operation, error) {
 	room, err := s.RoomRepository.FindById(ctx, roomId)
 	if err != nil {
-		log.Err(err).Msgf("cannot find room id: %s", roomId)
+		log.Ctx(ctx).Err(err).Msgf("cannot find room id: %s", roomId)
 		return nil, err
 	}
 	var debtOperations []api.Operation
@@ -108,7 +868,7 @@ func (s *OperationService) GetAllDebtOperations(ctx context.Context, roomId stri
 func (s *OperationService) GetAllSpendOperations(ctx context.Context, roomId string) (*[]api.Operation, error) {
 	room, err := s.RoomRepository.FindById(ctx, roomId)
 	if err != nil {
-		log.Err(err).Msgf("cannot find room id: %s", roomId)
+		log.Ctx(ctx).Err(err).Msgf("cannot find room id: %s", roomId)
 		return nil, err
 	}
 	var spendOperations []api.Operation
@@ -123,7 +883,7 @@ func (s *OperationService) GetAllSpendOperations(ctx context.Context, roomId str
 func (s *OperationService) GetUserSpendOperations(ctx context.Context, userId int, roomId string) (*[]api.Operation, error) {
 	room, err := s.RoomRepository.FindById(ctx, roomId)
 	if err != nil {
-		log.Err(err).Msgf("cannot find room id: %s", roomId)
+		log.Ctx(ctx).Err(err).Msgf("cannot find room id: %s", roomId)
 		return nil, err
 	}
 	var spendUserOperations []api.Operation
@@ -138,7 +898,7 @@ func (s *OperationService) GetUserSpendOperations(ctx context.Context, userId in
 func (s *OperationService) GetUserParticipateInOperations(ctx context.Context, userId int, roomId string) (*[]api.Operation, error) {
 	room, err := s.RoomRepository.FindById(ctx, roomId)
 	if err != nil {
-		log.Err(err).Msgf("cannot find room id: %s", roomId)
+		log.Ctx(ctx).Err(err).Msgf("cannot find room id: %s", roomId)
 		return nil, err
 	}
 	var participateInOperations []api.Operation
@@ -173,7 +933,7 @@ func (s *OperationService) GetUserDebts(ctx context.Context, userId int, roomId
 
 	var uDbts []api.Debt
 	for _, debt := range allDbt {
-		if debt.Debtor.ID == userId {
+		if debt.Debtor.ID == userId || debt.Debtor.IsManagedBy(userId) {
 			uDbts = append(uDbts, debt)
 		}
 	}
@@ -196,13 +956,255 @@ func (s *OperationService) GetUserDebt(ctx context.Context, debtorId int, lender
 func (s *OperationService) GetAllDebts(ctx context.Context, roomId string) ([]api.Debt, error) {
 	room, err := s.RoomRepository.FindById(ctx, roomId)
 	if err != nil || room == nil {
-		log.Err(err).Msgf("cannot find room id: %s", roomId)
+		log.Ctx(ctx).Err(err).Msgf("cannot find room id: %s", roomId)
 		return nil, err
 	}
 
 	return GetRoomDebts(*room)
 }
 
+// GetUserBalances returns balances of the user with every counterparty over all active rooms
+func (s *OperationService) GetUserBalances(ctx context.Context, userId int) ([]api.Balance, error) {
+	rooms, err := s.RoomRepository.FindRoomsByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	return CalculateBalances(userId, *rooms)
+}
+
+// GetUserBalance returns balance of the user with the counterparty over all active rooms, nil if they have no debts
+func (s *OperationService) GetUserBalance(ctx context.Context, userId int, counterpartyId int) (*api.Balance, error) {
+	balances, err := s.GetUserBalances(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range balances {
+		if b.Counterparty.ID == counterpartyId {
+			return &b, nil
+		}
+	}
+	return nil, nil
+}
+
+// SettleBalance records repayments of all debts between the user and the counterparty as one settlement.
+// Repayments are added once per settlement, so the settlement can be retried with the same id after a failure
+func (s *OperationService) SettleBalance(ctx context.Context, userId int, counterpartyId int, settlementId primitive.ObjectID) (*api.Balance, []api.Operation, error) {
+	balance, err := s.GetUserBalance(ctx, userId, counterpartyId)
+	if err != nil || balance == nil {
+		return nil, nil, err
+	}
+	repayments := SettlementRepayments(userId, balance, settlementId, time.Now())
+	for i, d := range balance.Debts {
+		if err := s.RoomRepository.AddSettlementRepayment(ctx, d.RoomId, &repayments[i]); err != nil {
+			return nil, nil, errors.Wrapf(err, "cannot add repayment of settlement %s to room %s", settlementId.Hex(), d.RoomId)
+		}
+		s.changes.notify(d.RoomId)
+	}
+	return balance, repayments, nil
+}
+
+// ReviewSettlement confirms or disputes pending repayments of the settlement, returns ids of changed rooms
+func (s *OperationService) ReviewSettlement(ctx context.Context, settlementId primitive.ObjectID, status api.RepaymentStatus) ([]string, error) {
+	roomIds, err := s.RoomRepository.SetSettlementStatus(ctx, settlementId, status)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range roomIds {
+		s.changes.notify(id)
+	}
+	return roomIds, nil
+}
+
+// SettlementRepayments returns repayments closing every debt of the balance, the i-th repayment belongs to the room of
+// the i-th debt. Whoever records the settlement, it is confirmed by the member who receives the net sum: repayments are
+// confirmed at once when it is the user, otherwise all of them wait for the counterparty, even when the sums are even
+func SettlementRepayments(userId int, b *api.Balance, settlementId primitive.ObjectID, now time.Time) []api.Operation {
+	status := api.RepaymentPending
+	if b.Sum > 0 {
+		status = api.RepaymentConfirmed
+	}
+	repayments := make([]api.Operation, 0, len(b.Debts))
+	for _, d := range b.Debts {
+		repayments = append(repayments, api.Operation{
+			ID:              primitive.NewObjectID(),
+			Sum:             d.Debt.Unpaid(),
+			Donor:           d.Debt.Debtor,
+			Recipients:      &[]api.User{*d.Debt.Lender},
+			IsDebtRepayment: true,
+			RepaymentStatus: status,
+			SettlementId:    settlementId,
+			CreateAt:        now,
+		})
+	}
+	return repayments
+}
+
+// CalculateBalances nets unpaid debts between the user and other real members of rooms, where debts are distributed.
+// Virtual members exist only in their rooms, so their debts are not netted
+func CalculateBalances(userId int, rooms []api.Room) ([]api.Balance, error) {
+	balances := map[int]*api.Balance{}
+	for _, room := range rooms {
+		if room.CountRealMembers() != len(room.RoomStates.FinishedAddOperation) {
+			continue
+		}
+		debts, err := GetRoomDebts(room)
+		if err != nil {
+			return nil, err
+		}
+		for _, debt := range debts {
+			if debt.Unpaid() < 1 || debt.Debtor.IsVirtual || debt.Lender.IsVirtual {
+				continue
+			}
+			var counterparty *api.User
+			var sum int
+			if debt.Lender.ID == userId {
+				counterparty, sum = debt.Debtor, debt.Unpaid()
+			} else if debt.Debtor.ID == userId {
+				counterparty, sum = debt.Lender, -debt.Unpaid()
+			} else {
+				continue
+			}
+			b, ok := balances[counterparty.ID]
+			if !ok {
+				b = &api.Balance{Counterparty: counterparty}
+				balances[counterparty.ID] = b
+			}
+			b.Sum += sum
+			b.Debts = append(b.Debts, api.RoomDebt{RoomId: room.ID.Hex(), RoomName: room.Name, Debt: debt})
+		}
+	}
+
+	var result []api.Balance
+	for _, b := range balances {
+		result = append(result, *b)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Counterparty.ID < result[j].Counterparty.ID
+	})
+	return result, nil
+}
+
+// SuggestCategory returns category of the new operation learned from descriptions of categorized operations in the room
+func (s *OperationService) SuggestCategory(room *api.Room, description string) string {
+	return suggestCategory(room, description)
+}
+
+// suggestCategory scores categories by words of the description used in descriptions of their operations,
+// empty category is returned when no word was used before
+func suggestCategory(room *api.Room, description string) string {
+	words := descriptionWords(description)
+	if len(words) == 0 || room.Operations == nil {
+		return ""
+	}
+	scores := map[string]int{}
+	for _, o := range *room.Operations {
+		if o.Category == "" || o.IsDebtRepayment {
+			continue
+		}
+		for w := range descriptionWords(o.Description) {
+			if words[w] {
+				scores[o.Category]++
+			}
+		}
+	}
+	var category string
+	var max int
+	for _, c := range room.ExpenseCategories() {
+		if scores[c] > max {
+			category, max = c, scores[c]
+		}
+	}
+	return category
+}
+
+// descriptionWords returns keywords of the description, short words are skipped
+func descriptionWords(description string) map[string]bool {
+	words := map[string]bool{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(description), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		if len([]rune(w)) >= 3 {
+			words[w] = true
+		}
+	}
+	return words
+}
+
+// BudgetStatuses returns spending of the room against every budget set in it
+func (s *OperationService) BudgetStatuses(room *api.Room) []api.BudgetStatus {
+	return CalculateBudgetStatuses(room)
+}
+
+// BudgetForecast returns spending of the room expected at the current rate
+func (s *OperationService) BudgetForecast(room *api.Room, now time.Time) api.BudgetForecast {
+	return CalculateBudgetForecast(room, now)
+}
+
+// CalculateBudgetStatuses returns the total budget first, then budgets of categories and members in order of setting.
+// Spending of the member is his share of operations
+func CalculateBudgetStatuses(room *api.Room) []api.BudgetStatus {
+	var total int
+	categories := map[string]int{}
+	shares := map[int]float64{}
+	if room.Operations != nil {
+		for _, o := range *room.Operations {
+			if o.IsDebtRepayment {
+				continue
+			}
+			total += o.Sum
+			categories[o.Category] += o.Sum
+			for id, share := range o.Shares() {
+				shares[id] += share
+			}
+		}
+	}
+
+	var statuses []api.BudgetStatus
+	if room.Budget.Total > 0 {
+		statuses = append(statuses, api.BudgetStatus{Key: api.BudgetTotalKey, Limit: room.Budget.Total, Spent: total})
+	}
+	for _, c := range room.Budget.Categories {
+		statuses = append(statuses, api.BudgetStatus{Key: api.CategoryBudgetKey(c.Category), Category: c.Category, Limit: c.Sum, Spent: categories[c.Category]})
+	}
+	for _, m := range room.Budget.Members {
+		member := room.FindMember(m.UserId)
+		if member == nil {
+			continue
+		}
+		statuses = append(statuses, api.BudgetStatus{Key: api.MemberBudgetKey(m.UserId), Member: member, Limit: m.Sum, Spent: int(math.Round(shares[m.UserId]))})
+	}
+	return statuses
+}
+
+// CalculateBudgetForecast extrapolates the average daily spending since the room creation,
+// the first day is counted as whole day to avoid huge forecasts right after the first operation
+func CalculateBudgetForecast(room *api.Room, now time.Time) api.BudgetForecast {
+	var spent int
+	if room.Operations != nil {
+		for _, o := range *room.Operations {
+			if !o.IsDebtRepayment {
+				spent += o.Sum
+			}
+		}
+	}
+	days := math.Max(now.Sub(room.CreateAt).Hours()/24, 1)
+	rate := float64(spent) / days
+
+	forecast := api.BudgetForecast{DaysLeft: -1}
+	if room.Budget.Days > 0 {
+		forecast.Projected = spent
+		if days < float64(room.Budget.Days) {
+			forecast.Projected = int(math.Round(rate * float64(room.Budget.Days)))
+		}
+	}
+	switch {
+	case room.Budget.Total == 0 || rate == 0:
+	case spent >= room.Budget.Total:
+		forecast.DaysLeft = 0
+	default:
+		forecast.DaysLeft = int(float64(room.Budget.Total-spent) / rate)
+	}
+	return forecast
+}
+
 func GetRoomDebts(room api.Room) ([]api.Debt, error) {
 	idUser := map[int]api.User{}
 	for _, user := range *room.Members {
@@ -227,6 +1229,7 @@ func GetRoomDebts(room api.Room) ([]api.Debt, error) {
 
 	debts, err = AddReturnToDebts(debts, debtReturn)
 	sortDebts(debts)
+	addPendingToDebts(debts, debtReturn)
 	return debts, err
 
 }
@@ -240,8 +1243,15 @@ func sortDebts(debts []api.Debt) {
 	})
 }
 
+// AddReturnToDebts subtracts repayments from debts, only repayments confirmed by lenders are counted
 func AddReturnToDebts(debts []api.Debt, debtReturn []api.Operation) ([]api.Debt, error) {
-	returned, err := calculateUserBalance(debtReturn)
+	var confirmed []api.Operation
+	for _, op := range debtReturn {
+		if op.IsConfirmedRepayment() {
+			confirmed = append(confirmed, op)
+		}
+	}
+	returned, err := calculateUserBalance(confirmed)
 	if err != nil {
 		return nil, err
 	}
@@ -262,12 +1272,26 @@ func AddReturnToDebts(debts []api.Debt, debtReturn []api.Operation) ([]api.Debt,
 	}
 	for _, sum := range returned {
 		if sum > 2 {
-			return nil, errors.New("debt is not balanced")
+			return nil, errors.Wrap(api.ErrInconsistentRoom, "debt is not balanced")
 		}
 	}
 	return result, nil
 }
 
+// addPendingToDebts sets the sum of repayments waiting for confirmation, it is not more than the debt
+func addPendingToDebts(debts []api.Debt, debtReturn []api.Operation) {
+	for i := range debts {
+		for _, op := range debtReturn {
+			if op.IsPendingRepayment() && op.Donor.ID == debts[i].Debtor.ID && containsUserId(op.Recipients, debts[i].Lender.ID) {
+				debts[i].Pending += op.Sum
+			}
+		}
+		if debts[i].Pending > debts[i].Sum {
+			debts[i].Pending = debts[i].Sum
+		}
+	}
+}
+
 func getMin(f ...float64) float64 {
 	min := f[0]
 	for _, v := range f {
@@ -319,13 +1343,15 @@ func calculateDebt(users map[int]api.User, ops []api.Operation) ([]api.Debt, err
 func calculateUserBalance(ops []api.Operation) (map[int]float64, error) {
 	balance := map[int]float64{}
 	for _, op := range ops {
-		balance[op.Donor.ID] += float64(op.Sum)
-		for _, user := range *op.Recipients {
-			balance[user.ID] -= float64(op.Sum) / float64(len(*op.Recipients))
+		for _, p := range op.Payers() {
+			balance[p.User.ID] += float64(p.Sum)
+		}
+		for id, share := range op.Shares() {
+			balance[id] -= share
 		}
 		//на время тестов оставил
 		if !isUserBalanceValid(balance) {
-			return nil, errors.New("cannot calculate debts")
+			return nil, errors.Wrap(api.ErrInconsistentRoom, "cannot calculate debts")
 		}
 	}
 	return balance, nil
@@ -380,13 +1406,119 @@ func (s *StatisticService) GetUserCostsSum(ctx context.Context, userId int, room
 	}
 	var totalUserSpendSum float64
 	for _, v := range *room.Operations {
-		if !v.IsDebtRepayment && containsUserId(v.Recipients, userId) {
-			totalUserSpendSum += float64(v.Sum) / float64(len(*v.Recipients))
+		if !v.IsDebtRepayment {
+			totalUserSpendSum += v.Shares()[userId]
 		}
 	}
 	return int(totalUserSpendSum), nil
 }
 
+// GetCategoryCostsSums returns sums spent in every category of the room, the biggest first
+func (s *StatisticService) GetCategoryCostsSums(ctx context.Context, roomId string) ([]api.CategoryCosts, error) {
+	room, err := s.RoomService.FindById(ctx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	sums := map[string]int{}
+	for _, v := range *room.Operations {
+		if !v.IsDebtRepayment {
+			sums[v.Category] += v.Sum
+		}
+	}
+	var costs []api.CategoryCosts
+	for category, sum := range sums {
+		costs = append(costs, api.CategoryCosts{Category: category, Sum: sum})
+	}
+	sort.Slice(costs, func(i, j int) bool {
+		if costs[i].Sum == costs[j].Sum {
+			return costs[i].Category < costs[j].Category
+		}
+		return costs[i].Sum > costs[j].Sum
+	})
+	return costs, nil
+}
+
+// GetMembersCostsSums returns sums spent on every member of the room, the biggest first
+func (s *StatisticService) GetMembersCostsSums(ctx context.Context, roomId string) ([]api.MemberCosts, error) {
+	room, err := s.RoomService.FindById(ctx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	shares := map[int]float64{}
+	for _, v := range *room.Operations {
+		if v.IsDebtRepayment {
+			continue
+		}
+		for id, share := range v.Shares() {
+			shares[id] += share
+		}
+	}
+	var costs []api.MemberCosts
+	for i := range *room.Members {
+		member := &(*room.Members)[i]
+		costs = append(costs, api.MemberCosts{Member: member, Sum: int(math.Round(shares[member.ID]))})
+	}
+	sort.SliceStable(costs, func(i, j int) bool {
+		return costs[i].Sum > costs[j].Sum
+	})
+	return costs, nil
+}
+
+// GetUserDigest returns activity of the user since the moment in every active room, rooms without activity and debts are skipped
+func (s *StatisticService) GetUserDigest(ctx context.Context, userId int, since time.Time) ([]api.RoomDigest, error) {
+	rooms, err := s.RoomService.FindRoomsByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	var digests []api.RoomDigest
+	for _, room := range *rooms {
+		digest, err := CalculateRoomDigest(userId, room, since)
+		if err != nil {
+			return nil, err
+		}
+		if !digest.IsEmpty() {
+			digests = append(digests, digest)
+		}
+	}
+	return digests, nil
+}
+
+// CalculateRoomDigest sums operations created since the moment, debts are outstanding at the moment of calculation
+func CalculateRoomDigest(userId int, room api.Room, since time.Time) (api.RoomDigest, error) {
+	digest := api.RoomDigest{RoomId: room.ID.Hex(), RoomName: room.Name}
+	if room.Operations == nil {
+		return digest, nil
+	}
+	var spent float64
+	for _, o := range *room.Operations {
+		if o.IsDebtRepayment || o.CreateAt.Before(since) {
+			continue
+		}
+		share, participates := o.Shares()[userId]
+		spent += share
+		for _, p := range o.Payers() {
+			if p.User.ID == userId {
+				digest.Paid += p.Sum
+			}
+		}
+		if participates && !o.IsPayer(userId) {
+			digest.Operations = append(digest.Operations, o)
+		}
+	}
+	digest.Spent = int(math.Round(spent))
+
+	debts, err := GetRoomDebts(room)
+	if err != nil {
+		return digest, err
+	}
+	for _, d := range debts {
+		if (d.Debtor.ID == userId || d.Lender.ID == userId) && d.Unpaid() > 0 {
+			digest.Debts = append(digest.Debts, d)
+		}
+	}
+	return digest, nil
+}
+
 func (s *StatisticService) GetAllDebtsSum(ctx context.Context, roomId string) (int, error) {
 	debts, err := s.GetAllDebts(ctx, roomId)
 	if err != nil {
@@ -426,23 +1558,38 @@ func containsUserId(users *[]api.User, id int) bool {
 	return false
 }
 
+func (s RoomStateService) FinishedAddOperation(ctx context.Context, userId int, roomId string) error {
+	if err := s.RoomRepository.FinishedAddOperation(ctx, userId, roomId); err != nil {
+		return err
+	}
+	s.changes.notify(roomId)
+	return nil
+}
+
+func (s RoomStateService) UnFinishedAddOperation(ctx context.Context, userId int, roomId string) error {
+	if err := s.RoomRepository.UnFinishedAddOperation(ctx, userId, roomId); err != nil {
+		return err
+	}
+	s.changes.notify(roomId)
+	return nil
+}
+
+func (s RoomStateService) PaidOfDebts(ctx context.Context, userIds []int, roomId string) error {
+	if err := s.RoomRepository.PaidOfDebts(ctx, userIds, roomId); err != nil {
+		return err
+	}
+	s.changes.notify(roomId)
+	return nil
+}
+
 func (s RoomStateService) DefinePaidOfDebtsUserIdsAndSave(ctx context.Context, room *api.Room) error {
-	if len(*room.Members) == len(room.RoomStates.FinishedAddOperation) {
+	if room.CountRealMembers() == len(room.RoomStates.FinishedAddOperation) {
 		debts, err := s.OperationService.GetAllDebts(ctx, room.ID.Hex())
 		if err != nil {
-			log.Error().Err(err).Msg("")
+			log.Ctx(ctx).Error().Err(err).Msg("")
 			return err
 		}
-		for _, v := range debts {
-			if v.Sum != 0 {
-				*room.Members = deleteUser(*room.Members, v.Debtor.ID)
-			}
-		}
-		var paidOfDebtsUserIds []int
-		for _, user := range *room.Members {
-			paidOfDebtsUserIds = append(paidOfDebtsUserIds, user.ID)
-		}
-		err = s.RoomRepository.PaidOfDebts(ctx, paidOfDebtsUserIds, room.ID.Hex())
+		err = s.PaidOfDebts(ctx, PaidOffDebtUserIds(*room, debts), room.ID.Hex())
 		if err != nil {
 			return err
 		}
@@ -450,6 +1597,237 @@ func (s RoomStateService) DefinePaidOfDebtsUserIdsAndSave(ctx context.Context, r
 	return nil
 }
 
+// PaidOffDebtUserIds returns real members without debts, managers of virtual debtors have debts too
+func PaidOffDebtUserIds(room api.Room, debts []api.Debt) []int {
+	indebted := map[int]bool{}
+	for _, d := range debts {
+		if d.Sum != 0 {
+			indebted[d.Debtor.ID] = true
+			if d.Debtor.IsVirtual {
+				indebted[d.Debtor.ManagerId] = true
+			}
+		}
+	}
+	var paidOff []int
+	for _, user := range *room.Members {
+		if !user.IsVirtual && !indebted[user.ID] {
+			paidOff = append(paidOff, user.ID)
+		}
+	}
+	return paidOff
+}
+
+// OrphanOperations returns operations whose donor, co-payers or recipients are not members of the room anymore
+func OrphanOperations(room api.Room) []api.Operation {
+	var orphans []api.Operation
+	for i := range *room.Operations {
+		if o := &(*room.Operations)[i]; len(unknownUserIds(room, o)) > 0 {
+			orphans = append(orphans, *o)
+		}
+	}
+	return orphans
+}
+
+// unknownUserIds returns ids of payers and recipients of the operation, who are not members of the room.
+// The payer without user, e.g. the operation without donor, is the unknown user with zero id
+func unknownUserIds(room api.Room, o *api.Operation) []int {
+	var ids []int
+	seen := map[int]bool{}
+	check := func(u *api.User) {
+		id := 0
+		if u != nil {
+			id = u.ID
+		}
+		if !seen[id] && (u == nil || !containsUserId(room.Members, id)) {
+			ids = append(ids, id)
+		}
+		seen[id] = true
+	}
+	check(o.Donor)
+	for _, p := range o.CoPayers {
+		check(p.User)
+	}
+	if o.Recipients != nil {
+		for i := range *o.Recipients {
+			check(&(*o.Recipients)[i])
+		}
+	}
+	for _, item := range o.Items {
+		for i := range item.Recipients {
+			check(&item.Recipients[i])
+		}
+	}
+	return ids
+}
+
+// hasRecipients checks that the sum of the operation is shared: it has recipients or every item has them
+func hasRecipients(o *api.Operation) bool {
+	if len(o.Items) == 0 {
+		return o.Recipients != nil && len(*o.Recipients) > 0
+	}
+	for _, item := range o.Items {
+		if len(item.Recipients) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateRoom checks invariants of operations and debts of the room, debts can't be calculated if any is broken
+func ValidateRoom(room api.Room) []api.Violation {
+	if room.Members == nil || room.Operations == nil {
+		return nil
+	}
+	var violations []api.Violation
+	add := func(o *api.Operation, kind string, format string, args ...interface{}) {
+		v := api.Violation{RoomId: room.ID.Hex(), RoomName: room.Name, Kind: kind, Detail: fmt.Sprintf(format, args...)}
+		if o != nil {
+			v.OperationId = o.ID.Hex()
+		}
+		violations = append(violations, v)
+	}
+
+	var spends, repayments []api.Operation
+	var unbalanced bool
+	for i := range *room.Operations {
+		o := &(*room.Operations)[i]
+		var noPayer bool
+		for _, id := range unknownUserIds(room, o) {
+			add(o, api.ViolationUnknownUser, "user %d", id)
+			noPayer = noPayer || id == 0
+		}
+		if noPayer {
+			continue
+		}
+		if !hasRecipients(o) {
+			add(o, api.ViolationNoRecipients, "sum %d", o.Sum)
+			continue
+		}
+		var paid, shared float64
+		for _, p := range o.Payers() {
+			paid += float64(p.Sum)
+		}
+		for _, share := range o.Shares() {
+			shared += share
+		}
+		if math.Abs(paid-shared) >= 1 {
+			unbalanced = true
+			add(o, api.ViolationUnbalanced, "payments %.0f, shares %.2f", paid, shared)
+			continue
+		}
+		if o.IsDebtRepayment {
+			repayments = append(repayments, *o)
+		} else {
+			spends = append(spends, *o)
+		}
+	}
+
+	balance := map[int]float64{}
+	var sum float64
+	for _, o := range spends {
+		for _, p := range o.Payers() {
+			balance[p.User.ID] += float64(p.Sum)
+		}
+		for id, share := range o.Shares() {
+			balance[id] -= share
+		}
+	}
+	for _, b := range balance {
+		sum += b
+	}
+	// unbalanced operations break the sum, the room is reported if they are not found
+	if math.Abs(sum) >= 1 {
+		if !unbalanced {
+			add(nil, api.ViolationUnbalanced, "balances sum to %.2f", sum)
+		}
+		return violations
+	}
+
+	idUser := map[int]api.User{}
+	for _, u := range *room.Members {
+		idUser[u.ID] = u
+	}
+	debts, err := calculateDebt(idUser, spends)
+	if err != nil {
+		return violations
+	}
+	var confirmed []api.Operation
+	for _, o := range repayments {
+		if o.IsConfirmedRepayment() {
+			confirmed = append(confirmed, o)
+		}
+	}
+	owed := map[int]int{}
+	for _, d := range debts {
+		owed[d.Debtor.ID] += d.Sum
+	}
+	repaid := map[int]float64{}
+	for _, o := range confirmed {
+		repaid[o.Donor.ID] += float64(o.Sum)
+		for id, share := range o.Shares() {
+			repaid[id] -= share
+		}
+	}
+	for _, u := range *room.Members {
+		// the same tolerance as in AddReturnToDebts, sums of debts are rounded
+		if repaid[u.ID]-float64(owed[u.ID]) > 2 {
+			add(nil, api.ViolationOverpaid, "user %d repaid %.0f, owed %d", u.ID, repaid[u.ID], owed[u.ID])
+		}
+	}
+	return violations
+}
+
+// VerifyRoom validates the room and recomputes its debts, found problems are returned. It is empty for the consistent room
+func VerifyRoom(room api.Room) []string {
+	var problems []string
+	for _, v := range ValidateRoom(room) {
+		if v.OperationId != "" {
+			problems = append(problems, fmt.Sprintf("operation %s: %s, %s", v.OperationId, v.Kind, v.Detail))
+		} else {
+			problems = append(problems, v.Kind+", "+v.Detail)
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	debts, err := GetRoomDebts(room)
+	if err != nil {
+		return append(problems, fmt.Sprintf("debts are not calculated: %v", err))
+	}
+	for _, d := range debts {
+		if d.Sum < 0 {
+			problems = append(problems, fmt.Sprintf("negative debt of %d to %d: %d", d.Debtor.ID, d.Lender.ID, d.Sum))
+		}
+		if d.Debtor.ID == d.Lender.ID {
+			problems = append(problems, fmt.Sprintf("debt of %d to himself: %d", d.Debtor.ID, d.Sum))
+		}
+	}
+	if room.CountRealMembers() == len(room.RoomStates.FinishedAddOperation) {
+		expected := PaidOffDebtUserIds(room, debts)
+		if !sameUserIds(expected, room.RoomStates.PaidOffDebt) {
+			problems = append(problems, fmt.Sprintf("paid off debts %v, expected %v", room.RoomStates.PaidOffDebt, expected))
+		}
+	}
+	return problems
+}
+
+func sameUserIds(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	ids := map[int]int{}
+	for _, id := range a {
+		ids[id]++
+	}
+	for _, id := range b {
+		if ids[id] == 0 {
+			return false
+		}
+		ids[id]--
+	}
+	return true
+}
+
 func deleteUser(users []api.User, userId int) []api.User {
 	index := -1
 	for i, v := range users {
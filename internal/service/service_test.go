@@ -1,12 +1,17 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/almaznur91/splitty/internal/api"
+	"github.com/almaznur91/splitty/internal/repository"
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"io/ioutil"
 	"testing"
+	"time"
 )
 
 func TestGetRoomDebts(t *testing.T) {
@@ -69,3 +74,637 @@ func TestGetRoomDebtsByTestData(t *testing.T) {
 	assert.Empty(t, debt)
 
 }
+
+func TestMergeVirtualMember(t *testing.T) {
+
+	m := []api.User{
+		{ID: 1, DisplayName: "A"},
+		{ID: -5, DisplayName: "B", IsVirtual: true, ManagerId: 1},
+		{ID: 2, DisplayName: "C"},
+	}
+	o := []api.Operation{
+		{Donor: &m[1], Recipients: &[]api.User{m[0], m[1]}, Sum: 10},
+		{Donor: &m[0], Recipients: &[]api.User{m[1], m[2]}, Sum: 4},
+	}
+	room := api.Room{
+		Members:    &m,
+		Operations: &o,
+		RoomStates: api.RoomStatesUsers{FinishedAddOperation: []int{1, -5}},
+	}
+
+	joined := api.User{ID: 3, DisplayName: "B real"}
+	err := mergeMember(&room, -5, joined)
+	assert.Nil(t, err)
+	assert.Nil(t, room.FindMember(-5))
+	assert.Equal(t, "B real", room.FindMember(3).DisplayName)
+	assert.Equal(t, 3, (*room.Operations)[0].Donor.ID)
+	assert.Equal(t, []int{1, 3}, room.RoomStates.FinishedAddOperation)
+
+	debt, _ := GetRoomDebts(room)
+	var debtForAssert [][]interface{}
+	for _, d := range debt {
+		debtForAssert = append(debtForAssert, []interface{}{d.Debtor.DisplayName, d.Lender.DisplayName, d.Sum})
+	}
+	assert.ElementsMatch(t, debtForAssert, [][]interface{}{
+		{"A", "B real", 1},
+		{"C", "B real", 2},
+	})
+
+	assert.NotNil(t, mergeMember(&room, 2, joined))
+
+	shared := api.Room{
+		Members:    &[]api.User{m[0], {ID: -6, DisplayName: "D", IsVirtual: true}, m[2]},
+		Operations: &[]api.Operation{{Donor: &m[0], Recipients: &[]api.User{m[0], {ID: -6}, m[2]}, Sum: 9}},
+	}
+	err = mergeMember(&shared, -6, m[2])
+	assert.True(t, errors.Is(err, api.ErrMergeConflict))
+	assert.Equal(t, 3, len(*(*shared.Operations)[0].Recipients))
+	assert.NotNil(t, shared.FindMember(-6))
+}
+
+// concurrentRoomRepository changes the stored room between reads and writes of the first attempts
+type concurrentRoomRepository struct {
+	repository.RoomRepository
+	room      api.Room
+	conflicts int
+	updates   int
+}
+
+func (r *concurrentRoomRepository) FindById(_ context.Context, _ string) (*api.Room, error) {
+	room := r.room
+	members := append([]api.User{}, *r.room.Members...)
+	room.Members = &members
+	return &room, nil
+}
+
+func (r *concurrentRoomRepository) UpdateRoom(_ context.Context, room *api.Room) error {
+	r.updates++
+	if r.conflicts > 0 {
+		r.conflicts--
+		r.room.Version++
+		*r.room.Members = append(*r.room.Members, api.User{ID: 100 + r.conflicts})
+	}
+	if room.Version != r.room.Version {
+		return api.ErrRoomChanged
+	}
+	room.Version++
+	r.room = *room
+	return nil
+}
+
+func TestUpdateRoom(t *testing.T) {
+	rr := &concurrentRoomRepository{room: api.Room{Members: &[]api.User{{ID: 1}}}, conflicts: 2}
+	addMember := func(room *api.Room) (bool, error) {
+		*room.Members = append(*room.Members, api.User{ID: 2})
+		return true, nil
+	}
+	assert.NoError(t, updateRoom(context.Background(), rr, "room", addMember))
+	assert.Equal(t, 3, rr.updates)
+	var ids []int
+	for _, m := range *rr.room.Members {
+		ids = append(ids, m.ID)
+	}
+	assert.Equal(t, []int{1, 101, 100, 2}, ids, "concurrent changes are kept")
+
+	rr = &concurrentRoomRepository{room: api.Room{Members: &[]api.User{{ID: 1}}}, conflicts: updateRoomAttempts}
+	assert.True(t, errors.Is(updateRoom(context.Background(), rr, "room", addMember), api.ErrRoomChanged))
+
+	rr = &concurrentRoomRepository{room: api.Room{Members: &[]api.User{{ID: 1}}}}
+	assert.NoError(t, updateRoom(context.Background(), rr, "room", func(room *api.Room) (bool, error) { return false, nil }))
+	assert.Equal(t, 0, rr.updates)
+}
+
+// syncUserRepository returns the stored users
+type syncUserRepository struct {
+	repository.UserRepository
+	users []api.User
+}
+
+func (r *syncUserRepository) FindAll(_ context.Context) (*[]api.User, error) {
+	return &r.users, nil
+}
+
+// syncRoomRepository records users updated in rooms and fails for some of them
+type syncRoomRepository struct {
+	repository.RoomRepository
+	failed  map[int]bool
+	updated []int
+}
+
+func (r *syncRoomRepository) UpdateUserInRooms(_ context.Context, u api.User) error {
+	if r.failed[u.ID] {
+		return errors.New("update failed")
+	}
+	r.updated = append(r.updated, u.ID)
+	return nil
+}
+
+func TestSyncUsersInRooms(t *testing.T) {
+	ur := &syncUserRepository{users: []api.User{{ID: 1}, {ID: 2}, {ID: 3}}}
+	rr := &syncRoomRepository{}
+	us := NewUserService(ur, rr)
+	assert.NoError(t, us.SyncUsersInRooms(context.Background()))
+	assert.Equal(t, []int{1, 2, 3}, rr.updated)
+
+	rr = &syncRoomRepository{failed: map[int]bool{2: true}}
+	us = NewUserService(ur, rr)
+	err := us.SyncUsersInRooms(context.Background())
+	assert.EqualError(t, err, "1 of 3 users are not updated in rooms")
+	assert.Equal(t, []int{1, 3}, rr.updated, "users after the failed one are synced")
+}
+
+func TestGetRoomDebtsWithCoPayers(t *testing.T) {
+
+	m := []api.User{
+		{ID: 0, DisplayName: "A"},
+		{ID: 1, DisplayName: "B"},
+		{ID: 2, DisplayName: "C"},
+	}
+	o := []api.Operation{
+		{Donor: &m[0], CoPayers: []api.Payer{{User: &m[1], Sum: 60}}, Recipients: &[]api.User{m[0], m[1], m[2]}, Sum: 90},
+	}
+	room := api.Room{
+		Members:    &m,
+		Operations: &o,
+	}
+
+	debt, _ := GetRoomDebts(room)
+	var debtForAssert [][]interface{}
+	for _, d := range debt {
+		debtForAssert = append(debtForAssert, []interface{}{d.Debtor.DisplayName, d.Lender.DisplayName, d.Sum})
+	}
+	assert.ElementsMatch(t, debtForAssert, [][]interface{}{
+		{"C", "B", 30},
+	})
+	assert.True(t, o[0].IsPayer(1))
+	assert.False(t, o[0].IsPayer(2))
+}
+
+func TestGetRoomDebtsWithItems(t *testing.T) {
+
+	m := []api.User{
+		{ID: 0, DisplayName: "A"},
+		{ID: 1, DisplayName: "B"},
+		{ID: 2, DisplayName: "C"},
+	}
+	o := []api.Operation{
+		{Donor: &m[0], Recipients: &m, Sum: 110, Items: []api.Item{
+			{Name: "Pizza", Price: 60, Recipients: []api.User{m[0], m[1]}},
+			{Name: "Wine", Price: 40, Recipients: []api.User{m[2]}},
+		}},
+	}
+	room := api.Room{
+		Members:    &m,
+		Operations: &o,
+	}
+
+	debt, _ := GetRoomDebts(room)
+	var debtForAssert [][]interface{}
+	for _, d := range debt {
+		debtForAssert = append(debtForAssert, []interface{}{d.Debtor.DisplayName, d.Lender.DisplayName, d.Sum})
+	}
+	assert.ElementsMatch(t, debtForAssert, [][]interface{}{
+		{"B", "A", 33},
+		{"C", "A", 44},
+	})
+}
+
+func TestGetRoomDebtsWithPendingRepayment(t *testing.T) {
+
+	m := []api.User{
+		{ID: 0, DisplayName: "A"},
+		{ID: 1, DisplayName: "B"},
+	}
+	o := []api.Operation{
+		{Donor: &m[0], Recipients: &[]api.User{m[1]}, Sum: 100},
+		{Donor: &m[1], Recipients: &[]api.User{m[0]}, Sum: 30, IsDebtRepayment: true, RepaymentStatus: api.RepaymentConfirmed},
+		{Donor: &m[1], Recipients: &[]api.User{m[0]}, Sum: 50, IsDebtRepayment: true, RepaymentStatus: api.RepaymentPending},
+		{Donor: &m[1], Recipients: &[]api.User{m[0]}, Sum: 20, IsDebtRepayment: true, RepaymentStatus: api.RepaymentDisputed},
+	}
+	room := api.Room{
+		Members:    &m,
+		Operations: &o,
+	}
+
+	debt, err := GetRoomDebts(room)
+	assert.Nil(t, err)
+	var debtForAssert [][]interface{}
+	for _, d := range debt {
+		debtForAssert = append(debtForAssert, []interface{}{d.Debtor.DisplayName, d.Lender.DisplayName, d.Sum, d.Pending})
+	}
+	assert.ElementsMatch(t, debtForAssert, [][]interface{}{
+		{"B", "A", 70, 50},
+	})
+	assert.Equal(t, 20, debt[0].Unpaid())
+}
+
+func TestCalculateBalances(t *testing.T) {
+
+	m := []api.User{
+		{ID: 1, DisplayName: "A"},
+		{ID: 2, DisplayName: "B"},
+		{ID: 3, DisplayName: "C"},
+		{ID: -4, DisplayName: "V", IsVirtual: true},
+	}
+	rooms := []api.Room{
+		{
+			Name:       "first",
+			Members:    &[]api.User{m[0], m[1]},
+			Operations: &[]api.Operation{{Donor: &m[1], Recipients: &[]api.User{m[0]}, Sum: 100}},
+			RoomStates: api.RoomStatesUsers{FinishedAddOperation: []int{1, 2}},
+		},
+		{
+			Name:       "second",
+			Members:    &[]api.User{m[0], m[1], m[2], m[3]},
+			Operations: &[]api.Operation{{Donor: &m[0], Recipients: &[]api.User{m[1], m[2], m[3]}, Sum: 180}},
+			RoomStates: api.RoomStatesUsers{FinishedAddOperation: []int{1, 2, 3}},
+		},
+		{
+			Name:       "not distributed",
+			Members:    &[]api.User{m[0], m[1]},
+			Operations: &[]api.Operation{{Donor: &m[1], Recipients: &[]api.User{m[0]}, Sum: 1000}},
+			RoomStates: api.RoomStatesUsers{FinishedAddOperation: []int{1}},
+		},
+	}
+
+	balances, err := CalculateBalances(1, rooms)
+	assert.Nil(t, err)
+	var balanceForAssert [][]interface{}
+	for _, b := range balances {
+		balanceForAssert = append(balanceForAssert, []interface{}{b.Counterparty.DisplayName, b.Sum, len(b.Debts)})
+	}
+	assert.Equal(t, [][]interface{}{
+		{"B", -40, 2},
+		{"C", 60, 1},
+	}, balanceForAssert)
+}
+
+func TestSettlementRepayments(t *testing.T) {
+
+	m := []api.User{
+		{ID: 1, DisplayName: "A"},
+		{ID: 2, DisplayName: "B"},
+	}
+	newRooms := func() []api.Room {
+		return []api.Room{
+			{
+				ID:         primitive.NewObjectID(),
+				Name:       "first",
+				Members:    &[]api.User{m[0], m[1]},
+				Operations: &[]api.Operation{{Donor: &m[1], Recipients: &[]api.User{m[0]}, Sum: 100}},
+				RoomStates: api.RoomStatesUsers{FinishedAddOperation: []int{1, 2}},
+			},
+			{
+				ID:         primitive.NewObjectID(),
+				Name:       "second",
+				Members:    &[]api.User{m[0], m[1]},
+				Operations: &[]api.Operation{{Donor: &m[0], Recipients: &[]api.User{m[1]}, Sum: 60}},
+				RoomStates: api.RoomStatesUsers{FinishedAddOperation: []int{1, 2}},
+			},
+		}
+	}
+	settlementId := primitive.NewObjectID()
+
+	for _, tt := range []struct {
+		userId int
+		sum    int
+		status api.RepaymentStatus
+	}{
+		{userId: 1, sum: -40, status: api.RepaymentPending},
+		{userId: 2, sum: 40, status: api.RepaymentConfirmed},
+	} {
+		rooms := newRooms()
+		balances, err := CalculateBalances(tt.userId, rooms)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(balances))
+		assert.Equal(t, tt.sum, balances[0].Sum)
+
+		repayments := SettlementRepayments(tt.userId, &balances[0], settlementId, time.Now())
+		assert.Equal(t, len(balances[0].Debts), len(repayments))
+		for i, d := range balances[0].Debts {
+			assert.Equal(t, tt.status, repayments[i].RepaymentStatus)
+			assert.Equal(t, settlementId, repayments[i].SettlementId)
+			assert.Equal(t, d.Debt.Unpaid(), repayments[i].Sum)
+			assert.Equal(t, d.Debt.Debtor.ID, repayments[i].Donor.ID)
+			assert.Equal(t, d.Debt.Lender.ID, (*repayments[i].Recipients)[0].ID)
+			for j := range rooms {
+				if rooms[j].ID.Hex() == d.RoomId {
+					*rooms[j].Operations = append(*rooms[j].Operations, repayments[i])
+				}
+			}
+		}
+
+		balances, err = CalculateBalances(tt.userId, rooms)
+		assert.Nil(t, err)
+		assert.Empty(t, balances)
+	}
+}
+
+func TestSuggestCategory(t *testing.T) {
+
+	m := []api.User{{ID: 1, DisplayName: "A"}}
+	o := []api.Operation{
+		{Description: "Taxi to the airport", Category: "transport", Donor: &m[0], Recipients: &m, Sum: 10},
+		{Description: "Pizza and beer", Category: "food", Donor: &m[0], Recipients: &m, Sum: 10},
+		{Description: "Beer in the bar", Category: "entertainment", Donor: &m[0], Recipients: &m, Sum: 10},
+		{Description: "Beer, chips", Category: "food", Donor: &m[0], Recipients: &m, Sum: 10},
+		{Description: "Hotel", Donor: &m[0], Recipients: &m, Sum: 10},
+	}
+	room := &api.Room{Members: &m, Operations: &o}
+
+	assert.Equal(t, "transport", suggestCategory(room, "taxi home"))
+	assert.Equal(t, "food", suggestCategory(room, "BEER"))
+	assert.Equal(t, "", suggestCategory(room, "hotel"))
+	assert.Equal(t, "", suggestCategory(room, "museum"))
+
+	room.Categories = []string{"drinks"}
+	assert.Equal(t, "", suggestCategory(room, "beer"))
+}
+
+func TestCalculateBudgetStatuses(t *testing.T) {
+
+	m := []api.User{{ID: 1, DisplayName: "A"}, {ID: 2, DisplayName: "B"}}
+	o := []api.Operation{
+		{Category: "food", Donor: &m[0], Recipients: &m, Sum: 100},
+		{Category: "transport", Donor: &m[1], Recipients: &[]api.User{m[1]}, Sum: 50},
+		{IsDebtRepayment: true, Donor: &m[0], Recipients: &[]api.User{m[1]}, Sum: 30},
+	}
+	room := &api.Room{Members: &m, Operations: &o, Budget: api.Budget{
+		Total:      200,
+		Categories: []api.CategoryBudget{{Category: "food", Sum: 80}},
+		Members:    []api.MemberBudget{{UserId: 2, Sum: 100}, {UserId: 3, Sum: 10}},
+	}}
+
+	statuses := CalculateBudgetStatuses(room)
+	assert.Equal(t, 3, len(statuses))
+	assert.Equal(t, api.BudgetStatus{Key: api.BudgetTotalKey, Limit: 200, Spent: 150}, statuses[0])
+	assert.Equal(t, 75, statuses[0].Percent())
+	assert.Equal(t, api.CategoryBudgetKey("food"), statuses[1].Key)
+	assert.Equal(t, -20, statuses[1].Left())
+	assert.Equal(t, 2, statuses[2].Member.ID)
+	assert.Equal(t, 100, statuses[2].Spent)
+}
+
+func TestCalculateBudgetForecast(t *testing.T) {
+
+	m := []api.User{{ID: 1, DisplayName: "A"}}
+	o := []api.Operation{{Donor: &m[0], Recipients: &m, Sum: 300}}
+	now := time.Date(2021, 7, 4, 12, 0, 0, 0, time.UTC)
+	room := &api.Room{Members: &m, Operations: &o, CreateAt: now.Add(-72 * time.Hour), Budget: api.Budget{Total: 1000, Days: 10}}
+
+	assert.Equal(t, api.BudgetForecast{Projected: 1000, DaysLeft: 7}, CalculateBudgetForecast(room, now))
+
+	room.Budget.Days = 0
+	assert.Equal(t, api.BudgetForecast{DaysLeft: 7}, CalculateBudgetForecast(room, now))
+
+	room.Budget.Total = 200
+	assert.Equal(t, api.BudgetForecast{DaysLeft: 0}, CalculateBudgetForecast(room, now))
+
+	room.Budget.Total = 0
+	room.CreateAt = now.Add(-time.Hour)
+	room.Budget.Days = 2
+	assert.Equal(t, api.BudgetForecast{Projected: 600, DaysLeft: -1}, CalculateBudgetForecast(room, now))
+}
+
+func TestCalculateRoomDigest(t *testing.T) {
+
+	m := []api.User{{ID: 1, DisplayName: "A"}, {ID: 2, DisplayName: "B"}}
+	since := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	o := []api.Operation{
+		{Description: "old", Donor: &m[0], Recipients: &m, Sum: 1000, CreateAt: since.Add(-time.Hour)},
+		{Description: "taxi", Donor: &m[1], Recipients: &m, Sum: 100, CreateAt: since.Add(time.Hour)},
+		{Description: "dinner", Donor: &m[0], Recipients: &m, Sum: 60, CreateAt: since.Add(2 * time.Hour)},
+		{IsDebtRepayment: true, Donor: &m[1], Recipients: &[]api.User{m[0]}, Sum: 470, CreateAt: since.Add(3 * time.Hour)},
+	}
+	room := api.Room{Name: "trip", Members: &m, Operations: &o}
+
+	digest, err := CalculateRoomDigest(1, room, since)
+	assert.NoError(t, err)
+	assert.Equal(t, "trip", digest.RoomName)
+	assert.Equal(t, 80, digest.Spent)
+	assert.Equal(t, 60, digest.Paid)
+	assert.Equal(t, 1, len(digest.Operations))
+	assert.Equal(t, "taxi", digest.Operations[0].Description)
+	assert.Equal(t, 1, len(digest.Debts))
+	assert.Equal(t, 2, digest.Debts[0].Debtor.ID)
+	assert.Equal(t, 10, digest.Debts[0].Sum)
+	assert.False(t, digest.IsEmpty())
+}
+
+func TestVerifyRoom(t *testing.T) {
+
+	m := []api.User{
+		{ID: 1, DisplayName: "A"},
+		{ID: 2, DisplayName: "B"},
+		{ID: -3, DisplayName: "C", IsVirtual: true, ManagerId: 2},
+	}
+	o := []api.Operation{
+		{Donor: &m[0], Recipients: &[]api.User{m[1], m[2]}, Sum: 10},
+	}
+	room := api.Room{
+		Members:    &m,
+		Operations: &o,
+		RoomStates: api.RoomStatesUsers{FinishedAddOperation: []int{1, 2}, PaidOffDebt: []int{1}},
+	}
+
+	debts, _ := GetRoomDebts(room)
+	assert.Equal(t, []int{1}, PaidOffDebtUserIds(room, debts))
+	assert.Empty(t, OrphanOperations(room))
+	assert.Empty(t, VerifyRoom(room))
+
+	room.RoomStates.PaidOffDebt = []int{1, 2}
+	assert.Equal(t, []string{"paid off debts [1 2], expected [1]"}, VerifyRoom(room))
+
+	left := api.User{ID: 4, DisplayName: "D"}
+	o = append(o, api.Operation{Description: "pizza", Donor: &left, Recipients: &[]api.User{m[0]}, Sum: 4})
+	room.RoomStates.PaidOffDebt = []int{1}
+	assert.Equal(t, 1, len(OrphanOperations(room)))
+	assert.Equal(t, "pizza", OrphanOperations(room)[0].Description)
+	assert.Equal(t, 1, len(VerifyRoom(room)))
+}
+
+func TestValidateRoom(t *testing.T) {
+
+	m := []api.User{{ID: 1, DisplayName: "A"}, {ID: 2, DisplayName: "B"}}
+	o := []api.Operation{
+		{Donor: &m[0], Recipients: &[]api.User{m[0], m[1]}, Sum: 10},
+		{Donor: &m[1], Recipients: &[]api.User{m[0]}, Sum: 5, IsDebtRepayment: true},
+	}
+	room := api.Room{Name: "trip", Members: &m, Operations: &o}
+	assert.Empty(t, ValidateRoom(room))
+
+	kinds := func(violations []api.Violation) []string {
+		var k []string
+		for _, v := range violations {
+			k = append(k, v.Kind)
+		}
+		return k
+	}
+
+	o[1].Sum = 50
+	assert.Equal(t, []string{api.ViolationOverpaid}, kinds(ValidateRoom(room)))
+	_, err := GetRoomDebts(room)
+	assert.True(t, errors.Is(err, api.ErrInconsistentRoom))
+
+	o[1].Sum = 5
+	o = append(o,
+		api.Operation{Donor: &m[0], Recipients: &[]api.User{}, Sum: 7},
+		api.Operation{Donor: &m[0], Sum: 8, Items: []api.Item{{Name: "tea", Recipients: []api.User{m[1]}}}},
+		api.Operation{Donor: &api.User{ID: 3}, Recipients: &[]api.User{m[0]}, Sum: 3},
+	)
+	room.Operations = &o
+	assert.Equal(t, []string{api.ViolationNoRecipients, api.ViolationUnbalanced, api.ViolationUnknownUser},
+		kinds(ValidateRoom(room)))
+}
+
+func TestAnonymizeMember(t *testing.T) {
+
+	m := []api.User{
+		{ID: 1, DisplayName: "A"},
+		{ID: 2, DisplayName: "B"},
+		{ID: -3, DisplayName: "C", IsVirtual: true, ManagerId: 2},
+	}
+	o := []api.Operation{
+		{Description: "hotel", Donor: &m[1], Recipients: &[]api.User{m[0], m[1], m[2]}, Sum: 30, NotificationSent: []int{2}},
+		{Description: "taxi", Donor: &m[0], CoPayers: []api.Payer{{User: &m[1], Sum: 5}}, Recipients: &[]api.User{m[0]}, Sum: 10},
+		{Description: "tea", Donor: &m[0], Sum: 4, Items: []api.Item{{Name: "tea", Price: 4, Recipients: []api.User{m[1]}}}},
+	}
+	room := api.Room{
+		Members:    &m,
+		Operations: &o,
+		RoomStates: api.RoomStatesUsers{FinishedAddOperation: []int{1, 2}},
+		Reminder:   api.Reminder{Sent: []api.ReminderSent{{UserId: 2, Count: 1}}},
+	}
+
+	outstanding, err := UserOutstandingDebts(2, []api.Room{room})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(outstanding))
+
+	export, err := NewUserExport(m[1], []api.Room{room}, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(export.Rooms[0].Operations))
+	export, _ = NewUserExport(m[2], []api.Room{room}, time.Now())
+	assert.Equal(t, "hotel", export.Rooms[0].Operations[0].Description)
+	assert.Equal(t, 1, len(export.Rooms[0].Operations))
+
+	before, _ := GetRoomDebts(room)
+	anonymous := api.User{ID: -7, DisplayName: api.DeletedUserName, IsVirtual: true, Deleted: true}
+	anonymizeMember(&room, 2, anonymous)
+
+	assert.Nil(t, room.FindMember(2))
+	assert.Equal(t, anonymous, *room.FindMember(-7))
+	assert.Equal(t, 0, room.FindMember(-3).ManagerId)
+	assert.Equal(t, -7, o[0].Donor.ID)
+	assert.Equal(t, -7, o[1].CoPayers[0].User.ID)
+	assert.Equal(t, -7, o[2].Items[0].Recipients[0].ID)
+	assert.Equal(t, []int{-7}, o[0].NotificationSent)
+	assert.Equal(t, []int{1, -7}, room.RoomStates.FinishedAddOperation)
+	assert.Equal(t, -7, room.Reminder.Sent[0].UserId)
+	assert.Empty(t, ValidateRoom(room))
+
+	after, _ := GetRoomDebts(room)
+	assert.Equal(t, len(before), len(after))
+	for i := range before {
+		assert.Equal(t, before[i].Sum, after[i].Sum)
+	}
+	outstanding, _ = UserOutstandingDebts(2, []api.Room{room})
+	assert.Empty(t, outstanding)
+
+	assert.False(t, room.FindMember(-7).IsMergeable())
+	assert.False(t, room.FindMember(-7).IsManagedBy(0))
+	assert.NotNil(t, mergeMember(&room, -7, api.User{ID: 5, DisplayName: "E"}))
+	assert.NotNil(t, room.FindMember(-7))
+	assert.Nil(t, room.FindMember(5))
+}
+
+func TestOwesTo(t *testing.T) {
+
+	m := []api.User{
+		{ID: 1, DisplayName: "A"},
+		{ID: 2, DisplayName: "B", LegacyBank: "card 1234"},
+		{ID: 3, DisplayName: "C"},
+		{ID: -4, DisplayName: "D", IsVirtual: true, ManagerId: 3},
+	}
+	o := []api.Operation{
+		{Donor: &m[0], Recipients: &[]api.User{m[0], m[1], m[3]}, Sum: 30},
+	}
+	room := api.Room{Members: &m, Operations: &o}
+
+	owes, err := OwesTo([]api.Room{room}, 1, 2)
+	assert.NoError(t, err)
+	assert.True(t, owes)
+	owes, _ = OwesTo([]api.Room{room}, 1, 3)
+	assert.True(t, owes)
+	owes, _ = OwesTo([]api.Room{room}, 2, 1)
+	assert.False(t, owes)
+
+	o = append(o, api.Operation{Donor: &m[1], Recipients: &[]api.User{m[0]}, Sum: 10, IsDebtRepayment: true})
+	room.Operations = &o
+	owes, _ = OwesTo([]api.Room{room}, 1, 2)
+	assert.False(t, owes)
+
+	assert.True(t, stripBankDetails(&room))
+	assert.Equal(t, "", m[1].LegacyBank)
+	assert.Equal(t, "", (*o[0].Recipients)[1].LegacyBank)
+	assert.Equal(t, "", o[1].Donor.LegacyBank)
+	assert.False(t, stripBankDetails(&room))
+}
+
+func TestParsePaymentMethod(t *testing.T) {
+	m, err := ParsePaymentMethod(api.PaymentIban, "eur\nDE89 3704 0044 0532 0130 00\ncobadeffxxx")
+	assert.NoError(t, err)
+	assert.Equal(t, api.PaymentMethod{Kind: api.PaymentIban, Currency: "EUR", Account: "DE89370400440532013000", Bic: "COBADEFFXXX"}, *m)
+	_, err = ParsePaymentMethod(api.PaymentIban, "EUR\nDE89 3704 0044 0532 0130 01")
+	assert.True(t, errors.Is(err, api.ErrInvalidPaymentMethod))
+	_, err = ParsePaymentMethod(api.PaymentIban, "EUR\nDE89370400440532013000\nCOBA")
+	assert.Error(t, err)
+
+	m, err = ParsePaymentMethod(api.PaymentCard, "RUB\n4111-1111-1111-1111\nSber")
+	assert.NoError(t, err)
+	assert.Equal(t, "4111111111111111", m.Account)
+	assert.Equal(t, "Sber", m.Bank)
+	_, err = ParsePaymentMethod(api.PaymentCard, "RUB\n4111 1111 1111 1112")
+	assert.Error(t, err)
+
+	m, err = ParsePaymentMethod(api.PaymentPhone, "RUB\n+7 (912) 345-67-89\nTinkoff")
+	assert.NoError(t, err)
+	assert.Equal(t, "+79123456789", m.Account)
+	_, err = ParsePaymentMethod(api.PaymentPhone, "RUB\n89123456789")
+	assert.Error(t, err)
+
+	m, err = ParsePaymentMethod(api.PaymentPaypal, "usd\nhttps://paypal.me/JohnDoe/")
+	assert.NoError(t, err)
+	assert.Equal(t, "JohnDoe", m.Account)
+	assert.Equal(t, "USD", m.Currency)
+
+	_, err = ParsePaymentMethod(api.PaymentPaypal, "dollars\nJohnDoe")
+	assert.Error(t, err)
+	_, err = ParsePaymentMethod(api.PaymentCard, "4111111111111111")
+	assert.Error(t, err)
+}
+
+func TestPaymentQRPayload(t *testing.T) {
+	iban := api.PaymentMethod{Kind: api.PaymentIban, Currency: "EUR", Account: "DE89370400440532013000", Bic: "COBADEFFXXX"}
+	payload, ok := iban.QRPayload("John Doe", 1250, "EUR", "Trip")
+	assert.True(t, ok)
+	assert.Equal(t, "BCD\n002\n1\nSCT\nCOBADEFFXXX\nJohn Doe\nDE89370400440532013000\nEUR1250.00\n\n\nTrip", payload)
+
+	// sums of rooms without currency or in other currencies are not prefilled
+	_, ok = iban.QRPayload("John Doe", 1250, "", "Trip")
+	assert.False(t, ok)
+	_, ok = iban.QRPayload("John Doe", 1250, "RUB", "Trip")
+	assert.False(t, ok)
+
+	iban.Currency = "CHF"
+	_, ok = iban.QRPayload("John Doe", 1250, "CHF", "Trip")
+	assert.False(t, ok)
+
+	paypal := api.PaymentMethod{Kind: api.PaymentPaypal, Currency: "USD", Account: "JohnDoe"}
+	payload, ok = paypal.QRPayload("John Doe", 15, "USD", "Trip")
+	assert.True(t, ok)
+	assert.Equal(t, "https://paypal.me/JohnDoe/15USD", payload)
+
+	card := api.PaymentMethod{Kind: api.PaymentCard, Currency: "RUB", Account: "4111111111111111"}
+	_, ok = card.QRPayload("John Doe", 15, "RUB", "Trip")
+	assert.False(t, ok)
+}
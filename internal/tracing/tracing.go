@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"context"
+	"github.com/almaznur91/splitty/internal/api"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"time"
+)
+
+const (
+	tracerName      = "github.com/almaznur91/splitty"
+	shutdownTimeout = 5 * time.Second
+)
+
+// Init exports spans to the file as json lines, spans are not recorded if the file is empty.
+// The returned func flushes spans and closes the file
+func Init(file string, revision string) (func(), error) {
+	if file == "" {
+		return func() {}, nil
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName("splitty"), semconv.ServiceVersion(revision))),
+	)
+	otel.SetTracerProvider(provider)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("can't flush spans")
+		}
+		_ = f.Close()
+	}, nil
+}
+
+// Start starts the span, the logger of the returned context writes ids of the trace and the span
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+	if sc := span.SpanContext(); sc.IsValid() {
+		l := log.Ctx(ctx).With().Str("trace_id", sc.TraceID().String()).Str("span_id", sc.SpanID().String()).Logger()
+		ctx = l.WithContext(ctx)
+	}
+	return ctx, span
+}
+
+// WithUpdate adds update, user, chat, action and room of the update to the logger of the context and the current span
+func WithUpdate(ctx context.Context, upd *api.Update) context.Context {
+	lc := log.Ctx(ctx).With().Int("update_id", upd.UpdateID)
+	attrs := []attribute.KeyValue{attribute.Int("update_id", upd.UpdateID)}
+	if upd.User != nil {
+		lc = lc.Int("user_id", upd.User.ID)
+		attrs = append(attrs, attribute.Int("user_id", upd.User.ID))
+	}
+	if chatId := chatOf(upd); chatId != 0 {
+		lc = lc.Int64("chat_id", chatId)
+		attrs = append(attrs, attribute.Int64("chat_id", chatId))
+	}
+	action := Action(upd)
+	lc = lc.Str("action", action)
+	attrs = append(attrs, attribute.String("action", action))
+	if roomId := roomOf(upd); roomId != "" {
+		lc = lc.Str("room_id", roomId)
+		attrs = append(attrs, attribute.String("room_id", roomId))
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+	l := lc.Logger()
+	return l.WithContext(ctx)
+}
+
+// Action returns the action of the button or the chat state, it should be called before bots change the update
+func Action(upd *api.Update) string {
+	switch {
+	case upd.Button != nil:
+		return string(upd.Button.Action)
+	case upd.CallbackQuery != nil:
+		return "unknown_button"
+	case upd.ChatState != nil:
+		return string(upd.ChatState.Action)
+	case upd.InlineQuery != nil:
+		return "inline_query"
+	default:
+		return "message"
+	}
+}
+
+func chatOf(upd *api.Update) int64 {
+	switch {
+	case upd.Message != nil && upd.Message.Chat != nil:
+		return upd.Message.Chat.ID
+	case upd.CallbackQuery != nil && upd.CallbackQuery.Message != nil && upd.CallbackQuery.Message.Chat != nil:
+		return upd.CallbackQuery.Message.Chat.ID
+	default:
+		return 0
+	}
+}
+
+func roomOf(upd *api.Update) string {
+	switch {
+	case upd.Button != nil && upd.Button.CallbackData != nil:
+		return upd.Button.CallbackData.RoomId
+	case upd.ChatState != nil && upd.ChatState.CallbackData != nil:
+		return upd.ChatState.CallbackData.RoomId
+	default:
+		return ""
+	}
+}